@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -11,9 +12,13 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/config"
 	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/NERVsystems/osmmcp/pkg/server"
+	"github.com/NERVsystems/osmmcp/pkg/tracing"
 )
 
 // Version information
@@ -21,8 +26,33 @@ var (
 	version        bool
 	debug          bool
 	generateConfig string
+	clientName     string
 	userAgent      string
 	mergeOnly      bool
+	transport      string
+	listenAddr     string
+	adminAddr      string
+	configFile     string
+
+	// Upstream service base URLs
+	nominatimURL string
+	overpassURL  string
+	osrmURL      string
+
+	// Persistent response cache
+	cacheDir       string
+	cacheMaxSizeMB int
+
+	// Offline mode
+	offlinePBF string
+
+	// Recorded upstream exchanges
+	fixtures    string
+	fixturesDir string
+
+	// Trace exporters
+	otlpEndpoint string
+	traceFile    string
 
 	// Rate limits for each service
 	nominatimRPS   float64
@@ -41,32 +71,55 @@ var (
 func init() {
 	flag.BoolVar(&version, "version", false, "Display version information")
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
-	flag.StringVar(&generateConfig, "generate-config", "", "Generate a Claude Desktop Client config file at the specified path")
+	flag.StringVar(&generateConfig, "generate-config", "", "Write an MCP client config file with an osmmcp server entry at the specified path")
+	flag.StringVar(&clientName, "client", clientClaude, "MCP client the generated config is for: claude, cursor or vscode")
 	flag.StringVar(&userAgent, "user-agent", osm.UserAgent, "User-Agent string for OSM API requests")
-	flag.BoolVar(&mergeOnly, "merge-only", false, "Only merge new config, don't overwrite existing")
+	flag.BoolVar(&mergeOnly, "merge-only", false, "Add the osmmcp entry to an existing client config instead of overwriting it")
+	flag.StringVar(&transport, "transport", "stdio", "MCP transport: stdio, sse or http")
+	flag.StringVar(&listenAddr, "listen", server.DefaultAddr, "Listen address for the sse and http transports")
+	flag.StringVar(&adminAddr, "admin-listen", "", "Listen address of the admin endpoint serving Prometheus metrics at /metrics (disabled if empty)")
+	flag.StringVar(&configFile, "config", "", "Path to a YAML or JSON config file, reloaded on SIGHUP (default $"+config.EnvConfigFile+")")
+
+	// Upstream endpoints
+	flag.StringVar(&nominatimURL, "nominatim-url", "", "Nominatim base URL, optionally followed by comma-separated mirrors (default "+osm.NominatimBaseURL+")")
+	flag.StringVar(&overpassURL, "overpass-url", "", "Overpass interpreter URL, optionally followed by comma-separated mirrors (default "+osm.OverpassBaseURL+")")
+	flag.StringVar(&osrmURL, "osrm-url", "", "OSRM base URL, optionally followed by comma-separated mirrors (default "+osm.OSRMBaseURL+")")
+
+	// Response cache
+	flag.StringVar(&cacheDir, "cache-dir", "", "Directory for the persistent response cache (default $"+config.EnvCacheDir+", disabled if empty)")
+	flag.IntVar(&cacheMaxSizeMB, "cache-max-size", config.DefaultCacheMaxSizeMB, "Maximum size of the persistent response cache in MB")
+
+	// Offline mode
+	flag.StringVar(&offlinePBF, "offline-pbf", "", "Answer feature searches, geocoding and routing from a local .osm.pbf extract instead of the public services (default $"+config.EnvOfflinePBF+")")
+
+	// Fixtures
+	flag.StringVar(&fixtures, "fixtures", "", "Record upstream exchanges to, or replay them from, the fixtures dir: record or replay (default $"+config.EnvFixtures+")")
+	flag.StringVar(&fixturesDir, "fixtures-dir", "", "Directory of recorded upstream exchanges (default $"+config.EnvFixturesDir+")")
+
+	// Tracing
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "Export trace spans to this OTLP/HTTP collector URL (default $"+tracing.EnvOTLPTracesEndpoint+" or $"+tracing.EnvOTLPEndpoint+")")
+	flag.StringVar(&traceFile, "trace-file", "", "Append trace spans as JSON lines to this file (default $"+tracing.EnvTraceFile+")")
 
 	// Nominatim rate limits
-	flag.Float64Var(&nominatimRPS, "nominatim-rps", 1.0, "Nominatim rate limit in requests per second")
-	flag.IntVar(&nominatimBurst, "nominatim-burst", 1, "Nominatim rate limit burst size")
+	flag.Float64Var(&nominatimRPS, "nominatim-rps", osm.DefaultRPS, "Nominatim rate limit in requests per second")
+	flag.IntVar(&nominatimBurst, "nominatim-burst", osm.DefaultBurst, "Nominatim rate limit burst size")
 
 	// Overpass rate limits
-	flag.Float64Var(&overpassRPS, "overpass-rps", 1.0, "Overpass rate limit in requests per second")
-	flag.IntVar(&overpassBurst, "overpass-burst", 1, "Overpass rate limit burst size")
+	flag.Float64Var(&overpassRPS, "overpass-rps", osm.DefaultRPS, "Overpass rate limit in requests per second")
+	flag.IntVar(&overpassBurst, "overpass-burst", osm.DefaultBurst, "Overpass rate limit burst size")
 
 	// OSRM rate limits
-	flag.Float64Var(&osrmRPS, "osrm-rps", 1.0, "OSRM rate limit in requests per second")
-	flag.IntVar(&osrmBurst, "osrm-burst", 1, "OSRM rate limit burst size")
+	flag.Float64Var(&osrmRPS, "osrm-rps", osm.DefaultRPS, "OSRM rate limit in requests per second")
+	flag.IntVar(&osrmBurst, "osrm-burst", osm.DefaultBurst, "OSRM rate limit burst size")
 }
 
 func main() {
 	flag.Parse()
 
-	// Configure logging
-	var logLevel slog.Level
+	// Configure logging; the config file may change the level and format
+	logLevel := new(slog.LevelVar)
 	if debug {
-		logLevel = slog.LevelDebug
-	} else {
-		logLevel = slog.LevelInfo
+		logLevel.Set(slog.LevelDebug)
 	}
 
 	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
@@ -80,48 +133,65 @@ func main() {
 		return
 	}
 
-	// Generate Claude Desktop config if requested
+	// Generate an MCP client config if requested
 	if generateConfig != "" {
-		if err := generateClientConfig(generateConfig, mergeOnly); err != nil {
+		if err := generateClientConfig(generateConfig, clientName, mergeOnly); err != nil {
 			logger.Error("failed to generate config", "error", err)
 			os.Exit(1)
 		}
-		logger.Info("successfully generated Claude Desktop Client config", "path", generateConfig)
+		logger.Info("successfully generated MCP client config", "client", clientName, "path", generateConfig)
 		return
 	}
 
-	// Update global user agent if specified
-	if userAgent != osm.UserAgent {
-		osm.SetUserAgent(userAgent)
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
 	}
 
-	// Update rate limits if specified
-	if nominatimRPS != 1.0 || nominatimBurst != 1 {
-		osm.UpdateNominatimRateLimits(nominatimRPS, nominatimBurst)
+	logger = newLogger(cfg, logLevel)
+	slog.SetDefault(logger)
+
+	if err := cfg.Apply(); err != nil {
+		logger.Error("invalid upstream configuration", "error", err)
+		os.Exit(1)
 	}
-	if overpassRPS != 1.0 || overpassBurst != 1 {
-		osm.UpdateOverpassRateLimits(overpassRPS, overpassBurst)
+
+	mcpTransport, err := server.ParseTransport(cfg.Transport.Type)
+	if err != nil {
+		logger.Error("invalid transport", "error", err)
+		os.Exit(1)
 	}
-	if osrmRPS != 1.0 || osrmBurst != 1 {
-		osm.UpdateOSRMRateLimits(osrmRPS, osrmBurst)
+
+	// Update global user agent if specified
+	if userAgent != osm.UserAgent {
+		osm.SetUserAgent(userAgent)
 	}
 
 	logger.Info("starting OpenStreetMap MCP server",
 		"version", buildVersion,
-		"log_level", logLevel.String(),
+		"log_level", logLevel.Level().String(),
+		"transport", mcpTransport,
+		"admin_addr", cfg.Transport.AdminListen,
 		"user_agent", userAgent,
-		"nominatim_rps", nominatimRPS,
-		"nominatim_burst", nominatimBurst,
-		"overpass_rps", overpassRPS,
-		"overpass_burst", overpassBurst,
-		"osrm_rps", osrmRPS,
-		"osrm_burst", osrmBurst)
+		"nominatim_urls", osm.BaseURLs(osm.ServiceNominatim),
+		"overpass_urls", osm.BaseURLs(osm.ServiceOverpass),
+		"osrm_urls", osm.BaseURLs(osm.ServiceOSRM),
+		"rate_limits", osm.GetRateLimiter().State(),
+		"cache_dir", cfg.Cache.Dir,
+		"offline_pbf", cfg.Offline.PBF,
+		"fixtures", cfg.Fixtures.Mode)
 
 	// Debug print to stderr to help diagnose MCP initialization issues
 	fmt.Fprintf(os.Stderr, "DEBUG: Creating new server instance\n")
 
 	// Create a new server instance
-	s, err := server.NewServer()
+	s, err := server.NewServer(
+		server.WithTransport(mcpTransport),
+		server.WithAddr(cfg.Transport.Listen),
+		server.WithAdminAddr(cfg.Transport.AdminListen),
+		server.WithRegistryOptions(cfg.ToolOptions()...),
+	)
 	if err != nil {
 		logger.Error("failed to create server", "error", err)
 		os.Exit(1)
@@ -133,6 +203,43 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Reload the config file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if next, err := reloadConfig(s, cfg, logLevel); errors.Is(err, config.ErrPartlyApplied) {
+					slog.Error("config reload failed after changing some upstream settings; fix the config and reload again", "error", err)
+				} else if err != nil {
+					slog.Error("config reload failed, keeping the current settings", "error", err)
+				} else {
+					cfg = next
+				}
+			}
+		}
+	}()
+
+	traceOpts := tracing.Options{
+		ServiceName:    "osmmcp",
+		ServiceVersion: buildVersion,
+		OTLPEndpoint:   otlpEndpoint,
+		File:           traceFile,
+	}
+	traceOpts.ApplyEnv(os.Getenv)
+	shutdownTracing, err := tracing.Setup(ctx, traceOpts)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if traceOpts.Enabled() {
+		logger.Info("exporting trace spans", "otlp_endpoint", traceOpts.OTLPEndpoint, "file", traceOpts.File)
+	}
+
 	// Run the MCP server with context
 	fmt.Fprintf(os.Stderr, "DEBUG: Starting MCP server\n")
 	if err := s.RunWithContext(ctx); err != nil {
@@ -140,12 +247,223 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Flush the spans of the last calls
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("failed to flush trace spans", "error", err)
+	}
+	cancelFlush()
+
+	// Release the response cache backend
+	if backend := osm.SetResponseCache(nil); backend != nil {
+		if err := backend.Close(); err != nil {
+			logger.Error("failed to close response cache", "error", err)
+		}
+	}
+
+	// Release the default client and end the cleanup of its cache store
+	osm.Default().Close()
+	cache.Default().Stop()
+
 	// Server has shut down gracefully
 	logger.Info("server stopped")
 }
 
-// generateClientConfig generates a configuration file for the Claude Desktop Client
-func generateClientConfig(path string, mergeOnly bool) error {
+// newLogger creates the logger selected by the config. The level is
+// taken from the config unless -debug was given, and stays adjustable
+// through level.
+func newLogger(cfg *config.Config, level *slog.LevelVar) *slog.Logger {
+	if !debug {
+		// Validate has checked the level
+		l, _ := cfg.LogLevel()
+		level.Set(l)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Logging.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// reloadConfig reads the config again and applies it to the running
+// server without closing any session. The transport and the log format
+// only change on restart. It returns the config now in effect.
+func reloadConfig(s *server.Server, prev *config.Config, level *slog.LevelVar) (*config.Config, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyChanges(prev); err != nil {
+		return nil, err
+	}
+	s.Reconfigure(cfg.ToolOptions()...)
+	if !debug {
+		l, _ := cfg.LogLevel()
+		level.Set(l)
+	}
+
+	if cfg.Transport != prev.Transport {
+		slog.Warn("transport settings changed; they take effect after a restart")
+	}
+	if cfg.Logging.Format != prev.Logging.Format {
+		slog.Warn("logging format changed; it takes effect after a restart")
+	}
+	slog.Info("config reloaded",
+		"log_level", level.Level().String(),
+		"nominatim_urls", osm.BaseURLs(osm.ServiceNominatim),
+		"overpass_urls", osm.BaseURLs(osm.ServiceOverpass),
+		"osrm_urls", osm.BaseURLs(osm.ServiceOSRM),
+		"rate_limits", osm.GetRateLimiter().State(),
+		"cache_dir", cfg.Cache.Dir,
+		"offline_pbf", cfg.Offline.PBF)
+	return cfg, nil
+}
+
+// loadConfig reads the config file and merges the settings of the
+// environment and the command line into it. Later sources win: built-in
+// defaults, the config file, environment variables, then flags. Auth
+// headers are only read from the config file and the environment so that
+// credentials do not show up in process listings.
+func loadConfig() (*config.Config, error) {
+	path := configFile
+	if path == "" {
+		path = os.Getenv(config.EnvConfigFile)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+
+	for service, u := range map[string]string{
+		osm.ServiceNominatim: nominatimURL,
+		osm.ServiceOverpass:  overpassURL,
+		osm.ServiceOSRM:      osrmURL,
+	} {
+		if u != "" {
+			cfg.SetURL(service, u)
+		}
+	}
+
+	// Only flags given on the command line override the config file
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if setFlags["transport"] || cfg.Transport.Type == "" {
+		cfg.Transport.Type = transport
+	}
+	if setFlags["listen"] || cfg.Transport.Listen == "" {
+		cfg.Transport.Listen = listenAddr
+	}
+	if adminAddr != "" {
+		cfg.Transport.AdminListen = adminAddr
+	}
+	if cacheDir != "" {
+		cfg.Cache.Dir = cacheDir
+	}
+	if setFlags["cache-max-size"] {
+		cfg.Cache.MaxSizeMB = cacheMaxSizeMB
+	}
+	if offlinePBF != "" {
+		cfg.Offline.PBF = offlinePBF
+	}
+	if fixtures != "" {
+		cfg.Fixtures.Mode = fixtures
+	}
+	if fixturesDir != "" {
+		cfg.Fixtures.Dir = fixturesDir
+	}
+
+	for service, limit := range map[string]struct {
+		rps   float64
+		burst int
+	}{
+		osm.ServiceNominatim: {nominatimRPS, nominatimBurst},
+		osm.ServiceOverpass:  {overpassRPS, overpassBurst},
+		osm.ServiceOSRM:      {osrmRPS, osrmBurst},
+	} {
+		rpsSet, burstSet := setFlags[service+"-rps"], setFlags[service+"-burst"]
+		if !rpsSet && !burstSet {
+			continue
+		}
+
+		rl, ok := cfg.RateLimits[service]
+		if !ok {
+			rl = config.RateLimitConfig{RPS: osm.DefaultRPS, Burst: osm.DefaultBurst}
+		}
+		if rpsSet {
+			rl.RPS = limit.rps
+		}
+		if burstSet {
+			rl.Burst = limit.burst
+		}
+		cfg.SetRateLimit(service, rl.RPS, rl.Burst)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// MCP clients -generate-config writes entries for
+const (
+	clientClaude = "claude"
+	clientCursor = "cursor"
+	clientVSCode = "vscode"
+)
+
+// serverEntryName is the name of the osmmcp entry in client configs
+const serverEntryName = "osmmcp"
+
+// clientServerEntry returns the key holding the MCP servers in the config
+// of a client and the entry that starts osmmcp over stdio. Claude Desktop
+// and Cursor list servers under mcpServers; VS Code lists them under
+// servers and names their type.
+func clientServerEntry(client, command string, args []string) (string, map[string]interface{}, error) {
+	entry := map[string]interface{}{
+		"command": command,
+		"args":    args,
+	}
+	switch client {
+	case clientClaude, clientCursor:
+		return "mcpServers", entry, nil
+	case clientVSCode:
+		entry["type"] = "stdio"
+		return "servers", entry, nil
+	}
+	return "", nil, fmt.Errorf("unknown client %q (must be %s, %s or %s)", client, clientClaude, clientCursor, clientVSCode)
+}
+
+// serverArgs returns the arguments the client starts osmmcp with. The
+// config file is passed on as an absolute path, since clients start
+// servers from a directory of their own.
+func serverArgs() ([]string, error) {
+	args := []string{}
+	path := configFile
+	if path == "" {
+		path = os.Getenv(config.EnvConfigFile)
+	}
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-config", abs)
+	}
+	return args, nil
+}
+
+// generateClientConfig writes the config file of an MCP client with an
+// entry starting this osmmcp binary. With mergeOnly the entry is added to
+// an existing file, keeping its other servers and settings.
+func generateClientConfig(path, client string, mergeOnly bool) error {
 	// Sanity check the path
 	if path == "" {
 		return fmt.Errorf("config path cannot be empty")
@@ -154,10 +472,26 @@ func generateClientConfig(path string, mergeOnly bool) error {
 		return fmt.Errorf("config file must have .json extension")
 	}
 
-	// Clean the path and check for path traversal attempts
+	// Clean the path and check for path traversal attempts. Absolute
+	// paths are allowed since client configs live in the user's home.
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return fmt.Errorf("refusing to traverse outside workspace")
+		}
+	}
 	cleanPath := filepath.Clean(path)
-	if strings.Contains(cleanPath, "..") || filepath.IsAbs(cleanPath) {
-		return fmt.Errorf("refusing to traverse outside workspace")
+
+	command, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the osmmcp binary: %w", err)
+	}
+	args, err := serverArgs()
+	if err != nil {
+		return fmt.Errorf("failed to resolve the config path: %w", err)
+	}
+	key, entry, err := clientServerEntry(client, command, args)
+	if err != nil {
+		return err
 	}
 
 	// Create config directory if it doesn't exist
@@ -167,38 +501,27 @@ func generateClientConfig(path string, mergeOnly bool) error {
 	}
 
 	// Read existing config if it exists and mergeOnly is true
-	var existingConfig map[string]interface{}
+	clientConfig := map[string]interface{}{}
 	if mergeOnly {
 		if data, err := os.ReadFile(cleanPath); err == nil {
-			if err := json.Unmarshal(data, &existingConfig); err != nil {
+			if err := json.Unmarshal(data, &clientConfig); err != nil {
 				return fmt.Errorf("failed to parse existing config: %w", err)
 			}
 		}
 	}
 
-	// Create new config
-	config := map[string]interface{}{
-		"claude": map[string]interface{}{
-			"api_key": os.Getenv("CLAUDE_API_KEY"),
-			"model":   "claude-3-opus-20240229",
-		},
-		"server": map[string]interface{}{
-			"host": "localhost",
-			"port": 8080,
-		},
-	}
-
-	// Merge with existing config if needed
-	if mergeOnly && existingConfig != nil {
-		for k, v := range existingConfig {
-			if _, exists := config[k]; !exists {
-				config[k] = v
-			}
+	servers, ok := clientConfig[key].(map[string]interface{})
+	if !ok {
+		if _, exists := clientConfig[key]; exists {
+			return fmt.Errorf("existing config has a %q entry that is not an object", key)
 		}
+		servers = map[string]interface{}{}
 	}
+	servers[serverEntryName] = entry
+	clientConfig[key] = servers
 
 	// Write config file
-	data, err := json.MarshalIndent(config, "", "  ")
+	data, err := json.MarshalIndent(clientConfig, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
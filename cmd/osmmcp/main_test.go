@@ -28,6 +28,7 @@ func TestGenerateClientConfig(t *testing.T) {
 	tests := []struct {
 		name      string
 		path      string
+		client    string
 		mergeOnly bool
 		wantErr   bool
 	}{
@@ -37,6 +38,22 @@ func TestGenerateClientConfig(t *testing.T) {
 			mergeOnly: false,
 			wantErr:   false,
 		},
+		{
+			name:   "cursor",
+			path:   filepath.Join(".cursor", "mcp.json"),
+			client: clientCursor,
+		},
+		{
+			name:   "vscode",
+			path:   filepath.Join(".vscode", "mcp.json"),
+			client: clientVSCode,
+		},
+		{
+			name:    "unknown client",
+			path:    "unknown.json",
+			client:  "emacs",
+			wantErr: true,
+		},
 		{
 			name:      "empty path",
 			path:      "",
@@ -69,6 +86,9 @@ func TestGenerateClientConfig(t *testing.T) {
 			if tt.name == "merge with existing" {
 				existing := map[string]interface{}{
 					"existing_key": "existing_value",
+					"mcpServers": map[string]interface{}{
+						"other": map[string]interface{}{"command": "other-server"},
+					},
 				}
 				data, err := json.Marshal(existing)
 				if err != nil {
@@ -79,7 +99,11 @@ func TestGenerateClientConfig(t *testing.T) {
 				}
 			}
 
-			err := generateClientConfig(tt.path, tt.mergeOnly)
+			client := tt.client
+			if client == "" {
+				client = clientClaude
+			}
+			err := generateClientConfig(tt.path, client, tt.mergeOnly)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("generateClientConfig() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -105,12 +129,24 @@ func TestGenerateClientConfig(t *testing.T) {
 					t.Errorf("Failed to parse config JSON: %v", err)
 				}
 
-				// Check required fields
-				if _, ok := config["claude"]; !ok {
-					t.Error("Config missing 'claude' section")
+				// Check the server entry
+				key := "mcpServers"
+				if client == clientVSCode {
+					key = "servers"
+				}
+				servers, _ := config[key].(map[string]interface{})
+				entry, ok := servers[serverEntryName].(map[string]interface{})
+				if !ok {
+					t.Fatalf("Config missing %s.%s entry: %s", key, serverEntryName, data)
+				}
+				if command, _ := entry["command"].(string); !filepath.IsAbs(command) {
+					t.Errorf("command = %v, want the absolute path of the binary", entry["command"])
 				}
-				if _, ok := config["server"]; !ok {
-					t.Error("Config missing 'server' section")
+				if _, ok := entry["args"].([]interface{}); !ok {
+					t.Errorf("args = %v, want a list", entry["args"])
+				}
+				if client == clientVSCode && entry["type"] != "stdio" {
+					t.Errorf("type = %v, want stdio", entry["type"])
 				}
 
 				// Check merged content for merge test
@@ -118,6 +154,9 @@ func TestGenerateClientConfig(t *testing.T) {
 					if val, ok := config["existing_key"]; !ok || val != "existing_value" {
 						t.Error("Merge failed to preserve existing content")
 					}
+					if _, ok := servers["other"]; !ok {
+						t.Error("Merge failed to preserve the other servers")
+					}
 				}
 			}
 		})
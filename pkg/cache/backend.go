@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"time"
+)
+
+// Backend stores raw cache entries. Implementations must be safe for
+// concurrent use.
+type Backend interface {
+	// Get returns the value stored under key if it has not expired
+	Get(key string) ([]byte, bool)
+
+	// Set stores value under key for ttl; a ttl of 0 never expires
+	Set(key string, value []byte, ttl time.Duration)
+
+	// Delete removes the value stored under key
+	Delete(key string)
+
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// MemoryBackend is a Backend that keeps entries in a Namespace. Entries
+// are lost when the process exits.
+type MemoryBackend struct {
+	ns *Namespace[[]byte]
+}
+
+// NewMemoryBackend creates an in-memory backend in the namespace name of
+// s, holding at most maxItems entries
+func NewMemoryBackend(s *Store, name string, maxItems int) *MemoryBackend {
+	return &MemoryBackend{ns: NewNamespace[[]byte](s, name, 0, maxItems)}
+}
+
+// Get implements Backend
+func (b *MemoryBackend) Get(key string) ([]byte, bool) {
+	return b.ns.Get(key)
+}
+
+// Set implements Backend
+func (b *MemoryBackend) Set(key string, value []byte, ttl time.Duration) {
+	b.ns.SetWithTTL(key, value, ttl)
+}
+
+// Delete implements Backend
+func (b *MemoryBackend) Delete(key string) {
+	b.ns.Delete(key)
+}
+
+// Close implements Backend. The entries stay in the namespace.
+func (b *MemoryBackend) Close() error {
+	return nil
+}
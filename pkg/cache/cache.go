@@ -3,210 +3,296 @@
 package cache
 
 import (
-	"math"
+	"container/list"
+	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Item represents a cached item with expiration
-type Item struct {
-	Value      interface{}
-	Expiration int64
+// DefaultCleanupInterval is how often the default store removes expired entries
+const DefaultCleanupInterval = time.Minute
+
+// Stats describes the contents and effectiveness of one namespace
+type Stats struct {
+	Namespace   string `json:"namespace"`
+	Size        int    `json:"size"`
+	Capacity    int    `json:"capacity"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`   // Entries dropped to make room
+	Expirations uint64 `json:"expirations"` // Entries dropped because their TTL passed
 }
 
-// Expired checks if the item has expired
-func (item Item) Expired() bool {
-	if item.Expiration == 0 {
-		return false
-	}
-	return time.Now().UnixNano() > item.Expiration
+// namespace is the untyped view of a Namespace that the Store manages
+type namespace interface {
+	stats() Stats
+	deleteExpired(now time.Time)
 }
 
-// TTLCache is a thread-safe cache with time-based expiration
-type TTLCache struct {
-	items           map[string]Item
-	mu              sync.RWMutex
-	defaultTTL      time.Duration
-	cleanupInterval time.Duration
-	maxItems        int
-	stopCleanup     chan bool
+// Store groups the namespaces of a process. It reports their statistics
+// and removes expired entries in the background until it is stopped.
+type Store struct {
+	mu         sync.Mutex
+	namespaces map[string]namespace
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-// NewTTLCache creates a new cache with the specified TTL and cleanup interval
-// maxItems specifies the maximum number of items before oldest are evicted
-func NewTTLCache(defaultTTL, cleanupInterval time.Duration, maxItems int) *TTLCache {
-	cache := &TTLCache{
-		items:           make(map[string]Item),
-		defaultTTL:      defaultTTL,
-		cleanupInterval: cleanupInterval,
-		maxItems:        maxItems,
-		stopCleanup:     make(chan bool),
+// NewStore creates a store that removes expired entries every
+// cleanupInterval. A zero interval disables the background cleanup;
+// expired entries are then only dropped when they are read.
+func NewStore(cleanupInterval time.Duration) *Store {
+	s := &Store{
+		namespaces: make(map[string]namespace),
+		stop:       make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go s.cleanup(cleanupInterval)
 	}
+	return s
+}
 
-	// Start the cleanup process
-	cache.startCleanupTimer()
+var (
+	defaultStore     *Store
+	defaultStoreOnce sync.Once
+)
 
-	return cache
+// Default returns the store shared by all tools
+func Default() *Store {
+	defaultStoreOnce.Do(func() {
+		defaultStore = NewStore(DefaultCleanupInterval)
+	})
+	return defaultStore
 }
 
-// Set adds an item to the cache with the default TTL
-func (c *TTLCache) Set(key string, value interface{}) {
-	c.SetWithTTL(key, value, c.defaultTTL)
+// Stop ends the background cleanup. The namespaces stay usable. Stop may
+// be called more than once.
+func (s *Store) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
 }
 
-// SetWithTTL adds an item to the cache with a specific TTL
-func (c *TTLCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
-	var expiration int64
-
-	if ttl > 0 {
-		expiration = time.Now().Add(ttl).UnixNano()
+// Stopped reports whether Stop was called
+func (s *Store) Stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
 	}
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.items[key] = Item{
-		Value:      value,
-		Expiration: expiration,
+// Stats returns the statistics of every namespace, sorted by name
+func (s *Store) Stats() []Stats {
+	s.mu.Lock()
+	namespaces := make([]namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
 	}
+	s.mu.Unlock()
 
-	// If we're over capacity, remove oldest items
-	if c.maxItems > 0 && len(c.items) > c.maxItems {
-		c.evictOldest()
+	stats := make([]Stats, 0, len(namespaces))
+	for _, ns := range namespaces {
+		stats = append(stats, ns.stats())
 	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Namespace < stats[j].Namespace
+	})
+	return stats
 }
 
-// Get retrieves an item from the cache
-// Returns the item and a bool indicating if the item was found
-func (c *TTLCache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	item, found := c.items[key]
-	c.mu.RUnlock()
+// cleanup periodically drops expired entries from every namespace
+func (s *Store) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			namespaces := make([]namespace, 0, len(s.namespaces))
+			for _, ns := range s.namespaces {
+				namespaces = append(namespaces, ns)
+			}
+			s.mu.Unlock()
 
-	if !found {
-		return nil, false
+			for _, ns := range namespaces {
+				ns.deleteExpired(now)
+			}
+		case <-s.stop:
+			return
+		}
 	}
+}
 
-	// Check if the item has expired
-	if item.Expired() {
-		c.mu.Lock()
-		delete(c.items, key)
-		c.mu.Unlock()
-		return nil, false
-	}
+// Namespace is a typed LRU cache with per-entry expiry, registered in a
+// Store under a unique name
+type Namespace[V any] struct {
+	name     string
+	ttl      time.Duration
+	capacity int
 
-	return item.Value, true
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+
+	hits, misses, evictions, expirations atomic.Uint64
+}
+
+// entry is one cached value
+type entry[V any] struct {
+	key     string
+	value   V
+	expires time.Time // zero means never
 }
 
-// Delete removes an item from the cache
-func (c *TTLCache) Delete(key string) {
-	c.mu.Lock()
-	delete(c.items, key)
-	c.mu.Unlock()
+// NewNamespace registers a namespace in s. Entries live for ttl (0 means
+// until evicted) and at most capacity entries are kept (0 means no
+// limit). Registering a name twice returns the existing namespace if the
+// value types match, and panics otherwise.
+func NewNamespace[V any](s *Store, name string, ttl time.Duration, capacity int) *Namespace[V] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.namespaces[name]; ok {
+		ns, ok := existing.(*Namespace[V])
+		if !ok {
+			panic(fmt.Sprintf("cache: namespace %q already registered with a different type", name))
+		}
+		return ns
+	}
+
+	ns := &Namespace[V]{
+		name:     name,
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+	s.namespaces[name] = ns
+	return ns
 }
 
-// Count returns the number of items in the cache
-func (c *TTLCache) Count() int {
-	c.mu.RLock()
-	count := len(c.items)
-	c.mu.RUnlock()
-	return count
+// Get returns the value stored under key if it has not expired
+func (n *Namespace[V]) Get(key string) (V, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var zero V
+	el, ok := n.items[key]
+	if !ok {
+		n.misses.Add(1)
+		return zero, false
+	}
+
+	e := el.Value.(*entry[V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		n.removeElement(el)
+		n.expirations.Add(1)
+		n.misses.Add(1)
+		return zero, false
+	}
+
+	n.order.MoveToFront(el)
+	n.hits.Add(1)
+	return e.value, true
 }
 
-// Clear removes all items from the cache
-func (c *TTLCache) Clear() {
-	c.mu.Lock()
-	c.items = make(map[string]Item)
-	c.mu.Unlock()
+// Set stores value under key with the namespace TTL
+func (n *Namespace[V]) Set(key string, value V) {
+	n.SetWithTTL(key, value, n.ttl)
 }
 
-// evictOldest removes the oldest items when cache exceeds maxItems
-// This function assumes the lock is already held
-func (c *TTLCache) evictOldest() {
-	// Create a slice of keys and their expiration times
-	type keyExpiration struct {
-		key        string
-		expiration int64
+// SetWithTTL stores value under key for ttl; 0 means until evicted
+func (n *Namespace[V]) SetWithTTL(key string, value V, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
 	}
 
-	// Calculate how many items to remove
-	itemsToRemove := len(c.items) - c.maxItems
-	if itemsToRemove <= 0 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if el, ok := n.items[key]; ok {
+		e := el.Value.(*entry[V])
+		e.value = value
+		e.expires = expires
+		n.order.MoveToFront(el)
 		return
 	}
 
-	// Collect all key expirations
-	keyExpirations := make([]keyExpiration, 0, len(c.items))
-	for k, v := range c.items {
-		// Use MaxInt64 for items without expiration to treat them as lowest eviction priority
-		exp := v.Expiration
-		if exp == 0 {
-			exp = math.MaxInt64
-		}
-		keyExpirations = append(keyExpirations, keyExpiration{k, exp})
+	n.items[key] = n.order.PushFront(&entry[V]{key: key, value: value, expires: expires})
+	for n.capacity > 0 && n.order.Len() > n.capacity {
+		n.removeElement(n.order.Back())
+		n.evictions.Add(1)
 	}
+}
 
-	// Sort by expiration time (oldest first)
-	sort.Slice(keyExpirations, func(i, j int) bool {
-		return keyExpirations[i].expiration < keyExpirations[j].expiration
-	})
+// Delete removes the value stored under key
+func (n *Namespace[V]) Delete(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-	// Delete the oldest items
-	for i := 0; i < itemsToRemove; i++ {
-		delete(c.items, keyExpirations[i].key)
+	if el, ok := n.items[key]; ok {
+		n.removeElement(el)
 	}
 }
 
-// startCleanupTimer starts the cleanup timer
-func (c *TTLCache) startCleanupTimer() {
-	if c.cleanupInterval <= 0 {
-		return
-	}
+// Clear removes every entry
+func (n *Namespace[V]) Clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-	ticker := time.NewTicker(c.cleanupInterval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.deleteExpired()
-			case <-c.stopCleanup:
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+	n.order.Init()
+	n.items = make(map[string]*list.Element)
 }
 
-// deleteExpired deletes all expired items
-func (c *TTLCache) deleteExpired() {
-	now := time.Now().UnixNano()
+// Len returns the number of entries, including expired ones not yet removed
+func (n *Namespace[V]) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.order.Len()
+}
 
-	c.mu.Lock()
-	for k, v := range c.items {
-		if v.Expiration > 0 && v.Expiration < now {
-			delete(c.items, k)
-		}
-	}
-	c.mu.Unlock()
+// Stats returns the statistics of the namespace
+func (n *Namespace[V]) Stats() Stats {
+	return n.stats()
 }
 
-// Stop stops the cleanup timer
-func (c *TTLCache) Stop() {
-	close(c.stopCleanup)
+// stats implements namespace
+func (n *Namespace[V]) stats() Stats {
+	return Stats{
+		Namespace:   n.name,
+		Size:        n.Len(),
+		Capacity:    n.capacity,
+		Hits:        n.hits.Load(),
+		Misses:      n.misses.Load(),
+		Evictions:   n.evictions.Load(),
+		Expirations: n.expirations.Load(),
+	}
 }
 
-// Global cache instance
-var (
-	globalCache     *TTLCache
-	globalCacheOnce sync.Once
-)
+// deleteExpired implements namespace
+func (n *Namespace[V]) deleteExpired(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-// GetGlobalCache returns the global cache instance
-func GetGlobalCache() *TTLCache {
-	globalCacheOnce.Do(func() {
-		// 5 minute TTL, cleanup every minute, max 1000 items
-		globalCache = NewTTLCache(5*time.Minute, time.Minute, 1000)
-	})
-	return globalCache
+	for el := n.order.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*entry[V]); !e.expires.IsZero() && now.After(e.expires) {
+			n.removeElement(el)
+			n.expirations.Add(1)
+		}
+		el = prev
+	}
+}
+
+// removeElement unlinks an entry. The caller must hold n.mu.
+func (n *Namespace[V]) removeElement(el *list.Element) {
+	n.order.Remove(el)
+	delete(n.items, el.Value.(*entry[V]).key)
 }
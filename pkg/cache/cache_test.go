@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceLRU(t *testing.T) {
+	s := NewStore(0)
+	ns := NewNamespace[int](s, "numbers", 0, 2)
+
+	ns.Set("a", 1)
+	ns.Set("b", 2)
+	ns.Get("a") // b is now least recently used
+	ns.Set("c", 3)
+
+	if _, ok := ns.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok := ns.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %v, %v, want 1", v, ok)
+	}
+
+	stats := ns.Stats()
+	want := Stats{Namespace: "numbers", Size: 2, Capacity: 2, Hits: 2, Misses: 1, Evictions: 1}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestNamespaceTTL(t *testing.T) {
+	s := NewStore(0)
+	ns := NewNamespace[string](s, "short", time.Millisecond, 0)
+
+	ns.Set("k", "v")
+	ns.SetWithTTL("forever", "v", 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := ns.Get("k"); ok {
+		t.Error("Get() returned an expired entry")
+	}
+	if _, ok := ns.Get("forever"); !ok {
+		t.Error("entry without TTL expired")
+	}
+	if got := ns.Stats().Expirations; got != 1 {
+		t.Errorf("Expirations = %d, want 1", got)
+	}
+}
+
+func TestStoreCleanup(t *testing.T) {
+	s := NewStore(5 * time.Millisecond)
+	defer s.Stop()
+	ns := NewNamespace[int](s, "cleaned", time.Millisecond, 0)
+	ns.Set("k", 1)
+
+	deadline := time.Now().Add(time.Second)
+	for ns.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ns.Len() != 0 {
+		t.Error("background cleanup did not remove the expired entry")
+	}
+
+	s.Stop()
+	s.Stop() // must not panic
+}
+
+func TestNewNamespaceRegistration(t *testing.T) {
+	s := NewStore(0)
+	a := NewNamespace[int](s, "shared", 0, 0)
+	if b := NewNamespace[int](s, "shared", 0, 0); a != b {
+		t.Error("registering a name twice created a second namespace")
+	}
+	NewNamespace[string](s, "other", 0, 0)
+
+	stats := s.Stats()
+	if len(stats) != 2 || stats[0].Namespace != "other" || stats[1].Namespace != "shared" {
+		t.Errorf("Stats() = %+v, want other and shared in order", stats)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name with another type did not panic")
+		}
+	}()
+	NewNamespace[string](s, "shared", 0, 0)
+}
@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entryHeaderSize is the size of the expiry stamp that precedes every
+// value stored on disk
+const entryHeaderSize = 8
+
+// DiskBackend is a Backend that stores each entry in its own file, so
+// the cache survives restarts. When the files grow beyond the size cap
+// the least recently used entries are removed. Files are read and
+// written without holding the lock, which only guards the index.
+type DiskBackend struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	index map[string]*list.Element // keyed by file path, holding *diskEntry
+	lru   *list.List               // most recently used first
+	size  int64
+}
+
+// diskEntry is the bookkeeping for one file
+type diskEntry struct {
+	path string
+	size int64
+}
+
+// NewDiskBackend opens or creates a cache in dir holding at most maxBytes
+// of data. A maxBytes of 0 means no cap. Entries left by an earlier run
+// are picked up, with their modification time as last use.
+func NewDiskBackend(dir string, maxBytes int64) (*DiskBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	b := &DiskBackend{
+		dir:      dir,
+		maxBytes: maxBytes,
+		index:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	type found struct {
+		entry    *diskEntry
+		lastUsed time.Time
+	}
+	var entries []found
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if isTempName(path) {
+			// Left over from an interrupted write
+			os.Remove(path)
+			return nil
+		}
+		if !isEntryName(path) {
+			// Never touch files the cache did not write
+			return nil
+		}
+		entries = append(entries, found{&diskEntry{path: path, size: info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan cache directory: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.After(entries[j].lastUsed)
+	})
+	for _, f := range entries {
+		b.index[f.entry.path] = b.lru.PushBack(f.entry)
+		b.size += f.entry.size
+	}
+
+	b.mu.Lock()
+	b.evict()
+	b.mu.Unlock()
+
+	slog.Debug("disk cache opened", "dir", dir, "entries", len(b.index), "bytes", b.size)
+	return b, nil
+}
+
+// path returns the file that stores key. Keys are hashed so that any
+// string is a valid file name.
+func (b *DiskBackend) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(b.dir, name[:2], name)
+}
+
+// isEntryName reports whether path has the form written by path()
+func isEntryName(path string) bool {
+	name := filepath.Base(path)
+	if len(name) != 2*sha256.Size || filepath.Base(filepath.Dir(path)) != name[:2] {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// isTempName reports whether path is a temporary file of writeTemp
+func isTempName(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, "entry-") && strings.HasSuffix(name, ".tmp") &&
+		len(filepath.Base(filepath.Dir(path))) == 2
+}
+
+// Get implements Backend
+func (b *DiskBackend) Get(key string) ([]byte, bool) {
+	path := b.path(key)
+
+	b.mu.Lock()
+	elem := b.index[path]
+	b.mu.Unlock()
+	if elem == nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+
+	// An entry replaced or removed during the read is not ours to judge
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.index[path] != elem {
+		return nil, false
+	}
+
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Debug("disk cache read failed", "error", err)
+		}
+		b.forget(path)
+		return nil, false
+	}
+	if len(data) < entryHeaderSize {
+		b.remove(path)
+		return nil, false
+	}
+
+	expiry := int64(binary.BigEndian.Uint64(data[:entryHeaderSize]))
+	if expiry != 0 && time.Now().UnixNano() > expiry {
+		b.remove(path)
+		return nil, false
+	}
+
+	b.lru.MoveToFront(elem)
+	// The modification time carries the last use across restarts
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return data[entryHeaderSize:], true
+}
+
+// Set implements Backend
+func (b *DiskBackend) Set(key string, value []byte, ttl time.Duration) {
+	size := int64(entryHeaderSize + len(value))
+	if b.maxBytes > 0 && size > b.maxBytes {
+		return
+	}
+
+	var expiry int64
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl).UnixNano()
+	}
+	data := make([]byte, size)
+	binary.BigEndian.PutUint64(data, uint64(expiry))
+	copy(data[entryHeaderSize:], value)
+
+	path := b.path(key)
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		slog.Warn("disk cache write failed", "error", err)
+		return
+	}
+
+	// Renaming under the lock keeps the index in step with the files
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		slog.Warn("disk cache write failed", "error", err)
+		return
+	}
+
+	b.forget(path)
+	b.index[path] = b.lru.PushFront(&diskEntry{path: path, size: size})
+	b.size += size
+	b.evict()
+}
+
+// Delete implements Backend
+func (b *DiskBackend) Delete(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(b.path(key))
+}
+
+// Close implements Backend. Entries stay on disk for the next run.
+func (b *DiskBackend) Close() error {
+	return nil
+}
+
+// Size returns the number of bytes stored
+func (b *DiskBackend) Size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// forget drops a file from the index. The caller must hold b.mu.
+func (b *DiskBackend) forget(path string) {
+	if elem, ok := b.index[path]; ok {
+		b.size -= b.lru.Remove(elem).(*diskEntry).size
+		delete(b.index, path)
+	}
+}
+
+// remove deletes a file and drops it from the index. The caller must
+// hold b.mu.
+func (b *DiskBackend) remove(path string) {
+	os.Remove(path)
+	b.forget(path)
+}
+
+// evict removes the least recently used files until the cache fits its
+// size cap. The caller must hold b.mu.
+func (b *DiskBackend) evict() {
+	for b.maxBytes > 0 && b.size > b.maxBytes && b.lru.Len() > 0 {
+		b.remove(b.lru.Back().Value.(*diskEntry).path)
+	}
+}
+
+// writeTemp writes data to a temporary file next to path and returns its
+// name. Renaming it to path replaces the entry at once, so readers never
+// see a partial entry.
+func writeTemp(path string, data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "entry-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskBackendSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := NewDiskBackend(dir, 0)
+	if err != nil {
+		t.Fatalf("NewDiskBackend() error = %v", err)
+	}
+	b.Set("nominatim GET https://example.com/search?q=x", []byte(`[{"lat":"1"}]`), time.Hour)
+	b.Set("expired", []byte("old"), time.Nanosecond)
+	b.Close()
+
+	b, err = NewDiskBackend(dir, 0)
+	if err != nil {
+		t.Fatalf("NewDiskBackend() reopen error = %v", err)
+	}
+	got, ok := b.Get("nominatim GET https://example.com/search?q=x")
+	if !ok || !bytes.Equal(got, []byte(`[{"lat":"1"}]`)) {
+		t.Errorf("Get() after reopen = %q, %v", got, ok)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, ok := b.Get("expired"); ok {
+		t.Error("Get() returned an expired entry")
+	}
+
+	b.Delete("nominatim GET https://example.com/search?q=x")
+	if _, ok := b.Get("nominatim GET https://example.com/search?q=x"); ok {
+		t.Error("Get() returned a deleted entry")
+	}
+	if b.Size() != 0 {
+		t.Errorf("Size() = %d after removing every entry, want 0", b.Size())
+	}
+}
+
+func TestDiskBackendSizeCap(t *testing.T) {
+	value := bytes.Repeat([]byte("x"), 100)
+	entry := int64(entryHeaderSize + len(value))
+
+	b, err := NewDiskBackend(t.TempDir(), 3*entry)
+	if err != nil {
+		t.Fatalf("NewDiskBackend() error = %v", err)
+	}
+
+	b.Set("a", value, 0)
+	b.Set("b", value, 0)
+	b.Set("c", value, 0)
+	time.Sleep(10 * time.Millisecond)
+	b.Get("a") // a is now more recent than b
+	b.Set("d", value, 0)
+
+	if _, ok := b.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := b.Get(key); !ok {
+			t.Errorf("entry %q evicted, want kept", key)
+		}
+	}
+	if b.Size() > 3*entry {
+		t.Errorf("Size() = %d, want at most %d", b.Size(), 3*entry)
+	}
+
+	b.Set("huge", bytes.Repeat([]byte("x"), int(4*entry)), 0)
+	if _, ok := b.Get("huge"); ok {
+		t.Error("entry larger than the cap was stored")
+	}
+}
+
+func TestDiskBackendIgnoresForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "notes.txt")
+	os.WriteFile(foreign, bytes.Repeat([]byte("x"), 1000), 0o600)
+
+	b, err := NewDiskBackend(dir, 10)
+	if err != nil {
+		t.Fatalf("NewDiskBackend() error = %v", err)
+	}
+	if b.Size() != 0 {
+		t.Errorf("Size() = %d, want foreign files not counted", b.Size())
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("foreign file removed: %v", err)
+	}
+}
+
+func TestDiskBackendConcurrentUse(t *testing.T) {
+	value := bytes.Repeat([]byte("x"), 100)
+	entry := int64(entryHeaderSize + len(value))
+	b, err := NewDiskBackend(t.TempDir(), 8*entry)
+	if err != nil {
+		t.Fatalf("NewDiskBackend() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				key := fmt.Sprintf("k%d", (w+i)%12)
+				switch i % 3 {
+				case 0:
+					b.Set(key, value, 0)
+				case 1:
+					if got, ok := b.Get(key); ok && !bytes.Equal(got, value) {
+						t.Errorf("Get(%q) = %d bytes, want the stored value", key, len(got))
+					}
+				default:
+					b.Delete(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The index matches the files left behind
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var size int64
+	for path, elem := range b.index {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("indexed entry %s has no file: %v", path, err)
+			continue
+		}
+		if e := elem.Value.(*diskEntry); e.size != info.Size() {
+			t.Errorf("entry %s indexed with %d bytes, file has %d", path, e.size, info.Size())
+		}
+		size += info.Size()
+	}
+	if size != b.size || b.size > 8*entry || b.lru.Len() != len(b.index) {
+		t.Errorf("size = %d, indexed files = %d bytes, lru = %d entries for %d indexed, want consistent and at most %d bytes",
+			b.size, size, b.lru.Len(), len(b.index), 8*entry)
+	}
+}
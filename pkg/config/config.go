@@ -0,0 +1,652 @@
+// Package config loads the osmmcp server configuration from a YAML or
+// JSON file and the environment.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/offline"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/server"
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvConfigFile names the environment variable holding the config file path
+	EnvConfigFile = "OSMMCP_CONFIG"
+
+	// EnvCacheDir names the environment variable holding the cache directory
+	EnvCacheDir = "OSMMCP_CACHE_DIR"
+
+	// EnvOfflinePBF names the environment variable holding the path of
+	// the extract used in offline mode
+	EnvOfflinePBF = "OSMMCP_OFFLINE_PBF"
+
+	// EnvFixtures names the environment variable holding the fixture
+	// mode, record or replay
+	EnvFixtures = "OSMMCP_FIXTURES"
+
+	// EnvFixturesDir names the environment variable holding the fixture
+	// directory
+	EnvFixturesDir = "OSMMCP_FIXTURES_DIR"
+
+	// DefaultCacheMaxSizeMB caps the disk cache when no size is configured
+	DefaultCacheMaxSizeMB = 256
+)
+
+// EndpointConfig configures how one upstream service is reached
+type EndpointConfig struct {
+	// URL replaces the public base URL of the service
+	URL string `json:"url,omitempty"`
+
+	// Headers are sent with every request to the service, for example
+	// an Authorization header for a private instance
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Mirrors are tried in order when the primary URL fails
+	Mirrors []MirrorConfig `json:"mirrors,omitempty"`
+}
+
+// MirrorConfig configures one fallback instance of a service
+type MirrorConfig struct {
+	URL string `json:"url"`
+
+	// Headers are sent only to this mirror
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// RateLimitConfig sets the request rate allowed to one upstream service
+type RateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// CacheConfig configures the persistent response cache
+type CacheConfig struct {
+	// Dir enables the disk cache in this directory
+	Dir string `json:"dir,omitempty"`
+
+	// MaxSizeMB caps the disk cache; 0 means DefaultCacheMaxSizeMB
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+
+	// TTL is keyed by service name and overrides osm.DefaultCacheTTLs.
+	// A TTL of "0s" stops caching the service.
+	TTL map[string]Duration `json:"ttl,omitempty"`
+}
+
+// OfflineConfig configures offline mode
+type OfflineConfig struct {
+	// PBF is the path of a .osm.pbf extract. When set, feature searches
+	// are answered from the extract instead of Overpass.
+	PBF string `json:"pbf,omitempty"`
+}
+
+// FixturesConfig configures recording and replaying of upstream exchanges
+type FixturesConfig struct {
+	// Mode is "record", "replay" or empty for neither
+	Mode string `json:"mode,omitempty"`
+
+	// Dir holds one fixture file per recorded request
+	Dir string `json:"dir,omitempty"`
+}
+
+// ToolsConfig selects the tools the server offers
+type ToolsConfig struct {
+	// Enabled lists the only tools offered; empty offers all of them
+	Enabled []string `json:"enabled,omitempty"`
+
+	// Disabled lists tools that are never offered
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+// LoggingConfig configures the server log, which is written to stderr
+type LoggingConfig struct {
+	// Level is debug, info, warn or error; empty means info
+	Level string `json:"level,omitempty"`
+
+	// Format is text or json; empty means text. A changed format takes
+	// effect after a restart.
+	Format string `json:"format,omitempty"`
+}
+
+// TransportConfig selects how MCP clients reach the server. Changes take
+// effect after a restart.
+type TransportConfig struct {
+	// Type is stdio, sse or http; empty means stdio
+	Type string `json:"type,omitempty"`
+
+	// Listen is the address of the sse and http transports
+	Listen string `json:"listen,omitempty"`
+
+	// AdminListen is the address serving Prometheus metrics at /metrics;
+	// empty disables it
+	AdminListen string `json:"admin_listen,omitempty"`
+}
+
+// ErrPartlyApplied is wrapped by the error of an Apply or ApplyChanges
+// that failed after changing some settings. Any other error leaves the
+// settings as they were.
+var ErrPartlyApplied = errors.New("config partly applied")
+
+// Duration is a time.Duration written as a string such as "90m" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the server configuration
+type Config struct {
+	// Endpoints is keyed by service name: nominatim, overpass or osrm
+	Endpoints map[string]EndpointConfig `json:"endpoints,omitempty"`
+
+	// RateLimits is keyed by service name like Endpoints
+	RateLimits map[string]RateLimitConfig `json:"rate_limits,omitempty"`
+
+	// Cache configures the response cache
+	Cache CacheConfig `json:"cache,omitempty"`
+
+	// Offline configures the local data used instead of the public services
+	Offline OfflineConfig `json:"offline,omitempty"`
+
+	// Fixtures records upstream exchanges or replays recorded ones
+	Fixtures FixturesConfig `json:"fixtures,omitempty"`
+
+	// Tools selects the tools the server offers
+	Tools ToolsConfig `json:"tools,omitempty"`
+
+	// DefaultRegion is appended to ambiguous geocoding queries; nil means
+	// tools.DefaultRegion and an empty string appends nothing
+	DefaultRegion *string `json:"default_region,omitempty"`
+
+	// Units is metric or imperial and selects how tool summaries write
+	// distances; empty means metric
+	Units string `json:"units,omitempty"`
+
+	// Logging configures the server log
+	Logging LoggingConfig `json:"logging,omitempty"`
+
+	// Transport selects how MCP clients reach the server
+	Transport TransportConfig `json:"transport,omitempty"`
+}
+
+// Load reads a config file, which is YAML if its name ends in .yaml or
+// .yml and JSON otherwise. An empty path yields an empty config.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if isYAML(path) {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// isYAML reports whether path names a YAML file
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML document to JSON, so that YAML and JSON
+// files are decoded by the same rules. An empty document is an empty
+// object.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return []byte("{}"), nil
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported YAML: %w", err)
+	}
+	return out, nil
+}
+
+// Validate checks that every section names known services and tools and
+// that the rate limits, units, logging and transport settings are usable
+func (c *Config) Validate() error {
+	for service, ep := range c.Endpoints {
+		if !isService(service) {
+			return unknownServiceError("endpoints", service)
+		}
+		for i, m := range ep.Mirrors {
+			if m.URL == "" {
+				return fmt.Errorf("mirror %d of %s has no url", i+1, service)
+			}
+		}
+	}
+	for service, rl := range c.RateLimits {
+		if !isService(service) {
+			return unknownServiceError("rate_limits", service)
+		}
+		if rl.RPS <= 0 || rl.Burst < 1 {
+			return fmt.Errorf("invalid rate limit for %s: rps must be positive and burst at least 1", service)
+		}
+	}
+	if c.Cache.MaxSizeMB < 0 {
+		return fmt.Errorf("invalid cache max_size_mb: must not be negative")
+	}
+	for service, ttl := range c.Cache.TTL {
+		if !isService(service) {
+			return unknownServiceError("cache ttl", service)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("invalid cache ttl for %s: must not be negative", service)
+		}
+	}
+	mode, err := osm.ParseFixtureMode(c.Fixtures.Mode)
+	if err != nil {
+		return err
+	}
+	if mode != osm.FixturesOff && c.Fixtures.Dir == "" {
+		return fmt.Errorf("fixture mode %s needs a fixtures dir", mode)
+	}
+	if err := validateToolNames("tools enabled", c.Tools.Enabled); err != nil {
+		return err
+	}
+	if err := validateToolNames("tools disabled", c.Tools.Disabled); err != nil {
+		return err
+	}
+	if _, err := tools.ParseUnits(c.Units); err != nil {
+		return err
+	}
+	if _, err := c.LogLevel(); err != nil {
+		return err
+	}
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown logging format %q (must be text or json)", c.Logging.Format)
+	}
+	if _, err := server.ParseTransport(c.Transport.Type); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateToolNames checks that every name in a tool list is a tool
+func validateToolNames(section string, names []string) error {
+	known := make(map[string]bool)
+	for _, name := range tools.Names() {
+		known[name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("unknown tool %q in %s", name, section)
+		}
+	}
+	return nil
+}
+
+// LogLevel returns the configured log level, info if none is set
+func (c *Config) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if c.Logging.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		return 0, fmt.Errorf("unknown logging level %q (must be debug, info, warn or error)", c.Logging.Level)
+	}
+	return level, nil
+}
+
+// ToolOptions returns the registry options for the tools, default region
+// and units. Every setting is included, even when unset, so that the
+// options also undo settings removed from a reloaded config.
+func (c *Config) ToolOptions() []tools.RegistryOption {
+	region := tools.DefaultRegion
+	if c.DefaultRegion != nil {
+		region = *c.DefaultRegion
+	}
+	units, _ := tools.ParseUnits(c.Units)
+	return []tools.RegistryOption{
+		tools.WithEnabledTools(c.Tools.Enabled...),
+		tools.WithDisabledTools(c.Tools.Disabled...),
+		tools.WithDefaultRegion(region),
+		tools.WithUnits(units),
+	}
+}
+
+// unknownServiceError reports a service name that is not recognized
+func unknownServiceError(section, service string) error {
+	return fmt.Errorf("unknown service %q in %s (must be one of %s)",
+		service, section, strings.Join(osm.Services(), ", "))
+}
+
+// ApplyEnv overrides the config with environment variables. For each
+// service OSMMCP_<SERVICE>_URL sets the base URL, optionally followed by
+// comma-separated mirrors, and
+// OSMMCP_<SERVICE>_AUTH_HEADER adds a header in "Name: value" form.
+// OSMMCP_CACHE_DIR enables the disk cache and OSMMCP_OFFLINE_PBF offline
+// mode. OSMMCP_FIXTURES and OSMMCP_FIXTURES_DIR record or replay
+// upstream exchanges.
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	if dir := getenv(EnvCacheDir); dir != "" {
+		c.Cache.Dir = dir
+	}
+	if path := getenv(EnvOfflinePBF); path != "" {
+		c.Offline.PBF = path
+	}
+	if mode := getenv(EnvFixtures); mode != "" {
+		c.Fixtures.Mode = mode
+	}
+	if dir := getenv(EnvFixturesDir); dir != "" {
+		c.Fixtures.Dir = dir
+	}
+
+	for _, service := range osm.Services() {
+		prefix := "OSMMCP_" + strings.ToUpper(service)
+
+		if u := getenv(prefix + "_URL"); u != "" {
+			c.SetURL(service, u)
+		}
+
+		if spec := getenv(prefix + "_AUTH_HEADER"); spec != "" {
+			name, value, err := osm.ParseHeader(spec)
+			if err != nil {
+				return fmt.Errorf("%s_AUTH_HEADER: %w", prefix, err)
+			}
+			c.SetHeader(service, name, value)
+		}
+	}
+	return nil
+}
+
+// SetURL sets the base URL of a service from a comma-separated list. The
+// first URL is the primary; any others replace the configured mirrors.
+func (c *Config) SetURL(service, list string) {
+	var urls []string
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	ep := c.endpoint(service)
+	ep.URL = urls[0]
+	ep.Mirrors = nil
+	for _, u := range urls[1:] {
+		ep.Mirrors = append(ep.Mirrors, MirrorConfig{URL: u})
+	}
+	c.Endpoints[service] = ep
+}
+
+// SetHeader sets an extra header for a service
+func (c *Config) SetHeader(service, name, value string) {
+	ep := c.endpoint(service)
+	headers := make(map[string]string, len(ep.Headers)+1)
+	for k, v := range ep.Headers {
+		headers[k] = v
+	}
+	headers[name] = value
+	ep.Headers = headers
+	c.Endpoints[service] = ep
+}
+
+// endpoint returns the endpoint config of a service, creating the map if needed
+func (c *Config) endpoint(service string) EndpointConfig {
+	if c.Endpoints == nil {
+		c.Endpoints = make(map[string]EndpointConfig)
+	}
+	return c.Endpoints[service]
+}
+
+// SetRateLimit sets the rate limit of a service
+func (c *Config) SetRateLimit(service string, rps float64, burst int) {
+	if c.RateLimits == nil {
+		c.RateLimits = make(map[string]RateLimitConfig)
+	}
+	c.RateLimits[service] = RateLimitConfig{RPS: rps, Burst: burst}
+}
+
+// Apply configures the osm package with the endpoint, rate limit, cache,
+// offline and fixture settings
+func (c *Config) Apply() error {
+	return c.apply(nil)
+}
+
+// ApplyChanges applies a reloaded config in place of prev, the config
+// applied before. Settings removed since prev return to their defaults;
+// the disk cache and the offline extract are only reopened when their
+// settings changed.
+func (c *Config) ApplyChanges(prev *Config) error {
+	return c.apply(prev)
+}
+
+// apply configures the osm package, undoing the settings of prev, if
+// any, that c no longer has. The disk cache and the offline extract are
+// opened before any setting changes, so that a config that cannot be
+// applied leaves the current settings in place.
+func (c *Config) apply(prev *Config) error {
+	for service, ep := range c.Endpoints {
+		if err := validateEndpoint(service, ep); err != nil {
+			return err
+		}
+	}
+	mode, err := osm.ParseFixtureMode(c.Fixtures.Mode)
+	if err != nil {
+		return err
+	}
+	if mode == osm.FixturesRecord {
+		if err := os.MkdirAll(c.Fixtures.Dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create fixture directory: %w", err)
+		}
+	}
+	backend, swapCache, err := c.openCache(prev)
+	if err != nil {
+		return err
+	}
+	dataset, swapOffline, err := c.loadOffline(prev)
+	if err != nil {
+		if backend != nil {
+			backend.Close()
+		}
+		return err
+	}
+
+	// Nothing below is expected to fail; should it, the settings are
+	// left partly changed and the error wraps ErrPartlyApplied
+	if swapCache {
+		if old := osm.SetResponseCache(backend); old != nil {
+			old.Close()
+		}
+	}
+	if swapOffline {
+		if dataset != nil {
+			osm.SetElementProvider(dataset)
+			osm.SetGeocoder(dataset)
+			osm.SetRouter(dataset)
+		} else {
+			osm.SetElementProvider(nil)
+			osm.SetGeocoder(nil)
+			osm.SetRouter(nil)
+		}
+	}
+	if err := c.commit(prev, mode); err != nil {
+		return fmt.Errorf("%w: %w", ErrPartlyApplied, err)
+	}
+	return nil
+}
+
+// commit sets the fixture mode, cache TTLs, rate limits and endpoints,
+// returning the settings of prev that c no longer has to their defaults
+func (c *Config) commit(prev *Config, mode osm.FixtureMode) error {
+	if err := osm.SetFixtures(mode, c.Fixtures.Dir); err != nil {
+		return err
+	}
+	if prev != nil {
+		for service := range prev.Cache.TTL {
+			if _, ok := c.Cache.TTL[service]; !ok {
+				if err := osm.SetCacheTTL(service, osm.DefaultCacheTTLs[service]); err != nil {
+					return err
+				}
+			}
+		}
+		for service := range prev.RateLimits {
+			if _, ok := c.RateLimits[service]; !ok {
+				if err := osm.GetRateLimiter().SetLimit(service, osm.DefaultRPS, osm.DefaultBurst); err != nil {
+					return err
+				}
+			}
+		}
+		for service := range prev.Endpoints {
+			if _, ok := c.Endpoints[service]; !ok {
+				if err := c.applyEndpoint(service, EndpointConfig{}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for service, ttl := range c.Cache.TTL {
+		if err := osm.SetCacheTTL(service, time.Duration(ttl)); err != nil {
+			return err
+		}
+	}
+	for service, rl := range c.RateLimits {
+		if err := osm.GetRateLimiter().SetLimit(service, rl.RPS, rl.Burst); err != nil {
+			return err
+		}
+	}
+	for service, ep := range c.Endpoints {
+		if err := c.applyEndpoint(service, ep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateEndpoint checks the URLs of an endpoint before any is applied
+func validateEndpoint(service string, ep EndpointConfig) error {
+	if ep.URL != "" {
+		if err := osm.ValidateBaseURL(service, ep.URL); err != nil {
+			return err
+		}
+	}
+	for _, m := range ep.Mirrors {
+		if err := osm.ValidateBaseURL(service, m.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEndpoint sets the mirrors and headers of a service. An endpoint
+// without URLs points the service at its public instance.
+func (c *Config) applyEndpoint(service string, ep EndpointConfig) error {
+	primary := ep.URL
+	if primary == "" {
+		primary = osm.DefaultBaseURL(service)
+	}
+	mirrors := []osm.Mirror{{BaseURL: primary}}
+	for _, m := range ep.Mirrors {
+		mirrors = append(mirrors, osm.Mirror{BaseURL: m.URL, Headers: m.Headers})
+	}
+	if err := osm.SetMirrors(service, mirrors...); err != nil {
+		return err
+	}
+	return osm.SetHeaders(service, ep.Headers)
+}
+
+// isService reports whether name is a known upstream service
+func isService(name string) bool {
+	for _, service := range osm.Services() {
+		if service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// openCache opens the disk cache c configures. It reports whether the
+// response cache is to be replaced, by the backend or, when the disk
+// cache was turned off, by none.
+func (c *Config) openCache(prev *Config) (cache.Backend, bool, error) {
+	if prev != nil && prev.Cache.Dir == c.Cache.Dir && prev.Cache.MaxSizeMB == c.Cache.MaxSizeMB {
+		return nil, false, nil
+	}
+	if c.Cache.Dir == "" {
+		return nil, prev != nil, nil
+	}
+
+	maxMB := c.Cache.MaxSizeMB
+	if maxMB == 0 {
+		maxMB = DefaultCacheMaxSizeMB
+	}
+	backend, err := cache.NewDiskBackend(c.Cache.Dir, int64(maxMB)<<20)
+	if err != nil {
+		return nil, false, err
+	}
+	return backend, true, nil
+}
+
+// loadOffline loads the offline extract c configures. It reports
+// whether the offline providers are to be replaced, by the dataset or,
+// when offline mode was turned off, by none.
+func (c *Config) loadOffline(prev *Config) (*offline.Dataset, bool, error) {
+	if prev != nil && prev.Offline.PBF == c.Offline.PBF {
+		return nil, false, nil
+	}
+	if c.Offline.PBF == "" {
+		return nil, prev != nil, nil
+	}
+	dataset, err := offline.Load(c.Offline.PBF)
+	if err != nil {
+		return nil, false, err
+	}
+	return dataset, true, nil
+}
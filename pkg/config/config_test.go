@@ -0,0 +1,294 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"endpoints": {"osrm": {"url": "https://osrm.example.com", "headers": {"X-Api-Key": "k"}}}}`), 0600)
+
+	unknownService := filepath.Join(dir, "service.json")
+	os.WriteFile(unknownService, []byte(`{"endpoints": {"tiles": {"url": "https://tiles.example.com"}}}`), 0600)
+
+	unknownField := filepath.Join(dir, "field.json")
+	os.WriteFile(unknownField, []byte(`{"endpoint": {}}`), 0600)
+
+	cfg, err := Load(valid)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if ep := cfg.Endpoints["osrm"]; ep.URL != "https://osrm.example.com" || ep.Headers["X-Api-Key"] != "k" {
+		t.Errorf("Load() osrm endpoint = %+v", ep)
+	}
+
+	for _, path := range []string{unknownService, unknownField, filepath.Join(dir, "missing.json")} {
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", filepath.Base(path))
+		}
+	}
+
+	if cfg, err := Load(""); err != nil || len(cfg.Endpoints) != 0 {
+		t.Errorf("Load(\"\") = %+v, %v, want empty config", cfg, err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"OSMMCP_NOMINATIM_URL":         "https://nominatim.example.com",
+		"OSMMCP_OVERPASS_AUTH_HEADER":  "Authorization: Bearer t",
+		"OSMMCP_OSRM_URL":              "",
+		"OSMMCP_UNRELATED_AUTH_HEADER": "ignored: yes",
+		"OSMMCP_OFFLINE_PBF":           "/data/berlin.osm.pbf",
+		"OSMMCP_FIXTURES":              "replay",
+		"OSMMCP_FIXTURES_DIR":          "testdata/fixtures",
+	}
+
+	cfg := &Config{Endpoints: map[string]EndpointConfig{
+		"nominatim": {URL: "https://from-file.example.com"},
+		"osrm":      {URL: "https://osrm.example.com"},
+	}}
+	if err := cfg.ApplyEnv(func(k string) string { return env[k] }); err != nil {
+		t.Fatalf("ApplyEnv() error = %v", err)
+	}
+
+	if got := cfg.Endpoints["nominatim"].URL; got != "https://nominatim.example.com" {
+		t.Errorf("nominatim URL = %q, want environment to override file", got)
+	}
+	if got := cfg.Endpoints["osrm"].URL; got != "https://osrm.example.com" {
+		t.Errorf("osrm URL = %q, want file value kept", got)
+	}
+	if got := cfg.Endpoints["overpass"].Headers["Authorization"]; got != "Bearer t" {
+		t.Errorf("overpass Authorization = %q", got)
+	}
+	if cfg.Offline.PBF != "/data/berlin.osm.pbf" {
+		t.Errorf("offline PBF = %q", cfg.Offline.PBF)
+	}
+	if cfg.Fixtures != (FixturesConfig{Mode: "replay", Dir: "testdata/fixtures"}) {
+		t.Errorf("fixtures = %+v", cfg.Fixtures)
+	}
+
+	bad := &Config{}
+	err := bad.ApplyEnv(func(k string) string {
+		if k == "OSMMCP_OSRM_AUTH_HEADER" {
+			return "missing-colon"
+		}
+		return ""
+	})
+	if err == nil {
+		t.Error("ApplyEnv() accepted a malformed header")
+	}
+}
+
+func TestSetURLMirrors(t *testing.T) {
+	cfg := &Config{Endpoints: map[string]EndpointConfig{
+		"osrm": {Mirrors: []MirrorConfig{{URL: "https://old.example.com"}}},
+	}}
+	cfg.SetURL("osrm", "https://a.example.com, https://b.example.com,https://c.example.com")
+
+	ep := cfg.Endpoints["osrm"]
+	if ep.URL != "https://a.example.com" {
+		t.Errorf("primary URL = %q", ep.URL)
+	}
+	if len(ep.Mirrors) != 2 || ep.Mirrors[0].URL != "https://b.example.com" || ep.Mirrors[1].URL != "https://c.example.com" {
+		t.Errorf("mirrors = %+v, want b and c replacing the old list", ep.Mirrors)
+	}
+
+	bad := &Config{Endpoints: map[string]EndpointConfig{"osrm": {Mirrors: []MirrorConfig{{}}}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("Validate() accepted a mirror without url")
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "cache.json")
+	os.WriteFile(valid, []byte(`{"cache": {"dir": "/var/cache/osmmcp", "max_size_mb": 64, "ttl": {"overpass": "30m", "osrm": "0s"}}}`), 0600)
+
+	cfg, err := Load(valid)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Cache.Dir != "/var/cache/osmmcp" || cfg.Cache.MaxSizeMB != 64 {
+		t.Errorf("Load() cache = %+v", cfg.Cache)
+	}
+	if got := time.Duration(cfg.Cache.TTL["overpass"]); got != 30*time.Minute {
+		t.Errorf("overpass TTL = %v, want 30m", got)
+	}
+
+	for name, body := range map[string]string{
+		"number.json":  `{"cache": {"ttl": {"osrm": 60}}}`,
+		"service.json": `{"cache": {"ttl": {"tiles": "1h"}}}`,
+		"size.json":    `{"cache": {"max_size_mb": -1}}`,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0600)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", name)
+		}
+	}
+}
+
+func TestValidateFixtures(t *testing.T) {
+	tests := []struct {
+		fixtures FixturesConfig
+		valid    bool
+	}{
+		{FixturesConfig{}, true},
+		{FixturesConfig{Mode: "replay", Dir: "testdata/fixtures"}, true},
+		{FixturesConfig{Mode: "record"}, false},
+		{FixturesConfig{Mode: "rewind", Dir: "testdata/fixtures"}, false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Fixtures: tt.fixtures}
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) error = %v, want valid %v", tt.fixtures, err, tt.valid)
+		}
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "osmmcp.yaml")
+	os.WriteFile(valid, []byte(`
+endpoints:
+  osrm:
+    url: https://osrm.example.com
+    headers:
+      X-Api-Key: k
+rate_limits:
+  nominatim: {rps: 2, burst: 4}
+cache:
+  ttl:
+    overpass: 30m
+tools:
+  disabled: [explore_area]
+default_region: ""
+units: imperial
+logging:
+  level: debug
+  format: json
+transport:
+  type: http
+  listen: 127.0.0.1:7777
+`), 0600)
+
+	cfg, err := Load(valid)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if ep := cfg.Endpoints["osrm"]; ep.URL != "https://osrm.example.com" || ep.Headers["X-Api-Key"] != "k" {
+		t.Errorf("osrm endpoint = %+v", ep)
+	}
+	if rl := cfg.RateLimits["nominatim"]; rl.RPS != 2 || rl.Burst != 4 {
+		t.Errorf("nominatim rate limit = %+v", rl)
+	}
+	if got := time.Duration(cfg.Cache.TTL["overpass"]); got != 30*time.Minute {
+		t.Errorf("overpass TTL = %v, want 30m", got)
+	}
+	if cfg.DefaultRegion == nil || *cfg.DefaultRegion != "" {
+		t.Errorf("default region = %v, want an explicitly empty region", cfg.DefaultRegion)
+	}
+	if level, _ := cfg.LogLevel(); level != slog.LevelDebug || cfg.Logging.Format != "json" {
+		t.Errorf("logging = %+v", cfg.Logging)
+	}
+	if cfg.Transport != (TransportConfig{Type: "http", Listen: "127.0.0.1:7777"}) {
+		t.Errorf("transport = %+v", cfg.Transport)
+	}
+
+	empty := filepath.Join(dir, "empty.yml")
+	os.WriteFile(empty, nil, 0600)
+	if _, err := Load(empty); err != nil {
+		t.Errorf("Load(empty.yml) error = %v", err)
+	}
+
+	for name, body := range map[string]string{
+		"field.yaml":     "endpoint: {}\n",
+		"syntax.yaml":    "tools: [\n",
+		"tool.yaml":      "tools:\n  enabled: [teleport]\n",
+		"units.yaml":     "units: furlongs\n",
+		"level.yaml":     "logging:\n  level: loud\n",
+		"format.yaml":    "logging:\n  format: xml\n",
+		"transport.yaml": "transport:\n  type: carrier-pigeon\n",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0600)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded, want error", name)
+		}
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	t.Cleanup(func() {
+		(&Config{}).ApplyChanges(&Config{
+			Endpoints:  map[string]EndpointConfig{"osrm": {}},
+			RateLimits: map[string]RateLimitConfig{"osrm": {}},
+			Cache:      CacheConfig{TTL: map[string]Duration{"osrm": 0}},
+		})
+	})
+
+	prev := &Config{
+		Endpoints:  map[string]EndpointConfig{"osrm": {URL: "https://osrm.example.com", Headers: map[string]string{"X-Api-Key": "k"}}},
+		RateLimits: map[string]RateLimitConfig{"osrm": {RPS: 5, Burst: 5}},
+		Cache:      CacheConfig{TTL: map[string]Duration{"osrm": Duration(time.Minute)}},
+	}
+	if err := prev.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := osm.BaseURL("osrm"); got != "https://osrm.example.com" {
+		t.Fatalf("osrm URL = %q after Apply", got)
+	}
+
+	// Settings no longer in the reloaded config return to their defaults
+	if err := (&Config{}).ApplyChanges(prev); err != nil {
+		t.Fatalf("ApplyChanges() error = %v", err)
+	}
+	if got := osm.BaseURL("osrm"); got != osm.OSRMBaseURL {
+		t.Errorf("osrm URL = %q, want the public instance", got)
+	}
+	if got := osm.CacheTTL("osrm"); got != osm.DefaultCacheTTLs["osrm"] {
+		t.Errorf("osrm TTL = %v, want the default", got)
+	}
+	for _, state := range osm.GetRateLimiter().State() {
+		if state.Service == "osrm" && (state.RPS != osm.DefaultRPS || state.Burst != osm.DefaultBurst) {
+			t.Errorf("osrm rate limit = %+v, want the default", state)
+		}
+	}
+}
+
+func TestApplyChangesFailure(t *testing.T) {
+	prev := &Config{Cache: CacheConfig{TTL: map[string]Duration{"osrm": Duration(time.Minute)}}}
+	if err := prev.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	t.Cleanup(func() { (&Config{}).ApplyChanges(prev) })
+
+	// An extract that cannot be loaded leaves every setting in place
+	next := &Config{
+		Endpoints: map[string]EndpointConfig{"osrm": {URL: "https://osrm.example.com"}},
+		Cache:     CacheConfig{TTL: map[string]Duration{"osrm": Duration(time.Hour)}},
+		Offline:   OfflineConfig{PBF: filepath.Join(t.TempDir(), "missing.osm.pbf")},
+	}
+	err := next.ApplyChanges(prev)
+	if err == nil || errors.Is(err, ErrPartlyApplied) {
+		t.Fatalf("ApplyChanges() error = %v, want a failure before any change", err)
+	}
+	if got := osm.BaseURL("osrm"); got != osm.OSRMBaseURL {
+		t.Errorf("osrm URL = %q, want the public instance", got)
+	}
+	if got := osm.CacheTTL("osrm"); got != time.Minute {
+		t.Errorf("osrm TTL = %v, want %v", got, time.Minute)
+	}
+}
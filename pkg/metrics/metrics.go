@@ -0,0 +1,209 @@
+// Package metrics exports what osmmcp is doing as Prometheus metrics:
+// tool calls, upstream requests, rate limiter waits, retries and caches.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every metric
+const namespace = "osmmcp"
+
+// Metrics collects the metrics of one server. It is an osm.Observer and
+// a tools.Observer, so it is fed by handing it to the osm client and the
+// tool registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	toolCalls    *prometheus.CounterVec
+	toolDuration *prometheus.HistogramVec
+
+	upstreamRequests *prometheus.CounterVec
+	upstreamDuration *prometheus.HistogramVec
+	rateLimitWait    *prometheus.HistogramVec
+	retries          *prometheus.CounterVec
+	responseCache    *prometheus.CounterVec
+}
+
+var (
+	_ osm.Observer   = (*Metrics)(nil)
+	_ tools.Observer = (*Metrics)(nil)
+)
+
+// New creates the metrics in a registry of their own, along with the Go
+// runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		toolCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tool_calls_total",
+			Help:      "Tool calls by tool and result code: ok, the HTTP status of the error, or a tool specific error code.",
+		}, []string{"tool", "code"}),
+		toolDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "tool_call_duration_seconds",
+			Help:      "Duration of tool calls.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"tool"}),
+		upstreamRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_requests_total",
+			Help:      "Requests sent to upstream services by HTTP status; code is \"error\" when no response was received.",
+		}, []string{"service", "code"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Duration of upstream requests, excluding rate limiter waits.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service"}),
+		rateLimitWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_rate_limit_wait_seconds",
+			Help:      "Time upstream requests waited for the rate limiter of their service.",
+			Buckets:   []float64{.001, .01, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"service"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_retries_total",
+			Help:      "Upstream requests sent again after a transient failure.",
+		}, []string{"service"}),
+		responseCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_cache_requests_total",
+			Help:      "Cacheable upstream requests by whether the response cache answered them: hit or miss.",
+		}, []string{"service", "result"}),
+	}
+
+	m.registry.MustRegister(
+		m.toolCalls,
+		m.toolDuration,
+		m.upstreamRequests,
+		m.upstreamDuration,
+		m.rateLimitWait,
+		m.retries,
+		m.responseCache,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Registry returns the Prometheus registry holding the metrics, so that
+// embedders can add their own
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// RegisterCache exports the statistics of every namespace of a cache
+// store. A store can only be registered once.
+func (m *Metrics) RegisterCache(s *cache.Store) error {
+	return m.registry.Register(newCacheCollector(s))
+}
+
+// ObserveToolCall implements tools.Observer
+func (m *Metrics) ObserveToolCall(tool, code string, duration time.Duration) {
+	m.toolCalls.WithLabelValues(tool, code).Inc()
+	m.toolDuration.WithLabelValues(tool).Observe(duration.Seconds())
+}
+
+// ObserveRequest implements osm.Observer
+func (m *Metrics) ObserveRequest(service string, status int, duration time.Duration) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	m.upstreamRequests.WithLabelValues(service, code).Inc()
+	m.upstreamDuration.WithLabelValues(service).Observe(duration.Seconds())
+}
+
+// ObserveRetry implements osm.Observer
+func (m *Metrics) ObserveRetry(service string) {
+	m.retries.WithLabelValues(serviceLabel(service)).Inc()
+}
+
+// ObserveRateLimitWait implements osm.Observer
+func (m *Metrics) ObserveRateLimitWait(service string, wait time.Duration) {
+	m.rateLimitWait.WithLabelValues(service).Observe(wait.Seconds())
+}
+
+// ObserveCache implements osm.Observer
+func (m *Metrics) ObserveCache(service string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.responseCache.WithLabelValues(serviceLabel(service), result).Inc()
+}
+
+// serviceLabel names requests to hosts that belong to no service
+func serviceLabel(service string) string {
+	if service == "" {
+		return "other"
+	}
+	return service
+}
+
+// cacheCollector reads the statistics of a cache store at scrape time
+type cacheCollector struct {
+	store *cache.Store
+
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+	entries     *prometheus.Desc
+	capacity    *prometheus.Desc
+}
+
+// newCacheCollector creates a collector for the namespaces of s
+func newCacheCollector(s *cache.Store) *cacheCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, []string{"namespace"}, nil)
+	}
+	return &cacheCollector{
+		store:       s,
+		hits:        desc("hits_total", "Cache lookups that found an entry."),
+		misses:      desc("misses_total", "Cache lookups that found no entry."),
+		evictions:   desc("evictions_total", "Entries dropped to make room."),
+		expirations: desc("expirations_total", "Entries dropped because their TTL passed."),
+		entries:     desc("entries", "Entries currently cached."),
+		capacity:    desc("capacity", "Maximum number of entries."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.entries
+	ch <- c.capacity
+}
+
+// Collect implements prometheus.Collector
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.store.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits), s.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses), s.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions), s.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(s.Expirations), s.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Size), s.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(s.Capacity), s.Namespace)
+	}
+}
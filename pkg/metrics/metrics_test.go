@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+)
+
+// scrape returns the metrics served by m
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+
+	store := cache.NewStore(0)
+	ns := cache.NewNamespace[string](store, "geocode", time.Minute, 10)
+	ns.Set("a", "x")
+	ns.Get("a")
+	ns.Get("b")
+	if err := m.RegisterCache(store); err != nil {
+		t.Fatalf("RegisterCache() error = %v", err)
+	}
+	if err := m.RegisterCache(store); err == nil {
+		t.Error("RegisterCache() twice succeeded, want an error")
+	}
+
+	m.ObserveToolCall("geocode_address", "ok", 20*time.Millisecond)
+	m.ObserveToolCall("geocode_address", "429", time.Second)
+	m.ObserveRequest("nominatim", 429, 10*time.Millisecond)
+	m.ObserveRequest("nominatim", 0, time.Millisecond)
+	m.ObserveRetry("nominatim")
+	m.ObserveRateLimitWait("nominatim", 500*time.Millisecond)
+	m.ObserveCache("overpass", true)
+	m.ObserveCache("", false)
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`osmmcp_tool_calls_total{code="ok",tool="geocode_address"} 1`,
+		`osmmcp_tool_calls_total{code="429",tool="geocode_address"} 1`,
+		`osmmcp_tool_call_duration_seconds_count{tool="geocode_address"} 2`,
+		`osmmcp_upstream_requests_total{code="429",service="nominatim"} 1`,
+		`osmmcp_upstream_requests_total{code="error",service="nominatim"} 1`,
+		`osmmcp_upstream_retries_total{service="nominatim"} 1`,
+		`osmmcp_upstream_rate_limit_wait_seconds_sum{service="nominatim"} 0.5`,
+		`osmmcp_upstream_cache_requests_total{result="hit",service="overpass"} 1`,
+		`osmmcp_upstream_cache_requests_total{result="miss",service="other"} 1`,
+		`osmmcp_cache_hits_total{namespace="geocode"} 1`,
+		`osmmcp_cache_misses_total{namespace="geocode"} 1`,
+		`osmmcp_cache_entries{namespace="geocode"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
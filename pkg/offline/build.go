@@ -0,0 +1,270 @@
+package offline
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// coordScale stores coordinates as 1e-7 degree integers, the precision
+// of OSM itself
+const coordScale = 1e7
+
+// nodeCoords maps node IDs to coordinates. It is filled in file order
+// and sorted once, which keeps it far smaller than a map.
+type nodeCoords struct {
+	ids      []int64
+	lat, lon []int32
+	sorted   bool
+}
+
+// add records the coordinates of a node
+func (c *nodeCoords) add(id int64, lat, lon float64) {
+	if n := len(c.ids); n > 0 && c.ids[n-1] >= id {
+		c.sorted = false
+	}
+	c.ids = append(c.ids, id)
+	c.lat = append(c.lat, int32(math.Round(lat*coordScale)))
+	c.lon = append(c.lon, int32(math.Round(lon*coordScale)))
+}
+
+// sortByID orders the nodes by ID unless the file already did
+func (c *nodeCoords) sortByID() {
+	if c.sorted {
+		return
+	}
+	sort.Sort(c)
+	c.sorted = true
+}
+
+// lookup returns the coordinates of a node. The nodes must be sorted.
+func (c *nodeCoords) lookup(id int64) (lat, lon float64, ok bool) {
+	i := sort.Search(len(c.ids), func(i int) bool { return c.ids[i] >= id })
+	if i == len(c.ids) || c.ids[i] != id {
+		return 0, 0, false
+	}
+	return float64(c.lat[i]) / coordScale, float64(c.lon[i]) / coordScale, true
+}
+
+// Len, Less and Swap implement sort.Interface
+func (c *nodeCoords) Len() int           { return len(c.ids) }
+func (c *nodeCoords) Less(i, j int) bool { return c.ids[i] < c.ids[j] }
+func (c *nodeCoords) Swap(i, j int) {
+	c.ids[i], c.ids[j] = c.ids[j], c.ids[i]
+	c.lat[i], c.lat[j] = c.lat[j], c.lat[i]
+	c.lon[i], c.lon[j] = c.lon[j], c.lon[i]
+}
+
+// builder collects the elements of an extract while it is decoded
+type builder struct {
+	coords nodeCoords
+	nodes  []osm.OverpassElement
+	ways   []Way
+
+	// boundaries are the administrative boundary relations, found in a
+	// first pass so that the second can keep their member ways
+	boundaries []Relation
+
+	// memberWays holds the node IDs of ways that are boundary members
+	memberWays map[int64][]int64
+}
+
+// newBuilder returns an empty builder
+func newBuilder() *builder {
+	return &builder{
+		coords:     nodeCoords{sorted: true},
+		memberWays: make(map[int64][]int64),
+	}
+}
+
+// build decodes an extract in two passes and indexes it
+func (b *builder) build(r io.ReadSeeker) (*Dataset, error) {
+	err := DecodePBF(r, Handler{Relation: b.addBoundary})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	err = DecodePBF(r, Handler{
+		Node: func(n Node) {
+			b.coords.add(n.ID, n.Lat, n.Lon)
+			if len(n.Tags) > 0 {
+				b.nodes = append(b.nodes, osm.OverpassElement{Type: "node", ID: n.ID, Lat: n.Lat, Lon: n.Lon, Tags: n.Tags})
+			}
+		},
+		Way: func(w Way) {
+			if _, ok := b.memberWays[w.ID]; ok {
+				b.memberWays[w.ID] = w.NodeIDs
+			}
+			if len(w.Tags) > 0 {
+				b.ways = append(b.ways, w)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.coords.sortByID()
+	elements := b.nodes
+	for _, w := range b.ways {
+		if lat, lon, ok := b.wayCenter(w); ok {
+			elements = append(elements, osm.OverpassElement{Type: "way", ID: w.ID, Lat: lat, Lon: lon, Tags: w.Tags})
+		}
+	}
+
+	d := index(elements)
+	d.gazetteer = newGazetteer(elements, b.buildBoundaries())
+	d.graph = newGraph(b.ways, &b.coords)
+	return d, nil
+}
+
+// addBoundary keeps named administrative boundaries and marks their
+// member ways
+func (b *builder) addBoundary(r Relation) {
+	if r.Tags["boundary"] != "administrative" || r.Tags["name"] == "" {
+		return
+	}
+	if _, err := strconv.Atoi(r.Tags["admin_level"]); err != nil {
+		return
+	}
+	b.boundaries = append(b.boundaries, r)
+	for _, m := range r.Members {
+		if m.Type == "way" {
+			b.memberWays[m.ID] = nil
+		}
+	}
+}
+
+// buildBoundaries assembles the rings of the boundary relations.
+// Boundaries without a closed ring, as found at the edge of a clipped
+// extract, are dropped.
+func (b *builder) buildBoundaries() []boundary {
+	var out []boundary
+	for _, r := range b.boundaries {
+		var parts [][]int64
+		var label *point
+		for _, m := range r.Members {
+			switch {
+			case m.Type == "way" && (m.Role == "outer" || m.Role == "inner" || m.Role == ""):
+				if ids := b.memberWays[m.ID]; len(ids) > 1 {
+					parts = append(parts, ids)
+				}
+			case m.Type == "node" && (m.Role == "label" || m.Role == "admin_centre"):
+				if lat, lon, ok := b.coords.lookup(m.ID); ok && (label == nil || m.Role == "label") {
+					label = &point{lat: lat, lon: lon}
+				}
+			}
+		}
+
+		var rings [][]point
+		for _, ring := range joinRings(parts) {
+			pts := make([]point, 0, len(ring))
+			for _, id := range ring {
+				if lat, lon, ok := b.coords.lookup(id); ok {
+					pts = append(pts, point{lat: lat, lon: lon})
+				}
+			}
+			if len(pts) >= 4 {
+				rings = append(rings, pts)
+			}
+		}
+		if len(rings) == 0 {
+			continue
+		}
+
+		level, _ := strconv.Atoi(r.Tags["admin_level"])
+		out = append(out, newBoundary(r.Tags, level, rings, label))
+	}
+	return out
+}
+
+// joinRings joins way node lists that share end nodes into closed
+// rings. Lists that cannot be closed are dropped.
+func joinRings(parts [][]int64) [][]int64 {
+	used := make([]bool, len(parts))
+	var rings [][]int64
+	for i := range parts {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		ring := append([]int64(nil), parts[i]...)
+
+		for ring[0] != ring[len(ring)-1] {
+			extended := false
+			for j := range parts {
+				if used[j] {
+					continue
+				}
+				p := parts[j]
+				last := ring[len(ring)-1]
+				switch {
+				case p[0] == last:
+					ring = append(ring, p[1:]...)
+				case p[len(p)-1] == last:
+					for k := len(p) - 2; k >= 0; k-- {
+						ring = append(ring, p[k])
+					}
+				default:
+					continue
+				}
+				used[j] = true
+				extended = true
+				break
+			}
+			if !extended {
+				break
+			}
+		}
+		if ring[0] == ring[len(ring)-1] {
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+// wayCenter returns the center of a way's bounding box, like Overpass
+// does for "out center". Nodes missing from a clipped extract are
+// ignored.
+func (b *builder) wayCenter(w Way) (lat, lon float64, ok bool) {
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	for _, id := range w.NodeIDs {
+		nodeLat, nodeLon, found := b.coords.lookup(id)
+		if !found {
+			continue
+		}
+		minLat, maxLat = min(minLat, nodeLat), max(maxLat, nodeLat)
+		minLon, maxLon = min(minLon, nodeLon), max(maxLon, nodeLon)
+		ok = true
+	}
+	return (minLat + maxLat) / 2, (minLon + maxLon) / 2, ok
+}
+
+// index builds the spatial and tag indexes of elements
+func index(elements []osm.OverpassElement) *Dataset {
+	d := &Dataset{
+		elements: elements,
+		grid:     make(map[cell][]int32),
+		tags:     make(map[string]map[string][]int32),
+	}
+	for i, e := range elements {
+		c := cellOf(e.Lat, e.Lon)
+		d.grid[c] = append(d.grid[c], int32(i))
+		for k, v := range e.Tags {
+			values := d.tags[k]
+			if values == nil {
+				values = make(map[string][]int32)
+				d.tags[k] = values
+			}
+			values[v] = append(values[v], int32(i))
+		}
+	}
+	return d
+}
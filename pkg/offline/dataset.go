@@ -0,0 +1,169 @@
+// Package offline answers OpenStreetMap queries from a local PBF extract,
+// for deployments that cannot reach the public services. A Dataset can
+// stand in for Overpass, Nominatim and OSRM.
+package offline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+const (
+	// cellSize is the edge length of a spatial index cell in degrees
+	cellSize = 0.01
+
+	// maxIndexCells bounds the cells scanned for one query; larger areas
+	// are answered from the tag index
+	maxIndexCells = 1 << 16
+)
+
+// cell identifies one cell of the spatial index
+type cell struct {
+	x, y int32
+}
+
+// cellOf returns the cell containing a point
+func cellOf(lat, lon float64) cell {
+	return cell{x: int32(math.Floor(lon / cellSize)), y: int32(math.Floor(lat / cellSize))}
+}
+
+// Dataset holds the tagged nodes and ways of an extract with a spatial
+// index and a tag index, a gazetteer and a road graph. It is read-only once loaded and safe for
+// concurrent use.
+type Dataset struct {
+	elements []osm.OverpassElement
+
+	// grid lists the elements in each cell
+	grid map[cell][]int32
+
+	// tags lists the elements by tag key and value
+	tags map[string]map[string][]int32
+
+	// gazetteer answers geocoding queries
+	gazetteer *gazetteer
+
+	// graph answers route queries
+	graph *graph
+}
+
+// Load reads a .osm.pbf extract from path
+func Load(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open extract: %w", err)
+	}
+	defer f.Close()
+
+	start := time.Now()
+	d, err := newBuilder().build(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	slog.Info("offline extract loaded",
+		"path", path,
+		"elements", len(d.elements),
+		"places", len(d.gazetteer.places),
+		"boundaries", len(d.gazetteer.boundaries),
+		"road_nodes", len(d.graph.lat),
+		"duration", time.Since(start).Round(time.Millisecond))
+	return d, nil
+}
+
+// Len returns the number of indexed elements
+func (d *Dataset) Len() int {
+	return len(d.elements)
+}
+
+// QueryElements implements osm.ElementProvider
+func (d *Dataset) QueryElements(ctx context.Context, filter osm.ElementFilter, box geo.BoundingBox) ([]osm.OverpassElement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []osm.OverpassElement
+	for _, i := range d.candidates(filter, box) {
+		e := d.elements[i]
+		if e.Lat < box.MinLat || e.Lat > box.MaxLat || e.Lon < box.MinLon || e.Lon > box.MaxLon {
+			continue
+		}
+		if filter.Matches(e.Type, e.Tags) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// candidates returns the elements that may match, taken from whichever
+// index yields fewer
+func (d *Dataset) candidates(filter osm.ElementFilter, box geo.BoundingBox) []int32 {
+	var byTag [][]int32
+	tagCount := 0
+	for key, values := range filter.Tags {
+		if len(values) == 0 {
+			for _, list := range d.tags[key] {
+				byTag = append(byTag, list)
+				tagCount += len(list)
+			}
+			continue
+		}
+		for _, v := range values {
+			list := d.tags[key][v]
+			byTag = append(byTag, list)
+			tagCount += len(list)
+		}
+	}
+
+	lo := cellOf(box.MinLat, box.MinLon)
+	hi := cellOf(box.MaxLat, box.MaxLon)
+	cells := (int64(hi.x) - int64(lo.x) + 1) * (int64(hi.y) - int64(lo.y) + 1)
+	if cells <= maxIndexCells && cells <= int64(tagCount) {
+		var byCell [][]int32
+		cellCount := 0
+		for y := lo.y; y <= hi.y; y++ {
+			for x := lo.x; x <= hi.x; x++ {
+				if list := d.grid[cell{x: x, y: y}]; len(list) > 0 {
+					byCell = append(byCell, list)
+					cellCount += len(list)
+				}
+			}
+		}
+		if cellCount < tagCount {
+			// Cells do not overlap, so no element is listed twice
+			return concat(byCell, cellCount)
+		}
+	}
+	return union(byTag, tagCount)
+}
+
+// concat joins index lists
+func concat(lists [][]int32, n int) []int32 {
+	out := make([]int32, 0, n)
+	for _, list := range lists {
+		out = append(out, list...)
+	}
+	return out
+}
+
+// union joins index lists, dropping elements listed more than once
+func union(lists [][]int32, n int) []int32 {
+	out := concat(lists, n)
+	if len(lists) <= 1 {
+		return out
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	unique := out[:0]
+	for i, v := range out {
+		if i == 0 || v != out[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,109 @@
+package offline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+func loadTestExtract(t *testing.T) *Dataset {
+	t.Helper()
+	return loadExtract(t, testExtract(t))
+}
+
+func loadExtract(t *testing.T, data []byte) *Dataset {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.osm.pbf")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestLoadIndexesTaggedElements(t *testing.T) {
+	d := loadTestExtract(t)
+
+	// Three tagged nodes and two tagged ways; the untagged way is dropped
+	if d.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", d.Len())
+	}
+
+	box := geo.BoundingBox{MinLat: 52.5, MinLon: 13.4, MaxLat: 52.53, MaxLon: 13.42}
+	parking, err := d.QueryElements(context.Background(), osm.ElementFilter{
+		Types: []string{"way"},
+		Tags:  map[string][]string{"amenity": {"parking"}},
+	}, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parking) != 1 {
+		t.Fatalf("QueryElements() = %+v, want the parking way", parking)
+	}
+	// The center of the way's bounding box, as Overpass reports it
+	if p := parking[0]; p.ID != 100 || p.Type != "way" || !near(p.Lat, 52.523) || !near(p.Lon, 13.412) {
+		t.Errorf("parking = %+v, want way 100 at 52.523,13.412", p)
+	}
+}
+
+func TestQueryElementsUsesEitherIndex(t *testing.T) {
+	d := loadTestExtract(t)
+	filter := osm.ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"amenity": {"cafe", "school"}}}
+
+	// A small box is answered from the spatial index, a huge one from
+	// the tag index; both must agree with a plain scan
+	for _, box := range []geo.BoundingBox{
+		{MinLat: 52.519, MinLon: 13.404, MaxLat: 52.53, MaxLon: 13.406},
+		{MinLat: -90, MinLon: -180, MaxLat: 90, MaxLon: 180},
+	} {
+		got, err := d.QueryElements(context.Background(), filter, box)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		for _, e := range d.elements {
+			if filter.Matches(e.Type, e.Tags) && e.Lat >= box.MinLat && e.Lat <= box.MaxLat && e.Lon >= box.MinLon && e.Lon <= box.MaxLon {
+				want++
+			}
+		}
+		if len(got) != want {
+			t.Errorf("QueryElements(%+v) returned %d elements, want %d", box, len(got), want)
+		}
+	}
+}
+
+func TestDatasetAsElementProvider(t *testing.T) {
+	d := loadTestExtract(t)
+	prev := osm.SetElementProvider(d)
+	t.Cleanup(func() { osm.SetElementProvider(prev) })
+
+	// No Overpass endpoint is reachable in tests; the answer must come
+	// from the extract
+	elements, err := osm.QueryElementsAround(context.Background(), osm.ElementFilter{
+		Types: []string{"node", "way"},
+		Tags:  map[string][]string{"amenity": {"cafe"}},
+	}, 52.5200, 13.4050, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 1 || elements[0].Tags["name"] != "Café Eins" {
+		t.Errorf("QueryElementsAround() = %+v, want Café Eins only", elements)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.osm.pbf")); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
+
+func near(a, b float64) bool {
+	return a-b < 1e-6 && b-a < 1e-6
+}
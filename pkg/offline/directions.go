@@ -0,0 +1,139 @@
+package offline
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// minTurnAngle is the smallest change of direction at a junction that
+// is announced even when the road name stays the same, in degrees
+const minTurnAngle = 45
+
+// route turns a path into a route with OSRM-style maneuvers. A step
+// starts wherever the road name changes, at sharp turns at junctions,
+// and at roundabouts, which are one step up to the exit taken.
+func (g *graph) route(from int32, path []int32, p profile) *osm.RouteResult {
+	loc := func(n int32) geo.Location {
+		return geo.Location{Latitude: g.lat[n], Longitude: g.lon[n]}
+	}
+
+	r := &osm.RouteResult{Geometry: []geo.Location{loc(from)}}
+	name := ""
+	if len(path) > 0 {
+		name = g.ways[g.edges[path[0]].way].name
+	}
+	steps := []osm.RouteStep{{Type: "depart", Name: name, Location: loc(from)}}
+
+	n := from
+	exits := 0
+	for i, ei := range path {
+		e := &g.edges[ei]
+		w := &g.ways[e.way]
+		if i > 0 {
+			prev := &g.ways[g.edges[path[i-1]].way]
+			angle := turnAngle(g, g.source(path[i-1]), n, e.to)
+			switch {
+			case w.roundabout && !prev.roundabout:
+				steps = append(steps, osm.RouteStep{Type: "roundabout", Name: w.name, Location: loc(n)})
+				exits = 0
+			case w.roundabout:
+				if g.hasExit(n, p) {
+					exits++
+				}
+			case prev.roundabout:
+				steps[len(steps)-1].Modifier = ordinal(exits + 1)
+				steps[len(steps)-1].Name = w.name
+			case w.name != prev.name || (math.Abs(angle) >= minTurnAngle && len(g.out(n)) > 2):
+				step := osm.RouteStep{Type: "turn", Modifier: turnModifier(angle), Name: w.name, Location: loc(n)}
+				if step.Modifier == "straight" {
+					step.Type = "continue"
+				}
+				steps = append(steps, step)
+			}
+		}
+
+		duration := float64(e.length) / float64(w.speeds[p])
+		last := &steps[len(steps)-1]
+		last.Distance += float64(e.length)
+		last.Duration += duration
+		r.Distance += float64(e.length)
+		r.Duration += duration
+
+		n = e.to
+		r.Geometry = append(r.Geometry, loc(n))
+		name = w.name
+	}
+
+	// A route ending inside a roundabout leaves it at the next exit
+	if last := &steps[len(steps)-1]; last.Type == "roundabout" && last.Modifier == "" {
+		last.Modifier = ordinal(exits + 1)
+	}
+	r.Steps = append(steps, osm.RouteStep{Type: "arrive", Name: name, Location: loc(n)})
+	return r
+}
+
+// hasExit reports whether a profile may leave a roundabout at a node
+func (g *graph) hasExit(n int32, p profile) bool {
+	for _, e := range g.out(n) {
+		if e.access&(1<<p) != 0 && !g.ways[e.way].roundabout {
+			return true
+		}
+	}
+	return false
+}
+
+// turnAngle returns the change of direction at b when traveling from a
+// to c in degrees, positive to the right
+func turnAngle(g *graph, a, b, c int32) float64 {
+	before := bearing(g.lat[a], g.lon[a], g.lat[b], g.lon[b])
+	after := bearing(g.lat[b], g.lon[b], g.lat[c], g.lon[c])
+	angle := math.Mod(after-before+540, 360) - 180
+	if angle == -180 {
+		angle = 180
+	}
+	return angle
+}
+
+// bearing returns the initial bearing from one point to another in
+// degrees clockwise from north
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// turnModifier returns the OSRM modifier of a turn angle
+func turnModifier(angle float64) string {
+	side := "right"
+	if angle < 0 {
+		side = "left"
+	}
+	switch a := math.Abs(angle); {
+	case a < 20:
+		return "straight"
+	case a < 60:
+		return "slight " + side
+	case a < 120:
+		return side
+	case a < 170:
+		return "sharp " + side
+	default:
+		return "uturn"
+	}
+}
+
+// ordinals name the exits of a roundabout
+var ordinals = []string{"first", "second", "third", "fourth", "fifth", "sixth", "seventh", "eighth", "ninth", "tenth"}
+
+// ordinal returns the name of the nth exit
+func ordinal(n int) string {
+	if n >= 1 && n <= len(ordinals) {
+		return ordinals[n-1]
+	}
+	return fmt.Sprintf("%dth", n)
+}
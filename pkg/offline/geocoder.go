@@ -0,0 +1,528 @@
+package offline
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+const (
+	// bandSize is the latitude span of the edge buckets of a boundary
+	bandSize = 0.01
+
+	// maxReverseDistance is how far from the point reverse lookups
+	// search for an address, in meters
+	maxReverseDistance = 500
+)
+
+// point is a coordinate pair
+type point struct {
+	lat, lon float64
+}
+
+// edge is one segment of a boundary ring
+type edge struct {
+	a, b point
+}
+
+// boundary is an administrative area
+type boundary struct {
+	name  string
+	level int
+	tags  map[string]string
+	box   geo.BoundingBox
+	label point
+
+	// bands buckets the ring edges by latitude, so containment tests
+	// only look at edges near the point
+	bands map[int32][]edge
+}
+
+// newBoundary indexes the rings of an administrative area. The label
+// defaults to the center of the bounding box.
+func newBoundary(tags map[string]string, level int, rings [][]point, label *point) boundary {
+	b := boundary{
+		name:  tags["name"],
+		level: level,
+		tags:  tags,
+		box:   geo.BoundingBox{MinLat: math.Inf(1), MinLon: math.Inf(1), MaxLat: math.Inf(-1), MaxLon: math.Inf(-1)},
+		bands: make(map[int32][]edge),
+	}
+	for _, ring := range rings {
+		for i := 1; i < len(ring); i++ {
+			e := edge{a: ring[i-1], b: ring[i]}
+			lo := int32(math.Floor(min(e.a.lat, e.b.lat) / bandSize))
+			hi := int32(math.Floor(max(e.a.lat, e.b.lat) / bandSize))
+			for band := lo; band <= hi; band++ {
+				b.bands[band] = append(b.bands[band], e)
+			}
+			b.box.ExtendWithPoint(e.b.lat, e.b.lon)
+		}
+	}
+	if label != nil {
+		b.label = *label
+	} else {
+		b.label = point{lat: (b.box.MinLat + b.box.MaxLat) / 2, lon: (b.box.MinLon + b.box.MaxLon) / 2}
+	}
+	return b
+}
+
+// contains reports whether a point lies inside the area. Rings are
+// combined with the even-odd rule, so inner rings cut holes.
+func (b *boundary) contains(lat, lon float64) bool {
+	if lat < b.box.MinLat || lat > b.box.MaxLat || lon < b.box.MinLon || lon > b.box.MaxLon {
+		return false
+	}
+	inside := false
+	for _, e := range b.bands[int32(math.Floor(lat/bandSize))] {
+		if (e.a.lat > lat) != (e.b.lat > lat) {
+			x := e.a.lon + (lat-e.a.lat)/(e.b.lat-e.a.lat)*(e.b.lon-e.a.lon)
+			if lon < x {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// gazetteerPlace is one entry of the gazetteer
+type gazetteerPlace struct {
+	name        string
+	kind        string // the "type" Nominatim would report
+	lat, lon    float64
+	importance  float64
+	houseNumber string
+	street      string
+	city        string
+	postcode    string
+	boundary    bool
+
+	// level is the deepest admin level of the areas listed for the place,
+	// or 0 for all of them
+	level int
+
+	// admin indexes gazetteer.hierarchies
+	admin int32
+}
+
+// gazetteer is a geocoding index over named places, addresses and
+// administrative boundaries
+type gazetteer struct {
+	places     []gazetteerPlace
+	boundaries []boundary
+
+	// hierarchies are the distinct sets of boundaries containing a place,
+	// as boundary indexes ordered from country down
+	hierarchies [][]int32
+
+	// tokens lists the places by the words of their name and address
+	tokens map[string][]int32
+
+	// grid lists the places that can answer a reverse lookup
+	grid map[cell][]int32
+
+	// boundaryPlaces maps each boundary to its place
+	boundaryPlaces []int32
+}
+
+// placeKinds are the tag keys whose value becomes the type of a place,
+// in order of preference
+var placeKinds = []string{
+	"place", "amenity", "shop", "tourism", "leisure", "historic", "office",
+	"railway", "aeroway", "natural", "highway", "building",
+}
+
+// placeLevels are the admin levels of settlements and other places.
+// Areas nested more deeply than the place are not part of its address.
+var placeLevels = map[string]int{
+	"country": 2, "state": 4, "region": 5, "county": 6,
+	"city": 8, "town": 8, "village": 8, "municipality": 8,
+	"borough": 9, "suburb": 9, "quarter": 10, "neighbourhood": 10,
+}
+
+// newGazetteer indexes the named and addressed elements and the
+// boundaries
+func newGazetteer(elements []osm.OverpassElement, boundaries []boundary) *gazetteer {
+	g := &gazetteer{
+		boundaries: boundaries,
+		tokens:     make(map[string][]int32),
+		grid:       make(map[cell][]int32),
+	}
+	hierarchyIDs := make(map[string]int32)
+
+	add := func(p gazetteerPlace) {
+		var ids []int32
+		var key strings.Builder
+		for i := range g.boundaries {
+			if p.level > 0 && g.boundaries[i].level > p.level {
+				continue
+			}
+			if g.boundaries[i].contains(p.lat, p.lon) {
+				ids = append(ids, int32(i))
+			}
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			return g.boundaries[ids[a]].level < g.boundaries[ids[b]].level
+		})
+		for _, id := range ids {
+			key.WriteString(strconv.Itoa(int(id)))
+			key.WriteByte(',')
+		}
+		h, ok := hierarchyIDs[key.String()]
+		if !ok {
+			h = int32(len(g.hierarchies))
+			hierarchyIDs[key.String()] = h
+			g.hierarchies = append(g.hierarchies, ids)
+		}
+		p.admin = h
+
+		i := int32(len(g.places))
+		g.places = append(g.places, p)
+		for _, t := range uniqueTokens(p.name, p.houseNumber, p.street, p.postcode) {
+			g.tokens[t] = append(g.tokens[t], i)
+		}
+		if !p.boundary {
+			c := cellOf(p.lat, p.lon)
+			g.grid[c] = append(g.grid[c], i)
+		}
+	}
+
+	for _, e := range elements {
+		name := e.Tags["name"]
+		houseNumber := e.Tags["addr:housenumber"]
+		if name == "" && houseNumber == "" {
+			continue
+		}
+		if e.Tags["boundary"] == "administrative" {
+			continue
+		}
+		add(gazetteerPlace{
+			name:        name,
+			kind:        placeKind(e.Tags),
+			lat:         e.Lat,
+			lon:         e.Lon,
+			importance:  placeImportance(e.Tags),
+			houseNumber: houseNumber,
+			street:      e.Tags["addr:street"],
+			city:        e.Tags["addr:city"],
+			postcode:    e.Tags["addr:postcode"],
+			level:       placeLevels[e.Tags["place"]],
+		})
+	}
+	for _, b := range boundaries {
+		g.boundaryPlaces = append(g.boundaryPlaces, int32(len(g.places)))
+		add(gazetteerPlace{
+			name:       b.name,
+			kind:       "administrative",
+			lat:        b.label.lat,
+			lon:        b.label.lon,
+			importance: boundaryImportance(b.level, b.tags),
+			boundary:   true,
+			level:      b.level,
+		})
+	}
+	return g
+}
+
+// placeKind returns the Nominatim type of an element
+func placeKind(tags map[string]string) string {
+	for _, key := range placeKinds {
+		if v := tags[key]; v != "" && v != "yes" {
+			return v
+		}
+	}
+	if tags["addr:housenumber"] != "" {
+		return "house"
+	}
+	return "yes"
+}
+
+// placeImportance estimates Nominatim's importance, which ranks
+// settlements above landmarks, landmarks above other named features and
+// those above bare addresses
+func placeImportance(tags map[string]string) float64 {
+	var importance float64
+	switch tags["place"] {
+	case "country":
+		importance = 0.9
+	case "state":
+		importance = 0.8
+	case "city":
+		importance = 0.75
+	case "town":
+		importance = 0.6
+	case "village", "suburb", "borough":
+		importance = 0.5
+	case "quarter", "neighbourhood":
+		importance = 0.45
+	case "hamlet", "locality", "square":
+		importance = 0.4
+	default:
+		switch {
+		case tags["tourism"] != "" || tags["historic"] != "":
+			importance = 0.35
+		case tags["name"] != "" && tags["highway"] != "":
+			importance = 0.25
+		case tags["name"] != "":
+			importance = 0.3
+		default:
+			importance = 0.2
+		}
+	}
+	if tags["wikipedia"] != "" || tags["wikidata"] != "" {
+		importance += 0.1
+	}
+	return min(importance, 1)
+}
+
+// boundaryImportance ranks larger administrative areas higher
+func boundaryImportance(level int, tags map[string]string) float64 {
+	importance := max(0.95-0.05*float64(level), 0.3)
+	if tags["wikipedia"] != "" || tags["wikidata"] != "" {
+		importance += 0.05
+	}
+	return min(importance, 1)
+}
+
+// tokenize splits text into lower-case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// uniqueTokens returns the distinct words of the texts
+func uniqueTokens(texts ...string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, text := range texts {
+		for _, t := range tokenize(text) {
+			if !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// match scores a place against the query words. Every word must occur
+// in the place's own name or address, or in the names of the areas that
+// contain it, and at least one must be its own. The score counts the
+// words the place matched itself.
+func (g *gazetteer) match(i int32, words []string) (int, bool) {
+	p := &g.places[i]
+	own := make(map[string]bool)
+	for _, t := range uniqueTokens(p.name, p.houseNumber, p.street, p.postcode) {
+		own[t] = true
+	}
+	var context map[string]bool
+
+	score := 0
+	for _, w := range words {
+		if own[w] {
+			score++
+			continue
+		}
+		if context == nil {
+			context = make(map[string]bool)
+			texts := []string{p.city}
+			for _, b := range g.hierarchies[p.admin] {
+				texts = append(texts, g.boundaries[b].name)
+			}
+			for _, t := range uniqueTokens(texts...) {
+				context[t] = true
+			}
+		}
+		if !context[w] {
+			return 0, false
+		}
+	}
+	return score, score > 0
+}
+
+// search returns the places matching a query, most important first
+func (g *gazetteer) search(query string, limit int) []osm.GeocodeResult {
+	words := uniqueTokens(query)
+	if len(words) == 0 || limit <= 0 {
+		return nil
+	}
+
+	type candidate struct {
+		place int32
+		score int
+	}
+	var candidates []candidate
+	seen := make(map[int32]bool)
+	for _, w := range words {
+		for _, i := range g.tokens[w] {
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			if score, ok := g.match(i, words); ok {
+				candidates = append(candidates, candidate{place: i, score: score})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		pa, pb := &g.places[candidates[a].place], &g.places[candidates[b].place]
+		if pa.importance != pb.importance {
+			return pa.importance > pb.importance
+		}
+		if candidates[a].score != candidates[b].score {
+			return candidates[a].score > candidates[b].score
+		}
+		return candidates[a].place < candidates[b].place
+	})
+
+	// Streets are split into many ways; report each address once
+	var results []osm.GeocodeResult
+	names := make(map[string]bool)
+	for _, c := range candidates {
+		r := g.result(c.place)
+		if names[r.DisplayName] {
+			continue
+		}
+		names[r.DisplayName] = true
+		results = append(results, r)
+		if len(results) == limit {
+			break
+		}
+	}
+	return results
+}
+
+// reverse returns the place nearest to a point, falling back to the
+// smallest area containing it
+func (g *gazetteer) reverse(lat, lon float64) *osm.GeocodeResult {
+	best := int32(-1)
+	bestDist := float64(maxReverseDistance)
+	center := cellOf(lat, lon)
+	for dy := int32(-1); dy <= 1; dy++ {
+		for dx := int32(-1); dx <= 1; dx++ {
+			for _, i := range g.grid[cell{x: center.x + dx, y: center.y + dy}] {
+				p := &g.places[i]
+				if p.houseNumber == "" && p.street == "" && p.name == "" {
+					continue
+				}
+				d := osm.HaversineDistance(lat, lon, p.lat, p.lon)
+				// Prefer addresses over other features at the same spot
+				if p.houseNumber == "" {
+					d += 10
+				}
+				if d < bestDist {
+					best, bestDist = i, d
+				}
+			}
+		}
+	}
+	if best >= 0 {
+		r := g.result(best)
+		return &r
+	}
+
+	smallest := -1
+	for i := range g.boundaries {
+		if g.boundaries[i].contains(lat, lon) && (smallest < 0 || g.boundaries[i].level > g.boundaries[smallest].level) {
+			smallest = i
+		}
+	}
+	if smallest < 0 {
+		return nil
+	}
+	r := g.result(g.boundaryPlaces[smallest])
+	return &r
+}
+
+// result converts a place to a geocoding result with the address
+// details and display name Nominatim would give it
+func (g *gazetteer) result(i int32) osm.GeocodeResult {
+	p := &g.places[i]
+	hierarchy := g.hierarchies[p.admin]
+
+	addr := osm.GeocodeAddress{
+		Road:        p.street,
+		HouseNumber: p.houseNumber,
+		City:        p.city,
+		PostCode:    p.postcode,
+	}
+	if addr.Road == "" && isHighway(p) {
+		addr.Road = p.name
+	}
+	cityLevel := 0
+	for _, b := range hierarchy {
+		area := &g.boundaries[b]
+		switch {
+		case area.level == 2:
+			addr.Country = area.name
+		case area.level <= 4:
+			addr.State = area.name
+		case area.level <= 8 && p.city == "" && area.level >= cityLevel:
+			addr.City = area.name
+			cityLevel = area.level
+		}
+	}
+
+	// Nominatim lists the parts from the most specific to the country,
+	// with the postcode just before the country
+	parts := []string{p.name, p.houseNumber, addr.Road}
+	for k := len(hierarchy) - 1; k >= 0; k-- {
+		if area := &g.boundaries[hierarchy[k]]; area.level > 2 {
+			parts = append(parts, area.name)
+		}
+	}
+	parts = append(parts, p.postcode, addr.Country)
+
+	var display []string
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		if part != "" && !seen[part] {
+			seen[part] = true
+			display = append(display, part)
+		}
+	}
+
+	return osm.GeocodeResult{
+		ID:          int64(i) + 1,
+		DisplayName: strings.Join(display, ", "),
+		Lat:         p.lat,
+		Lon:         p.lon,
+		Type:        p.kind,
+		Importance:  p.importance,
+		Address:     addr,
+	}
+}
+
+// isHighway reports whether the place is a named street
+func isHighway(p *gazetteerPlace) bool {
+	switch p.kind {
+	case "residential", "primary", "secondary", "tertiary", "unclassified",
+		"living_street", "pedestrian", "trunk", "motorway", "service", "footway":
+		return p.houseNumber == ""
+	}
+	return false
+}
+
+// Search implements osm.Geocoder
+func (d *Dataset) Search(ctx context.Context, query string, limit int) ([]osm.GeocodeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.gazetteer.search(query, limit), nil
+}
+
+// Reverse implements osm.Geocoder
+func (d *Dataset) Reverse(ctx context.Context, lat, lon float64) (*osm.GeocodeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.gazetteer.reverse(lat, lon), nil
+}
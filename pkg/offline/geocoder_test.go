@@ -0,0 +1,204 @@
+package offline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+var _ osm.Geocoder = (*Dataset)(nil)
+
+// square returns the corner nodes of a box, starting at id
+func square(id int64, minLat, minLon, maxLat, maxLon float64) []testNode {
+	return []testNode{
+		{id: id, lat: minLat, lon: minLon},
+		{id: id + 1, lat: minLat, lon: maxLon},
+		{id: id + 2, lat: maxLat, lon: maxLon},
+		{id: id + 3, lat: maxLat, lon: minLon},
+	}
+}
+
+// geocodeExtract builds an extract with three nested boundaries, a city,
+// a street, addresses and a café
+func geocodeExtract(t *testing.T) []byte {
+	t.Helper()
+	nodes := []testNode{
+		{id: 10, lat: 52.5200, lon: 13.4050, tags: []string{"place", "city", "name", "Berlin", "wikidata", "Q64"}},
+		{id: 11, lat: 52.5170, lon: 13.3890, tags: []string{"addr:housenumber", "1", "addr:street", "Unter den Linden", "addr:postcode", "10117"}},
+		{id: 12, lat: 52.4000, lon: 12.5000, tags: []string{"addr:housenumber", "1", "addr:street", "Unter den Linden", "addr:city", "Potsdam"}},
+		{id: 13, lat: 52.5200, lon: 13.4061, tags: []string{"amenity", "cafe", "name", "Café Eins"}},
+		{id: 14, lat: 52.5170, lon: 13.3800},
+		{id: 15, lat: 52.5170, lon: 13.4000},
+	}
+	nodes = append(nodes, square(1001, 52.3, 13.0, 52.7, 13.8)...)
+	nodes = append(nodes, square(2001, 47, 5, 55, 15)...)
+	nodes = append(nodes, square(3001, 52.50, 13.36, 52.54, 13.42)...)
+
+	w := &pbfWriter{}
+	w.header(t, "OsmSchema-V0.6", "DenseNodes")
+	w.data(t, nodes, nil, []testWay{
+		{id: 101, tags: []string{"highway", "residential", "name", "Unter den Linden"}, nodes: []int64{14, 15}},
+		// Berlin's outline is split in two ways running in opposite directions
+		{id: 500, nodes: []int64{1001, 1002, 1003}},
+		{id: 501, nodes: []int64{1001, 1004, 1003}},
+		{id: 600, nodes: []int64{2001, 2002, 2003, 2004, 2001}},
+		{id: 700, nodes: []int64{3001, 3002, 3003, 3004, 3001}},
+	})
+	w.relations(t, []testRelation{
+		{id: 1, tags: []string{"type", "boundary", "boundary", "administrative", "admin_level", "2", "name", "Deutschland"},
+			members: []Member{{Type: "way", ID: 600, Role: "outer"}}},
+		{id: 2, tags: []string{"type", "boundary", "boundary", "administrative", "admin_level", "4", "name", "Berlin"},
+			members: []Member{{Type: "way", ID: 500, Role: "outer"}, {Type: "way", ID: 501, Role: "outer"}, {Type: "node", ID: 10, Role: "label"}}},
+		{id: 3, tags: []string{"type", "boundary", "boundary", "administrative", "admin_level", "9", "name", "Mitte"},
+			members: []Member{{Type: "way", ID: 700, Role: "outer"}}},
+		// Unnamed boundaries are ignored
+		{id: 4, tags: []string{"type", "boundary", "boundary", "administrative", "admin_level", "6"},
+			members: []Member{{Type: "way", ID: 700, Role: "outer"}}},
+	})
+	return w.buf.Bytes()
+}
+
+func TestDecodePBFRelations(t *testing.T) {
+	var relations []Relation
+	err := DecodePBF(bytes.NewReader(geocodeExtract(t)), Handler{
+		Relation: func(r Relation) { relations = append(relations, r) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relations) != 4 {
+		t.Fatalf("decoded %d relations, want 4", len(relations))
+	}
+	r := relations[1]
+	if r.ID != 2 || r.Tags["name"] != "Berlin" || len(r.Members) != 3 {
+		t.Fatalf("relation 2 = %+v", r)
+	}
+	if m := r.Members[2]; m.Type != "node" || m.ID != 10 || m.Role != "label" {
+		t.Errorf("label member = %+v", m)
+	}
+	if m := r.Members[1]; m.Type != "way" || m.ID != 501 || m.Role != "outer" {
+		t.Errorf("second member = %+v", m)
+	}
+}
+
+func TestGeocoderSearch(t *testing.T) {
+	d := loadExtract(t, geocodeExtract(t))
+	ctx := context.Background()
+
+	if got := len(d.gazetteer.boundaries); got != 3 {
+		t.Fatalf("loaded %d boundaries, want 3", got)
+	}
+
+	// The city node and the boundary share a display name; only the node
+	// is reported
+	results, err := d.Search(ctx, "Berlin", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Search(Berlin) = %+v, want one result", results)
+	}
+	if r := results[0]; r.Type != "city" || r.DisplayName != "Berlin, Deutschland" || r.Address.Country != "Deutschland" {
+		t.Errorf("Search(Berlin) = %+v", r)
+	}
+
+	// Area names narrow the search to addresses inside the area
+	results, err = d.Search(ctx, "Unter den Linden 1, Berlin", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Search(Unter den Linden 1, Berlin) = %+v, want one result", results)
+	}
+	want := osm.GeocodeAddress{Road: "Unter den Linden", HouseNumber: "1", State: "Berlin", Country: "Deutschland", PostCode: "10117"}
+	if r := results[0]; r.Type != "house" || r.Address != want || r.DisplayName != "1, Unter den Linden, Mitte, Berlin, 10117, Deutschland" {
+		t.Errorf("address = %+v", r)
+	}
+
+	// addr:city counts as context too
+	results, err = d.Search(ctx, "unter den linden 1 potsdam", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Address.City != "Potsdam" {
+		t.Errorf("Search(unter den linden 1 potsdam) = %+v", results)
+	}
+
+	// Named streets rank above bare addresses
+	results, err = d.Search(ctx, "Unter den Linden", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Search(Unter den Linden) returned %d results, want 3", len(results))
+	}
+	if r := results[0]; r.Type != "residential" || r.Address.Road != "Unter den Linden" {
+		t.Errorf("first result = %+v, want the street", r)
+	}
+
+	if results, _ := d.Search(ctx, "Hamburg", 5); len(results) != 0 {
+		t.Errorf("Search(Hamburg) = %+v, want none", results)
+	}
+}
+
+func TestGeocoderReverse(t *testing.T) {
+	d := loadExtract(t, geocodeExtract(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		display  string
+		typ      string
+	}{
+		{"address", 52.5171, 13.3891, "1, Unter den Linden, Mitte, Berlin, 10117, Deutschland", "house"},
+		{"nearest feature", 52.5200, 13.4062, "Café Eins, Mitte, Berlin, Deutschland", "cafe"},
+		{"containing area", 52.6500, 13.7000, "Berlin, Deutschland", "administrative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := d.Reverse(ctx, tt.lat, tt.lon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r == nil {
+				t.Fatal("Reverse() = nil")
+			}
+			if r.DisplayName != tt.display || r.Type != tt.typ {
+				t.Errorf("Reverse() = %q (%s), want %q (%s)", r.DisplayName, r.Type, tt.display, tt.typ)
+			}
+		})
+	}
+
+	r, err := d.Reverse(ctx, 60, 0)
+	if err != nil || r != nil {
+		t.Errorf("Reverse() outside the extract = %+v, %v; want nil", r, err)
+	}
+}
+
+func TestBoundaryContains(t *testing.T) {
+	outer := []point{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}
+	hole := []point{{0.4, 0.4}, {0.4, 0.6}, {0.6, 0.6}, {0.6, 0.4}, {0.4, 0.4}}
+	b := newBoundary(map[string]string{"name": "Ring"}, 8, [][]point{outer, hole}, nil)
+
+	tests := []struct {
+		lat, lon float64
+		want     bool
+	}{
+		{0.2, 0.2, true},
+		{0.5, 0.5, false}, // inside the hole
+		{0.5, 0.9, true},
+		{1.5, 0.5, false},
+		{0.5, -0.1, false},
+	}
+	for _, tt := range tests {
+		if got := b.contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("contains(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+		}
+	}
+	if b.label != (point{0.5, 0.5}) {
+		t.Errorf("label = %+v, want the center of the box", b.label)
+	}
+}
@@ -0,0 +1,616 @@
+package offline
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// maxBlobHeaderSize and maxBlobSize are the limits set by the PBF
+	// specification
+	maxBlobHeaderSize = 64 << 10
+	maxBlobSize       = 32 << 20
+)
+
+// supportedFeatures are the required features of a PBF header that the
+// decoder understands
+var supportedFeatures = map[string]bool{
+	"OsmSchema-V0.6": true,
+	"DenseNodes":     true,
+}
+
+// Node is a decoded OSM node. Tags is nil for untagged nodes.
+type Node struct {
+	ID       int64
+	Lat, Lon float64
+	Tags     map[string]string
+}
+
+// Way is a decoded OSM way with the IDs of its nodes
+type Way struct {
+	ID      int64
+	Tags    map[string]string
+	NodeIDs []int64
+}
+
+// Member is one member of a relation
+type Member struct {
+	Type string // "node", "way" or "relation"
+	ID   int64
+	Role string
+}
+
+// Relation is a decoded OSM relation
+type Relation struct {
+	ID      int64
+	Tags    map[string]string
+	Members []Member
+}
+
+// Handler receives the decoded elements of a PBF file. Nil callbacks
+// skip the element kind.
+type Handler struct {
+	Node     func(Node)
+	Way      func(Way)
+	Relation func(Relation)
+}
+
+// DecodePBF reads an OSM PBF file and passes its elements to h in file
+// order. Only uncompressed and zlib compressed blobs are supported, which
+// covers the extracts published by Geofabrik and planet.openstreetmap.org.
+func DecodePBF(r io.Reader, h Handler) error {
+	var sizeBuf [4]byte
+	for {
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read blob header size: %w", err)
+		}
+		size := binary.BigEndian.Uint32(sizeBuf[:])
+		if size > maxBlobHeaderSize {
+			return fmt.Errorf("blob header too large: %d bytes", size)
+		}
+
+		headerBuf := make([]byte, size)
+		if _, err := io.ReadFull(r, headerBuf); err != nil {
+			return fmt.Errorf("failed to read blob header: %w", err)
+		}
+		blobType, dataSize, err := parseBlobHeader(headerBuf)
+		if err != nil {
+			return err
+		}
+		if dataSize > maxBlobSize {
+			return fmt.Errorf("blob too large: %d bytes", dataSize)
+		}
+
+		blobBuf := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, blobBuf); err != nil {
+			return fmt.Errorf("failed to read blob: %w", err)
+		}
+		data, err := blobData(blobBuf)
+		if err != nil {
+			return err
+		}
+
+		switch blobType {
+		case "OSMHeader":
+			if err := checkHeaderBlock(data); err != nil {
+				return err
+			}
+		case "OSMData":
+			if err := decodePrimitiveBlock(data, h); err != nil {
+				return err
+			}
+		default:
+			// Unknown blob types are skipped, as the specification asks
+		}
+	}
+}
+
+// parseBlobHeader returns the type and data size of a BlobHeader
+func parseBlobHeader(buf []byte) (string, int, error) {
+	var blobType string
+	var dataSize int
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			blobType = string(m.bytes())
+		case 3:
+			dataSize = int(m.varint())
+		}
+	}
+	if m.err != nil {
+		return "", 0, fmt.Errorf("invalid blob header: %w", m.err)
+	}
+	return blobType, dataSize, nil
+}
+
+// blobData returns the uncompressed content of a Blob
+func blobData(buf []byte) ([]byte, error) {
+	var raw, zlibData []byte
+	rawSize := -1
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			raw = m.bytes()
+		case 2:
+			rawSize = int(m.varint())
+		case 3:
+			zlibData = m.bytes()
+		case 4, 5, 6, 7:
+			return nil, fmt.Errorf("unsupported blob compression (field %d)", m.field)
+		}
+	}
+	if m.err != nil {
+		return nil, fmt.Errorf("invalid blob: %w", m.err)
+	}
+
+	if raw != nil {
+		return raw, nil
+	}
+	if zlibData == nil {
+		return nil, errors.New("blob has no data")
+	}
+	if rawSize < 0 || rawSize > maxBlobSize {
+		return nil, fmt.Errorf("invalid blob raw size %d", rawSize)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(zlibData))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zlib blob: %w", err)
+	}
+	defer zr.Close()
+	data := make([]byte, rawSize)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("failed to inflate blob: %w", err)
+	}
+	return data, nil
+}
+
+// checkHeaderBlock fails if the file needs features the decoder lacks
+func checkHeaderBlock(buf []byte) error {
+	m := message(buf)
+	for m.next() {
+		if m.field == 4 {
+			if feature := string(m.bytes()); !supportedFeatures[feature] {
+				return fmt.Errorf("unsupported PBF feature %q", feature)
+			}
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid header block: %w", m.err)
+	}
+	return nil
+}
+
+// block holds the shared state of a PrimitiveBlock
+type block struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+// coord converts a stored coordinate to degrees
+func (b *block) coord(offset, value int64) float64 {
+	return 1e-9 * float64(offset+b.granularity*value)
+}
+
+// tags builds a tag map from string table indexes
+func (b *block) tags(keys, vals []uint64) (map[string]string, error) {
+	if len(keys) != len(vals) {
+		return nil, errors.New("mismatched tag keys and values")
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(keys))
+	for i := range keys {
+		k, err := b.string(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		v, err := b.string(vals[i])
+		if err != nil {
+			return nil, err
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// string looks up an entry of the string table
+func (b *block) string(i uint64) (string, error) {
+	if i >= uint64(len(b.strings)) {
+		return "", fmt.Errorf("string index %d out of range", i)
+	}
+	return string(b.strings[i]), nil
+}
+
+// decodePrimitiveBlock passes the elements of a PrimitiveBlock to h
+func decodePrimitiveBlock(buf []byte, h Handler) error {
+	b := &block{granularity: 100}
+	var groups [][]byte
+
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			st := message(m.bytes())
+			for st.next() {
+				if st.field == 1 {
+					b.strings = append(b.strings, st.bytes())
+				}
+			}
+			if st.err != nil {
+				return fmt.Errorf("invalid string table: %w", st.err)
+			}
+		case 2:
+			groups = append(groups, m.bytes())
+		case 17:
+			b.granularity = int64(m.varint())
+		case 19:
+			b.latOffset = int64(m.varint())
+		case 20:
+			b.lonOffset = int64(m.varint())
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid primitive block: %w", m.err)
+	}
+
+	// Groups are decoded after the whole block was read, since the string
+	// table and granularity may follow them
+	for _, g := range groups {
+		if err := b.decodeGroup(g, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeGroup passes the elements of a PrimitiveGroup to h
+func (b *block) decodeGroup(buf []byte, h Handler) error {
+	m := message(buf)
+	for m.next() {
+		var err error
+		switch m.field {
+		case 1:
+			if h.Node != nil {
+				err = b.decodeNode(m.bytes(), h.Node)
+			}
+		case 2:
+			if h.Node != nil {
+				err = b.decodeDenseNodes(m.bytes(), h.Node)
+			}
+		case 3:
+			if h.Way != nil {
+				err = b.decodeWay(m.bytes(), h.Way)
+			}
+		case 4:
+			if h.Relation != nil {
+				err = b.decodeRelation(m.bytes(), h.Relation)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid primitive group: %w", m.err)
+	}
+	return nil
+}
+
+// decodeNode decodes a plain Node message
+func (b *block) decodeNode(buf []byte, fn func(Node)) error {
+	var n Node
+	var keys, vals []uint64
+	var lat, lon int64
+
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			n.ID = zigzag(m.varint())
+		case 2:
+			keys = m.packedVarints(keys)
+		case 3:
+			vals = m.packedVarints(vals)
+		case 8:
+			lat = zigzag(m.varint())
+		case 9:
+			lon = zigzag(m.varint())
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid node: %w", m.err)
+	}
+
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("node %d: %w", n.ID, err)
+	}
+	n.Tags = tags
+	n.Lat = b.coord(b.latOffset, lat)
+	n.Lon = b.coord(b.lonOffset, lon)
+	fn(n)
+	return nil
+}
+
+// decodeDenseNodes decodes a DenseNodes message, whose IDs and
+// coordinates are delta coded and whose tags are one list of key and
+// value indexes with each node's tags ended by a 0
+func (b *block) decodeDenseNodes(buf []byte, fn func(Node)) error {
+	var ids, lats, lons, keysVals []uint64
+
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			ids = m.packedVarints(ids)
+		case 8:
+			lats = m.packedVarints(lats)
+		case 9:
+			lons = m.packedVarints(lons)
+		case 10:
+			keysVals = m.packedVarints(keysVals)
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid dense nodes: %w", m.err)
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errors.New("invalid dense nodes: mismatched lengths")
+	}
+
+	var id, lat, lon int64
+	kv := 0
+	for i := range ids {
+		id += zigzag(ids[i])
+		lat += zigzag(lats[i])
+		lon += zigzag(lons[i])
+
+		var tags map[string]string
+		if len(keysVals) > 0 {
+			for kv < len(keysVals) && keysVals[kv] != 0 {
+				if kv+1 >= len(keysVals) {
+					return fmt.Errorf("node %d: truncated tags", id)
+				}
+				k, err := b.string(keysVals[kv])
+				if err != nil {
+					return fmt.Errorf("node %d: %w", id, err)
+				}
+				v, err := b.string(keysVals[kv+1])
+				if err != nil {
+					return fmt.Errorf("node %d: %w", id, err)
+				}
+				if tags == nil {
+					tags = make(map[string]string)
+				}
+				tags[k] = v
+				kv += 2
+			}
+			kv++ // Skip the 0 that ends the node's tags
+		}
+
+		fn(Node{
+			ID:   id,
+			Lat:  b.coord(b.latOffset, lat),
+			Lon:  b.coord(b.lonOffset, lon),
+			Tags: tags,
+		})
+	}
+	return nil
+}
+
+// decodeWay decodes a Way message
+func (b *block) decodeWay(buf []byte, fn func(Way)) error {
+	var w Way
+	var keys, vals, refs []uint64
+
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			w.ID = int64(m.varint())
+		case 2:
+			keys = m.packedVarints(keys)
+		case 3:
+			vals = m.packedVarints(vals)
+		case 8:
+			refs = m.packedVarints(refs)
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid way: %w", m.err)
+	}
+
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("way %d: %w", w.ID, err)
+	}
+	w.Tags = tags
+
+	w.NodeIDs = make([]int64, len(refs))
+	var ref int64
+	for i, r := range refs {
+		ref += zigzag(r)
+		w.NodeIDs[i] = ref
+	}
+	fn(w)
+	return nil
+}
+
+// memberTypes maps the MemberType enum to element types
+var memberTypes = [...]string{"node", "way", "relation"}
+
+// decodeRelation decodes a Relation message
+func (b *block) decodeRelation(buf []byte, fn func(Relation)) error {
+	var r Relation
+	var keys, vals, roles, ids, types []uint64
+
+	m := message(buf)
+	for m.next() {
+		switch m.field {
+		case 1:
+			r.ID = int64(m.varint())
+		case 2:
+			keys = m.packedVarints(keys)
+		case 3:
+			vals = m.packedVarints(vals)
+		case 8:
+			roles = m.packedVarints(roles)
+		case 9:
+			ids = m.packedVarints(ids)
+		case 10:
+			types = m.packedVarints(types)
+		}
+	}
+	if m.err != nil {
+		return fmt.Errorf("invalid relation: %w", m.err)
+	}
+	if len(roles) != len(ids) || len(types) != len(ids) {
+		return fmt.Errorf("relation %d: mismatched member lengths", r.ID)
+	}
+
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("relation %d: %w", r.ID, err)
+	}
+	r.Tags = tags
+
+	r.Members = make([]Member, len(ids))
+	var id int64
+	for i := range ids {
+		id += zigzag(ids[i])
+		if types[i] >= uint64(len(memberTypes)) {
+			return fmt.Errorf("relation %d: invalid member type %d", r.ID, types[i])
+		}
+		role, err := b.string(roles[i])
+		if err != nil {
+			return fmt.Errorf("relation %d: %w", r.ID, err)
+		}
+		r.Members[i] = Member{Type: memberTypes[types[i]], ID: id, Role: role}
+	}
+	fn(r)
+	return nil
+}
+
+// zigzag decodes a sint64 value
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// protobuf wire types used by the PBF format
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// wireMessage iterates over the fields of an encoded protobuf message.
+// Errors stop the iteration and are kept in err.
+type wireMessage struct {
+	buf   []byte
+	field int
+	wire  int
+	value []byte // payload of a bytes field
+	num   uint64 // value of a varint field
+	err   error
+}
+
+// message starts iterating over an encoded message
+func message(buf []byte) *wireMessage {
+	return &wireMessage{buf: buf}
+}
+
+// next advances to the next field
+func (m *wireMessage) next() bool {
+	if m.err != nil || len(m.buf) == 0 {
+		return false
+	}
+
+	key, n := binary.Uvarint(m.buf)
+	if n <= 0 {
+		m.err = errors.New("invalid field key")
+		return false
+	}
+	m.buf = m.buf[n:]
+	m.field = int(key >> 3)
+	m.wire = int(key & 7)
+	m.value = nil
+	m.num = 0
+
+	switch m.wire {
+	case wireVarint:
+		v, n := binary.Uvarint(m.buf)
+		if n <= 0 {
+			m.err = errors.New("invalid varint")
+			return false
+		}
+		m.num = v
+		m.buf = m.buf[n:]
+	case wireBytes:
+		l, n := binary.Uvarint(m.buf)
+		if n <= 0 || l > uint64(len(m.buf)-n) {
+			m.err = errors.New("invalid length")
+			return false
+		}
+		m.value = m.buf[n : n+int(l)]
+		m.buf = m.buf[n+int(l):]
+	case wireFixed64:
+		if len(m.buf) < 8 {
+			m.err = errors.New("truncated fixed64")
+			return false
+		}
+		m.buf = m.buf[8:]
+	case wireFixed32:
+		if len(m.buf) < 4 {
+			m.err = errors.New("truncated fixed32")
+			return false
+		}
+		m.buf = m.buf[4:]
+	default:
+		m.err = fmt.Errorf("unsupported wire type %d", m.wire)
+		return false
+	}
+	return true
+}
+
+// varint returns the value of the current varint field
+func (m *wireMessage) varint() uint64 {
+	return m.num
+}
+
+// bytes returns the payload of the current bytes field
+func (m *wireMessage) bytes() []byte {
+	return m.value
+}
+
+// packedVarints appends the values of the current repeated varint field,
+// which may be packed or a single unpacked value
+func (m *wireMessage) packedVarints(dst []uint64) []uint64 {
+	if m.wire == wireVarint {
+		return append(dst, m.num)
+	}
+	buf := m.value
+	for len(buf) > 0 {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			m.err = errors.New("invalid packed varint")
+			return dst
+		}
+		dst = append(dst, v)
+		buf = buf[n:]
+	}
+	return dst
+}
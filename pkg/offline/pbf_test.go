@@ -0,0 +1,304 @@
+package offline
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// pbfWriter encodes a minimal PBF file for tests
+type pbfWriter struct {
+	buf bytes.Buffer
+}
+
+// testNode and testWay describe the elements written by pbfWriter
+type testNode struct {
+	id       int64
+	lat, lon float64
+	tags     []string // key, value, key, value...
+}
+
+type testWay struct {
+	id    int64
+	tags  []string
+	nodes []int64
+}
+
+type testRelation struct {
+	id      int64
+	tags    []string
+	members []Member
+}
+
+func appendKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendKey(b, field, wireVarint), v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(appendKey(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendPacked(b []byte, field int, values []uint64) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, v)
+	}
+	return appendBytesField(b, field, packed)
+}
+
+func encodeZigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// blob writes one file block, zlib compressed if compress is set
+func (w *pbfWriter) blob(t *testing.T, blobType string, data []byte, compress bool) {
+	t.Helper()
+
+	var blob []byte
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		blob = appendVarintField(blob, 2, uint64(len(data)))
+		blob = appendBytesField(blob, 3, z.Bytes())
+	} else {
+		blob = appendBytesField(blob, 1, data)
+	}
+
+	var header []byte
+	header = appendBytesField(header, 1, []byte(blobType))
+	header = appendVarintField(header, 3, uint64(len(blob)))
+
+	binary.Write(&w.buf, binary.BigEndian, uint32(len(header)))
+	w.buf.Write(header)
+	w.buf.Write(blob)
+}
+
+// header writes an OSMHeader block requiring features
+func (w *pbfWriter) header(t *testing.T, features ...string) {
+	var block []byte
+	for _, f := range features {
+		block = appendBytesField(block, 4, []byte(f))
+	}
+	w.blob(t, "OSMHeader", block, false)
+}
+
+// data writes an OSMData block with dense nodes, plain nodes and ways
+func (w *pbfWriter) data(t *testing.T, dense, plain []testNode, ways []testWay) {
+	strs := []string{""}
+	index := map[string]uint64{}
+	str := func(s string) uint64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+	coord := func(deg float64) int64 {
+		return int64(math.Round(deg * 1e7)) // granularity 100 nanodegrees
+	}
+
+	var group []byte
+	if len(dense) > 0 {
+		var ids, lats, lons, kv []uint64
+		var prevID, prevLat, prevLon int64
+		for _, n := range dense {
+			lat, lon := coord(n.lat), coord(n.lon)
+			ids = append(ids, encodeZigzag(n.id-prevID))
+			lats = append(lats, encodeZigzag(lat-prevLat))
+			lons = append(lons, encodeZigzag(lon-prevLon))
+			prevID, prevLat, prevLon = n.id, lat, lon
+			for _, s := range n.tags {
+				kv = append(kv, str(s))
+			}
+			kv = append(kv, 0)
+		}
+		var d []byte
+		d = appendPacked(d, 1, ids)
+		d = appendPacked(d, 8, lats)
+		d = appendPacked(d, 9, lons)
+		d = appendPacked(d, 10, kv)
+		group = appendBytesField(group, 2, d)
+	}
+	for _, n := range plain {
+		var keys, vals []uint64
+		for i := 0; i < len(n.tags); i += 2 {
+			keys = append(keys, str(n.tags[i]))
+			vals = append(vals, str(n.tags[i+1]))
+		}
+		var m []byte
+		m = appendVarintField(m, 1, encodeZigzag(n.id))
+		m = appendPacked(m, 2, keys)
+		m = appendPacked(m, 3, vals)
+		m = appendVarintField(m, 8, encodeZigzag(coord(n.lat)))
+		m = appendVarintField(m, 9, encodeZigzag(coord(n.lon)))
+		group = appendBytesField(group, 1, m)
+	}
+	for _, way := range ways {
+		var keys, vals, refs []uint64
+		for i := 0; i < len(way.tags); i += 2 {
+			keys = append(keys, str(way.tags[i]))
+			vals = append(vals, str(way.tags[i+1]))
+		}
+		var prev int64
+		for _, id := range way.nodes {
+			refs = append(refs, encodeZigzag(id-prev))
+			prev = id
+		}
+		var m []byte
+		m = appendVarintField(m, 1, uint64(way.id))
+		m = appendPacked(m, 2, keys)
+		m = appendPacked(m, 3, vals)
+		m = appendPacked(m, 8, refs)
+		group = appendBytesField(group, 3, m)
+	}
+
+	// The string table is written after the groups, which the decoder
+	// must accept
+	var block []byte
+	block = appendBytesField(block, 2, group)
+	var table []byte
+	for _, s := range strs {
+		table = appendBytesField(table, 1, []byte(s))
+	}
+	block = appendBytesField(block, 1, table)
+	w.blob(t, "OSMData", block, true)
+}
+
+// relations writes an OSMData block with relations
+func (w *pbfWriter) relations(t *testing.T, relations []testRelation) {
+	strs := []string{""}
+	index := map[string]uint64{}
+	str := func(s string) uint64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+	memberType := map[string]uint64{"node": 0, "way": 1, "relation": 2}
+
+	var group []byte
+	for _, r := range relations {
+		var keys, vals, roles, ids, types []uint64
+		for i := 0; i < len(r.tags); i += 2 {
+			keys = append(keys, str(r.tags[i]))
+			vals = append(vals, str(r.tags[i+1]))
+		}
+		var prev int64
+		for _, m := range r.members {
+			roles = append(roles, str(m.Role))
+			ids = append(ids, encodeZigzag(m.ID-prev))
+			types = append(types, memberType[m.Type])
+			prev = m.ID
+		}
+		var m []byte
+		m = appendVarintField(m, 1, uint64(r.id))
+		m = appendPacked(m, 2, keys)
+		m = appendPacked(m, 3, vals)
+		m = appendPacked(m, 8, roles)
+		m = appendPacked(m, 9, ids)
+		m = appendPacked(m, 10, types)
+		group = appendBytesField(group, 4, m)
+	}
+
+	var table []byte
+	for _, s := range strs {
+		table = appendBytesField(table, 1, []byte(s))
+	}
+	var block []byte
+	block = appendBytesField(block, 1, table)
+	block = appendBytesField(block, 2, group)
+	w.blob(t, "OSMData", block, true)
+}
+
+// testExtract builds a small extract around Berlin Mitte
+func testExtract(t *testing.T) []byte {
+	t.Helper()
+	w := &pbfWriter{}
+	w.header(t, "OsmSchema-V0.6", "DenseNodes")
+	w.data(t,
+		[]testNode{
+			{id: 1, lat: 52.5200, lon: 13.4050, tags: []string{"amenity", "cafe", "name", "Café Eins"}},
+			{id: 2, lat: 52.5210, lon: 13.4060},
+			{id: 3, lat: 52.5290, lon: 13.4050, tags: []string{"amenity", "school", "name", "Schule"}},
+			{id: 10, lat: 52.5220, lon: 13.4100},
+			{id: 11, lat: 52.5240, lon: 13.4100},
+			{id: 12, lat: 52.5240, lon: 13.4140},
+		},
+		[]testNode{
+			{id: 4, lat: 52.5000, lon: 13.3000, tags: []string{"amenity", "cafe", "name", "Fern"}},
+		},
+		[]testWay{
+			{id: 100, tags: []string{"amenity", "parking", "parking", "surface"}, nodes: []int64{10, 11, 12, 10}},
+			{id: 101, tags: []string{"highway", "residential"}, nodes: []int64{2, 10}},
+			{id: 102, nodes: []int64{1, 2}},
+		},
+	)
+	return w.buf.Bytes()
+}
+
+func TestDecodePBF(t *testing.T) {
+	var nodes []Node
+	var ways []Way
+	err := DecodePBF(bytes.NewReader(testExtract(t)), Handler{
+		Node: func(n Node) { nodes = append(nodes, n) },
+		Way:  func(w Way) { ways = append(ways, w) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 7 {
+		t.Fatalf("decoded %d nodes, want 7", len(nodes))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	first := nodes[0]
+	if first.ID != 1 || math.Abs(first.Lat-52.52) > 1e-9 || math.Abs(first.Lon-13.405) > 1e-9 {
+		t.Errorf("node 1 = %+v", first)
+	}
+	if first.Tags["name"] != "Café Eins" || first.Tags["amenity"] != "cafe" {
+		t.Errorf("node 1 tags = %v", first.Tags)
+	}
+	if nodes[1].Tags != nil {
+		t.Errorf("untagged node has tags %v", nodes[1].Tags)
+	}
+	if plain := nodes[3]; plain.ID != 4 || plain.Tags["name"] != "Fern" || math.Abs(plain.Lon-13.3) > 1e-9 {
+		t.Errorf("plain node = %+v", plain)
+	}
+
+	if len(ways) != 3 {
+		t.Fatalf("decoded %d ways, want 3", len(ways))
+	}
+	if w := ways[0]; w.ID != 100 || w.Tags["amenity"] != "parking" || len(w.NodeIDs) != 4 || w.NodeIDs[2] != 12 {
+		t.Errorf("way 100 = %+v", w)
+	}
+}
+
+func TestDecodePBFRejectsUnsupportedFeatures(t *testing.T) {
+	w := &pbfWriter{}
+	w.header(t, "OsmSchema-V0.6", "HistoricalInformation")
+	err := DecodePBF(bytes.NewReader(w.buf.Bytes()), Handler{})
+	if err == nil || !strings.Contains(err.Error(), "HistoricalInformation") {
+		t.Errorf("DecodePBF() error = %v, want unsupported feature", err)
+	}
+}
+
+func TestDecodePBFTruncated(t *testing.T) {
+	data := testExtract(t)
+	if err := DecodePBF(bytes.NewReader(data[:len(data)-10]), Handler{}); err == nil {
+		t.Error("DecodePBF() accepted a truncated file")
+	}
+}
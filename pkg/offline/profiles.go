@@ -0,0 +1,184 @@
+package offline
+
+import (
+	"strconv"
+	"strings"
+)
+
+// profile selects the travel mode of a route
+type profile int
+
+const (
+	carProfile profile = iota
+	bikeProfile
+	footProfile
+	numProfiles
+)
+
+// profileNames maps the profile names used by the tools to profiles
+var profileNames = map[string]profile{
+	"car":  carProfile,
+	"bike": bikeProfile,
+	"foot": footProfile,
+}
+
+// profileRules holds the tag rules of one travel mode. Speeds are in
+// km/h, as OSM tags them.
+type profileRules struct {
+	// speeds lists the routable highway types and their default speed
+	speeds map[string]float64
+
+	// accessKeys are the access tags that apply, most specific first.
+	// The first one present decides.
+	accessKeys []string
+
+	// onewayKeys are the oneway tags that apply, most specific first.
+	// Without any, the mode ignores oneway streets.
+	onewayKeys []string
+
+	// impliedOneway makes roundabouts and motorways oneway unless tagged
+	// otherwise
+	impliedOneway bool
+
+	// contraflow opens oneway streets with cycleway=opposite lanes
+	contraflow bool
+
+	// maxSpeed caps every speed, including tagged maxspeeds, so the A*
+	// heuristic never overestimates
+	maxSpeed float64
+
+	// useMaxspeed makes tagged maxspeeds override the defaults
+	useMaxspeed bool
+}
+
+// rules are the profiles' tag rules, modelled on OSRM's default profiles
+var rules = [numProfiles]profileRules{
+	carProfile: {
+		speeds: map[string]float64{
+			"motorway": 110, "motorway_link": 60,
+			"trunk": 90, "trunk_link": 50,
+			"primary": 70, "primary_link": 40,
+			"secondary": 60, "secondary_link": 40,
+			"tertiary": 50, "tertiary_link": 30,
+			"unclassified": 40, "residential": 30,
+			"living_street": 10, "service": 15,
+		},
+		accessKeys:    []string{"motorcar", "motor_vehicle", "vehicle", "access"},
+		onewayKeys:    []string{"oneway"},
+		impliedOneway: true,
+		maxSpeed:      130,
+		useMaxspeed:   true,
+	},
+	bikeProfile: {
+		speeds: map[string]float64{
+			"trunk": 15, "trunk_link": 15,
+			"primary": 15, "primary_link": 15,
+			"secondary": 15, "secondary_link": 15,
+			"tertiary": 15, "tertiary_link": 15,
+			"unclassified": 15, "residential": 15,
+			"living_street": 12, "service": 12,
+			"cycleway": 18, "track": 12, "path": 12,
+			// Bikes are pushed along footways
+			"footway": 5, "pedestrian": 5,
+		},
+		accessKeys:    []string{"bicycle", "vehicle", "access"},
+		onewayKeys:    []string{"oneway:bicycle", "oneway"},
+		impliedOneway: true,
+		contraflow:    true,
+		maxSpeed:      18,
+	},
+	footProfile: {
+		speeds: map[string]float64{
+			"trunk": 5, "trunk_link": 5,
+			"primary": 5, "primary_link": 5,
+			"secondary": 5, "secondary_link": 5,
+			"tertiary": 5, "tertiary_link": 5,
+			"unclassified": 5, "residential": 5,
+			"living_street": 5, "service": 5,
+			"cycleway": 5, "track": 5, "path": 5,
+			"footway": 5, "pedestrian": 5, "steps": 3,
+		},
+		accessKeys: []string{"foot", "access"},
+		onewayKeys: []string{"oneway:foot"},
+		maxSpeed:   5,
+	},
+}
+
+// deniedAccess are the access values that close a way
+var deniedAccess = map[string]bool{
+	"no": true, "private": true, "agricultural": true, "forestry": true, "delivery": true,
+}
+
+// direction tells which way along a way a mode may travel
+type direction uint8
+
+const (
+	forward direction = 1 << iota
+	backward
+)
+
+// access returns the directions a mode may travel along a way and its
+// speed in km/h. A zero direction means the way is closed to the mode.
+func (r *profileRules) access(tags map[string]string) (direction, float64) {
+	speed, ok := r.speeds[tags["highway"]]
+	if !ok || tags["area"] == "yes" {
+		return 0, 0
+	}
+	for _, key := range r.accessKeys {
+		if v, ok := tags[key]; ok {
+			if deniedAccess[v] {
+				return 0, 0
+			}
+			break
+		}
+	}
+
+	if r.useMaxspeed {
+		if s, ok := parseMaxspeed(tags["maxspeed"]); ok {
+			speed = s
+		}
+	}
+	speed = min(speed, r.maxSpeed)
+
+	return r.oneway(tags), speed
+}
+
+// oneway returns the directions a mode may travel along a way
+func (r *profileRules) oneway(tags map[string]string) direction {
+	both := forward | backward
+	if r.contraflow && strings.HasPrefix(tags["cycleway"], "opposite") {
+		return both
+	}
+	for _, key := range r.onewayKeys {
+		switch tags[key] {
+		case "yes", "true", "1":
+			return forward
+		case "-1", "reverse":
+			return backward
+		case "no", "false", "0":
+			return both
+		}
+	}
+	if r.impliedOneway {
+		switch {
+		case tags["junction"] == "roundabout", tags["junction"] == "circular",
+			tags["highway"] == "motorway":
+			return forward
+		}
+	}
+	return both
+}
+
+// parseMaxspeed parses a maxspeed tag in km/h or mph
+func parseMaxspeed(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	factor := 1.0
+	if s, ok := strings.CutSuffix(v, "mph"); ok {
+		v, factor = strings.TrimSpace(s), 1.609344
+	}
+	speed, err := strconv.ParseFloat(v, 64)
+	if err != nil || speed <= 0 {
+		return 0, false
+	}
+	return speed * factor, true
+}
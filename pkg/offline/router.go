@@ -0,0 +1,434 @@
+package offline
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// maxSnapDistance is how far from the road network route endpoints may
+// be, in meters
+const maxSnapDistance = 1000
+
+// graphEdge is a directed edge of the road graph
+type graphEdge struct {
+	to     int32
+	way    int32
+	length float32 // meters
+
+	// access has a bit per profile that may use the edge
+	access uint8
+}
+
+// graphWay holds what routing needs of one way
+type graphWay struct {
+	name       string
+	roundabout bool
+	speeds     [numProfiles]float32 // meters per second
+}
+
+// graph is the road network of an extract in compressed sparse row form
+type graph struct {
+	lat, lon []float64
+
+	// edges[first[n]:first[n+1]] are the edges leaving node n
+	first []int32
+	edges []graphEdge
+
+	ways []graphWay
+
+	// grid lists the nodes in each cell
+	grid map[cell][]int32
+}
+
+// newGraph builds the road graph from the ways routable by any profile
+func newGraph(ways []Way, coords *nodeCoords) *graph {
+	g := &graph{grid: make(map[cell][]int32)}
+
+	type rawEdge struct {
+		from int32
+		edge graphEdge
+	}
+	var raw []rawEdge
+	nodes := make(map[int64]int32)
+	node := func(id int64) (int32, bool) {
+		if n, ok := nodes[id]; ok {
+			return n, true
+		}
+		lat, lon, ok := coords.lookup(id)
+		if !ok {
+			return 0, false
+		}
+		n := int32(len(g.lat))
+		nodes[id] = n
+		g.lat = append(g.lat, lat)
+		g.lon = append(g.lon, lon)
+		c := cellOf(lat, lon)
+		g.grid[c] = append(g.grid[c], n)
+		return n, true
+	}
+
+	for _, w := range ways {
+		var dirs [numProfiles]direction
+		var gw graphWay
+		routable := false
+		for p := range numProfiles {
+			dir, speed := rules[p].access(w.Tags)
+			dirs[p] = dir
+			gw.speeds[p] = float32(speed / 3.6)
+			routable = routable || dir != 0
+		}
+		if !routable {
+			continue
+		}
+		gw.name = w.Tags["name"]
+		if gw.name == "" {
+			gw.name = w.Tags["ref"]
+		}
+		gw.roundabout = w.Tags["junction"] == "roundabout" || w.Tags["junction"] == "circular"
+		wayIndex := int32(len(g.ways))
+		g.ways = append(g.ways, gw)
+
+		var fwd, bwd uint8
+		for p := range numProfiles {
+			if dirs[p]&forward != 0 {
+				fwd |= 1 << p
+			}
+			if dirs[p]&backward != 0 {
+				bwd |= 1 << p
+			}
+		}
+
+		// Nodes missing from a clipped extract split the way
+		prev, prevOK := int32(0), false
+		for _, id := range w.NodeIDs {
+			n, ok := node(id)
+			if ok && prevOK && n != prev {
+				length := float32(osm.HaversineDistance(g.lat[prev], g.lon[prev], g.lat[n], g.lon[n]))
+				if fwd != 0 {
+					raw = append(raw, rawEdge{from: prev, edge: graphEdge{to: n, way: wayIndex, length: length, access: fwd}})
+				}
+				if bwd != 0 {
+					raw = append(raw, rawEdge{from: n, edge: graphEdge{to: prev, way: wayIndex, length: length, access: bwd}})
+				}
+			}
+			prev, prevOK = n, ok
+		}
+	}
+
+	sort.SliceStable(raw, func(i, j int) bool { return raw[i].from < raw[j].from })
+	g.first = make([]int32, len(g.lat)+1)
+	g.edges = make([]graphEdge, len(raw))
+	for i, e := range raw {
+		g.first[e.from+1]++
+		g.edges[i] = e.edge
+	}
+	for n := range len(g.lat) {
+		g.first[n+1] += g.first[n]
+	}
+	return g
+}
+
+// out returns the edges leaving a node
+func (g *graph) out(n int32) []graphEdge {
+	return g.edges[g.first[n]:g.first[n+1]]
+}
+
+// usable reports whether a profile may leave a node
+func (g *graph) usable(n int32, p profile) bool {
+	for _, e := range g.out(n) {
+		if e.access&(1<<p) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nearest returns the node closest to a point that a profile may leave
+func (g *graph) nearest(lat, lon float64, p profile) (int32, bool) {
+	nodes := g.nearestNodes(lat, lon, p, 1)
+	if len(nodes) == 0 {
+		return -1, false
+	}
+	return nodes[0], true
+}
+
+// nearestNodes returns up to number nodes within maxSnapDistance of a
+// point that a profile may leave, nearest first
+func (g *graph) nearestNodes(lat, lon float64, p profile, number int) []int32 {
+	type candidate struct {
+		node int32
+		dist float64
+	}
+	var candidates []candidate
+	center := cellOf(lat, lon)
+	for dy := int32(-1); dy <= 1; dy++ {
+		for dx := int32(-1); dx <= 1; dx++ {
+			for _, n := range g.grid[cell{x: center.x + dx, y: center.y + dy}] {
+				d := osm.HaversineDistance(lat, lon, g.lat[n], g.lon[n])
+				if d < maxSnapDistance && g.usable(n, p) {
+					candidates = append(candidates, candidate{node: n, dist: d})
+				}
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist != candidates[j].dist {
+			return candidates[i].dist < candidates[j].dist
+		}
+		return candidates[i].node < candidates[j].node
+	})
+
+	nodes := make([]int32, 0, min(number, len(candidates)))
+	for _, c := range candidates[:min(number, len(candidates))] {
+		nodes = append(nodes, c.node)
+	}
+	return nodes
+}
+
+// waypoint describes node n as the snapped point of a requested point
+func (g *graph) waypoint(n int32, requested geo.Location, p profile) osm.Waypoint {
+	w := osm.Waypoint{
+		Location: geo.Location{Latitude: g.lat[n], Longitude: g.lon[n]},
+		Distance: osm.HaversineDistance(requested.Latitude, requested.Longitude, g.lat[n], g.lon[n]),
+	}
+	// Name the first named way the profile may take from the node
+	for _, e := range g.out(n) {
+		if e.access&(1<<p) != 0 && g.ways[e.way].name != "" {
+			w.Name = g.ways[e.way].name
+			break
+		}
+	}
+	return w
+}
+
+// queueItem is a node waiting in the A* open set
+type queueItem struct {
+	node     int32
+	priority float64
+}
+
+// queue is a min-heap of queueItems
+type queue []queueItem
+
+func (q queue) Len() int           { return len(q) }
+func (q queue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q queue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x any)        { *q = append(*q, x.(queueItem)) }
+func (q *queue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// shortestPath runs an A* search for the fastest path between two nodes.
+// It returns the edges taken, each as the index of the edge in g.edges.
+func (g *graph) shortestPath(ctx context.Context, from, to int32, p profile) ([]int32, error) {
+	mask := uint8(1 << p)
+	// Straight-line time at top speed never overestimates
+	topSpeed := rules[p].maxSpeed / 3.6
+	heuristic := func(n int32) float64 {
+		return osm.HaversineDistance(g.lat[n], g.lon[n], g.lat[to], g.lon[to]) / topSpeed
+	}
+
+	cost := make(map[int32]float64)
+	via := make(map[int32]int32) // node to the edge that reached it
+	done := make(map[int32]bool)
+	cost[from] = 0
+	open := &queue{{node: from, priority: heuristic(from)}}
+
+	for steps := 0; open.Len() > 0; steps++ {
+		if steps%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		n := heap.Pop(open).(queueItem).node
+		if done[n] {
+			continue
+		}
+		done[n] = true
+		if n == to {
+			break
+		}
+
+		for i := g.first[n]; i < g.first[n+1]; i++ {
+			e := &g.edges[i]
+			if e.access&mask == 0 || done[e.to] {
+				continue
+			}
+			c := cost[n] + float64(e.length)/float64(g.ways[e.way].speeds[p])
+			if old, seen := cost[e.to]; seen && old <= c {
+				continue
+			}
+			cost[e.to] = c
+			via[e.to] = i
+			heap.Push(open, queueItem{node: e.to, priority: c + heuristic(e.to)})
+		}
+	}
+	if !done[to] {
+		return nil, osm.ErrNoRoute
+	}
+
+	var path []int32
+	for n := to; n != from; {
+		i := via[n]
+		path = append(path, i)
+		n = g.source(i)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// fastestFrom runs a single Dijkstra search from one node until every
+// target is settled or the reachable graph is exhausted. It returns the
+// duration and distance of the fastest path to each target, with a
+// duration of -1 for targets it cannot reach or that are negative.
+func (g *graph) fastestFrom(ctx context.Context, from int32, targets []int32, p profile) (durations, distances []float64, err error) {
+	mask := uint8(1 << p)
+	pending := make(map[int32]bool)
+	for _, t := range targets {
+		if t >= 0 {
+			pending[t] = true
+		}
+	}
+
+	cost := make(map[int32]float64)
+	dist := make(map[int32]float64)
+	done := make(map[int32]bool)
+	cost[from] = 0
+	open := &queue{{node: from}}
+
+	for steps := 0; open.Len() > 0 && len(pending) > 0; steps++ {
+		if steps%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		n := heap.Pop(open).(queueItem).node
+		if done[n] {
+			continue
+		}
+		done[n] = true
+		delete(pending, n)
+
+		for i := g.first[n]; i < g.first[n+1]; i++ {
+			e := &g.edges[i]
+			if e.access&mask == 0 || done[e.to] {
+				continue
+			}
+			c := cost[n] + float64(e.length)/float64(g.ways[e.way].speeds[p])
+			if old, seen := cost[e.to]; seen && old <= c {
+				continue
+			}
+			cost[e.to] = c
+			dist[e.to] = dist[n] + float64(e.length)
+			heap.Push(open, queueItem{node: e.to, priority: c})
+		}
+	}
+
+	durations = make([]float64, len(targets))
+	distances = make([]float64, len(targets))
+	for i, t := range targets {
+		if t < 0 || !done[t] {
+			durations[i] = -1
+			continue
+		}
+		durations[i], distances[i] = cost[t], dist[t]
+	}
+	return durations, distances, nil
+}
+
+// source returns the node an edge leaves from
+func (g *graph) source(edge int32) int32 {
+	n := sort.Search(len(g.first)-1, func(n int) bool { return g.first[n+1] > edge })
+	return int32(n)
+}
+
+// Route implements osm.Router
+func (d *Dataset) Route(ctx context.Context, profileName string, start, end geo.Location) (*osm.RouteResult, error) {
+	p, ok := profileNames[profileName]
+	if !ok {
+		return nil, fmt.Errorf("unknown routing profile %q", profileName)
+	}
+	g := d.graph
+	from, ok := g.nearest(start.Latitude, start.Longitude, p)
+	if !ok {
+		return nil, fmt.Errorf("%w: start is not near a road", osm.ErrNoRoute)
+	}
+	to, ok := g.nearest(end.Latitude, end.Longitude, p)
+	if !ok {
+		return nil, fmt.Errorf("%w: destination is not near a road", osm.ErrNoRoute)
+	}
+
+	path, err := g.shortestPath(ctx, from, to, p)
+	if err != nil {
+		return nil, err
+	}
+	r := g.route(from, path, p)
+	r.Waypoints = []osm.Waypoint{g.waypoint(from, start, p), g.waypoint(to, end, p)}
+	return r, nil
+}
+
+// RoutesFrom implements osm.TableRouter. Every end is timed by the same
+// search, which is much cheaper than one Route per end when there are
+// many ends around a start, as for an isochrone.
+func (d *Dataset) RoutesFrom(ctx context.Context, profileName string, start geo.Location, ends []geo.Location) ([]*osm.RouteResult, error) {
+	p, ok := profileNames[profileName]
+	if !ok {
+		return nil, fmt.Errorf("unknown routing profile %q", profileName)
+	}
+	g := d.graph
+	from, ok := g.nearest(start.Latitude, start.Longitude, p)
+	if !ok {
+		return nil, fmt.Errorf("%w: start is not near a road", osm.ErrNoRoute)
+	}
+	targets := make([]int32, len(ends))
+	for i, end := range ends {
+		if targets[i], ok = g.nearest(end.Latitude, end.Longitude, p); !ok {
+			targets[i] = -1
+		}
+	}
+
+	durations, distances, err := g.fastestFrom(ctx, from, targets, p)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]*osm.RouteResult, len(ends))
+	for i, end := range ends {
+		if durations[i] < 0 {
+			continue
+		}
+		routes[i] = &osm.RouteResult{
+			Distance:  distances[i],
+			Duration:  durations[i],
+			Waypoints: []osm.Waypoint{g.waypoint(from, start, p), g.waypoint(targets[i], end, p)},
+		}
+	}
+	return routes, nil
+}
+
+// Nearest implements osm.Snapper
+func (d *Dataset) Nearest(ctx context.Context, profileName string, point geo.Location, number int) ([]osm.Waypoint, error) {
+	p, ok := profileNames[profileName]
+	if !ok {
+		return nil, fmt.Errorf("unknown routing profile %q", profileName)
+	}
+	g := d.graph
+	nodes := g.nearestNodes(point.Latitude, point.Longitude, p, number)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%w: no road within %d m", osm.ErrNoRoute, maxSnapDistance)
+	}
+	waypoints := make([]osm.Waypoint, len(nodes))
+	for i, n := range nodes {
+		waypoints[i] = g.waypoint(n, point, p)
+	}
+	return waypoints, nil
+}
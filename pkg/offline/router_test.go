@@ -0,0 +1,297 @@
+package offline
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+var (
+	_ osm.Router      = (*Dataset)(nil)
+	_ osm.Snapper     = (*Dataset)(nil)
+	_ osm.TableRouter = (*Dataset)(nil)
+)
+
+// roadExtract builds a small road network: two residential streets
+// meeting at a right angle, a oneway shortcut, a footway and, apart
+// from the rest, a roundabout with three approaches
+func roadExtract(t *testing.T) []byte {
+	t.Helper()
+	w := &pbfWriter{}
+	w.header(t, "OsmSchema-V0.6", "DenseNodes")
+	w.data(t,
+		[]testNode{
+			{id: 1, lat: 52.500, lon: 13.40},
+			{id: 2, lat: 52.500, lon: 13.41},
+			{id: 3, lat: 52.500, lon: 13.42},
+			{id: 4, lat: 52.510, lon: 13.41},
+			{id: 6, lat: 52.505, lon: 13.42},
+			{id: 20, lat: 52.5195, lon: 13.4100},
+			{id: 21, lat: 52.5200, lon: 13.4108},
+			{id: 22, lat: 52.5205, lon: 13.4100},
+			{id: 23, lat: 52.5200, lon: 13.4092},
+			{id: 24, lat: 52.5150, lon: 13.4100},
+			{id: 25, lat: 52.5200, lon: 13.4200},
+			{id: 26, lat: 52.5250, lon: 13.4100},
+		},
+		nil,
+		[]testWay{
+			{id: 100, tags: []string{"highway", "residential", "name", "Hauptstraße"}, nodes: []int64{1, 2, 3}},
+			{id: 101, tags: []string{"highway", "residential", "name", "Nebenstraße"}, nodes: []int64{2, 4}},
+			{id: 102, tags: []string{"highway", "residential", "name", "Einbahnstraße", "oneway", "yes"}, nodes: []int64{4, 6, 3}},
+			{id: 103, tags: []string{"highway", "footway", "name", "Parkweg"}, nodes: []int64{1, 4}},
+			{id: 110, tags: []string{"highway", "residential", "junction", "roundabout"}, nodes: []int64{20, 21, 22, 23, 20}},
+			{id: 111, tags: []string{"highway", "residential", "name", "Südstraße"}, nodes: []int64{24, 20}},
+			{id: 112, tags: []string{"highway", "residential", "name", "Oststraße"}, nodes: []int64{21, 25}},
+			{id: 113, tags: []string{"highway", "residential", "name", "Nordstraße"}, nodes: []int64{22, 26}},
+		},
+	)
+	return w.buf.Bytes()
+}
+
+func routeBetween(t *testing.T, d *Dataset, profile string, from, to geo.Location) *osm.RouteResult {
+	t.Helper()
+	r, err := d.Route(context.Background(), profile, from, to)
+	if err != nil {
+		t.Fatalf("Route(%s) error = %v", profile, err)
+	}
+	return r
+}
+
+func TestRouteFollowsProfiles(t *testing.T) {
+	d := loadExtract(t, roadExtract(t))
+	node1 := geo.Location{Latitude: 52.500, Longitude: 13.40}
+	node3 := geo.Location{Latitude: 52.500, Longitude: 13.42}
+	node4 := geo.Location{Latitude: 52.510, Longitude: 13.41}
+
+	// Cars may not use the footway or drive the oneway street backwards
+	around := osm.HaversineDistance(52.5, 13.40, 52.5, 13.41) + osm.HaversineDistance(52.5, 13.41, 52.51, 13.41)
+	if r := routeBetween(t, d, "car", node1, node4); math.Abs(r.Distance-around) > 1 {
+		t.Errorf("car distance = %.0f, want %.0f along the streets", r.Distance, around)
+	}
+	direct := osm.HaversineDistance(52.5, 13.40, 52.51, 13.41)
+	if r := routeBetween(t, d, "foot", node1, node4); math.Abs(r.Distance-direct) > 1 {
+		t.Errorf("foot distance = %.0f, want %.0f along the footway", r.Distance, direct)
+	}
+
+	back := routeBetween(t, d, "car", node3, node4)
+	ahead := routeBetween(t, d, "car", node4, node3)
+	if ahead.Distance >= back.Distance {
+		t.Errorf("oneway route %.0f m is not shorter than the detour %.0f m", ahead.Distance, back.Distance)
+	}
+	if foot := routeBetween(t, d, "foot", node3, node4); foot.Distance >= back.Distance {
+		t.Errorf("pedestrians should ignore the oneway, got %.0f m", foot.Distance)
+	}
+
+	// Residential streets take 30 km/h
+	if want := back.Distance / (30 / 3.6); math.Abs(back.Duration-want) > 1 {
+		t.Errorf("car duration = %.0f s, want %.0f s", back.Duration, want)
+	}
+	if len(back.Geometry) != 3 {
+		t.Errorf("geometry has %d points, want 3", len(back.Geometry))
+	}
+}
+
+func TestRouteSteps(t *testing.T) {
+	d := loadExtract(t, roadExtract(t))
+
+	r := routeBetween(t, d, "car",
+		geo.Location{Latitude: 52.500, Longitude: 13.42},
+		geo.Location{Latitude: 52.510, Longitude: 13.41})
+	want := []osm.RouteStep{
+		{Type: "depart", Name: "Hauptstraße"},
+		{Type: "turn", Modifier: "right", Name: "Nebenstraße"},
+		{Type: "arrive", Name: "Nebenstraße"},
+	}
+	checkSteps(t, r.Steps, want)
+	if turn := r.Steps[1].Location; turn.Latitude != 52.5 || turn.Longitude != 13.41 {
+		t.Errorf("turn location = %+v, want the junction", turn)
+	}
+	var total float64
+	for _, s := range r.Steps {
+		total += s.Distance
+	}
+	if math.Abs(total-r.Distance) > 1e-6 {
+		t.Errorf("step distances add up to %f, want %f", total, r.Distance)
+	}
+
+	// Passing the exit to Oststraße makes Nordstraße the second exit
+	r = routeBetween(t, d, "car",
+		geo.Location{Latitude: 52.5150, Longitude: 13.41},
+		geo.Location{Latitude: 52.5250, Longitude: 13.41})
+	want = []osm.RouteStep{
+		{Type: "depart", Name: "Südstraße"},
+		{Type: "roundabout", Modifier: "second", Name: "Nordstraße"},
+		{Type: "arrive", Name: "Nordstraße"},
+	}
+	checkSteps(t, r.Steps, want)
+}
+
+func checkSteps(t *testing.T, got, want []osm.RouteStep) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("steps = %+v, want %d steps", got, len(want))
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || got[i].Modifier != want[i].Modifier || got[i].Name != want[i].Name {
+			t.Errorf("step %d = %s %q %q, want %s %q %q", i,
+				got[i].Type, got[i].Modifier, got[i].Name, want[i].Type, want[i].Modifier, want[i].Name)
+		}
+	}
+}
+
+func TestRouteNotFound(t *testing.T) {
+	d := loadExtract(t, roadExtract(t))
+	ctx := context.Background()
+	main := geo.Location{Latitude: 52.500, Longitude: 13.40}
+
+	tests := []struct {
+		name string
+		to   geo.Location
+	}{
+		{"disconnected", geo.Location{Latitude: 52.5250, Longitude: 13.41}},
+		{"far from roads", geo.Location{Latitude: 60, Longitude: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Route(ctx, "car", main, tt.to); !errors.Is(err, osm.ErrNoRoute) {
+				t.Errorf("Route() error = %v, want ErrNoRoute", err)
+			}
+		})
+	}
+
+	if _, err := d.Route(ctx, "boat", main, main); err == nil {
+		t.Error("Route() accepted an unknown profile")
+	}
+}
+
+func TestRoutesFrom(t *testing.T) {
+	d := loadExtract(t, roadExtract(t))
+	ctx := context.Background()
+	start := geo.Location{Latitude: 52.500, Longitude: 13.42}
+	ends := []geo.Location{
+		{Latitude: 52.510, Longitude: 13.41},
+		{Latitude: 52.500, Longitude: 13.40},
+		{Latitude: 52.5250, Longitude: 13.41}, // disconnected
+		{Latitude: 60, Longitude: 0},          // far from roads
+		start,
+	}
+
+	for _, profile := range []string{"car", "foot"} {
+		routes, err := d.RoutesFrom(ctx, profile, start, ends)
+		if err != nil {
+			t.Fatalf("RoutesFrom(%s) error = %v", profile, err)
+		}
+		if len(routes) != len(ends) {
+			t.Fatalf("RoutesFrom(%s) returned %d routes, want %d", profile, len(routes), len(ends))
+		}
+		for i, end := range ends {
+			want, err := d.Route(ctx, profile, start, end)
+			if errors.Is(err, osm.ErrNoRoute) {
+				if routes[i] != nil {
+					t.Errorf("%s route to ends[%d] = %+v, want none", profile, i, routes[i])
+				}
+				continue
+			}
+			got := routes[i]
+			if got == nil {
+				t.Errorf("%s route to ends[%d] is missing", profile, i)
+				continue
+			}
+			if math.Abs(got.Duration-want.Duration) > 1e-6 || math.Abs(got.Distance-want.Distance) > 1e-6 {
+				t.Errorf("%s route to ends[%d] = %.1f s, %.1f m, want %.1f s, %.1f m", profile, i,
+					got.Duration, got.Distance, want.Duration, want.Distance)
+			}
+			if len(got.Waypoints) != 2 || got.Waypoints[0] != want.Waypoints[0] || got.Waypoints[1] != want.Waypoints[1] {
+				t.Errorf("%s waypoints to ends[%d] = %+v, want %+v", profile, i, got.Waypoints, want.Waypoints)
+			}
+		}
+	}
+
+	if _, err := d.RoutesFrom(ctx, "car", geo.Location{Latitude: 60, Longitude: 0}, ends); !errors.Is(err, osm.ErrNoRoute) {
+		t.Errorf("RoutesFrom() far from roads error = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	d := loadExtract(t, roadExtract(t))
+	ctx := context.Background()
+
+	// About 110 m north of node 2, where Hauptstraße meets Nebenstraße
+	point := geo.Location{Latitude: 52.501, Longitude: 13.41}
+	got, err := d.Nearest(ctx, "car", point, 2)
+	if err != nil {
+		t.Fatalf("Nearest() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Location != (geo.Location{Latitude: 52.500, Longitude: 13.41}) || got[0].Name != "Hauptstraße" {
+		t.Fatalf("Nearest() = %+v, want node 2 on Hauptstraße first", got)
+	}
+	if want := osm.HaversineDistance(52.501, 13.41, 52.500, 13.41); math.Abs(got[0].Distance-want) > 1e-6 || got[1].Distance < got[0].Distance {
+		t.Errorf("snap distances = %.1f, %.1f, want %.1f first and ascending", got[0].Distance, got[1].Distance, want)
+	}
+
+	r := routeBetween(t, d, "car", point, geo.Location{Latitude: 52.510, Longitude: 13.41})
+	if len(r.Waypoints) != 2 || r.Waypoints[0] != got[0] || r.Waypoints[1].Distance != 0 {
+		t.Errorf("route waypoints = %+v, want the start snapped to node 2 and the end unmoved", r.Waypoints)
+	}
+
+	if _, err := d.Nearest(ctx, "car", geo.Location{Latitude: 60, Longitude: 0}, 1); !errors.Is(err, osm.ErrNoRoute) {
+		t.Errorf("Nearest() far from roads error = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestProfileAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile profile
+		tags    map[string]string
+		dir     direction
+		speed   float64
+	}{
+		{"residential", carProfile, map[string]string{"highway": "residential"}, forward | backward, 30},
+		{"maxspeed", carProfile, map[string]string{"highway": "primary", "maxspeed": "50"}, forward | backward, 50},
+		{"maxspeed mph", carProfile, map[string]string{"highway": "primary", "maxspeed": "30 mph"}, forward | backward, 30 * 1.609344},
+		{"private", carProfile, map[string]string{"highway": "service", "access": "private"}, 0, 0},
+		{"specific access wins", carProfile, map[string]string{"highway": "service", "access": "no", "motor_vehicle": "yes"}, forward | backward, 15},
+		{"roundabout", carProfile, map[string]string{"highway": "primary", "junction": "roundabout"}, forward, 70},
+		{"reverse oneway", carProfile, map[string]string{"highway": "primary", "oneway": "-1"}, backward, 70},
+		{"no footways", carProfile, map[string]string{"highway": "footway"}, 0, 0},
+		{"no motorways", bikeProfile, map[string]string{"highway": "motorway"}, 0, 0},
+		{"contraflow", bikeProfile, map[string]string{"highway": "residential", "oneway": "yes", "cycleway": "opposite_lane"}, forward | backward, 15},
+		{"bike oneway", bikeProfile, map[string]string{"highway": "residential", "oneway": "yes", "oneway:bicycle": "no"}, forward | backward, 15},
+		{"pushed", bikeProfile, map[string]string{"highway": "footway"}, forward | backward, 5},
+		{"walk any way", footProfile, map[string]string{"highway": "primary", "oneway": "yes"}, forward | backward, 5},
+		{"no walking", footProfile, map[string]string{"highway": "primary", "foot": "no"}, 0, 0},
+		{"area", footProfile, map[string]string{"highway": "pedestrian", "area": "yes"}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, speed := rules[tt.profile].access(tt.tags)
+			if dir != tt.dir || math.Abs(speed-tt.speed) > 1e-9 {
+				t.Errorf("access() = %d, %v; want %d, %v", dir, speed, tt.dir, tt.speed)
+			}
+		})
+	}
+}
+
+func TestTurnModifier(t *testing.T) {
+	tests := []struct {
+		angle float64
+		want  string
+	}{
+		{5, "straight"},
+		{-30, "slight left"},
+		{90, "right"},
+		{-150, "sharp left"},
+		{178, "uturn"},
+	}
+	for _, tt := range tests {
+		if got := turnModifier(tt.angle); got != tt.want {
+			t.Errorf("turnModifier(%v) = %q, want %q", tt.angle, got, tt.want)
+		}
+	}
+}
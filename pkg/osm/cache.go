@@ -1,91 +0,0 @@
-package osm
-
-import (
-	"sync"
-	"time"
-)
-
-// TTLCache is a generic thread-safe cache with TTL support
-type TTLCache[K comparable, V any] struct {
-	mu    sync.RWMutex
-	items map[K]cacheItem[V]
-	ttl   time.Duration
-}
-
-type cacheItem[V any] struct {
-	value     V
-	expiresAt time.Time
-}
-
-// NewTTLCache creates a new TTL cache with the specified TTL duration
-func NewTTLCache[K comparable, V any](ttl time.Duration) *TTLCache[K, V] {
-	return &TTLCache[K, V]{
-		items: make(map[K]cacheItem[V]),
-		ttl:   ttl,
-	}
-}
-
-// Get retrieves a value from the cache if it exists and hasn't expired
-func (c *TTLCache[K, V]) Get(key K) (V, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	item, exists := c.items[key]
-	if !exists {
-		var zero V
-		return zero, false
-	}
-
-	if time.Now().After(item.expiresAt) {
-		delete(c.items, key)
-		var zero V
-		return zero, false
-	}
-
-	return item.value, true
-}
-
-// Set adds a value to the cache with the configured TTL
-func (c *TTLCache[K, V]) Set(key K, value V) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.items[key] = cacheItem[V]{
-		value:     value,
-		expiresAt: time.Now().Add(c.ttl),
-	}
-}
-
-// Delete removes a value from the cache
-func (c *TTLCache[K, V]) Delete(key K) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.items, key)
-}
-
-// Clear removes all items from the cache
-func (c *TTLCache[K, V]) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.items = make(map[K]cacheItem[V])
-}
-
-// Size returns the number of items in the cache
-func (c *TTLCache[K, V]) Size() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return len(c.items)
-}
-
-// Cleanup removes expired items from the cache
-func (c *TTLCache[K, V]) Cleanup() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	now := time.Now()
-	for key, item := range c.items {
-		if now.After(item.expiresAt) {
-			delete(c.items, key)
-		}
-	}
-}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
 	"log/slog"
 
-	"golang.org/x/time/rate"
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -20,118 +23,294 @@ const (
 	DefaultUserAgent = "OSMMCP/0.1.0"
 )
 
-var (
-	// Global HTTP client with connection pooling
+// Client sends requests to the upstream OpenStreetMap services. It owns
+// the HTTP transport and rate limiters, the service endpoints and mirror
+// health, the response and tile caches, the fixture settings and any
+// local providers, so clients configured differently do not affect each
+// other. The package-level functions act on the default client.
+type Client struct {
+	logger *slog.Logger
+
+	// httpClient sends upstream requests through the rate limiters
 	httpClient *http.Client
 
-	// Rate limiters for each service
-	nominatimLimiter *rate.Limiter
-	overpassLimiter  *rate.Limiter
-	osrmLimiter      *rate.Limiter
+	// statusClient reaches service status endpoints without rate limiting
+	statusClient *http.Client
 
-	// User agent string
 	userAgent     string
 	userAgentLock sync.RWMutex
-)
 
-// init initializes the global HTTP client and rate limiters
-func init() {
-	// Initialize HTTP client with connection pooling
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
+	// endpoints holds the configured endpoint for each service
+	endpoints     map[string]Endpoint
+	endpointsLock sync.RWMutex
+
+	// mirrorHealth is keyed by mirror base URL
+	mirrorHealth     map[string]*mirrorHealth
+	mirrorHealthLock sync.Mutex
+
+	limiter *RateLimiter
+
+	// retryPolicy replaces DefaultRetryPolicy when set
+	retryPolicy     *RetryPolicy
+	retryPolicyLock sync.RWMutex
+
+	// responseCache stores successful upstream responses; nil disables it
+	responseCache cache.Backend
+
+	// cacheTTLs holds the configured lifetime per service
+	cacheTTLs         map[string]time.Duration
+	responseCacheLock sync.RWMutex
+
+	overpassScheduler     *OverpassScheduler
+	overpassSchedulerLock sync.RWMutex
+
+	elementProvider ElementProvider
+	geocoder        Geocoder
+	router          Router
+	providerLock    sync.RWMutex
+
+	fixtureMode FixtureMode
+	fixtureDir  string
+	fixtureLock sync.RWMutex
+
+	// observer receives the events of upstream requests
+	observer     Observer
+	observerLock sync.RWMutex
+
+	// store holds the client's cache namespaces; ownStore is set when the
+	// client created it and must stop it
+	store    *cache.Store
+	ownStore bool
+
+	// tileCache holds the elements of each tile by filter
+	tileCache *cache.Namespace[[]OverpassElement]
+
+	// tileGroup merges concurrent fetches of the same tiles
+	tileGroup singleflight.Group
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithLogger sets the logger of the client
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithCacheStore keeps the client's tile cache, and the caches tools
+// create for it, in s instead of a store of its own
+func WithCacheStore(s *cache.Store) ClientOption {
+	return func(c *Client) {
+		c.store = s
+	}
+}
+
+// WithBaseTransport sends the client's requests through rt once they
+// have passed rate limiting, for example to reach an in-process server
+func WithBaseTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.httpClient.Transport = rt
+		c.statusClient.Transport = rt
+	}
+}
+
+// NewOSMClient creates a client with the default endpoints, rate limits,
+// retry policy and cache TTLs
+func NewOSMClient(opts ...ClientOption) *Client {
+	base := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	c := &Client{
+		logger:       slog.Default(),
+		httpClient:   &http.Client{Transport: base, Timeout: 30 * time.Second},
+		statusClient: &http.Client{Transport: base, Timeout: overpassStatusTimeout},
+		userAgent:    DefaultUserAgent,
+		endpoints:    defaultEndpoints(),
+		mirrorHealth: make(map[string]*mirrorHealth),
+		limiter:      NewRateLimiter(),
+		cacheTTLs:    copyTTLs(DefaultCacheTTLs),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// Every request passes the rate limiter registry before it is sent,
+	// whichever helper the caller used to send it. Replayed fixtures are
+	// answered before that.
+	c.httpClient.Transport = &fixtureTransport{client: c, base: c.newTransport(c.httpClient.Transport)}
+	c.statusClient.Transport = &endpointTransport{client: c, base: c.statusClient.Transport}
+	c.overpassScheduler = NewOverpassScheduler(c.fetchOverpassStatus)
+
+	if c.store == nil {
+		c.store = cache.NewStore(cache.DefaultCleanupInterval)
+		c.ownStore = true
+	}
+	c.tileCache = cache.NewNamespace[[]OverpassElement](c.store, "overpass_tiles", DefaultCacheTTLs[ServiceOverpass], tileCacheSize)
+	return c
+}
+
+// defaultClient serves the package-level functions and every context
+// that carries no client of its own
+var defaultClient = NewOSMClient(WithCacheStore(cache.Default()))
+
+// Default returns the client used by the package-level functions
+func Default() *Client {
+	return defaultClient
+}
+
+// clientKey is the context key of the client
+type clientKey struct{}
+
+// NewContext returns a context whose upstream requests are sent by c
+func NewContext(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// FromContext returns the client carried by ctx, or the default client
+func FromContext(ctx context.Context) *Client {
+	if c, ok := ctx.Value(clientKey{}).(*Client); ok && c != nil {
+		return c
+	}
+	return defaultClient
+}
+
+// Close releases the idle connections of the client and stops the
+// cleanup of a cache store it created. The response cache backend
+// belongs to the caller and is left open.
+func (c *Client) Close() {
+	c.httpClient.CloseIdleConnections()
+	c.statusClient.CloseIdleConnections()
+	if c.ownStore {
+		c.store.Stop()
+	}
+}
+
+// newTransport wraps a base transport with Overpass slot scheduling,
+// rate limiting and the per-service endpoint headers
+func (c *Client) newTransport(base http.RoundTripper) http.RoundTripper {
+	return &overpassTransport{
+		client: c,
+		base: &rateLimitTransport{
+			client:  c,
+			limiter: c.limiter,
+			base:    &endpointTransport{client: c, base: base},
 		},
-		Timeout: 30 * time.Second,
 	}
+}
 
-	// Initialize rate limiters with default values
-	initRateLimiters()
+// Logger returns the logger of the client
+func (c *Client) Logger() *slog.Logger {
+	return c.logger
+}
 
-	// Set default user agent
-	SetUserAgent(DefaultUserAgent)
+// SetLogger sets the logger for the client
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Cache returns the store holding the client's cache namespaces. Tools
+// keep their per-client caches there too.
+func (c *Client) Cache() *cache.Store {
+	return c.store
+}
+
+// RateLimiter returns the rate limiter registry of the client
+func (c *Client) RateLimiter() *RateLimiter {
+	return c.limiter
 }
 
-// initRateLimiters initializes the rate limiters with default values
-func initRateLimiters() {
-	// Default to 1 request per second with burst of 1
-	nominatimLimiter = rate.NewLimiter(rate.Limit(1), 1)
-	overpassLimiter = rate.NewLimiter(rate.Limit(1), 1)
-	osrmLimiter = rate.NewLimiter(rate.Limit(1), 1)
+// HTTPClient returns the HTTP client whose requests pass the client's
+// rate limiters
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
 }
 
 // UpdateNominatimRateLimits updates the Nominatim rate limiter
-func UpdateNominatimRateLimits(rps float64, burst int) {
-	nominatimLimiter = rate.NewLimiter(rate.Limit(rps), burst)
+func UpdateNominatimRateLimits(rps float64, burst int) error {
+	return GetRateLimiter().SetLimit(ServiceNominatim, rps, burst)
 }
 
 // UpdateOverpassRateLimits updates the Overpass rate limiter
-func UpdateOverpassRateLimits(rps float64, burst int) {
-	overpassLimiter = rate.NewLimiter(rate.Limit(rps), burst)
+func UpdateOverpassRateLimits(rps float64, burst int) error {
+	return GetRateLimiter().SetLimit(ServiceOverpass, rps, burst)
 }
 
 // UpdateOSRMRateLimits updates the OSRM rate limiter
-func UpdateOSRMRateLimits(rps float64, burst int) {
-	osrmLimiter = rate.NewLimiter(rate.Limit(rps), burst)
+func UpdateOSRMRateLimits(rps float64, burst int) error {
+	return GetRateLimiter().SetLimit(ServiceOSRM, rps, burst)
 }
 
-// SetUserAgent sets the User-Agent string
+// SetUserAgent sets the User-Agent string of the default client
 func SetUserAgent(ua string) {
-	userAgentLock.Lock()
-	defer userAgentLock.Unlock()
-	userAgent = ua
+	defaultClient.SetUserAgent(ua)
 }
 
-// GetUserAgent returns the current User-Agent string
+// GetUserAgent returns the User-Agent string of the default client
 func GetUserAgent() string {
-	userAgentLock.RLock()
-	defer userAgentLock.RUnlock()
-	return userAgent
+	return defaultClient.UserAgent()
 }
 
-// GetClient returns the global HTTP client
-func GetClient(ctx context.Context) *http.Client {
-	return httpClient
+// SetUserAgent sets the User-Agent string
+func (c *Client) SetUserAgent(ua string) {
+	c.userAgentLock.Lock()
+	defer c.userAgentLock.Unlock()
+	c.userAgent = ua
 }
 
-// hostFromURL extracts the host from a URL string
-func hostFromURL(urlStr string) string {
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return ""
-	}
-	return u.Host
-}
-
-// waitForRateLimit waits for the appropriate rate limiter based on the request URL
-func waitForRateLimit(ctx context.Context, req *http.Request) error {
-	host := hostFromURL(req.URL.String())
-
-	switch host {
-	case hostFromURL(NominatimBaseURL):
-		return nominatimLimiter.Wait(ctx)
-	case hostFromURL(OverpassBaseURL):
-		return overpassLimiter.Wait(ctx)
-	case hostFromURL(OSRMBaseURL):
-		return osrmLimiter.Wait(ctx)
-	default:
-		return nil // No rate limiting for unknown hosts
-	}
+// UserAgent returns the current User-Agent string
+func (c *Client) UserAgent() string {
+	c.userAgentLock.RLock()
+	defer c.userAgentLock.RUnlock()
+	return c.userAgent
+}
+
+// GetClient returns the HTTP client of the client carried by ctx
+func GetClient(ctx context.Context) *http.Client {
+	return FromContext(ctx).httpClient
 }
 
-// DoRequest performs an HTTP request with rate limiting
+// DoRequest sends req with the client carried by ctx, or the default
+// client. All tools send their upstream requests through DoRequest.
 func DoRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return FromContext(ctx).DoRequest(ctx, req)
+}
+
+// DoRequest performs an HTTP request with the User-Agent header set,
+// retrying transient failures under the client's retry policy. Rate
+// limiting is applied by the client's transport. Successful responses
+// are kept in the response cache, if one is set.
+func (c *Client) DoRequest(ctx context.Context, req *http.Request) (*http.Response, error) {
 	// Set User-Agent header
-	req.Header.Set("User-Agent", GetUserAgent())
+	req.Header.Set("User-Agent", c.UserAgent())
 
-	// Wait for rate limit
-	if err := waitForRateLimit(ctx, req); err != nil {
-		return nil, err
+	backend, key, ttl, cacheable := c.responseCacheFor(req)
+	if cacheable {
+		service := c.ServiceForURL(req.URL)
+		_, span := tracing.Tracer().Start(ctx, "cache lookup", trace.WithAttributes(serviceAttribute(service)))
+		body, ok := backend.Get(key)
+		span.SetAttributes(attribute.Bool("cache.hit", ok))
+		span.End()
+		c.Observer().ObserveCache(service, ok)
+		if ok {
+			updateRequestInfo(ctx, func(info *RequestInfo) {
+				info.cacheHits++
+			})
+			return cachedResponse(req, body), nil
+		}
 	}
 
 	// Perform request
-	return httpClient.Do(req)
+	resp, err := c.do(ctx, c.RetryPolicy(), c.httpClient, req)
+	if err != nil || !cacheable || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	return storeResponse(backend, key, ttl, resp), nil
 }
 
 // NewRequestWithUserAgent creates a new HTTP request with proper User-Agent header
@@ -155,24 +334,7 @@ func NewRequestWithUserAgent(ctx context.Context, method, url string, body inter
 	}
 
 	// Set required User-Agent for Nominatim's usage policy
-	req.Header.Set("User-Agent", GetUserAgent())
+	req.Header.Set("User-Agent", FromContext(ctx).UserAgent())
 
 	return req, nil
 }
-
-// Client represents an OSM API client
-type Client struct {
-	logger *slog.Logger
-}
-
-// NewOSMClient creates a new OSM API client
-func NewOSMClient() *Client {
-	return &Client{
-		logger: slog.Default(),
-	}
-}
-
-// SetLogger sets the logger for the client
-func (c *Client) SetLogger(logger *slog.Logger) {
-	c.logger = logger
-}
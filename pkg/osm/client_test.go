@@ -0,0 +1,120 @@
+package osm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientsAreIndependent(t *testing.T) {
+	a, b := NewOSMClient(), NewOSMClient()
+	t.Cleanup(a.Close)
+	t.Cleanup(b.Close)
+
+	upA, callsA := statusServer(t, "")
+	upB, callsB := statusServer(t, "")
+	if err := a.SetBaseURL(ServiceOSRM, upA.URL+"/osrm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetBaseURL(ServiceOSRM, upB.URL+"/osrm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RateLimiter().SetLimit(ServiceOSRM, 50, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := BaseURL(ServiceOSRM); got != OSRMBaseURL {
+		t.Errorf("default client OSRM URL = %s, want %s", got, OSRMBaseURL)
+	}
+	for _, state := range b.RateLimiter().State() {
+		if state.RPS != DefaultRPS {
+			t.Errorf("client b %s limit = %g, want the default", state.Service, state.RPS)
+		}
+	}
+
+	// DoRequest sends with the client carried by the context
+	for _, c := range []*Client{a, b} {
+		ctx := NewContext(context.Background(), c)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL(ServiceOSRM)+"/route", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := DoRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if na, nb := atomic.LoadInt32(callsA), atomic.LoadInt32(callsB); na != 1 || nb != 1 {
+		t.Errorf("upstreams received %d and %d requests, want 1 each", na, nb)
+	}
+
+	if FromContext(context.Background()) != Default() {
+		t.Error("FromContext() without a client did not return the default client")
+	}
+}
+
+// recordingObserver records the events reported to it
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) ObserveRequest(service string, status int, _ time.Duration) {
+	o.record(fmt.Sprintf("request %s %d", service, status))
+}
+
+func (o *recordingObserver) ObserveRetry(service string) {
+	o.record("retry " + service)
+}
+
+func (o *recordingObserver) ObserveRateLimitWait(service string, _ time.Duration) {
+	o.record("wait " + service)
+}
+
+func (o *recordingObserver) ObserveCache(service string, hit bool) {
+	o.record(fmt.Sprintf("cache %s %t", service, hit))
+}
+
+func TestClientObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	c := NewOSMClient(WithObserver(observer))
+	t.Cleanup(c.Close)
+	c.SetRetryPolicy(testPolicy)
+	if err := c.RateLimiter().SetLimit(ServiceOSRM, 1000, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	up, _ := statusServer(t, "", http.StatusServiceUnavailable)
+	if err := c.SetBaseURL(ServiceOSRM, up.URL+"/osrm"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL(ServiceOSRM)+"/route", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.DoRequest(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{
+		"wait osrm", "request osrm 503", "retry osrm",
+		"wait osrm", "request osrm 200",
+	}
+	if fmt.Sprint(observer.events) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", observer.events, want)
+	}
+}
@@ -0,0 +1,356 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Mirror is one instance of an upstream service
+type Mirror struct {
+	BaseURL string
+
+	// Headers are sent only to this mirror, in addition to the
+	// service-wide headers of its Endpoint
+	Headers map[string]string
+}
+
+// Endpoint describes where an upstream service is reached and which
+// extra headers, such as credentials for a private mirror, it requires.
+// Requests go to the first healthy mirror in order.
+type Endpoint struct {
+	Mirrors []Mirror
+	Headers map[string]string
+}
+
+// defaultEndpoints returns the public instance of every service
+func defaultEndpoints() map[string]Endpoint {
+	return map[string]Endpoint{
+		ServiceNominatim: {Mirrors: []Mirror{{BaseURL: NominatimBaseURL}}},
+		ServiceOverpass:  {Mirrors: []Mirror{{BaseURL: OverpassBaseURL}}},
+		ServiceOSRM:      {Mirrors: []Mirror{{BaseURL: OSRMBaseURL}}},
+	}
+}
+
+// Services returns the names of all upstream services
+func Services() []string {
+	return []string{ServiceNominatim, ServiceOverpass, ServiceOSRM}
+}
+
+// DefaultBaseURL returns the base URL of the public instance of a service
+func DefaultBaseURL(service string) string {
+	mirrors := defaultEndpoints()[service].Mirrors
+	if len(mirrors) == 0 {
+		return ""
+	}
+	return mirrors[0].BaseURL
+}
+
+// validateBaseURL checks that a base URL is absolute and returns it
+// without a trailing slash
+func validateBaseURL(service, baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s URL %q: %w", service, baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("invalid %s URL %q: must be an absolute http or https URL", service, baseURL)
+	}
+	return strings.TrimSuffix(baseURL, "/"), nil
+}
+
+// ValidateBaseURL checks that baseURL can serve as a base URL of service
+func ValidateBaseURL(service, baseURL string) error {
+	_, err := validateBaseURL(service, baseURL)
+	return err
+}
+
+// SetBaseURL points a service of the default client at baseURL
+func SetBaseURL(service, baseURL string) error {
+	return defaultClient.SetBaseURL(service, baseURL)
+}
+
+// SetBaseURL points a service at a different base URL, for example a
+// self-hosted mirror. The URL must be absolute.
+func (c *Client) SetBaseURL(service, baseURL string) error {
+	return c.SetMirrors(service, Mirror{BaseURL: baseURL})
+}
+
+// SetMirrors replaces the mirrors of a service of the default client
+func SetMirrors(service string, mirrors ...Mirror) error {
+	return defaultClient.SetMirrors(service, mirrors...)
+}
+
+// SetMirrors replaces the mirrors of a service. Requests go to the first
+// mirror and fail over to the next ones in order.
+func (c *Client) SetMirrors(service string, mirrors ...Mirror) error {
+	if len(mirrors) == 0 {
+		return fmt.Errorf("no %s URL given", service)
+	}
+
+	list := make([]Mirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		baseURL, err := validateBaseURL(service, m.BaseURL)
+		if err != nil {
+			return err
+		}
+		headers := make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+		list = append(list, Mirror{BaseURL: baseURL, Headers: headers})
+	}
+
+	c.endpointsLock.Lock()
+	defer c.endpointsLock.Unlock()
+
+	ep, ok := c.endpoints[service]
+	if !ok {
+		return fmt.Errorf("unknown service: %s", service)
+	}
+	ep.Mirrors = list
+	c.endpoints[service] = ep
+	return nil
+}
+
+// BaseURL returns the base URL of a service's primary mirror in the
+// default client
+func BaseURL(service string) string {
+	return defaultClient.BaseURL(service)
+}
+
+// BaseURL returns the base URL of a service's primary mirror. Tools build
+// their request URLs from it; failover rewrites them for other mirrors.
+func (c *Client) BaseURL(service string) string {
+	c.endpointsLock.RLock()
+	defer c.endpointsLock.RUnlock()
+
+	mirrors := c.endpoints[service].Mirrors
+	if len(mirrors) == 0 {
+		return ""
+	}
+	return mirrors[0].BaseURL
+}
+
+// BaseURLs returns the base URLs of all mirrors of a service in the
+// default client
+func BaseURLs(service string) []string {
+	return defaultClient.BaseURLs(service)
+}
+
+// BaseURLs returns the base URLs of all mirrors of a service in order
+func (c *Client) BaseURLs(service string) []string {
+	c.endpointsLock.RLock()
+	defer c.endpointsLock.RUnlock()
+
+	mirrors := c.endpoints[service].Mirrors
+	urls := make([]string, len(mirrors))
+	for i, m := range mirrors {
+		urls[i] = m.BaseURL
+	}
+	return urls
+}
+
+// SetHeader sets an extra header sent to a service of the default client
+func SetHeader(service, name, value string) error {
+	return defaultClient.SetHeader(service, name, value)
+}
+
+// SetHeader sets an extra header sent with every request to every mirror
+// of a service. An empty value removes the header.
+func (c *Client) SetHeader(service, name, value string) error {
+	c.endpointsLock.Lock()
+	defer c.endpointsLock.Unlock()
+
+	ep, ok := c.endpoints[service]
+	if !ok {
+		return fmt.Errorf("unknown service: %s", service)
+	}
+
+	headers := make(map[string]string, len(ep.Headers)+1)
+	for k, v := range ep.Headers {
+		headers[k] = v
+	}
+	if value == "" {
+		delete(headers, http.CanonicalHeaderKey(name))
+	} else {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	ep.Headers = headers
+	c.endpoints[service] = ep
+	return nil
+}
+
+// SetHeaders replaces the extra headers sent to a service of the default
+// client
+func SetHeaders(service string, headers map[string]string) error {
+	return defaultClient.SetHeaders(service, headers)
+}
+
+// SetHeaders replaces all extra headers sent with every request to every
+// mirror of a service
+func (c *Client) SetHeaders(service string, headers map[string]string) error {
+	canonical := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if v != "" {
+			canonical[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+
+	c.endpointsLock.Lock()
+	defer c.endpointsLock.Unlock()
+
+	ep, ok := c.endpoints[service]
+	if !ok {
+		return fmt.Errorf("unknown service: %s", service)
+	}
+	ep.Headers = canonical
+	c.endpoints[service] = ep
+	return nil
+}
+
+// ParseHeader splits a "Name: value" header specification
+func ParseHeader(spec string) (string, string, error) {
+	name, value, ok := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid header %q: expected \"Name: value\"", spec)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// mirrorMatch identifies the mirror a URL is addressed to
+type mirrorMatch struct {
+	service string
+	mirror  Mirror
+	headers map[string]string // service-wide headers
+	prefix  string            // path prefix shared by all requests to the mirror
+	mirrors []Mirror          // all mirrors of the service, in order
+}
+
+// matchURL returns the mirror whose base URL covers u. When several
+// mirrors share a host the longest matching path wins, and of equally
+// long paths the service named first in sorted order.
+func (c *Client) matchURL(u *url.URL) (mirrorMatch, bool) {
+	c.endpointsLock.RLock()
+	defer c.endpointsLock.RUnlock()
+
+	var best mirrorMatch
+	bestLen := -1
+	for _, service := range slices.Sorted(maps.Keys(c.endpoints)) {
+		ep := c.endpoints[service]
+		for _, m := range ep.Mirrors {
+			base, err := url.Parse(m.BaseURL)
+			if err != nil || !strings.EqualFold(base.Host, u.Host) {
+				continue
+			}
+			prefix := servicePathPrefix(service, base.Path)
+			if !strings.HasPrefix(u.Path, prefix) {
+				continue
+			}
+			if len(prefix) > bestLen {
+				best = mirrorMatch{
+					service: service,
+					mirror:  m,
+					headers: ep.Headers,
+					prefix:  prefix,
+					mirrors: ep.Mirrors,
+				}
+				bestLen = len(prefix)
+			}
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// ServiceForURL returns the service of the default client whose mirrors
+// cover u
+func ServiceForURL(u *url.URL) string {
+	return defaultClient.ServiceForURL(u)
+}
+
+// ServiceForURL returns the service whose configured mirrors cover u,
+// or an empty string if u does not belong to a known service. When
+// several services share a host the longest matching path wins, and of
+// equally long paths the service named first in sorted order.
+func (c *Client) ServiceForURL(u *url.URL) string {
+	match, _ := c.matchURL(u)
+	return match.service
+}
+
+// MirrorForURL returns the base URL of the default client's mirror u is
+// addressed to, or an empty string
+func MirrorForURL(u *url.URL) string {
+	match, _ := defaultClient.matchURL(u)
+	return match.mirror.BaseURL
+}
+
+// servicePathPrefix returns the path prefix shared by all requests to a
+// service. The Overpass base URL names the interpreter itself, while
+// its sibling endpoints such as /api/status live next to it.
+func servicePathPrefix(service, basePath string) string {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if service == ServiceOverpass {
+		basePath = strings.TrimSuffix(basePath, "/interpreter")
+	}
+	return basePath
+}
+
+// rewriteURL moves a URL addressed to one mirror of a service over to
+// another mirror of the same service
+func rewriteURL(u *url.URL, service, fromPrefix, toBaseURL string) (*url.URL, error) {
+	to, err := url.Parse(toBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	out := *u
+	out.Scheme = to.Scheme
+	out.Host = to.Host
+	out.User = to.User
+	out.Path = servicePathPrefix(service, to.Path) + strings.TrimPrefix(u.Path, fromPrefix)
+	out.RawPath = ""
+	return &out, nil
+}
+
+// endpointHeaders returns the configured extra headers for the mirror
+// a URL is addressed to
+func (c *Client) endpointHeaders(u *url.URL) map[string]string {
+	match, ok := c.matchURL(u)
+	if !ok || len(match.headers)+len(match.mirror.Headers) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(match.headers)+len(match.mirror.Headers))
+	for k, v := range match.headers {
+		headers[k] = v
+	}
+	for k, v := range match.mirror.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// endpointTransport adds per-service headers to every outgoing request
+type endpointTransport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers := t.client.endpointHeaders(req.URL)
+	if len(headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
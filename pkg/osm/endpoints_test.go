@@ -0,0 +1,171 @@
+package osm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// restoreEndpoints resets the endpoint configuration after a test
+func restoreEndpoints(t *testing.T) {
+	t.Helper()
+
+	c := Default()
+	c.endpointsLock.RLock()
+	saved := make(map[string]Endpoint, len(c.endpoints))
+	for k, v := range c.endpoints {
+		saved[k] = v
+	}
+	c.endpointsLock.RUnlock()
+
+	t.Cleanup(func() {
+		c.endpointsLock.Lock()
+		c.endpoints = saved
+		c.endpointsLock.Unlock()
+	})
+}
+
+func TestSetBaseURL(t *testing.T) {
+	restoreEndpoints(t)
+
+	tests := []struct {
+		name    string
+		service string
+		url     string
+		wantErr bool
+	}{
+		{"valid https", ServiceNominatim, "https://nominatim.example.com/", false},
+		{"valid http with path", ServiceOSRM, "http://localhost:5000/osrm", false},
+		{"relative", ServiceOSRM, "/osrm", true},
+		{"unsupported scheme", ServiceOverpass, "ftp://example.com", true},
+		{"unknown service", "tiles", "https://tiles.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetBaseURL(tt.service, tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetBaseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := BaseURL(ServiceNominatim); got != "https://nominatim.example.com" {
+		t.Errorf("BaseURL() = %q, want trailing slash trimmed", got)
+	}
+}
+
+func TestServiceForURL(t *testing.T) {
+	restoreEndpoints(t)
+
+	// Two services behind one host, distinguished by path
+	if err := SetBaseURL(ServiceOSRM, "https://maps.example.com/osrm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBaseURL(ServiceOverpass, "https://maps.example.com/overpass/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://maps.example.com/osrm/route/v1/driving/1,2;3,4", ServiceOSRM},
+		{"https://maps.example.com/overpass/api/interpreter?data=x", ServiceOverpass},
+		{"https://maps.example.com/overpass/api/status", ServiceOverpass},
+		{"https://nominatim.openstreetmap.org/search", ServiceNominatim},
+		{"https://router.project-osrm.org/route/v1/driving/1,2;3,4", ""},
+		{"https://example.org/", ""},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ServiceForURL(u); got != tt.want {
+			t.Errorf("ServiceForURL(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestServiceForURLSharedPrefix(t *testing.T) {
+	c := NewOSMClient()
+	defer c.Close()
+
+	// Services with the same base URL resolve the same way every time
+	for _, service := range []string{ServiceOSRM, ServiceNominatim} {
+		if err := c.SetBaseURL(service, "https://maps.example.com/api"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := url.Parse("https://maps.example.com/api/route/v1/driving/1,2;3,4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 50 {
+		if got := c.ServiceForURL(u); got != ServiceNominatim {
+			t.Fatalf("ServiceForURL(%s) = %q, want %q", u, got, ServiceNominatim)
+		}
+	}
+}
+
+func TestEndpointTransportHeaders(t *testing.T) {
+	restoreEndpoints(t)
+
+	var gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	if err := SetBaseURL(ServiceOSRM, ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetHeader(ServiceOSRM, "authorization", "Bearer secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &endpointTransport{client: Default(), base: http.DefaultTransport}}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/route/v1/driving/1,2;3,4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", gotAuth, "Bearer secret")
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("transport modified the caller's request")
+	}
+
+	// An empty value removes the header again
+	if err := SetHeader(ServiceOSRM, "Authorization", ""); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Get(ts.URL + "/route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if gotAuth != "" {
+		t.Errorf("Authorization = %q after removal, want empty", gotAuth)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	name, value, err := ParseHeader("X-Api-Key: abc:def ")
+	if err != nil || name != "X-Api-Key" || value != "abc:def" {
+		t.Errorf("ParseHeader() = %q, %q, %v", name, value, err)
+	}
+	if _, _, err := ParseHeader("no separator"); err == nil {
+		t.Error("ParseHeader() accepted a header without a colon")
+	}
+}
@@ -0,0 +1,235 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FixtureMode selects whether upstream exchanges are recorded to or
+// replayed from fixture files
+type FixtureMode string
+
+const (
+	// FixturesOff sends requests upstream as usual
+	FixturesOff FixtureMode = ""
+
+	// FixturesRecord sends requests upstream and saves every exchange
+	FixturesRecord FixtureMode = "record"
+
+	// FixturesReplay answers requests from saved exchanges without
+	// contacting any service
+	FixturesReplay FixtureMode = "replay"
+)
+
+// ErrNoFixture is returned in replay mode for a request that was never
+// recorded
+var ErrNoFixture = errors.New("no fixture recorded for request")
+
+// Fixture is one recorded exchange with an upstream service
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest identifies the request of a Fixture
+type FixtureRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// FixtureResponse is the recorded response of a Fixture
+type FixtureResponse struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// fixtureHeaders are the response headers kept in fixtures. Others, such
+// as dates and server names, would only make recordings differ.
+var fixtureHeaders = []string{"Content-Type", "Retry-After"}
+
+// ParseFixtureMode converts "record", "replay" or "off" into a FixtureMode
+func ParseFixtureMode(name string) (FixtureMode, error) {
+	switch m := FixtureMode(strings.ToLower(strings.TrimSpace(name))); m {
+	case FixturesOff, FixturesRecord, FixturesReplay:
+		return m, nil
+	case "off":
+		return FixturesOff, nil
+	}
+	return FixturesOff, fmt.Errorf("unknown fixture mode %q (must be record, replay or off)", name)
+}
+
+// SetFixtures sets the fixture mode and directory of the default client
+func SetFixtures(mode FixtureMode, dir string) error {
+	return defaultClient.SetFixtures(mode, dir)
+}
+
+// SetFixtures makes DoRequest record upstream exchanges to, or replay
+// them from, one file per request in dir. FixturesOff restores normal
+// operation. Replayed requests skip rate limiting and the Overpass slot
+// scheduler; recorded ones pass through them as usual.
+func (c *Client) SetFixtures(mode FixtureMode, dir string) error {
+	if _, err := ParseFixtureMode(string(mode)); err != nil {
+		return err
+	}
+	if mode != FixturesOff && dir == "" {
+		return fmt.Errorf("fixture mode %s needs a directory", mode)
+	}
+	if mode == FixturesRecord {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create fixture directory: %w", err)
+		}
+	}
+
+	c.fixtureLock.Lock()
+	defer c.fixtureLock.Unlock()
+	c.fixtureMode, c.fixtureDir = mode, dir
+	return nil
+}
+
+// fixtureSettings returns the current fixture mode and directory
+func (c *Client) fixtureSettings() (FixtureMode, string) {
+	c.fixtureLock.RLock()
+	defer c.fixtureLock.RUnlock()
+	return c.fixtureMode, c.fixtureDir
+}
+
+// FixturePath returns the file in dir that holds the exchange for a
+// request with the given method, URL and body. The name starts with the
+// service, as the default client knows it, so fixtures of one service
+// sort together.
+func FixturePath(dir, method, rawURL string, body []byte) string {
+	return defaultClient.fixturePath(dir, method, rawURL, body)
+}
+
+// fixturePath is FixturePath with the services of c
+func (c *Client) fixturePath(dir, method, rawURL string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", method, rawURL)
+	h.Write(body)
+
+	service := "other"
+	if req, err := http.NewRequest(method, rawURL, nil); err == nil {
+		if s := c.ServiceForURL(req.URL); s != "" {
+			service = s
+		}
+	}
+	return filepath.Join(dir, service+"-"+hex.EncodeToString(h.Sum(nil))[:16]+".json")
+}
+
+// fixtureTransport records or replays exchanges in front of the rest of
+// the transport chain
+type fixtureTransport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mode, dir := t.client.fixtureSettings()
+	if mode == FixturesOff {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := t.client.fixturePath(dir, req.Method, req.URL.String(), body)
+
+	if mode == FixturesReplay {
+		return replayFixture(req, path)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	fixture := Fixture{
+		Request: FixtureRequest{Method: req.Method, URL: req.URL.String(), Body: string(body)},
+		Response: FixtureResponse{
+			StatusCode: resp.StatusCode,
+			Header:     make(http.Header),
+			Body:       string(respBody),
+		},
+	}
+	for _, name := range fixtureHeaders {
+		if v := resp.Header.Values(name); len(v) > 0 {
+			fixture.Response.Header[name] = v
+		}
+	}
+	if err := WriteFixture(path, fixture); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// WriteFixture saves a fixture as indented JSON
+func WriteFixture(path string, fixture Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// replayFixture answers a request from the fixture at path
+func replayFixture(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoFixture, req.Method, req.URL.Redacted())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+
+	header := fixture.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Response.StatusCode, http.StatusText(fixture.Response.StatusCode)),
+		StatusCode:    fixture.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(fixture.Response.Body)),
+		ContentLength: int64(len(fixture.Response.Body)),
+		Request:       req,
+	}, nil
+}
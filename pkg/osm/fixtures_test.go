@@ -0,0 +1,103 @@
+package osm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	restoreEndpoints(t)
+	t.Cleanup(func() { SetFixtures(FixturesOff, "") })
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/interpreter" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Time", time.Now().String())
+		w.Write([]byte(`{"query":"` + string(body) + `"}`))
+	}))
+	defer ts.Close()
+
+	if err := SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(q string) (string, error) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/interpreter", strings.NewReader(q))
+		resp, err := DoRequest(context.Background(), req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body) + resp.Header.Get("X-Request-Time"), nil
+	}
+
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	if err := SetFixtures(FixturesRecord, dir); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := query("data=a"); err != nil || !strings.HasPrefix(body, `{"query":"data=a"}`) {
+		t.Fatalf("recorded response = %q, %v", body, err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || !strings.HasPrefix(files[0].Name(), ServiceOverpass+"-") {
+		t.Fatalf("fixture files = %v, want one overpass fixture", files)
+	}
+
+	if err := SetFixtures(FixturesReplay, dir); err != nil {
+		t.Fatal(err)
+	}
+	// Volatile headers are not recorded
+	if body, err := query("data=a"); err != nil || body != `{"query":"data=a"}` {
+		t.Errorf("replayed response = %q, %v", body, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("upstream called %d times, want only while recording", n)
+	}
+
+	// Unrecorded requests fail at once instead of being retried
+	start := time.Now()
+	if _, err := query("data=b"); !errors.Is(err, ErrNoFixture) {
+		t.Errorf("unrecorded request error = %v, want ErrNoFixture", err)
+	}
+	if elapsed := time.Since(start); elapsed > DefaultRetryPolicy.InitialBackoff {
+		t.Errorf("unrecorded request took %v, want no retries", elapsed)
+	}
+}
+
+func TestParseFixtureMode(t *testing.T) {
+	for input, want := range map[string]FixtureMode{
+		"":        FixturesOff,
+		"off":     FixturesOff,
+		"Record":  FixturesRecord,
+		" replay": FixturesReplay,
+	} {
+		if got, err := ParseFixtureMode(input); err != nil || got != want {
+			t.Errorf("ParseFixtureMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFixtureMode("rewind"); err == nil {
+		t.Error("ParseFixtureMode() accepted an unknown mode")
+	}
+	if err := SetFixtures(FixturesReplay, ""); err == nil {
+		t.Error("SetFixtures() accepted replay mode without a directory")
+	}
+}
@@ -0,0 +1,241 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failures that take a
+	// mirror out of rotation
+	breakerThreshold = 3
+
+	// breakerCooldown is how long a failed mirror stays out of rotation
+	// before a single trial request may test it again
+	breakerCooldown = 30 * time.Second
+)
+
+// breakerState is the state of a mirror's circuit breaker
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // healthy, requests flow
+	breakerOpen                         // failing, requests go elsewhere
+	breakerHalfOpen                     // cooldown over, one trial request in flight
+)
+
+// String returns the name of the breaker state
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// mirrorHealth tracks the recent results of one mirror
+type mirrorHealth struct {
+	state               breakerState
+	consecutiveFailures int
+	openUntil           time.Time
+	successes           int64
+	failures            int64
+	lastError           string
+}
+
+// MirrorState describes the health of one mirror for diagnostics
+type MirrorState struct {
+	Service             string     `json:"service"`
+	URL                 string     `json:"url"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// healthOf returns the health record of a mirror, creating it if needed.
+// The caller must hold c.mirrorHealthLock.
+func (c *Client) healthOf(baseURL string) *mirrorHealth {
+	h, ok := c.mirrorHealth[baseURL]
+	if !ok {
+		h = &mirrorHealth{}
+		c.mirrorHealth[baseURL] = h
+	}
+	return h
+}
+
+// acquireMirror reports whether a request may be sent to a mirror now.
+// An open breaker whose cooldown has passed admits one trial request.
+func (c *Client) acquireMirror(baseURL string, now time.Time) bool {
+	c.mirrorHealthLock.Lock()
+	defer c.mirrorHealthLock.Unlock()
+
+	h := c.healthOf(baseURL)
+	switch h.state {
+	case breakerOpen:
+		if now.Before(h.openUntil) {
+			return false
+		}
+		h.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// recordMirrorResult updates a mirror's breaker with the outcome of a
+// request. A failure with a Retry-After delay opens the breaker at once
+// for at least that long.
+func (c *Client) recordMirrorResult(service, baseURL string, failed bool, reason string, retryAfter time.Duration) {
+	c.mirrorHealthLock.Lock()
+	defer c.mirrorHealthLock.Unlock()
+
+	h := c.healthOf(baseURL)
+	if !failed {
+		if h.state != breakerClosed {
+			c.logger.Info("mirror recovered", "service", service, "mirror", baseURL)
+		}
+		h.state = breakerClosed
+		h.consecutiveFailures = 0
+		h.successes++
+		return
+	}
+
+	h.failures++
+	h.consecutiveFailures++
+	h.lastError = reason
+
+	if h.state == breakerHalfOpen || h.consecutiveFailures >= breakerThreshold || retryAfter > 0 {
+		cooldown := max(breakerCooldown, retryAfter)
+		if h.state != breakerOpen {
+			c.logger.Warn("mirror taken out of rotation", "service", service, "mirror", baseURL,
+				"failures", h.consecutiveFailures, "error", reason, "cooldown", cooldown)
+		}
+		h.state = breakerOpen
+		h.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// releaseMirror returns a half-open mirror to the open state when its
+// trial request ended without a verdict, for example because the caller
+// gave up
+func (c *Client) releaseMirror(baseURL string) {
+	c.mirrorHealthLock.Lock()
+	defer c.mirrorHealthLock.Unlock()
+
+	if h := c.healthOf(baseURL); h.state == breakerHalfOpen {
+		h.state = breakerOpen
+	}
+}
+
+// isMirrorFailure reports whether a response status shows the mirror is
+// unhealthy or overloaded
+func isMirrorFailure(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// pickMirror chooses the mirror for the next attempt of a request. It
+// prefers, in configuration order, healthy mirrors this request has not
+// tried yet, then healthy mirrors it has tried. If every breaker is open
+// it returns the mirror the request was addressed to.
+func (c *Client) pickMirror(match mirrorMatch, tried map[string]bool) string {
+	now := time.Now()
+	var fallback string
+	for _, m := range match.mirrors {
+		if tried[m.BaseURL] {
+			if fallback == "" && c.mirrorAvailable(m.BaseURL, now) {
+				fallback = m.BaseURL
+			}
+			continue
+		}
+		if c.acquireMirror(m.BaseURL, now) {
+			return m.BaseURL
+		}
+	}
+	if fallback != "" && c.acquireMirror(fallback, now) {
+		return fallback
+	}
+	return match.mirror.BaseURL
+}
+
+// hasUntriedMirror reports whether a request has a healthy mirror left
+// that it has not been sent to yet
+func (c *Client) hasUntriedMirror(match mirrorMatch, tried map[string]bool) bool {
+	now := time.Now()
+	for _, m := range match.mirrors {
+		if !tried[m.BaseURL] && c.mirrorAvailable(m.BaseURL, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorAvailable reports whether a mirror would accept a request now,
+// without claiming a half-open trial
+func (c *Client) mirrorAvailable(baseURL string, now time.Time) bool {
+	c.mirrorHealthLock.Lock()
+	defer c.mirrorHealthLock.Unlock()
+
+	h := c.healthOf(baseURL)
+	return h.state == breakerClosed || h.state == breakerOpen && !now.Before(h.openUntil)
+}
+
+// MirrorStates returns the health of every mirror of the default client
+func MirrorStates() []MirrorState {
+	return defaultClient.MirrorStates()
+}
+
+// MirrorStates returns the health of every configured mirror
+func (c *Client) MirrorStates() []MirrorState {
+	var states []MirrorState
+	for _, service := range Services() {
+		for _, baseURL := range c.BaseURLs(service) {
+			states = append(states, c.mirrorState(service, baseURL))
+		}
+	}
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Service < states[j].Service
+	})
+	return states
+}
+
+// mirrorState returns the health of one mirror
+func (c *Client) mirrorState(service, baseURL string) MirrorState {
+	c.mirrorHealthLock.Lock()
+	defer c.mirrorHealthLock.Unlock()
+
+	h := c.healthOf(baseURL)
+	state := MirrorState{
+		Service:             service,
+		URL:                 redactURL(baseURL),
+		State:               h.state.String(),
+		ConsecutiveFailures: h.consecutiveFailures,
+		Successes:           h.successes,
+		Failures:            h.failures,
+		LastError:           h.lastError,
+	}
+	if h.state == breakerOpen {
+		retryAt := h.openUntil
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+// redactURL hides credentials embedded in a URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
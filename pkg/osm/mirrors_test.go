@@ -0,0 +1,119 @@
+package osm
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// resetMirrorHealth forgets all breaker state before and after a test
+func resetMirrorHealth(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		c := Default()
+		c.mirrorHealthLock.Lock()
+		c.mirrorHealth = make(map[string]*mirrorHealth)
+		c.mirrorHealthLock.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestRetryPolicyFailsOver(t *testing.T) {
+	restoreEndpoints(t)
+	resetMirrorHealth(t)
+
+	down, downCalls := statusServer(t, "", http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	up, upCalls := statusServer(t, "")
+	if err := SetMirrors(ServiceOSRM, Mirror{BaseURL: down.URL + "/osrm"}, Mirror{BaseURL: up.URL + "/osrm"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, info := WithRequestInfo(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, down.URL+"/osrm/route/v1/driving/1,2;3,4", nil)
+	resp, err := testPolicy.Do(ctx, down.Client(), req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if *downCalls != 1 || *upCalls != 1 {
+		t.Errorf("primary called %d times, mirror %d times, want 1 each", *downCalls, *upCalls)
+	}
+	if got := info.Mirror(ServiceOSRM); got != up.URL+"/osrm" {
+		t.Errorf("answering mirror = %q, want %q", got, up.URL+"/osrm")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	resetMirrorHealth(t)
+	const mirror = "https://mirror.example"
+
+	for i := 0; i < breakerThreshold; i++ {
+		if !Default().acquireMirror(mirror, time.Now()) {
+			t.Fatalf("mirror rejected after %d failures, threshold is %d", i, breakerThreshold)
+		}
+		Default().recordMirrorResult(ServiceOSRM, mirror, true, "502 Bad Gateway", 0)
+	}
+	if Default().acquireMirror(mirror, time.Now()) {
+		t.Fatal("mirror accepted requests with an open breaker")
+	}
+
+	// After the cooldown exactly one trial request is let through
+	later := time.Now().Add(breakerCooldown + time.Second)
+	if !Default().acquireMirror(mirror, later) {
+		t.Fatal("mirror rejected the trial request after the cooldown")
+	}
+	if Default().acquireMirror(mirror, later) {
+		t.Fatal("mirror accepted a second request while half-open")
+	}
+
+	Default().recordMirrorResult(ServiceOSRM, mirror, false, "", 0)
+	if !Default().acquireMirror(mirror, time.Now()) {
+		t.Error("mirror still rejected after a successful trial")
+	}
+	if state := Default().mirrorState(ServiceOSRM, mirror); state.State != "closed" || state.Failures != breakerThreshold {
+		t.Errorf("state = %+v, want closed with %d failures", state, breakerThreshold)
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	resetMirrorHealth(t)
+	const mirror = "https://mirror.example"
+
+	Default().recordMirrorResult(ServiceOverpass, mirror, true, "429 Too Many Requests", 2*breakerCooldown)
+	if Default().acquireMirror(mirror, time.Now().Add(breakerCooldown+time.Second)) {
+		t.Error("mirror accepted requests before its Retry-After delay passed")
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		in      string
+		prefix  string
+		to      string
+		want    string
+	}{
+		{"osrm", ServiceOSRM, "https://a.example/osrm/route/v1/driving/1,2;3,4?overview=false", "/osrm",
+			"http://b.example:5000", "http://b.example:5000/route/v1/driving/1,2;3,4?overview=false"},
+		{"overpass status", ServiceOverpass, "https://a.example/api/status", "/api",
+			"https://b.example/overpass/api/interpreter", "https://b.example/overpass/api/status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.in)
+			got, err := rewriteURL(u, tt.service, tt.prefix, tt.to)
+			if err != nil {
+				t.Fatalf("rewriteURL() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("rewriteURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
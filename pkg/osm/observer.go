@@ -0,0 +1,62 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"time"
+)
+
+// Observer receives events about the upstream requests of a client, for
+// example to export them as metrics. Services are named by the Service
+// constants. Methods are called concurrently and must return quickly.
+type Observer interface {
+	// ObserveRequest reports one request sent to a service, after any
+	// rate limiter wait. status is 0 when no response was received.
+	ObserveRequest(service string, status int, duration time.Duration)
+
+	// ObserveRetry reports that a failed request will be sent again
+	ObserveRetry(service string)
+
+	// ObserveRateLimitWait reports how long a request waited for the
+	// rate limiter of its service
+	ObserveRateLimitWait(service string, wait time.Duration)
+
+	// ObserveCache reports whether the response cache answered a
+	// cacheable request
+	ObserveCache(service string, hit bool)
+}
+
+// noopObserver discards all events
+type noopObserver struct{}
+
+func (noopObserver) ObserveRequest(string, int, time.Duration)  {}
+func (noopObserver) ObserveRetry(string)                        {}
+func (noopObserver) ObserveRateLimitWait(string, time.Duration) {}
+func (noopObserver) ObserveCache(string, bool)                  {}
+
+// WithObserver reports the client's upstream requests to o
+func WithObserver(o Observer) ClientOption {
+	return func(c *Client) {
+		c.SetObserver(o)
+	}
+}
+
+// SetObserver reports the client's upstream requests to o. A nil
+// observer discards them.
+func (c *Client) SetObserver(o Observer) {
+	if o == nil {
+		o = noopObserver{}
+	}
+	c.observerLock.Lock()
+	defer c.observerLock.Unlock()
+	c.observer = o
+}
+
+// Observer returns the observer of the client
+func (c *Client) Observer() Observer {
+	c.observerLock.RLock()
+	defer c.observerLock.RUnlock()
+	if c.observer == nil {
+		return noopObserver{}
+	}
+	return c.observer
+}
@@ -0,0 +1,408 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// overpassStatusTimeout bounds a single /api/status request
+	overpassStatusTimeout = 5 * time.Second
+
+	// overpassStatusMaxAge is how long a status that shows a free slot
+	// is reused for the next queries before the instance is asked again
+	overpassStatusMaxAge = time.Second
+
+	// overpassStatusRetry is how long the scheduler stops asking an
+	// instance for its status after the status endpoint failed
+	overpassStatusRetry = 5 * time.Minute
+
+	// overpassMinPoll and overpassMaxPoll bound the delay between two
+	// status checks while queries are queued
+	overpassMinPoll = 500 * time.Millisecond
+	overpassMaxPoll = 30 * time.Second
+)
+
+// OverpassStatus is the slot information reported by an Overpass
+// instance's /api/status endpoint
+type OverpassStatus struct {
+	// RateLimit is the number of slots per client; 0 means unlimited
+	RateLimit int
+
+	// SlotsAvailable is the number of slots free right now
+	SlotsAvailable int
+
+	// SlotTimes lists when the occupied slots become free again
+	SlotTimes []time.Time
+
+	// Running is the number of queries currently running for this client
+	Running int
+}
+
+// NextSlot returns how long until a slot is free, measured from now
+func (s *OverpassStatus) NextSlot(now time.Time) time.Duration {
+	if s.RateLimit == 0 || s.SlotsAvailable > 0 {
+		return 0
+	}
+	var next time.Duration = -1
+	for _, t := range s.SlotTimes {
+		if d := t.Sub(now); next < 0 || d < next {
+			next = d
+		}
+	}
+	if next < 0 {
+		// All slots are held by running queries, which report no end time
+		return overpassMinPoll
+	}
+	return max(next, 0)
+}
+
+var (
+	slotsAvailableRe = regexp.MustCompile(`^(\d+) slots? available now`)
+	slotAfterRe      = regexp.MustCompile(`^Slot available after: (\S+), in (-?\d+) seconds?`)
+	runningQueryRe   = regexp.MustCompile(`^\d+\t`)
+)
+
+// ParseOverpassStatus parses the plain text returned by /api/status
+func ParseOverpassStatus(r io.Reader, now time.Time) (*OverpassStatus, error) {
+	status := &OverpassStatus{}
+	found := false
+	inRunning := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "Rate limit:"):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Rate limit:")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit line %q", line)
+			}
+			status.RateLimit = n
+			found = true
+		case slotsAvailableRe.MatchString(line):
+			n, _ := strconv.Atoi(slotsAvailableRe.FindStringSubmatch(line)[1])
+			status.SlotsAvailable = n
+		case slotAfterRe.MatchString(line):
+			m := slotAfterRe.FindStringSubmatch(line)
+			t, err := time.Parse(time.RFC3339, strings.TrimSuffix(m[1], ","))
+			if err != nil {
+				// Fall back to the relative time
+				secs, _ := strconv.Atoi(m[2])
+				t = now.Add(time.Duration(secs) * time.Second)
+			}
+			status.SlotTimes = append(status.SlotTimes, t)
+		case strings.HasPrefix(line, "Currently running queries"):
+			inRunning = true
+		case inRunning && runningQueryRe.MatchString(scanner.Text()):
+			status.Running++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("not an Overpass status response")
+	}
+	return status, nil
+}
+
+// OverpassStatusFunc fetches the current slot status from the status
+// endpoint of an Overpass instance
+type OverpassStatusFunc func(ctx context.Context, statusURL string) (*OverpassStatus, error)
+
+// OverpassScheduler queues Overpass queries until the instance they are
+// sent to reports a free slot. It complements the token bucket in
+// RateLimiter, which knows nothing about how long queries occupy their
+// slots. Instances without a usable status endpoint are not held back.
+type OverpassScheduler struct {
+	status OverpassStatusFunc
+
+	mu        sync.Mutex
+	instances map[string]*overpassInstance
+}
+
+// overpassInstance is the scheduling state of one Overpass instance
+type overpassInstance struct {
+	// queue admits one waiting query at a time, in arrival order
+	queue chan struct{}
+
+	// active counts queries released by the scheduler that are still running
+	active int
+
+	// statusDisabledUntil suspends status checks after a failure
+	statusDisabledUntil time.Time
+
+	// status is the latest status with a free slot, fetched at statusAt
+	status   *OverpassStatus
+	statusAt time.Time
+}
+
+// NewOverpassScheduler creates a scheduler that learns the slot status
+// from status. A nil status function disables scheduling.
+func NewOverpassScheduler(status OverpassStatusFunc) *OverpassScheduler {
+	return &OverpassScheduler{
+		status:    status,
+		instances: make(map[string]*overpassInstance),
+	}
+}
+
+// GetOverpassScheduler returns the Overpass scheduler of the default client
+func GetOverpassScheduler() *OverpassScheduler {
+	return defaultClient.OverpassScheduler()
+}
+
+// SetOverpassScheduler replaces the Overpass scheduler of the default client
+func SetOverpassScheduler(s *OverpassScheduler) {
+	defaultClient.SetOverpassScheduler(s)
+}
+
+// OverpassScheduler returns the scheduler used for all Overpass queries
+func (c *Client) OverpassScheduler() *OverpassScheduler {
+	c.overpassSchedulerLock.RLock()
+	defer c.overpassSchedulerLock.RUnlock()
+	return c.overpassScheduler
+}
+
+// SetOverpassScheduler replaces the scheduler used for all Overpass
+// queries, for example with one backed by a stand-in status source
+func (c *Client) SetOverpassScheduler(s *OverpassScheduler) {
+	c.overpassSchedulerLock.Lock()
+	defer c.overpassSchedulerLock.Unlock()
+	c.overpassScheduler = s
+}
+
+// instance returns the state of the instance behind statusURL
+func (s *OverpassScheduler) instance(statusURL string) *overpassInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inst, ok := s.instances[statusURL]
+	if !ok {
+		inst = &overpassInstance{queue: make(chan struct{}, 1)}
+		s.instances[statusURL] = inst
+	}
+	return inst
+}
+
+// Acquire blocks until a query may be sent to the Overpass instance
+// whose status endpoint is statusURL. It returns a function that must be
+// called once the query has finished, along with the time spent waiting.
+// The expected wait is recorded in the context's RequestInfo while the
+// query is queued. Waiting stops when ctx is done, or at once if ctx
+// expires before the next slot is free.
+func (s *OverpassScheduler) Acquire(ctx context.Context, statusURL string) (func(), time.Duration, error) {
+	start := time.Now()
+	inst := s.instance(statusURL)
+
+	// Join the queue
+	select {
+	case inst.queue <- struct{}{}:
+	case <-ctx.Done():
+		return nil, time.Since(start), ctx.Err()
+	}
+	defer func() { <-inst.queue }()
+
+	for {
+		wait, err := s.expectedWait(ctx, statusURL, inst)
+		if err != nil {
+			return nil, time.Since(start), err
+		}
+		if wait <= 0 {
+			break
+		}
+
+		updateRequestInfo(ctx, func(info *RequestInfo) {
+			info.expectedWait = wait
+		})
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, time.Since(start), waitTooLong(fmt.Sprintf("no Overpass slot free for %s", wait.Round(time.Second)))
+		}
+		slog.Info("waiting for Overpass slot", "instance", statusURL, "expected_wait", wait)
+
+		timer := time.NewTimer(min(max(wait, overpassMinPoll), overpassMaxPoll))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, time.Since(start), ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	inst.active++
+	s.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			inst.active--
+			s.mu.Unlock()
+		})
+	}
+
+	waited := time.Since(start)
+	updateRequestInfo(ctx, func(info *RequestInfo) {
+		info.queueWait += waited
+	})
+	return release, waited, nil
+}
+
+// ExpectedWait returns how long a query sent now to the instance behind
+// statusURL would have to wait
+func (s *OverpassScheduler) ExpectedWait(ctx context.Context, statusURL string) (time.Duration, error) {
+	return s.expectedWait(ctx, statusURL, s.instance(statusURL))
+}
+
+// expectedWait asks the instance for its slot status. Queries released
+// by this scheduler that the instance does not list as running yet are
+// counted as occupying a slot. A status showing a free slot is reused
+// for overpassStatusMaxAge, with the queries released or finished since
+// counted locally, so a burst of queries costs one status request.
+func (s *OverpassScheduler) expectedWait(ctx context.Context, statusURL string, inst *overpassInstance) (time.Duration, error) {
+	if s.status == nil {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	disabled := time.Now().Before(inst.statusDisabledUntil)
+	cached := inst.status
+	if cached != nil && time.Since(inst.statusAt) > overpassStatusMaxAge {
+		cached = nil
+	}
+	s.mu.Unlock()
+	if disabled {
+		return 0, nil
+	}
+
+	status := cached
+	var err error
+	if status == nil {
+		status, err = s.status(ctx, statusURL)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		slog.Debug("Overpass status unavailable, scheduling disabled", "instance", statusURL, "error", err, "retry_in", overpassStatusRetry)
+		s.mu.Lock()
+		inst.statusDisabledUntil = time.Now().Add(overpassStatusRetry)
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status != cached {
+		inst.status, inst.statusAt = status, time.Now()
+	}
+
+	current := *status
+	if unseen := inst.active - status.Running; unseen > 0 && status.RateLimit > 0 {
+		current.SlotsAvailable = max(status.SlotsAvailable-unseen, 0)
+	}
+	wait := current.NextSlot(time.Now())
+	if wait > 0 {
+		// Waiting queries poll the instance itself
+		inst.status = nil
+	}
+	return wait, nil
+}
+
+// overpassStatusURL derives the status endpoint from an interpreter URL
+func overpassStatusURL(interpreter *url.URL) string {
+	u := *interpreter
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/interpreter") + "/status"
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// fetchOverpassStatus reads the status endpoint of an Overpass instance.
+// Status requests do not take a slot, so they bypass the rate limiter and
+// the scheduler.
+func (c *Client) fetchOverpassStatus(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, overpassStatusTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent())
+
+	resp, err := c.statusClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status endpoint returned %d", resp.StatusCode)
+	}
+	return ParseOverpassStatus(io.LimitReader(resp.Body, 64<<10), time.Now())
+}
+
+// isOverpassQuery reports whether a request runs an Overpass query
+func (c *Client) isOverpassQuery(req *http.Request) bool {
+	return c.ServiceForURL(req.URL) == ServiceOverpass && strings.HasSuffix(req.URL.Path, "/interpreter")
+}
+
+// overpassTransport holds Overpass queries back until a slot is free and
+// keeps the slot accounted for until the response body is closed
+type overpassTransport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *overpassTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.client.isOverpassQuery(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, span := startWaitSpan(req.Context(), "overpass slot wait", ServiceOverpass)
+	release, _, err := t.client.OverpassScheduler().Acquire(ctx, overpassStatusURL(req.URL))
+	endSpan(span, err)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody calls release when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close implements the io.Closer interface
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,224 @@
+package osm
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const statusBusy = `Connected as: 1234567890
+Current time: 2024-01-01T12:00:00Z
+Announced endpoint: none
+Rate limit: 2
+Slot available after: 2024-01-01T12:00:07Z, in 7 seconds.
+Slot available after: 2024-01-01T12:00:03Z, in 3 seconds.
+Currently running queries (pid, space limit, time limit, start time):
+`
+
+const statusFree = `Connected as: 1234567890
+Current time: 2024-01-01T12:00:00Z
+Rate limit: 2
+1 slots available now.
+Slot available after: 2024-01-01T12:00:03Z, in 3 seconds.
+Currently running queries (pid, space limit, time limit, start time):
+4242	536870912	180	2024-01-01T11:59:58Z
+`
+
+func TestParseOverpassStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	busy, err := ParseOverpassStatus(strings.NewReader(statusBusy), now)
+	if err != nil {
+		t.Fatalf("ParseOverpassStatus() error = %v", err)
+	}
+	if busy.RateLimit != 2 || busy.SlotsAvailable != 0 || len(busy.SlotTimes) != 2 {
+		t.Errorf("busy status = %+v", busy)
+	}
+	if got := busy.NextSlot(now); got != 3*time.Second {
+		t.Errorf("NextSlot() = %v, want 3s", got)
+	}
+
+	free, err := ParseOverpassStatus(strings.NewReader(statusFree), now)
+	if err != nil {
+		t.Fatalf("ParseOverpassStatus() error = %v", err)
+	}
+	if free.SlotsAvailable != 1 || free.Running != 1 || free.NextSlot(now) != 0 {
+		t.Errorf("free status = %+v", free)
+	}
+
+	if _, err := ParseOverpassStatus(strings.NewReader("<html>not found</html>"), now); err == nil {
+		t.Error("ParseOverpassStatus() accepted a non-status page")
+	}
+}
+
+// testStatusURL is the status endpoint the scheduler tests queue for
+const testStatusURL = "https://overpass.example/api/status"
+
+// fakeStatus returns busy for the first n calls, then a free slot
+func fakeStatus(busyCalls int32, slotIn time.Duration) (OverpassStatusFunc, *int32) {
+	var calls int32
+	return func(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n <= busyCalls {
+			return &OverpassStatus{RateLimit: 2, SlotTimes: []time.Time{time.Now().Add(slotIn)}}, nil
+		}
+		return &OverpassStatus{RateLimit: 2, SlotsAvailable: 1}, nil
+	}, &calls
+}
+
+func TestOverpassSchedulerQueues(t *testing.T) {
+	status, calls := fakeStatus(1, 10*time.Millisecond)
+	s := NewOverpassScheduler(status)
+
+	ctx, info := WithRequestInfo(context.Background())
+	release, waited, err := s.Acquire(ctx, testStatusURL)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	release()
+
+	if *calls != 2 {
+		t.Errorf("status checked %d times, want 2", *calls)
+	}
+	if waited < overpassMinPoll {
+		t.Errorf("Acquire() waited %v, want at least %v", waited, overpassMinPoll)
+	}
+	snapshot := info.Snapshot()
+	if _, ok := snapshot["expected_wait_seconds"]; !ok {
+		t.Errorf("expected wait not reported: %v", snapshot)
+	}
+	if _, ok := snapshot["queue_wait_seconds"]; !ok {
+		t.Errorf("queue wait not reported: %v", snapshot)
+	}
+}
+
+func TestOverpassSchedulerDeadline(t *testing.T) {
+	status, _ := fakeStatus(100, time.Minute)
+	s := NewOverpassScheduler(status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := s.Acquire(ctx, testStatusURL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire() error = %v, want context.DeadlineExceeded", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Acquire() waited although no slot could free up before the deadline")
+	}
+}
+
+func TestOverpassSchedulerCancel(t *testing.T) {
+	status, _ := fakeStatus(100, 10*time.Millisecond)
+	s := NewOverpassScheduler(status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, _, err := s.Acquire(ctx, testStatusURL); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestOverpassSchedulerCountsActiveQueries(t *testing.T) {
+	// The instance reports one free slot but has not seen our query yet
+	s := NewOverpassScheduler(func(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+		return &OverpassStatus{RateLimit: 2, SlotsAvailable: 1}, nil
+	})
+
+	release, _, err := s.Acquire(context.Background(), testStatusURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := s.ExpectedWait(context.Background(), testStatusURL); wait == 0 {
+		t.Error("ExpectedWait() = 0 while the only free slot is taken by our query")
+	}
+
+	release()
+	if wait, _ := s.ExpectedWait(context.Background(), testStatusURL); wait != 0 {
+		t.Errorf("ExpectedWait() = %v after release, want 0", wait)
+	}
+}
+
+func TestOverpassSchedulerReusesFreeStatus(t *testing.T) {
+	var calls int32
+	s := NewOverpassScheduler(func(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+		atomic.AddInt32(&calls, 1)
+		return &OverpassStatus{RateLimit: 2, SlotsAvailable: 2}, nil
+	})
+
+	// Queries in quick succession share one status request, and count
+	// the slots taken since locally
+	first, _, err := s.Acquire(context.Background(), testStatusURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := s.Acquire(context.Background(), testStatusURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("status checked %d times, want 1", calls)
+	}
+	if wait, _ := s.ExpectedWait(context.Background(), testStatusURL); wait == 0 {
+		t.Error("ExpectedWait() = 0 while both slots are taken by our queries")
+	}
+	first()
+	second()
+	if wait, _ := s.ExpectedWait(context.Background(), testStatusURL); wait != 0 || calls != 2 {
+		t.Errorf("ExpectedWait() = %v after %d status checks, want 0 from a fresh check once a query had to wait", wait, calls)
+	}
+}
+
+func TestOverpassSchedulerStatusUnavailable(t *testing.T) {
+	var calls int32
+	s := NewOverpassScheduler(func(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("404")
+	})
+
+	for i := 0; i < 3; i++ {
+		release, _, err := s.Acquire(context.Background(), testStatusURL)
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		release()
+	}
+	if calls != 1 {
+		t.Errorf("status checked %d times, want 1 before backing off", calls)
+	}
+}
+
+func TestOverpassSchedulerPerInstance(t *testing.T) {
+	busy := "https://busy.example/api/status"
+	s := NewOverpassScheduler(func(ctx context.Context, statusURL string) (*OverpassStatus, error) {
+		if statusURL == busy {
+			return &OverpassStatus{RateLimit: 1, SlotTimes: []time.Time{time.Now().Add(time.Minute)}}, nil
+		}
+		return &OverpassStatus{RateLimit: 1, SlotsAvailable: 1}, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, _, err := s.Acquire(ctx, "https://free.example/api/status")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v, a busy mirror must not hold back another", err)
+	}
+	release()
+
+	if _, _, err := s.Acquire(ctx, busy); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire(busy) error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestOverpassStatusURL(t *testing.T) {
+	u, _ := url.Parse("https://overpass.example/api/interpreter?data=x")
+	if got, want := overpassStatusURL(u), "https://overpass.example/api/status"; got != want {
+		t.Errorf("overpassStatusURL() = %q, want %q", got, want)
+	}
+}
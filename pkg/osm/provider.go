@@ -0,0 +1,222 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+)
+
+// ElementProvider answers element queries in place of Overpass, for
+// example from a local extract. Implementations must be safe for
+// concurrent use.
+type ElementProvider interface {
+	// QueryElements returns the elements matching filter inside box.
+	// Returning elements outside box is allowed; callers trim them.
+	QueryElements(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error)
+}
+
+// Geocoder answers geocoding queries in place of Nominatim. Results
+// carry Nominatim's fields so that callers rank them the same way.
+// Implementations must be safe for concurrent use.
+type Geocoder interface {
+	// Search returns up to limit places matching a free-form query
+	Search(ctx context.Context, query string, limit int) ([]GeocodeResult, error)
+
+	// Reverse returns the address at a point, or nil if nothing is known
+	// near it
+	Reverse(ctx context.Context, lat, lon float64) (*GeocodeResult, error)
+}
+
+// GeocodeResult is one place found by a Geocoder
+type GeocodeResult struct {
+	ID          int64
+	DisplayName string
+	Lat, Lon    float64
+	Type        string
+	Importance  float64 // 0 to 1, as reported by Nominatim
+	Address     GeocodeAddress
+}
+
+// GeocodeAddress holds the address details of a GeocodeResult
+type GeocodeAddress struct {
+	Road        string
+	HouseNumber string
+	City        string
+	Town        string
+	State       string
+	Country     string
+	PostCode    string
+}
+
+// Router answers route queries in place of OSRM. Implementations must be
+// safe for concurrent use.
+type Router interface {
+	// Route returns the fastest route between two points for a profile:
+	// car, bike or foot. It returns ErrNoRoute if the points are not
+	// connected.
+	Route(ctx context.Context, profile string, start, end geo.Location) (*RouteResult, error)
+}
+
+// Snapper is implemented by Routers that can also find the routable
+// points nearest to a location, as OSRM's nearest service does
+type Snapper interface {
+	// Nearest returns up to number points of the road network usable by
+	// a profile, nearest first. It returns ErrNoRoute if there are none
+	// close to point.
+	Nearest(ctx context.Context, profile string, point geo.Location, number int) ([]Waypoint, error)
+}
+
+// TableRouter is implemented by Routers that can find the routes from
+// one start to many ends in a single search, as OSRM's table service does
+type TableRouter interface {
+	// RoutesFrom returns the fastest route from start to each of ends,
+	// with Distance, Duration and Waypoints but no geometry or steps.
+	// The entry of an end that cannot be reached is nil. It returns
+	// ErrNoRoute if start is not near a road.
+	RoutesFrom(ctx context.Context, profile string, start geo.Location, ends []geo.Location) ([]*RouteResult, error)
+}
+
+// ErrNoRoute is returned by a Router that finds no route
+var ErrNoRoute = errors.New("no route found")
+
+// RouteResult is a route found by a Router, shaped like an OSRM route
+// with a single leg
+type RouteResult struct {
+	Distance float64 // meters
+	Duration float64 // seconds
+	Geometry []geo.Location
+	Steps    []RouteStep
+
+	// Waypoints are the start and end as snapped to the road network
+	Waypoints []Waypoint
+}
+
+// Waypoint is a requested point as snapped to the road network
+type Waypoint struct {
+	Location geo.Location
+	Name     string  // name of the road snapped to
+	Distance float64 // meters between the requested and the snapped point
+}
+
+// RouteStep is one maneuver of a RouteResult and the way followed after it
+type RouteStep struct {
+	Distance float64
+	Duration float64
+	Name     string
+	Type     string // OSRM maneuver type: depart, turn, continue, roundabout or arrive
+	Modifier string // direction of the maneuver, or the exit of a roundabout
+	Location geo.Location
+}
+
+// SetElementProvider sets the element provider of the default client
+func SetElementProvider(p ElementProvider) ElementProvider {
+	return defaultClient.SetElementProvider(p)
+}
+
+// SetElementProvider makes QueryElementsAround and QueryElementsInBBox
+// use p instead of Overpass and the tile cache. A nil provider restores
+// Overpass. The previous provider is returned.
+func (c *Client) SetElementProvider(p ElementProvider) ElementProvider {
+	c.providerLock.Lock()
+	defer c.providerLock.Unlock()
+
+	prev := c.elementProvider
+	c.elementProvider = p
+	return prev
+}
+
+// ElementProvider returns the configured provider, or nil for Overpass
+func (c *Client) ElementProvider() ElementProvider {
+	c.providerLock.RLock()
+	defer c.providerLock.RUnlock()
+	return c.elementProvider
+}
+
+// SetGeocoder sets the geocoder of the default client
+func SetGeocoder(g Geocoder) Geocoder {
+	return defaultClient.SetGeocoder(g)
+}
+
+// GetGeocoder returns the geocoder of the default client
+func GetGeocoder() Geocoder {
+	return defaultClient.Geocoder()
+}
+
+// SetGeocoder makes the geocoding tools use g instead of Nominatim. A nil
+// geocoder restores Nominatim. The previous geocoder is returned.
+func (c *Client) SetGeocoder(g Geocoder) Geocoder {
+	c.providerLock.Lock()
+	defer c.providerLock.Unlock()
+
+	prev := c.geocoder
+	c.geocoder = g
+	return prev
+}
+
+// Geocoder returns the configured geocoder, or nil if Nominatim is used
+func (c *Client) Geocoder() Geocoder {
+	c.providerLock.RLock()
+	defer c.providerLock.RUnlock()
+	return c.geocoder
+}
+
+// SetRouter sets the router of the default client
+func SetRouter(r Router) Router {
+	return defaultClient.SetRouter(r)
+}
+
+// GetRouter returns the router of the default client
+func GetRouter() Router {
+	return defaultClient.Router()
+}
+
+// SetRouter makes the routing tools use r instead of OSRM. A nil router
+// restores OSRM. The previous router is returned.
+func (c *Client) SetRouter(r Router) Router {
+	c.providerLock.Lock()
+	defer c.providerLock.Unlock()
+
+	prev := c.router
+	c.router = r
+	return prev
+}
+
+// Router returns the configured router, or nil if OSRM is used
+func (c *Client) Router() Router {
+	c.providerLock.RLock()
+	defer c.providerLock.RUnlock()
+	return c.router
+}
+
+// Matches reports whether an element of type typ with tags is selected
+// by the filter
+func (f ElementFilter) Matches(typ string, tags map[string]string) bool {
+	typeOK := false
+	for _, t := range f.Types {
+		if t == typ {
+			typeOK = true
+			break
+		}
+	}
+	if !typeOK {
+		return false
+	}
+
+	for key, values := range f.Tags {
+		v, ok := tags[key]
+		if !ok {
+			continue
+		}
+		if len(values) == 0 {
+			return true
+		}
+		for _, want := range values {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package osm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+)
+
+func TestElementFilterMatches(t *testing.T) {
+	filter := ElementFilter{
+		Types: []string{"node", "way"},
+		Tags:  map[string][]string{"amenity": {"cafe", "pub"}, "shop": nil},
+	}
+
+	tests := []struct {
+		typ  string
+		tags map[string]string
+		want bool
+	}{
+		{"node", map[string]string{"amenity": "cafe"}, true},
+		{"way", map[string]string{"amenity": "pub"}, true},
+		{"node", map[string]string{"shop": "bakery"}, true},
+		{"node", map[string]string{"amenity": "school"}, false},
+		{"relation", map[string]string{"amenity": "cafe"}, false},
+		{"node", nil, false},
+	}
+	for _, tt := range tests {
+		if got := filter.Matches(tt.typ, tt.tags); got != tt.want {
+			t.Errorf("Matches(%s, %v) = %v, want %v", tt.typ, tt.tags, got, tt.want)
+		}
+	}
+}
+
+// staticProvider serves a fixed list of elements
+type staticProvider []OverpassElement
+
+func (p staticProvider) QueryElements(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error) {
+	return p, nil
+}
+
+func TestSetElementProvider(t *testing.T) {
+	provider := staticProvider{
+		{Type: "node", ID: 1, Lat: 52.52, Lon: 13.405},
+		{Type: "node", ID: 2, Lat: 53, Lon: 13.405},
+	}
+	prev := SetElementProvider(provider)
+	t.Cleanup(func() { SetElementProvider(prev) })
+
+	filter := ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"amenity": nil}}
+	elements, err := QueryElementsAround(context.Background(), filter, 52.52, 13.405, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 1 || elements[0].ID != 1 {
+		t.Errorf("QueryElementsAround() = %+v, want element 1 only", elements)
+	}
+	if len(provider) != 2 || provider[1].ID != 2 {
+		t.Error("QueryElementsAround() modified the provider's elements")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,69 +20,241 @@ const (
 	ServiceOSRM      = "osrm"
 )
 
-// RateLimiter manages rate limiting for different OpenStreetMap API services
+const (
+	// DefaultRPS is the default number of requests per second per service.
+	// Nominatim's usage policy allows at most one request per second.
+	// https://operations.osmfoundation.org/policies/nominatim/
+	DefaultRPS = 1.0
+
+	// DefaultBurst is the default burst size per service
+	DefaultBurst = 1
+)
+
+// RateLimiter is the registry of rate limiters for the OpenStreetMap API
+// services. A Client's HTTP client consults its registry for every
+// outgoing request, so tools cannot bypass it.
 type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.RWMutex
+	limiters    map[string]*rate.Limiter
+	pausedUntil map[string]time.Time
+	mu          sync.RWMutex
 }
 
-var (
-	// globalRateLimiter is the singleton rate limiter instance
-	globalRateLimiter *RateLimiter
+// LimiterState describes the current state of one service's rate limiter
+type LimiterState struct {
+	Service string  `json:"service"`
+	RPS     float64 `json:"rps"`
+	Burst   int     `json:"burst"`
+	Tokens  float64 `json:"tokens"`
 
-	// rateLimiterOnce ensures we only create the rate limiter once
-	rateLimiterOnce sync.Once
-)
+	// PausedUntil is set while the service asked us to back off
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
 
-// GetRateLimiter returns the global rate limiter instance
-func GetRateLimiter() *RateLimiter {
-	rateLimiterOnce.Do(func() {
-		// Initialize the global rate limiter with service-specific limits
-		// according to OSM API usage policies
-		limiters := make(map[string]*rate.Limiter)
+// NewRateLimiter creates a registry with the default limits for every service
+func NewRateLimiter() *RateLimiter {
+	limiters := make(map[string]*rate.Limiter)
+	for _, service := range Services() {
+		limiters[service] = rate.NewLimiter(rate.Limit(DefaultRPS), DefaultBurst)
+	}
+	return &RateLimiter{
+		limiters:    limiters,
+		pausedUntil: make(map[string]time.Time),
+	}
+}
 
-		// Nominatim: 1 request per second
-		// https://operations.osmfoundation.org/policies/nominatim/
-		limiters[ServiceNominatim] = rate.NewLimiter(rate.Every(1*time.Second), 1)
+// GetRateLimiter returns the rate limiter registry of the default client
+func GetRateLimiter() *RateLimiter {
+	return defaultClient.limiter
+}
 
-		// Overpass: 2 requests per minute with bursts of up to 2 requests
-		// https://wiki.openstreetmap.org/wiki/Overpass_API#Public_Overpass_API_instances
-		limiters[ServiceOverpass] = rate.NewLimiter(rate.Every(30*time.Second), 2)
+// limiter returns the limiter of a service
+func (rl *RateLimiter) limiter(service string) (*rate.Limiter, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
 
-		// OSRM: 100 requests per minute (to be safe and avoid abuse)
-		limiters[ServiceOSRM] = rate.NewLimiter(rate.Every(600*time.Millisecond), 5)
+	limiter, exists := rl.limiters[service]
+	if !exists {
+		return nil, fmt.Errorf("no rate limiter defined for service: %s", service)
+	}
+	return limiter, nil
+}
 
-		globalRateLimiter = &RateLimiter{
-			limiters: limiters,
-		}
-	})
+// SetLimit changes the rate and burst size of a service. Requests already
+// waiting are rescheduled under the new limit.
+func (rl *RateLimiter) SetLimit(service string, rps float64, burst int) error {
+	if rps <= 0 {
+		return fmt.Errorf("invalid %s rate limit: rps must be positive, got %g", service, rps)
+	}
+	if burst < 1 {
+		return fmt.Errorf("invalid %s rate limit: burst must be at least 1, got %d", service, burst)
+	}
 
-	return globalRateLimiter
+	limiter, err := rl.limiter(service)
+	if err != nil {
+		return err
+	}
+	limiter.SetLimit(rate.Limit(rps))
+	limiter.SetBurst(burst)
+	return nil
 }
 
 // Wait blocks until the rate limit for the specified service allows an event
 // or the context is canceled.
 func (rl *RateLimiter) Wait(ctx context.Context, service string) error {
-	rl.mu.RLock()
-	limiter, exists := rl.limiters[service]
-	rl.mu.RUnlock()
+	limiter, err := rl.limiter(service)
+	if err != nil {
+		return err
+	}
 
-	if !exists {
-		return fmt.Errorf("no rate limiter defined for service: %s", service)
+	if err := rl.waitPause(ctx, service); err != nil {
+		return err
 	}
 
 	// Wait for rate limiter or context cancellation
-	err := limiter.Wait(ctx)
-	if err != nil {
+	if err := limiter.Wait(ctx); err != nil {
 		slog.Debug("rate limiter wait error", "service", service, "error", err)
-		return err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The limiter refuses early when the wait would outlast the deadline
+		return waitTooLong(fmt.Sprintf("%s rate limit wait exceeds the deadline", service))
 	}
 
 	return nil
 }
 
+// Pause holds back all requests to a service for d, for example after
+// the service answered with 429 Too Many Requests. Overlapping pauses
+// keep the later end time.
+func (rl *RateLimiter) Pause(service string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	until := time.Now().Add(d)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if until.After(rl.pausedUntil[service]) {
+		rl.pausedUntil[service] = until
+		slog.Info("pausing requests to rate limited service", "service", service, "duration", d)
+	}
+}
+
+// waitPause blocks until a pause set by Pause has ended
+func (rl *RateLimiter) waitPause(ctx context.Context, service string) error {
+	rl.mu.RLock()
+	until := rl.pausedUntil[service]
+	rl.mu.RUnlock()
+
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+		return waitTooLong(fmt.Sprintf("%s is paused for %s", service, d.Round(time.Second)))
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// State returns the current limits and available tokens of every service
+func (rl *RateLimiter) State() []LimiterState {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := time.Now()
+	states := make([]LimiterState, 0, len(rl.limiters))
+	for service, limiter := range rl.limiters {
+		state := LimiterState{
+			Service: service,
+			RPS:     float64(limiter.Limit()),
+			Burst:   limiter.Burst(),
+			Tokens:  limiter.Tokens(),
+		}
+		if until, ok := rl.pausedUntil[service]; ok && until.After(now) {
+			state.PausedUntil = &until
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Service < states[j].Service
+	})
+	return states
+}
+
+// WaitTooLongError is returned when waiting for the rate limiter or an
+// Overpass slot would outlast the context deadline. It matches
+// context.DeadlineExceeded, but unlike a timeout it says nothing about
+// the health of the upstream.
+type WaitTooLongError struct {
+	msg string
+}
+
+// waitTooLong creates a WaitTooLongError
+func waitTooLong(msg string) error {
+	return &WaitTooLongError{msg: msg}
+}
+
+// Error implements the error interface
+func (e *WaitTooLongError) Error() string {
+	return e.msg
+}
+
+// Is makes the error match context.DeadlineExceeded
+func (e *WaitTooLongError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
 // WaitForService is a convenience function to wait for a service's rate limit
-// using the global rate limiter
+// using the rate limiter of the client carried by ctx
 func WaitForService(ctx context.Context, service string) error {
-	return GetRateLimiter().Wait(ctx, service)
+	return FromContext(ctx).limiter.Wait(ctx, service)
+}
+
+// rateLimitTransport waits for the rate limiter of the service a request
+// is addressed to before sending it, and reports the wait and the request
+// to the client's observer. Requests to unknown hosts pass through
+// unlimited.
+type rateLimitTransport struct {
+	client  *Client
+	limiter *RateLimiter
+	base    http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := t.client.ServiceForURL(req.URL)
+	if service == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	observer := t.client.Observer()
+	start := time.Now()
+	_, span := startWaitSpan(req.Context(), "rate limit wait", service)
+	err := t.limiter.Wait(req.Context(), service)
+	endSpan(span, err)
+	if err != nil {
+		// RoundTrippers must close the body even when they fail
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	sent := time.Now()
+	observer.ObserveRateLimitWait(service, sent.Sub(start))
+
+	resp, err := t.base.RoundTrip(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	observer.ObserveRequest(service, status, time.Since(sent))
+	return resp, err
 }
@@ -0,0 +1,107 @@
+package osm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSetLimit(t *testing.T) {
+	rl := NewRateLimiter()
+
+	if err := rl.SetLimit(ServiceOSRM, 5, 3); err != nil {
+		t.Fatalf("SetLimit() error = %v", err)
+	}
+	for _, bad := range []struct {
+		service string
+		rps     float64
+		burst   int
+	}{
+		{ServiceOSRM, 0, 1},
+		{ServiceOSRM, 1, 0},
+		{"tiles", 1, 1},
+	} {
+		if err := rl.SetLimit(bad.service, bad.rps, bad.burst); err == nil {
+			t.Errorf("SetLimit(%s, %g, %d) succeeded, want error", bad.service, bad.rps, bad.burst)
+		}
+	}
+
+	states := rl.State()
+	if len(states) != len(Services()) {
+		t.Fatalf("State() returned %d services, want %d", len(states), len(Services()))
+	}
+	for _, s := range states {
+		if s.Service == ServiceOSRM && (s.RPS != 5 || s.Burst != 3) {
+			t.Errorf("osrm state = %+v, want rps 5 burst 3", s)
+		}
+		if s.Service == ServiceNominatim && (s.RPS != DefaultRPS || s.Burst != DefaultBurst) {
+			t.Errorf("nominatim state = %+v, want defaults", s)
+		}
+	}
+}
+
+func TestRateLimiterWaitDeadline(t *testing.T) {
+	rl := NewRateLimiter()
+	if err := rl.SetLimit(ServiceNominatim, 0.01, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rl.Wait(context.Background(), ServiceNominatim); err != nil {
+		t.Fatalf("first Wait() error = %v", err)
+	}
+
+	// The next token is 100s away, far beyond the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx, ServiceNominatim); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	restoreEndpoints(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	if err := SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+
+	rl := NewRateLimiter()
+	if err := rl.SetLimit(ServiceOverpass, 0.01, 1); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &rateLimitTransport{client: Default(), limiter: rl, base: http.DefaultTransport}}
+
+	get := func(ctx context.Context, path string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// The first request spends the only token
+	if err := get(context.Background(), "/api/interpreter"); err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := get(ctx, "/api/status"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second request error = %v, want rate limit deadline", err)
+	}
+
+	// Paths outside any service are not limited
+	if err := get(context.Background(), "/elsewhere"); err != nil {
+		t.Errorf("unlimited request failed: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RequestInfo collects what happened to the upstream requests made on
+// behalf of one tool call, so that the tool can report it. The HTTP
+// layers of this package record into the RequestInfo found in the
+// request context, if any.
+type RequestInfo struct {
+	mu sync.Mutex
+
+	// requests is the number of upstream requests sent
+	requests int
+
+	// cacheHits is the number of requests answered by the response cache
+	cacheHits int
+
+	// queueWait is the total time spent waiting for an Overpass slot
+	queueWait time.Duration
+
+	// expectedWait is the latest wait the Overpass scheduler announced
+	expectedWait time.Duration
+
+	// mirrors maps each service to the mirror that last answered
+	mirrors map[string]string
+}
+
+// requestInfoKey is the context key of the RequestInfo
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a context that records upstream request details
+// into a new RequestInfo
+func WithRequestInfo(ctx context.Context) (context.Context, *RequestInfo) {
+	info := &RequestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+// RequestInfoFromContext returns the RequestInfo of a context, or nil
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// updateRequestInfo applies fn to the RequestInfo of ctx, if there is one
+func updateRequestInfo(ctx context.Context, fn func(info *RequestInfo)) {
+	info := RequestInfoFromContext(ctx)
+	if info == nil {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	fn(info)
+}
+
+// setMirror records the mirror that answered a request to service.
+// The caller must hold i.mu.
+func (i *RequestInfo) setMirror(service, baseURL string) {
+	if i.mirrors == nil {
+		i.mirrors = make(map[string]string)
+	}
+	i.mirrors[service] = redactURL(baseURL)
+}
+
+// Mirror returns the base URL of the mirror that last answered a request
+// to service, or an empty string
+func (i *RequestInfo) Mirror(service string) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.mirrors[service]
+}
+
+// Requests returns the number of upstream requests sent so far
+func (i *RequestInfo) Requests() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.requests
+}
+
+// CacheHits returns the number of requests answered by the response cache
+func (i *RequestInfo) CacheHits() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.cacheHits
+}
+
+// Snapshot returns the recorded details as a map suitable for the _meta
+// field of a tool result. Durations are reported in seconds.
+func (i *RequestInfo) Snapshot() map[string]interface{} {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	out := map[string]interface{}{
+		"requests": i.requests,
+	}
+	if i.cacheHits > 0 {
+		out["cache_hits"] = i.cacheHits
+	}
+	if i.queueWait > 0 {
+		out["queue_wait_seconds"] = i.queueWait.Seconds()
+	}
+	if i.expectedWait > 0 {
+		out["expected_wait_seconds"] = i.expectedWait.Seconds()
+	}
+	if len(i.mirrors) > 0 {
+		mirrors := make(map[string]string, len(i.mirrors))
+		for service, baseURL := range i.mirrors {
+			mirrors[service] = baseURL
+		}
+		out["mirrors"] = mirrors
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+)
+
+// maxCachedResponse is the largest response body kept in the response cache
+const maxCachedResponse = 8 << 20
+
+// DefaultCacheTTLs are the response cache lifetimes per service. Addresses
+// rarely change, map features change now and then, and routes are cheap
+// to recompute.
+var DefaultCacheTTLs = map[string]time.Duration{
+	ServiceNominatim: 24 * time.Hour,
+	ServiceOverpass:  time.Hour,
+	ServiceOSRM:      15 * time.Minute,
+}
+
+// copyTTLs returns a copy of a TTL map
+func copyTTLs(ttls map[string]time.Duration) map[string]time.Duration {
+	out := make(map[string]time.Duration, len(ttls))
+	for k, v := range ttls {
+		out[k] = v
+	}
+	return out
+}
+
+// SetResponseCache sets the response cache backend of the default client
+func SetResponseCache(backend cache.Backend) cache.Backend {
+	return defaultClient.SetResponseCache(backend)
+}
+
+// SetResponseCache sets the backend in which DoRequest keeps successful
+// upstream responses. A nil backend disables the response cache. The
+// previous backend is returned so the caller can close it.
+func (c *Client) SetResponseCache(backend cache.Backend) cache.Backend {
+	c.responseCacheLock.Lock()
+	defer c.responseCacheLock.Unlock()
+
+	prev := c.responseCache
+	c.responseCache = backend
+	return prev
+}
+
+// SetCacheTTL sets how long responses of a service are cached by the
+// default client
+func SetCacheTTL(service string, ttl time.Duration) error {
+	return defaultClient.SetCacheTTL(service, ttl)
+}
+
+// SetCacheTTL sets how long responses of a service are cached. A TTL of
+// 0 stops caching the service.
+func (c *Client) SetCacheTTL(service string, ttl time.Duration) error {
+	if !isKnownService(service) {
+		return fmt.Errorf("unknown service: %s", service)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("invalid cache TTL for %s: must not be negative", service)
+	}
+
+	c.responseCacheLock.Lock()
+	defer c.responseCacheLock.Unlock()
+	c.cacheTTLs[service] = ttl
+	return nil
+}
+
+// CacheTTL returns how long responses of a service are cached by the
+// default client
+func CacheTTL(service string) time.Duration {
+	return defaultClient.CacheTTL(service)
+}
+
+// CacheTTL returns how long responses of a service are cached
+func (c *Client) CacheTTL(service string) time.Duration {
+	c.responseCacheLock.RLock()
+	defer c.responseCacheLock.RUnlock()
+	return c.cacheTTLs[service]
+}
+
+// isKnownService reports whether name is an upstream service
+func isKnownService(name string) bool {
+	for _, service := range Services() {
+		if service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCacheFor returns the backend, key and TTL to cache req with, or
+// false if req is not cached. Only requests that read data are cached:
+// GETs and Overpass queries, whose body becomes part of the key.
+func (c *Client) responseCacheFor(req *http.Request) (cache.Backend, string, time.Duration, bool) {
+	c.responseCacheLock.RLock()
+	backend := c.responseCache
+	service := c.ServiceForURL(req.URL)
+	ttl := c.cacheTTLs[service]
+	c.responseCacheLock.RUnlock()
+
+	if backend == nil || service == "" || ttl <= 0 {
+		return nil, "", 0, false
+	}
+
+	key := service + " " + req.Method + " " + req.URL.String()
+	switch {
+	case req.Method == "" || req.Method == http.MethodGet:
+	case req.Method == http.MethodPost && c.isOverpassQuery(req) && req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, "", 0, false
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return nil, "", 0, false
+		}
+		key += " " + hex.EncodeToString(h.Sum(nil))
+	default:
+		return nil, "", 0, false
+	}
+	return backend, key, ttl, true
+}
+
+// cachedResponse builds the response served for a cache hit
+func cachedResponse(req *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Cache": {"HIT"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// storeResponse reads a successful response into the cache and returns it
+// with a body that replays what was read. Bodies that are too large or
+// fail to read are passed through uncached.
+func storeResponse(backend cache.Backend, key string, ttl time.Duration, resp *http.Response) *http.Response {
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedResponse+1))
+	if err != nil || len(buf) > maxCachedResponse {
+		resp.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(buf), resp.Body), Closer: resp.Body}
+		return resp
+	}
+
+	resp.Body.Close()
+	backend.Set(key, buf, ttl)
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+	resp.ContentLength = int64(len(buf))
+	return resp
+}
+
+// replayBody reads from Reader but closes the original body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
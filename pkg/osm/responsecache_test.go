@@ -0,0 +1,79 @@
+package osm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+)
+
+func TestDoRequestUsesResponseCache(t *testing.T) {
+	restoreEndpoints(t)
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/interpreter" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("result for " + string(body)))
+	}))
+	defer ts.Close()
+
+	if err := SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := cache.NewDiskBackend(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetResponseCache(backend)
+	t.Cleanup(func() { SetResponseCache(nil) })
+
+	query := func(q string) (string, *RequestInfo) {
+		ctx, info := WithRequestInfo(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/api/interpreter", strings.NewReader(q))
+		resp, err := DoRequest(ctx, req)
+		if err != nil {
+			t.Fatalf("DoRequest() error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), info
+	}
+
+	if body, _ := query("data=a"); body != "result for data=a" {
+		t.Fatalf("first response = %q", body)
+	}
+	body, info := query("data=a")
+	if body != "result for data=a" || info.CacheHits() != 1 || info.Requests() != 0 {
+		t.Errorf("second response = %q with %d hits and %d requests, want a cache hit", body, info.CacheHits(), info.Requests())
+	}
+	if body, _ := query("data=b"); body != "result for data=b" {
+		t.Errorf("different query served %q, want its own result", body)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("upstream called %d times, want 2", n)
+	}
+}
+
+func TestSetCacheTTL(t *testing.T) {
+	t.Cleanup(func() { SetCacheTTL(ServiceOSRM, DefaultCacheTTLs[ServiceOSRM]) })
+
+	if err := SetCacheTTL(ServiceOSRM, 0); err != nil || CacheTTL(ServiceOSRM) != 0 {
+		t.Errorf("SetCacheTTL(0) = %v, TTL %v", err, CacheTTL(ServiceOSRM))
+	}
+	if err := SetCacheTTL("tiles", 0); err == nil {
+		t.Error("SetCacheTTL() accepted an unknown service")
+	}
+	if err := SetCacheTTL(ServiceOSRM, -1); err == nil {
+		t.Error("SetCacheTTL() accepted a negative TTL")
+	}
+}
@@ -0,0 +1,297 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RetryPolicy decides whether and when a failed upstream request is sent again
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry; it doubles with
+	// every further retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxRetryAfter is the longest Retry-After delay the policy waits out.
+	// Longer delays fail the request at once, but still pause the limiter.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by DoRequest unless a
+// client sets its own
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	MaxRetryAfter:  30 * time.Second,
+}
+
+// maxLimiterPause bounds how long a Retry-After header may pause a service
+const maxLimiterPause = 5 * time.Minute
+
+// RequestError is returned when an upstream request fails for good,
+// either because of a transport error or an error status that remained
+// after all retries
+type RequestError struct {
+	Service    string
+	StatusCode int           // Last HTTP status, 0 for transport errors
+	Attempts   int           // Number of attempts made
+	RetryAfter time.Duration // Delay requested by the upstream, if any
+	Err        error         // Underlying transport error, if any
+}
+
+// Error implements the error interface
+func (e *RequestError) Error() string {
+	name := e.Service
+	if name == "" {
+		name = "upstream"
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s request failed after %d attempt(s): %v", name, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s request failed after %d attempt(s): HTTP status %d", name, e.Attempts, e.StatusCode)
+}
+
+// Unwrap returns the underlying transport error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Do sends req with client, retrying transient failures of idempotent
+// requests. When the service has several mirrors, retries go to the next
+// healthy mirror without waiting, and every result feeds the mirror's
+// circuit breaker. Responses with a 429 or 503 status from a service with
+// a single mirror pause the service's rate limiter for the Retry-After
+// delay, or the backoff delay if the header is missing, so concurrent
+// requests back off too. Responses that are not retried are returned
+// unchanged, whatever their status; a *RequestError is returned when the
+// retries are exhausted or the request cannot be sent. Services, mirrors
+// and limiters are those of the default client.
+func (p RetryPolicy) Do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	return defaultClient.do(ctx, p, client, req)
+}
+
+// SetRetryPolicy sets the retry policy of the client's DoRequest
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retryPolicyLock.Lock()
+	defer c.retryPolicyLock.Unlock()
+	c.retryPolicy = &p
+}
+
+// RetryPolicy returns the retry policy of the client, which is
+// DefaultRetryPolicy unless SetRetryPolicy was called
+func (c *Client) RetryPolicy() RetryPolicy {
+	c.retryPolicyLock.RLock()
+	defer c.retryPolicyLock.RUnlock()
+	if c.retryPolicy != nil {
+		return *c.retryPolicy
+	}
+	return DefaultRetryPolicy
+}
+
+// do sends req with client under policy p, as described for RetryPolicy.Do,
+// using the services, mirrors and limiters of c
+func (c *Client) do(ctx context.Context, p RetryPolicy, client *http.Client, req *http.Request) (*http.Response, error) {
+	match, matched := c.matchURL(req.URL)
+	service := match.service
+	failover := matched && len(match.mirrors) > 1
+	logger := c.logger.With("service", service, "url", req.URL.Redacted())
+
+	retryable := c.isIdempotent(req) && (req.Body == nil || req.GetBody != nil)
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 || !retryable {
+		maxAttempts = 1
+	}
+
+	tried := make(map[string]bool)
+	delay := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		target := match.mirror.BaseURL
+		if failover {
+			target = c.pickMirror(match, tried)
+		}
+		tried[target] = true
+
+		if target != match.mirror.BaseURL {
+			u, err := rewriteURL(req.URL, service, match.prefix, target)
+			if err != nil {
+				return nil, &RequestError{Service: service, Attempts: attempt - 1, Err: err}
+			}
+			attemptReq = attemptReq.Clone(ctx)
+			attemptReq.URL = u
+			attemptReq.Host = ""
+		}
+
+		// A consumed body must be replaced before the request is sent again
+		if attempt > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, &RequestError{Service: service, Attempts: attempt - 1, Err: err}
+			}
+			if attemptReq == req {
+				attemptReq = attemptReq.Clone(ctx)
+			}
+			attemptReq.Body = body
+		}
+
+		updateRequestInfo(ctx, func(info *RequestInfo) {
+			info.requests++
+		})
+		attemptCtx, span := startAttemptSpan(ctx, service, attemptReq, attempt)
+		if matched {
+			span.SetAttributes(attribute.String("upstream.mirror", redactURL(target)))
+		}
+		resp, err := client.Do(attemptReq.WithContext(attemptCtx))
+		endAttemptSpan(span, resp, err)
+		if err != nil {
+			var waitErr *WaitTooLongError
+			if ctx.Err() != nil || errors.As(err, &waitErr) || errors.Is(err, ErrNoFixture) {
+				// The caller gave up, or the request was never recorded;
+				// that says nothing about the mirror
+				if matched {
+					c.releaseMirror(target)
+				}
+				return nil, &RequestError{Service: service, Attempts: attempt, Err: err}
+			}
+			if matched {
+				c.recordMirrorResult(service, target, true, err.Error(), 0)
+			}
+
+			// A timed out mirror is only worth retrying somewhere else
+			timedOut := errors.Is(err, context.DeadlineExceeded)
+			if attempt >= maxAttempts || timedOut && !c.hasUntriedMirror(match, tried) {
+				return nil, &RequestError{Service: service, Attempts: attempt, Err: err}
+			}
+			logger.Info("retrying request", "attempt", attempt+1, "max_attempts", maxAttempts, "delay", delay, "error", err)
+			c.Observer().ObserveRetry(service)
+		} else {
+			retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			overloaded := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+
+			if matched {
+				var breakerDelay time.Duration
+				if overloaded && hasRetryAfter {
+					breakerDelay = retryAfter
+				}
+				c.recordMirrorResult(service, target, isMirrorFailure(resp.StatusCode), resp.Status, breakerDelay)
+			}
+
+			if !isRetryableStatus(resp.StatusCode) {
+				if matched {
+					updateRequestInfo(ctx, func(info *RequestInfo) {
+						info.setMirror(service, target)
+					})
+				}
+				return resp, nil
+			}
+			drainAndClose(resp)
+
+			wait := delay
+			if hasRetryAfter {
+				wait = retryAfter
+			}
+
+			// Hold back every request to the service, not only this one.
+			// With mirrors the breaker takes the overloaded one out instead.
+			if service != "" && overloaded && !failover {
+				c.limiter.Pause(service, min(wait, maxLimiterPause))
+			}
+
+			if attempt >= maxAttempts || (hasRetryAfter && retryAfter > p.MaxRetryAfter && !c.hasUntriedMirror(match, tried)) {
+				return nil, &RequestError{
+					Service:    service,
+					StatusCode: resp.StatusCode,
+					Attempts:   attempt,
+					RetryAfter: retryAfter,
+				}
+			}
+
+			logger.Info("retrying request", "attempt", attempt+1, "max_attempts", maxAttempts, "delay", wait, "status", resp.StatusCode)
+			c.Observer().ObserveRetry(service)
+			delay = wait
+		}
+
+		// Another mirror can be tried at once
+		if c.hasUntriedMirror(match, tried) {
+			continue
+		}
+
+		// Give up early if the context expires before the retry is due
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, &RequestError{Service: service, Attempts: attempt, Err: context.DeadlineExceeded}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &RequestError{Service: service, Attempts: attempt, Err: ctx.Err()}
+		}
+
+		delay = min(2*delay, p.MaxBackoff)
+	}
+}
+
+// isIdempotent reports whether a request may safely be sent twice.
+// Overpass queries are posted but only read data. Following net/http,
+// an Idempotency-Key header marks other requests as safe too.
+func (c *Client) isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if c.ServiceForURL(req.URL) == ServiceOverpass {
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	_, hasXKey := req.Header["X-Idempotency-Key"]
+	return hasKey || hasXKey
+}
+
+// isRetryableStatus reports whether a status indicates a transient failure
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// drainAndClose discards a bounded amount of the body so the connection
+// can be reused, then closes it
+func drainAndClose(resp *http.Response) {
+	io.CopyN(io.Discard, resp.Body, 64<<10)
+	resp.Body.Close()
+}
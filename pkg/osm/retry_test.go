@@ -0,0 +1,200 @@
+package osm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPolicy retries quickly so tests do not sleep for long
+var testPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	MaxRetryAfter:  2 * time.Second,
+}
+
+// statusServer answers with the given statuses in turn, then 200
+func statusServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if int(n) <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestRetryPolicyRetriesTransientStatus(t *testing.T) {
+	ts, calls := statusServer(t, "", http.StatusServiceUnavailable, http.StatusBadGateway)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	resp, err := testPolicy.Do(context.Background(), ts.Client(), req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || *calls != 3 {
+		t.Errorf("status %d after %d calls, want 200 after 3", resp.StatusCode, *calls)
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	ts, calls := statusServer(t, "1", http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	policy := testPolicy
+	policy.MaxAttempts = 2
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	_, err := policy.Do(context.Background(), ts.Client(), req)
+
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("Do() error = %v, want *RequestError", err)
+	}
+	if reqErr.Attempts != 2 || reqErr.StatusCode != http.StatusTooManyRequests || reqErr.RetryAfter != time.Second {
+		t.Errorf("RequestError = %+v, want 2 attempts, status 429, retry after 1s", reqErr)
+	}
+	if *calls != 2 {
+		t.Errorf("server saw %d calls, want 2", *calls)
+	}
+}
+
+func TestRetryPolicyDoesNotRetry(t *testing.T) {
+	t.Run("non-idempotent request", func(t *testing.T) {
+		ts, calls := statusServer(t, "", http.StatusServiceUnavailable)
+
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("x"))
+		_, err := testPolicy.Do(context.Background(), ts.Client(), req)
+		if err == nil || *calls != 1 {
+			t.Errorf("Do() error = %v after %d calls, want failure after 1", err, *calls)
+		}
+	})
+
+	t.Run("permanent status", func(t *testing.T) {
+		ts, calls := statusServer(t, "", http.StatusBadRequest)
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := testPolicy.Do(context.Background(), ts.Client(), req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || *calls != 1 {
+			t.Errorf("status %d after %d calls, want 400 after 1", resp.StatusCode, *calls)
+		}
+	})
+
+	t.Run("retry after beyond limit", func(t *testing.T) {
+		ts, calls := statusServer(t, "3600", http.StatusTooManyRequests)
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		_, err := testPolicy.Do(context.Background(), ts.Client(), req)
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) || reqErr.RetryAfter != time.Hour || *calls != 1 {
+			t.Errorf("Do() error = %v after %d calls, want retry after 1h after 1 call", err, *calls)
+		}
+	})
+}
+
+func TestRetryPolicyReplaysBody(t *testing.T) {
+	restoreEndpoints(t)
+
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer ts.Close()
+
+	// Overpass queries are posted but safe to repeat
+	if err := SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/interpreter", strings.NewReader("data=query"))
+	resp, err := testPolicy.Do(context.Background(), ts.Client(), req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if len(bodies) != 2 || bodies[1] != "data=query" {
+		t.Errorf("server received bodies %q, want the query twice", bodies)
+	}
+}
+
+func TestRetryPolicyPausesLimiter(t *testing.T) {
+	restoreEndpoints(t)
+
+	ts, _ := statusServer(t, "1", http.StatusTooManyRequests)
+	if err := SetBaseURL(ServiceOSRM, ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		GetRateLimiter().mu.Lock()
+		delete(GetRateLimiter().pausedUntil, ServiceOSRM)
+		GetRateLimiter().mu.Unlock()
+	})
+
+	policy := testPolicy
+	policy.MaxAttempts = 1
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/route", nil)
+	if _, err := policy.Do(context.Background(), ts.Client(), req); err == nil {
+		t.Fatal("Do() succeeded, want 429 error")
+	}
+
+	for _, state := range GetRateLimiter().State() {
+		if state.Service == ServiceOSRM && state.PausedUntil == nil {
+			t.Error("OSRM limiter was not paused after a 429 response")
+		}
+	}
+
+	// A waiter whose deadline ends before the pause fails at once
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := WaitForService(ctx, ServiceOSRM); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForService() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
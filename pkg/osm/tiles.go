@@ -0,0 +1,402 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+)
+
+const (
+	// TileSize is the edge length of a cache tile in degrees, about 2 km
+	// north to south
+	TileSize = 0.02
+
+	// maxTilesPerQuery bounds the area served from tiles; larger queries
+	// go to Overpass directly and are not split into tiles
+	maxTilesPerQuery = 256
+
+	// tileCacheSize is the number of tiles kept in memory
+	tileCacheSize = 4096
+
+	// tileFetchTimeout bounds a tile query, which runs on for the other
+	// callers waiting for it when the caller that started it gives up
+	tileFetchTimeout = 2 * time.Minute
+)
+
+// OverpassElement is a node, way or relation returned by Overpass. Ways
+// and relations carry the coordinates of their center.
+type OverpassElement struct {
+	Type string            `json:"type"`
+	ID   int64             `json:"id"`
+	Lat  float64           `json:"lat,omitempty"`
+	Lon  float64           `json:"lon,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ElementFilter selects the elements a tile query fetches. Elements of
+// any of the types match if, for any key in Tags, their value is one of
+// the listed values; an empty value list only requires the key.
+type ElementFilter struct {
+	Types []string            // "node", "way" and/or "relation"
+	Tags  map[string][]string // key to accepted values
+}
+
+// ErrInvalidFilter is returned when an ElementFilter cannot be turned
+// into an Overpass query
+var ErrInvalidFilter = errors.New("invalid element filter")
+
+// validTag reports whether s can be a tag key or value in a query:
+// non-empty UTF-8 text without control characters. Anything else is
+// escaped by qlString.
+func validTag(s string) bool {
+	return s != "" && utf8.ValidString(s) && !strings.ContainsFunc(s, unicode.IsControl)
+}
+
+// qlString quotes s as an Overpass QL string literal
+func qlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// statements returns one Overpass statement per type and key for the
+// area given by bounds, in a stable order
+func (f ElementFilter) statements(bounds string) ([]string, error) {
+	keys := make([]string, 0, len(f.Tags))
+	for key := range f.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var stmts []string
+	for _, typ := range f.Types {
+		if typ != "node" && typ != "way" && typ != "relation" {
+			return nil, fmt.Errorf("%w: unsupported element type %q", ErrInvalidFilter, typ)
+		}
+		for _, key := range keys {
+			if !validTag(key) {
+				return nil, fmt.Errorf("%w: invalid tag key %q", ErrInvalidFilter, key)
+			}
+			values := append([]string(nil), f.Tags[key]...)
+			sort.Strings(values)
+			patterns := make([]string, len(values))
+			for i, v := range values {
+				if !validTag(v) {
+					return nil, fmt.Errorf("%w: invalid tag value %q", ErrInvalidFilter, v)
+				}
+				patterns[i] = regexp.QuoteMeta(v)
+			}
+
+			selector := "[" + qlString(key) + "]"
+			if len(values) > 0 {
+				selector = "[" + qlString(key) + "~" + qlString("^("+strings.Join(patterns, "|")+")$") + "]"
+			}
+			stmts = append(stmts, typ+selector+bounds+";")
+		}
+	}
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("%w: filter selects nothing", ErrInvalidFilter)
+	}
+	return stmts, nil
+}
+
+// key identifies the filter in the tile cache
+func (f ElementFilter) key() (string, error) {
+	stmts, err := f.statements("")
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(stmts, ""), nil
+}
+
+// tile identifies one cell of the tile grid
+type tile struct {
+	x, y int
+}
+
+// tileOf returns the tile containing a point
+func tileOf(lat, lon float64) tile {
+	return tile{x: int(math.Floor(lon / TileSize)), y: int(math.Floor(lat / TileSize))}
+}
+
+// bounds returns the bounding box of the tile
+func (t tile) bounds() geo.BoundingBox {
+	return geo.BoundingBox{
+		MinLat: float64(t.y) * TileSize,
+		MinLon: float64(t.x) * TileSize,
+		MaxLat: float64(t.y+1) * TileSize,
+		MaxLon: float64(t.x+1) * TileSize,
+	}
+}
+
+// tilesCovering returns the tiles that cover a bounding box, or false if
+// there are more than maxTilesPerQuery
+func tilesCovering(box geo.BoundingBox) ([]tile, bool) {
+	lo := tileOf(box.MinLat, box.MinLon)
+	hi := tileOf(box.MaxLat, box.MaxLon)
+	if (hi.x-lo.x+1)*(hi.y-lo.y+1) > maxTilesPerQuery {
+		return nil, false
+	}
+
+	var tiles []tile
+	for y := lo.y; y <= hi.y; y++ {
+		for x := lo.x; x <= hi.x; x++ {
+			tiles = append(tiles, tile{x: x, y: y})
+		}
+	}
+	return tiles, true
+}
+
+// tileKey returns the cache key of a tile for a filter
+func tileKey(filterKey string, t tile) string {
+	return fmt.Sprintf("%s@%d,%d", filterKey, t.x, t.y)
+}
+
+// QueryElementsAround queries the client carried by ctx, or the default
+// client, as Client.QueryElementsAround
+func QueryElementsAround(ctx context.Context, filter ElementFilter, lat, lon, radius float64) ([]OverpassElement, error) {
+	return FromContext(ctx).QueryElementsAround(ctx, filter, lat, lon, radius)
+}
+
+// QueryElementsInBBox queries the client carried by ctx, or the default
+// client, as Client.QueryElementsInBBox
+func QueryElementsInBBox(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error) {
+	return FromContext(ctx).QueryElementsInBBox(ctx, filter, box)
+}
+
+// QueryElementsAround returns the elements matching filter within radius
+// meters of a point. The answer is assembled from cached tiles, and only
+// tiles missing from the cache are fetched from Overpass, in one query.
+func (c *Client) QueryElementsAround(ctx context.Context, filter ElementFilter, lat, lon, radius float64) ([]OverpassElement, error) {
+	elements, err := c.queryElements(ctx, filter, circleBounds(lat, lon, radius))
+	if err != nil {
+		return nil, err
+	}
+
+	inside := elements[:0]
+	for _, e := range elements {
+		if HaversineDistance(lat, lon, e.Lat, e.Lon) <= radius {
+			inside = append(inside, e)
+		}
+	}
+	return inside, nil
+}
+
+// QueryElementsInBBox returns the elements matching filter inside a
+// bounding box, assembled from cached tiles like QueryElementsAround
+func (c *Client) QueryElementsInBBox(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error) {
+	elements, err := c.queryElements(ctx, filter, box)
+	if err != nil {
+		return nil, err
+	}
+
+	inside := elements[:0]
+	for _, e := range elements {
+		if e.Lat >= box.MinLat && e.Lat <= box.MaxLat && e.Lon >= box.MinLon && e.Lon <= box.MaxLon {
+			inside = append(inside, e)
+		}
+	}
+	return inside, nil
+}
+
+// circleBounds returns the bounding box of a circle. Unlike
+// geo.BoundingBox.Buffer it widens longitudes away from the equator, so
+// no part of the circle is left out.
+func circleBounds(lat, lon, radius float64) geo.BoundingBox {
+	const metersPerDegree = EarthRadius * math.Pi / 180
+	dLat := radius / metersPerDegree
+	dLon := 180.0
+	if cos := math.Cos(lat * math.Pi / 180); cos > 1e-6 {
+		dLon = min(radius/(metersPerDegree*cos), 180)
+	}
+	return geo.BoundingBox{
+		MinLat: max(lat-dLat, -90),
+		MinLon: max(lon-dLon, -180),
+		MaxLat: min(lat+dLat, 90),
+		MaxLon: min(lon+dLon, 180),
+	}
+}
+
+// queryElements returns the elements of every tile covering box. The
+// caller trims them to the exact area.
+func (c *Client) queryElements(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error) {
+	filterKey, err := filter.key()
+	if err != nil {
+		return nil, err
+	}
+
+	if p := c.ElementProvider(); p != nil {
+		// Local data needs no tiling
+		elements, err := p.QueryElements(ctx, filter, box)
+		if err != nil {
+			return nil, err
+		}
+		return append([]OverpassElement(nil), elements...), nil
+	}
+
+	tiles, ok := tilesCovering(box)
+	if !ok {
+		// Too large to tile; ask for the area itself
+		return c.fetchElements(ctx, filter, box)
+	}
+
+	var elements []OverpassElement
+	var missing []tile
+	for _, t := range tiles {
+		if cached, found := c.tileCache.Get(tileKey(filterKey, t)); found {
+			elements = append(elements, cached...)
+		} else {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) == 0 {
+		return dedupeElements(elements), nil
+	}
+
+	fetched, err := c.fetchTiles(ctx, filter, filterKey, missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range missing {
+		elements = append(elements, fetched[t]...)
+	}
+	return dedupeElements(elements), nil
+}
+
+// fetchTiles fetches the tiles in one query over their joint bounding
+// box, splits the elements by tile and caches every tile, empty or not
+func (c *Client) fetchTiles(ctx context.Context, filter ElementFilter, filterKey string, tiles []tile) (map[tile][]OverpassElement, error) {
+	box := tiles[0].bounds()
+	for _, t := range tiles[1:] {
+		b := t.bounds()
+		box.MinLat = min(box.MinLat, b.MinLat)
+		box.MinLon = min(box.MinLon, b.MinLon)
+		box.MaxLat = max(box.MaxLat, b.MaxLat)
+		box.MaxLon = max(box.MaxLon, b.MaxLon)
+	}
+
+	flightKey := fmt.Sprintf("%s|%v", filterKey, tiles)
+	flight := c.tileGroup.DoChan(flightKey, func() (interface{}, error) {
+		// Callers joining the flight wait for this fetch too, so it must
+		// not end when the caller that started it gives up
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tileFetchTimeout)
+		defer cancel()
+
+		elements, err := c.fetchElements(fetchCtx, filter, box)
+		if err != nil {
+			return nil, err
+		}
+
+		byTile := make(map[tile][]OverpassElement, len(tiles))
+		for _, t := range tiles {
+			byTile[t] = nil
+		}
+		for _, e := range elements {
+			t := tileOf(e.Lat, e.Lon)
+			if _, ok := byTile[t]; ok {
+				byTile[t] = append(byTile[t], e)
+			}
+		}
+
+		ttl := c.CacheTTL(ServiceOverpass)
+		for t, tileElements := range byTile {
+			if ttl > 0 {
+				c.tileCache.SetWithTTL(tileKey(filterKey, t), tileElements, ttl)
+			}
+		}
+		return byTile, nil
+	})
+
+	select {
+	case res := <-flight:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[tile][]OverpassElement), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchElements runs a filter query over a bounding box
+func (c *Client) fetchElements(ctx context.Context, filter ElementFilter, box geo.BoundingBox) ([]OverpassElement, error) {
+	stmts, err := filter.statements(box.String())
+	if err != nil {
+		return nil, err
+	}
+	query := "[out:json];(" + strings.Join(stmts, "") + ");out center;"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL(ServiceOverpass), strings.NewReader("data="+url.QueryEscape(query)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &RequestError{Service: ServiceOverpass, StatusCode: resp.StatusCode, Attempts: 1}
+	}
+
+	var overpassResp struct {
+		Elements []struct {
+			OverpassElement
+			Center *struct {
+				Lat float64 `json:"lat"`
+				Lon float64 `json:"lon"`
+			} `json:"center,omitempty"`
+		} `json:"elements"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	elements := make([]OverpassElement, 0, len(overpassResp.Elements))
+	for _, raw := range overpassResp.Elements {
+		e := raw.OverpassElement
+		if raw.Center != nil {
+			e.Lat, e.Lon = raw.Center.Lat, raw.Center.Lon
+		}
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
+
+// dedupeElements drops repeated elements, which occur when a way's
+// center moved between tiles fetched at different times
+func dedupeElements(elements []OverpassElement) []OverpassElement {
+	seen := make(map[string]bool, len(elements))
+	out := make([]OverpassElement, 0, len(elements))
+	for _, e := range elements {
+		id := fmt.Sprintf("%s/%d", e.Type, e.ID)
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package osm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+)
+
+func TestElementFilterStatements(t *testing.T) {
+	filter := ElementFilter{
+		Types: []string{"node", "way"},
+		Tags:  map[string][]string{"shop": nil, "amenity": {"pub", "cafe"}},
+	}
+
+	stmts, err := filter.statements("(1,2,3,4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`node["amenity"~"^(cafe|pub)$"](1,2,3,4);`,
+		`node["shop"](1,2,3,4);`,
+		`way["amenity"~"^(cafe|pub)$"](1,2,3,4);`,
+		`way["shop"](1,2,3,4);`,
+	}
+	if strings.Join(stmts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements() = %q, want %q", stmts, want)
+	}
+
+	// Other text is escaped rather than rejected
+	for _, tt := range []struct {
+		filter ElementFilter
+		want   string
+	}{
+		{ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"cuisine": {"café"}}}, `node["cuisine"~"^(café)$"];`},
+		{ElementFilter{Types: []string{"node"}, Tags: map[string][]string{`amenity"]`: nil}}, `node["amenity\"]"];`},
+		{ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"amenity": {`pub)$"];`}}}, `node["amenity"~"^(pub\\)\\$\"\\];)$"];`},
+	} {
+		stmts, err := tt.filter.statements("")
+		if err != nil || len(stmts) != 1 || stmts[0] != tt.want {
+			t.Errorf("statements() for %+v = %q, %v, want %q", tt.filter, stmts, err, tt.want)
+		}
+	}
+
+	invalid := []ElementFilter{
+		{Types: []string{"area"}, Tags: map[string][]string{"amenity": nil}},
+		{Types: []string{"node"}, Tags: map[string][]string{"": nil}},
+		{Types: []string{"node"}, Tags: map[string][]string{"amenity": {"pub\n"}}},
+		{Types: []string{"node"}, Tags: map[string][]string{"amenity": {"\xff"}}},
+		{Types: []string{"node"}},
+	}
+	for _, f := range invalid {
+		if _, err := f.statements(""); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("statements() for %+v error = %v, want ErrInvalidFilter", f, err)
+		}
+	}
+}
+
+func TestCircleBounds(t *testing.T) {
+	box := circleBounds(60, 10, 1000)
+	latSpan := box.MaxLat - box.MinLat
+	lonSpan := box.MaxLon - box.MinLon
+	// At 60 degrees a degree of longitude is half as long as one of latitude
+	if lonSpan < 1.99*latSpan || lonSpan > 2.01*latSpan {
+		t.Errorf("circleBounds() spans %f by %f degrees, want twice as wide as high", latSpan, lonSpan)
+	}
+	if d := HaversineDistance(60, 10, 60, box.MaxLon); d < 999 {
+		t.Errorf("circleBounds() east edge is %f m away, want at least the radius", d)
+	}
+
+	polar := circleBounds(90, 0, 1000)
+	if polar.MinLon != -180 || polar.MaxLon != 180 || polar.MaxLat != 90 {
+		t.Errorf("circleBounds() at the pole = %+v, want all longitudes", polar)
+	}
+}
+
+func TestTilesCovering(t *testing.T) {
+	tiles, ok := tilesCovering(geo.BoundingBox{MinLat: 52.50, MinLon: 13.39, MaxLat: 52.53, MaxLon: 13.41})
+	if !ok || len(tiles) != 4 {
+		t.Errorf("tilesCovering() = %v, %v, want 4 tiles", tiles, ok)
+	}
+	for _, tl := range tiles {
+		b := tl.bounds()
+		if tileOf((b.MinLat+b.MaxLat)/2, (b.MinLon+b.MaxLon)/2) != tl {
+			t.Errorf("tile %v does not contain its own center", tl)
+		}
+	}
+
+	if _, ok := tilesCovering(geo.BoundingBox{MinLat: 50, MinLon: 10, MaxLat: 55, MaxLon: 15}); ok {
+		t.Error("tilesCovering() accepted an area beyond maxTilesPerQuery")
+	}
+}
+
+func TestQueryElementsAroundUsesTiles(t *testing.T) {
+	restoreEndpoints(t)
+	Default().tileCache.Clear()
+	t.Cleanup(Default().tileCache.Clear)
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/interpreter" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		if q := form.Get("data"); !strings.Contains(q, `node["amenity"~"^(cafe)$"]`) || !strings.Contains(q, "out center;") {
+			t.Errorf("unexpected query %q", q)
+		}
+		w.Write([]byte(`{"elements":[
+			{"type":"node","id":1,"lat":52.5200,"lon":13.4050,"tags":{"name":"Near"}},
+			{"type":"node","id":2,"lat":52.5290,"lon":13.4050,"tags":{"name":"Far"}},
+			{"type":"way","id":3,"center":{"lat":52.5205,"lon":13.4055},"tags":{"name":"Way"}}
+		]}`))
+	}))
+	defer ts.Close()
+
+	if err := SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := ElementFilter{Types: []string{"node", "way"}, Tags: map[string][]string{"amenity": {"cafe"}}}
+	elements, err := QueryElementsAround(context.Background(), filter, 52.5200, 13.4050, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 {
+		t.Fatalf("QueryElementsAround() returned %+v, want the node and the way within 200 m", elements)
+	}
+	for _, e := range elements {
+		if e.Type == "way" && (e.Lat != 52.5205 || e.Lon != 13.4055) {
+			t.Errorf("way located at %f,%f, want its center", e.Lat, e.Lon)
+		}
+	}
+
+	// A nearby circle and a box inside the same tiles are answered from
+	// the cache
+	elements, err = QueryElementsAround(context.Background(), filter, 52.5210, 13.4100, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 {
+		t.Errorf("second query returned %d elements, want 2", len(elements))
+	}
+	box := geo.BoundingBox{MinLat: 52.51, MinLon: 13.40, MaxLat: 52.535, MaxLon: 13.41}
+	elements, err = QueryElementsInBBox(context.Background(), filter, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 3 {
+		t.Errorf("bounding box query returned %d elements, want 3", len(elements))
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Overpass was queried %d times, want 1", n)
+	}
+
+	// A different filter has its own tiles
+	other := ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"amenity": {"cafe"}}}
+	if _, err := QueryElementsAround(context.Background(), other, 52.5200, 13.4050, 200); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Overpass was queried %d times after changing the filter, want 2", n)
+	}
+}
+
+func TestFetchTilesOutlivesCancelledCaller(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/interpreter" {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		w.Write([]byte(`{"elements":[{"type":"node","id":1,"lat":52.5200,"lon":13.4050}]}`))
+	}))
+	defer ts.Close()
+
+	c := NewOSMClient()
+	defer c.Close()
+	if err := c.SetBaseURL(ServiceOverpass, ts.URL+"/api/interpreter"); err != nil {
+		t.Fatal(err)
+	}
+	filter := ElementFilter{Types: []string{"node"}, Tags: map[string][]string{"amenity": {"cafe"}}}
+	query := func(ctx context.Context) ([]OverpassElement, error) {
+		return c.QueryElementsAround(ctx, filter, 52.5200, 13.4050, 200)
+	}
+
+	// The first caller starts the fetch and gives up while a second
+	// caller waits for the same tiles
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := query(ctx)
+		first <- err
+	}()
+	<-started
+	type result struct {
+		elements []OverpassElement
+		err      error
+	}
+	second := make(chan result, 1)
+	go func() {
+		elements, err := query(context.Background())
+		second <- result{elements, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller error = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if res := <-second; res.err != nil || len(res.elements) != 1 {
+		t.Errorf("waiting caller got %+v, %v, want the fetched element", res.elements, res.err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Overpass was queried %d times, want 1", n)
+	}
+}
@@ -0,0 +1,69 @@
+// Package osm provides utilities for working with OpenStreetMap data.
+package osm
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/NERVsystems/osmmcp/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceAttribute names the upstream service of a span
+func serviceAttribute(service string) attribute.KeyValue {
+	if service == "" {
+		service = "other"
+	}
+	return attribute.String("upstream.service", service)
+}
+
+// startAttemptSpan starts the span of one attempt to send req to a
+// service. attempt counts from 1.
+func startAttemptSpan(ctx context.Context, service string, req *http.Request, attempt int) (context.Context, trace.Span) {
+	name := req.Method
+	if name == "" {
+		name = http.MethodGet
+	}
+	if service != "" {
+		name += " " + service
+	}
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			serviceAttribute(service),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.Redacted()),
+			attribute.Int("http.request.resend_count", attempt-1),
+		))
+}
+
+// endAttemptSpan records the outcome of an attempt and ends its span
+func endAttemptSpan(span trace.Span, resp *http.Response, err error) {
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+}
+
+// startWaitSpan starts the span of a wait for the rate limiter or an
+// Overpass slot
+func startWaitSpan(ctx context.Context, name, service string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name, trace.WithAttributes(serviceAttribute(service)))
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
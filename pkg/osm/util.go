@@ -10,7 +10,7 @@ import (
 )
 
 const (
-	// API endpoints
+	// Default API endpoints, see SetBaseURL to use other instances
 	NominatimBaseURL = "https://nominatim.openstreetmap.org"
 	OverpassBaseURL  = "https://overpass-api.de/api/interpreter"
 	OSRMBaseURL      = "https://router.project-osrm.org"
@@ -27,12 +27,12 @@ const (
 func NewClient() *http.Client {
 	return &http.Client{
 		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
+		Transport: defaultClient.newTransport(&http.Transport{
 			MaxIdleConns:        10,
 			MaxIdleConnsPerHost: 10,
 			MaxConnsPerHost:     10,
 			IdleConnTimeout:     30 * time.Second,
-		},
+		}),
 	}
 }
 
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/metrics"
+)
+
+// MetricsEndpoint is the path of the Prometheus metrics on the admin listener
+const MetricsEndpoint = "/metrics"
+
+// WithAdminAddr serves the admin endpoints, /metrics among them, on a
+// listener of their own next to the MCP transport. The server then
+// collects metrics unless WithMetrics supplied them.
+func WithAdminAddr(addr string) Option {
+	return func(s *Server) {
+		s.adminAddr = addr
+	}
+}
+
+// WithMetrics reports the server's tool calls and the upstream requests
+// of its osm client to m. The metrics are only served when an admin
+// address is set; embedders can serve m.Handler() themselves.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// Metrics returns the metrics of the server, or nil if it collects none
+func (s *Server) Metrics() *metrics.Metrics {
+	return s.metrics
+}
+
+// newAdminMux builds the routes of the admin listener
+func (s *Server) newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsEndpoint, s.metrics.Handler())
+	return mux
+}
+
+// startAdmin starts the admin listener, if one is configured, and returns
+// a function that stops it
+func (s *Server) startAdmin() (func(), error) {
+	if s.adminAddr == "" {
+		return func() {}, nil
+	}
+
+	ln, err := net.Listen("tcp", s.adminAddr)
+	if err != nil {
+		return nil, err
+	}
+	httpSrv := &http.Server{
+		Handler:           s.newAdminMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.logger.Info("serving admin endpoints", "addr", ln.Addr().String(), "metrics", MetricsEndpoint)
+	go func() {
+		if err := httpSrv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin listener failed", "error", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpSrv.Shutdown(ctx); err != nil {
+			s.logger.Error("admin shutdown error", "error", err)
+		}
+	}, nil
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// toolsPrefix is the path prefix of the generic tool endpoints
+	toolsPrefix = "/tools/"
+
+	// maxArgumentsSize bounds the size of a JSON argument body
+	maxArgumentsSize = 1 << 20
+)
+
+// restAliases maps the short REST paths to the tools they call
+var restAliases = map[string]string{
+	"/geocode": "geocode_address",
+	"/places":  "find_nearby_places",
+	"/route":   "get_route_directions",
+}
+
+// toolNames returns the names of a tool set in sorted order
+func toolNames(set map[string]tools.ToolDefinition) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleTool runs a tool with arguments taken from the query string (GET)
+// or from a JSON object body (POST) and writes the tool output as JSON.
+func (h *Handler) handleTool(w http.ResponseWriter, r *http.Request, name string) (int, error) {
+	def, ok := h.toolSet()[name]
+	if !ok {
+		return writeJSONError(w, http.StatusNotFound, fmt.Sprintf("unknown tool %q", name))
+	}
+
+	var args map[string]interface{}
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		args, err = argumentsFromQuery(def.Tool, r.URL.Query())
+		if err != nil {
+			return writeJSONError(w, http.StatusBadRequest, err.Error())
+		}
+	case http.MethodPost:
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxArgumentsSize))
+		if err := dec.Decode(&args); err != nil {
+			return writeJSONError(w, http.StatusBadRequest, "request body must be a JSON object of tool arguments")
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		return writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	if apiErr := tools.ValidateArguments(def.Tool, args); apiErr != nil {
+		return writeJSONError(w, http.StatusBadRequest, apiErr.Message)
+	}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+
+	result, err := def.Handler(r.Context(), req)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "tool execution failed")
+		return http.StatusInternalServerError, err
+	}
+
+	return writeToolResult(w, result)
+}
+
+// argumentsFromQuery converts query parameters to tool arguments using
+// the types declared in the tool's input schema.
+func argumentsFromQuery(tool mcp.Tool, query url.Values) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(query))
+	for name, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+		raw := values[0]
+
+		switch propertyType(tool, name) {
+		case "number", "integer":
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("argument %q must be a number", name)
+			}
+			args[name] = v
+		case "boolean":
+			v, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("argument %q must be a boolean", name)
+			}
+			args[name] = v
+		case "array", "object":
+			var v interface{}
+			if err := json.Unmarshal([]byte(raw), &v); err != nil {
+				// Arrays may also be given as comma separated values
+				if propertyType(tool, name) != "array" {
+					return nil, fmt.Errorf("argument %q must be JSON", name)
+				}
+				items := make([]interface{}, 0)
+				for _, item := range strings.Split(raw, ",") {
+					items = append(items, strings.TrimSpace(item))
+				}
+				v = items
+			}
+			args[name] = v
+		default:
+			args[name] = raw
+		}
+	}
+	return args, nil
+}
+
+// propertyType returns the JSON schema type of a tool argument
+func propertyType(tool mcp.Tool, name string) string {
+	prop, ok := tool.InputSchema.Properties[name].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	t, _ := prop["type"].(string)
+	return t
+}
+
+// writeToolResult writes the text content of a tool result. Tools return
+// JSON documents, which are passed through unchanged; error results are
+// reported with status 422 since the request itself was well formed.
+func writeToolResult(w http.ResponseWriter, result *mcp.CallToolResult) (int, error) {
+	var text string
+	for _, content := range result.Content {
+		if tc, ok := content.(mcp.TextContent); ok {
+			text = tc.Text
+			break
+		}
+	}
+
+	if result.IsError {
+		if json.Valid([]byte(text)) {
+			return writeRawJSON(w, http.StatusUnprocessableEntity, []byte(text))
+		}
+		return writeJSONError(w, http.StatusUnprocessableEntity, text)
+	}
+
+	if !json.Valid([]byte(text)) {
+		return writeJSON(w, http.StatusOK, map[string]string{"result": text})
+	}
+	return writeRawJSON(w, http.StatusOK, []byte(text))
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return http.StatusInternalServerError, err
+	}
+	return writeRawJSON(w, status, data)
+}
+
+// writeRawJSON writes an already encoded JSON document
+func writeRawJSON(w http.ResponseWriter, status int, data []byte) (int, error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write(data)
+	return status, err
+}
+
+// writeJSONError writes an error message as a JSON object
+func writeJSONError(w http.ResponseWriter, status int, message string) (int, error) {
+	return writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handleListTools lists the tools available through the REST API
+func (h *Handler) handleListTools(w http.ResponseWriter, r *http.Request) (int, error) {
+	type toolInfo struct {
+		Name        string              `json:"name"`
+		Description string              `json:"description"`
+		Path        string              `json:"path"`
+		InputSchema mcp.ToolInputSchema `json:"input_schema"`
+	}
+
+	set := h.toolSet()
+	list := make([]toolInfo, 0, len(set))
+	for _, name := range toolNames(set) {
+		def := set[name]
+		list = append(list, toolInfo{
+			Name:        def.Name,
+			Description: def.Description,
+			Path:        toolsPrefix + def.Name,
+			InputSchema: def.Tool.InputSchema,
+		})
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"tools": list})
+}
+
+// handleOpenAPI serves an OpenAPI 3 document describing the REST API
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) (int, error) {
+	return writeJSON(w, http.StatusOK, h.openAPIDocument())
+}
+
+// openAPIDocument builds the OpenAPI document from the tool definitions
+func (h *Handler) openAPIDocument() map[string]interface{} {
+	paths := map[string]interface{}{
+		"/health": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":     "Health check",
+				"operationId": "health",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "Server is healthy"},
+				},
+			},
+		},
+	}
+
+	set := h.toolSet()
+	for _, name := range toolNames(set) {
+		paths[toolsPrefix+name] = toolPathItem(set[name], name)
+	}
+	for alias, name := range restAliases {
+		if def, ok := set[name]; ok {
+			paths[alias] = toolPathItem(def, strings.TrimPrefix(alias, "/"))
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "OpenStreetMap MCP REST API",
+			"description": "REST access to the osmmcp tools. Each tool accepts its MCP arguments as query parameters (GET) or as a JSON object body (POST).",
+			"version":     ServerVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"error": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// toolPathItem describes the GET and POST operations of one tool
+func toolPathItem(def tools.ToolDefinition, operationID string) map[string]interface{} {
+	schema := def.Tool.InputSchema
+
+	required := make(map[string]bool, len(schema.Required))
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		prop := propertySchema(schema.Properties[name])
+		param := map[string]interface{}{
+			"name":     name,
+			"in":       "query",
+			"required": required[name],
+			"schema":   prop,
+		}
+		if desc, ok := prop["description"].(string); ok {
+			param["description"] = desc
+		}
+		params = append(params, param)
+	}
+
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Tool output",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"type": "object"},
+				},
+			},
+		},
+		"400": errorResponse("Invalid or missing arguments"),
+		"422": errorResponse("The tool reported an error"),
+	}
+
+	properties := make(map[string]interface{}, len(schema.Properties))
+	for name, prop := range schema.Properties {
+		properties[name] = propertySchema(prop)
+	}
+
+	bodySchema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(schema.Required) > 0 {
+		bodySchema["required"] = schema.Required
+	}
+
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     def.Description,
+			"operationId": operationID + "_get",
+			"parameters":  params,
+			"responses":   responses,
+		},
+		"post": map[string]interface{}{
+			"summary":     def.Description,
+			"operationId": operationID,
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": bodySchema,
+					},
+				},
+			},
+			"responses": responses,
+		},
+	}
+}
+
+// propertySchema converts a tool argument schema into an OpenAPI schema.
+// OpenAPI requires array schemas to describe their items.
+func propertySchema(prop interface{}) map[string]interface{} {
+	src, _ := prop.(map[string]interface{})
+	out := make(map[string]interface{}, len(src)+1)
+	for k, v := range src {
+		out[k] = v
+	}
+	if out["type"] == "array" {
+		if _, ok := out["items"]; !ok {
+			out["items"] = map[string]interface{}{}
+		}
+	}
+	return out
+}
+
+// errorResponse describes an error response in the OpenAPI document
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+			},
+		},
+	}
+}
@@ -3,12 +3,16 @@ package server
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/NERVsystems/osmmcp/pkg/metrics"
 	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/NERVsystems/osmmcp/pkg/tools"
 	"github.com/mark3labs/mcp-go/server"
@@ -20,47 +24,113 @@ const (
 
 	// ServerVersion is the version of the MCP server
 	ServerVersion = "0.1.0"
+
+	// shutdownTimeout bounds how long in-flight HTTP requests may take to drain
+	shutdownTimeout = 10 * time.Second
 )
 
 // Server encapsulates the MCP server with OpenStreetMap tools.
 type Server struct {
-	srv     *server.MCPServer
-	logger  *slog.Logger
-	stopCh  chan struct{}
-	doneCh  chan struct{}
-	running bool
-	mu      sync.Mutex
-	once    sync.Once // Ensure we only close stopCh once
+	srv       *server.MCPServer
+	logger    *slog.Logger
+	osm       *osm.Client
+	ownsOSM   bool // close osm when Run returns
+	registry  *tools.Registry
+	rest      *Handler
+	transport Transport
+	addr      string
+	adminAddr string
+	metrics   *metrics.Metrics
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	running   bool
+	mu        sync.Mutex
+	once      sync.Once // Ensure we only close stopCh once
+
+	// registryOpts are the options given to WithRegistryOptions
+	registryOpts []tools.RegistryOption
 }
 
 // NewServer creates a new OpenStreetMap MCP server with all tools registered.
-func NewServer() (*Server, error) {
-	logger := slog.Default()
+// Without options the server speaks MCP over stdin/stdout.
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		logger:    slog.Default(),
+		osm:       osm.Default(),
+		transport: TransportStdio,
+		addr:      DefaultAddr,
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if _, err := ParseTransport(string(s.transport)); err != nil {
+		return nil, err
+	}
+
+	logger := s.logger
 	logger.Info("initializing OpenStreetMap MCP server",
 		"name", ServerName,
-		"version", ServerVersion)
+		"version", ServerVersion,
+		"transport", s.transport)
 
 	// Create MCP server with options
 	srv := server.NewMCPServer(
 		ServerName,
 		ServerVersion,
-		server.WithToolCapabilities(false),
+		server.WithToolCapabilities(true),
 		server.WithRecovery(),
 	)
 
+	if s.adminAddr != "" && s.metrics == nil {
+		s.metrics = metrics.New()
+	}
+	if s.metrics != nil {
+		s.osm.SetObserver(s.metrics)
+		if err := s.metrics.RegisterCache(s.osm.Cache()); err != nil {
+			logger.Warn("cache metrics not exported", "error", err)
+		}
+	}
+
 	// Create tool registry and register all tools and prompts
-	registry := tools.NewRegistry(logger)
+	registry := s.newRegistry(s.registryOpts)
 	registry.RegisterAll(srv)
 
-	return &Server{
-		srv:    srv,
-		logger: logger,
-		stopCh: make(chan struct{}),
-		doneCh: make(chan struct{}),
-	}, nil
+	s.srv = srv
+	s.registry = registry
+	s.rest = NewHandler(logger, registry)
+	return s, nil
+}
+
+// newRegistry creates a tool registry using the server's osm client and
+// metrics with the given options
+func (s *Server) newRegistry(opts []tools.RegistryOption) *tools.Registry {
+	base := []tools.RegistryOption{tools.WithClient(s.osm)}
+	if s.metrics != nil {
+		base = append(base, tools.WithObserver(s.metrics))
+	}
+	return tools.NewRegistry(s.logger, append(base, opts...)...)
 }
 
-// Run starts the MCP server using stdin/stdout for communication.
+// Reconfigure replaces the server's tools with those of a registry built
+// with opts in place of the options given to WithRegistryOptions.
+// Connected sessions stay open and are notified that the tool list
+// changed; calls already running finish with the old settings.
+func (s *Server) Reconfigure(opts ...tools.RegistryOption) {
+	registry := s.newRegistry(opts)
+	registry.ClearResultCache()
+	s.srv.SetTools(registry.ServerTools()...)
+	s.rest.setRegistry(registry)
+
+	s.mu.Lock()
+	s.registry = registry
+	s.registryOpts = opts
+	s.mu.Unlock()
+}
+
+// Run starts the MCP server on the configured transport.
 // This method blocks until the server is stopped or an error occurs.
 func (s *Server) Run() error {
 	s.mu.Lock()
@@ -71,6 +141,36 @@ func (s *Server) Run() error {
 	s.running = true
 	s.mu.Unlock()
 
+	stopAdmin, err := s.startAdmin()
+	if err != nil {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+		close(s.doneCh)
+		return err
+	}
+	defer stopAdmin()
+
+	if s.transport.IsHTTP() {
+		err = s.runHTTP()
+	} else {
+		s.runStdio()
+	}
+
+	s.mu.Lock()
+	s.running = false
+	s.mu.Unlock()
+
+	// Clients given with WithOSMClient may serve others and are left
+	// running
+	if s.ownsOSM {
+		s.osm.Close()
+	}
+	return err
+}
+
+// runStdio serves a single client over stdin/stdout until stopped
+func (s *Server) runStdio() {
 	// Run the server in a goroutine
 	go func() {
 		defer close(s.doneCh)
@@ -83,12 +183,57 @@ func (s *Server) Run() error {
 	// Wait for stop signal
 	<-s.stopCh
 
-	s.mu.Lock()
-	s.running = false
-	s.mu.Unlock()
-
 	// Wait for server to finish before returning
 	<-s.doneCh
+}
+
+// runHTTP serves MCP clients over HTTP until stopped or the listener fails
+func (s *Server) runHTTP() error {
+	defer close(s.doneCh)
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	// Long-lived SSE streams only end when their request context does,
+	// so every request derives from a context we cancel on shutdown.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	httpSrv := &http.Server{
+		Handler:           s.newMux(baseCtx),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+
+	s.logger.Info("listening for MCP clients",
+		"transport", s.transport,
+		"addr", ln.Addr().String())
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpSrv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-s.stopCh:
+	}
+
+	cancelBase()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		s.logger.Error("HTTP shutdown error", "error", err)
+		return err
+	}
 	return nil
 }
 
@@ -109,7 +254,8 @@ func (s *Server) RunWithContext(ctx context.Context) error {
 }
 
 // Shutdown initiates a graceful shutdown of the server.
-// It does not block and returns immediately.
+// It does not block and returns immediately. HTTP transports stop
+// accepting connections and drain in-flight requests before Run returns.
 // Using sync.Once to ensure we don't close an already closed channel.
 func (s *Server) Shutdown() {
 	s.mu.Lock()
@@ -131,18 +277,50 @@ func (s *Server) WaitForShutdown() {
 	<-s.doneCh
 }
 
-// Handler represents the HTTP server handler
+// Handler serves the osmmcp tools as a JSON REST API.
+// Every tool is available at /tools/{name}; /geocode, /places and /route
+// are shortcuts for the most common tools.
 type Handler struct {
 	logger *slog.Logger
-	osm    *osm.Client
+
+	mu    sync.RWMutex
+	osm   *osm.Client
+	tools map[string]tools.ToolDefinition
 }
 
-// NewHandler creates a new server handler
-func NewHandler(logger *slog.Logger) *Handler {
-	return &Handler{
-		logger: logger,
-		osm:    osm.NewOSMClient(),
+// NewHandler creates a new server handler for the tools in registry
+func NewHandler(logger *slog.Logger, registry *tools.Registry) *Handler {
+	h := &Handler{logger: logger}
+	h.setRegistry(registry)
+	return h
+}
+
+// setRegistry makes the handler serve the tools of registry
+func (h *Handler) setRegistry(registry *tools.Registry) {
+	defs := registry.GetToolDefinitions()
+	byName := make(map[string]tools.ToolDefinition, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
 	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.osm = registry.Client()
+	h.tools = byName
+}
+
+// toolSet returns the tools currently served, keyed by name
+func (h *Handler) toolSet() map[string]tools.ToolDefinition {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.tools
+}
+
+// client returns the osm client of the tools currently served
+func (h *Handler) client() *osm.Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.osm
 }
 
 // ServeHTTP implements the http.Handler interface
@@ -157,7 +335,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if reqID == "" {
 		reqID = generateRequestID()
 	}
-	ctx = context.WithValue(ctx, "requestID", reqID)
+	ctx = tools.WithRequestID(ctx, reqID)
+	r = r.WithContext(ctx)
+	w.Header().Set("X-Request-ID", reqID)
 
 	// Log request
 	h.logger.Info("request started",
@@ -174,6 +354,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case path == "/health":
 		status, err = h.handleHealth(w, r)
+	case path == "/openapi.json":
+		status, err = h.handleOpenAPI(w, r)
+	case path == "/tools" || path == toolsPrefix:
+		status, err = h.handleListTools(w, r)
+	case strings.HasPrefix(path, toolsPrefix):
+		status, err = h.handleTool(w, r, strings.TrimPrefix(path, toolsPrefix))
 	case path == "/geocode":
 		status, err = h.handleGeocode(w, r)
 	case path == "/places":
@@ -181,8 +367,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case path == "/route":
 		status, err = h.handleRoute(w, r)
 	default:
-		status = http.StatusNotFound
-		err = nil
+		status, err = writeJSONError(w, http.StatusNotFound, "not found")
 	}
 
 	// Log response
@@ -205,30 +390,32 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleHealth handles health check requests
+// handleHealth handles health check requests. The response includes the
+// state of the upstream rate limiters and mirrors and the cache statistics
+// for diagnostics.
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) (int, error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
-	return http.StatusOK, nil
+	client := h.client()
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "ok",
+		"rate_limits": client.RateLimiter().State(),
+		"mirrors":     client.MirrorStates(),
+		"caches":      client.Cache().Stats(),
+	})
 }
 
-// handleGeocode handles geocoding requests
+// handleGeocode handles geocoding requests by calling geocode_address
 func (h *Handler) handleGeocode(w http.ResponseWriter, r *http.Request) (int, error) {
-	// TODO: Implement geocoding handler
-	return http.StatusNotImplemented, nil
+	return h.handleTool(w, r, restAliases["/geocode"])
 }
 
-// handlePlaces handles places search requests
+// handlePlaces handles places search requests by calling find_nearby_places
 func (h *Handler) handlePlaces(w http.ResponseWriter, r *http.Request) (int, error) {
-	// TODO: Implement places handler
-	return http.StatusNotImplemented, nil
+	return h.handleTool(w, r, restAliases["/places"])
 }
 
-// handleRoute handles routing requests
+// handleRoute handles routing requests by calling get_route_directions
 func (h *Handler) handleRoute(w http.ResponseWriter, r *http.Request) (int, error) {
-	// TODO: Implement route handler
-	return http.StatusNotImplemented, nil
+	return h.handleTool(w, r, restAliases["/route"])
 }
 
 // generateRequestID generates a unique request ID
@@ -2,7 +2,19 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/testutil"
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 func TestNewServer(t *testing.T) {
@@ -38,3 +50,326 @@ func TestServer_Run(t *testing.T) {
 	s.Shutdown()
 	s.WaitForShutdown()
 }
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Transport
+		wantErr bool
+	}{
+		{input: "", want: TransportStdio},
+		{input: "stdio", want: TransportStdio},
+		{input: "SSE", want: TransportSSE},
+		{input: " http ", want: TransportHTTP},
+		{input: "websocket", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTransport(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTransport(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTransport(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamableHandler(t *testing.T) {
+	s, err := NewServer(WithTransport(TransportHTTP))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	ts := httptest.NewServer(s.newMux(t.Context()))
+	defer ts.Close()
+
+	do := func(method, session, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+MCPEndpoint, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.Header.Set(sessionIDHeader, session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, MCPEndpoint, err)
+		}
+		return resp
+	}
+
+	// Initialize returns a session ID and the server info
+	resp := do(http.MethodPost, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1.0"}}}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("initialize status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	session := resp.Header.Get(sessionIDHeader)
+	if session == "" {
+		t.Fatal("initialize response is missing the session ID header")
+	}
+
+	// Notifications are accepted without a body
+	notify := do(http.MethodPost, session, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	notify.Body.Close()
+	if notify.StatusCode != http.StatusAccepted {
+		t.Errorf("notification status = %d, want %d", notify.StatusCode, http.StatusAccepted)
+	}
+
+	// Tools are listed from the shared registry
+	const listTools = `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`
+	list := do(http.MethodPost, session, listTools)
+	defer list.Body.Close()
+	var out struct {
+		Result struct {
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(list.Body).Decode(&out); err != nil {
+		t.Fatalf("failed to decode tools/list response: %v", err)
+	}
+	if len(out.Result.Tools) == 0 {
+		t.Error("tools/list returned no tools")
+	}
+
+	// Requests after initialize must name a live session
+	for _, tt := range []struct {
+		name, method, session string
+		want                  int
+	}{
+		{name: "POST without session", method: http.MethodPost, want: http.StatusBadRequest},
+		{name: "POST with unknown session", method: http.MethodPost, session: "nope", want: http.StatusNotFound},
+		{name: "GET without session", method: http.MethodGet, want: http.StatusBadRequest},
+		{name: "DELETE", method: http.MethodDelete, session: session, want: http.StatusNoContent},
+		{name: "POST with deleted session", method: http.MethodPost, session: session, want: http.StatusNotFound},
+		{name: "DELETE again", method: http.MethodDelete, session: session, want: http.StatusNotFound},
+	} {
+		resp := do(tt.method, tt.session, listTools)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestStreamableSessionReaping(t *testing.T) {
+	s, err := NewServer(WithTransport(TransportHTTP))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	h := newStreamableHandler(s.srv)
+	h.idleTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.reapSessions(ctx, 10*time.Millisecond)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	do := func(method, session string) *http.Response {
+		t.Helper()
+		body := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1.0"}}}`
+		if session != "" {
+			body = `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+		}
+		req, err := http.NewRequest(method, ts.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(sessionIDHeader, session)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		return resp
+	}
+	start := func() string {
+		t.Helper()
+		resp := do(http.MethodPost, "")
+		resp.Body.Close()
+		return resp.Header.Get(sessionIDHeader)
+	}
+	idle, streaming := start(), start()
+
+	// An open event stream keeps its session alive
+	stream := do(http.MethodGet, streaming)
+	defer stream.Body.Close()
+
+	// Requests would keep the session alive, so watch the handler instead
+	live := func(id string) bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.sessions[id] != nil
+	}
+	for deadline := time.Now().Add(5 * time.Second); live(idle); {
+		if time.Now().After(deadline) {
+			t.Fatal("idle session was not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reaped := do(http.MethodPost, idle)
+	reaped.Body.Close()
+	if reaped.StatusCode != http.StatusNotFound {
+		t.Errorf("reaped session status = %d, want %d", reaped.StatusCode, http.StatusNotFound)
+	}
+	if err := s.srv.SendNotificationToSpecificClient(idle, "test", nil); !errors.Is(err, server.ErrSessionNotFound) {
+		t.Errorf("notifying the reaped session: error = %v, want %v", err, server.ErrSessionNotFound)
+	}
+	resp := do(http.MethodPost, streaming)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("streaming session status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Shutdown ends the remaining sessions and their streams
+	cancel()
+	if _, err := io.Copy(io.Discard, stream.Body); err != nil {
+		t.Errorf("event stream ended with %v", err)
+	}
+	h.mu.Lock()
+	left := len(h.sessions)
+	h.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d sessions left after shutdown, want 0", left)
+	}
+}
+
+func TestServer_RunHTTP(t *testing.T) {
+	s, err := NewServer(WithTransport(TransportSSE), WithAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.RunWithContext(ctx)
+	}()
+
+	// Give the listener a moment to start, then shut down via the context
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("RunWithContext() error = %v", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestRunStopsOwnedClient(t *testing.T) {
+	owned, shared := osm.NewOSMClient(), osm.NewOSMClient()
+	defer shared.Close()
+
+	for _, opt := range []Option{WithOwnedOSMClient(owned), WithOSMClient(shared)} {
+		s, err := NewServer(WithTransport(TransportHTTP), WithAddr("127.0.0.1:0"), opt)
+		if err != nil {
+			t.Fatalf("NewServer() error = %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- s.RunWithContext(ctx)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("RunWithContext() error = %v", err)
+		}
+	}
+
+	if !owned.Cache().Stopped() {
+		t.Error("cache cleanup of the owned client still runs after shutdown")
+	}
+	if shared.Cache().Stopped() {
+		t.Error("cache cleanup of a shared client was stopped")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := tools.NewRegistry(testutil.DiscardLogger())
+	ts := httptest.NewServer(NewHandler(testutil.DiscardLogger(), registry))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "health", method: http.MethodGet, path: "/health", wantStatus: http.StatusOK},
+		{name: "list tools", method: http.MethodGet, path: "/tools", wantStatus: http.StatusOK},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", wantStatus: http.StatusNotFound},
+		{name: "unknown tool", method: http.MethodPost, path: "/tools/nope", body: `{}`, wantStatus: http.StatusNotFound},
+		{name: "missing argument", method: http.MethodGet, path: "/geocode", wantStatus: http.StatusBadRequest},
+		{name: "bad number", method: http.MethodGet, path: "/places?latitude=abc&longitude=1", wantStatus: http.StatusBadRequest},
+		{name: "bad body", method: http.MethodPost, path: "/tools/find_nearby_places", body: `[1,2]`, wantStatus: http.StatusBadRequest},
+		{name: "tool error", method: http.MethodPost, path: "/tools/find_nearby_places", body: `{"latitude":100,"longitude":0}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "wrong method", method: http.MethodDelete, path: "/route", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.wantStatus)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+		})
+	}
+}
+
+func TestHandler_OpenAPI(t *testing.T) {
+	registry := tools.NewRegistry(testutil.DiscardLogger())
+	ts := httptest.NewServer(NewHandler(testutil.DiscardLogger(), registry))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("failed to decode OpenAPI document: %v", err)
+	}
+
+	if doc.OpenAPI == "" {
+		t.Error("OpenAPI version is missing")
+	}
+	for _, def := range registry.GetToolDefinitions() {
+		if _, ok := doc.Paths["/tools/"+def.Name]; !ok {
+			t.Errorf("OpenAPI document has no path for tool %q", def.Name)
+		}
+	}
+	for alias := range restAliases {
+		if _, ok := doc.Paths[alias]; !ok {
+			t.Errorf("OpenAPI document has no path for %q", alias)
+		}
+	}
+}
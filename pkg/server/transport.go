@@ -0,0 +1,448 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"github.com/NERVsystems/osmmcp/pkg/tracing"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Transport identifies how MCP clients connect to the server.
+type Transport string
+
+const (
+	// TransportStdio serves a single client over stdin/stdout
+	TransportStdio Transport = "stdio"
+
+	// TransportSSE serves many clients over the HTTP+SSE transport
+	TransportSSE Transport = "sse"
+
+	// TransportHTTP serves many clients over the Streamable HTTP transport
+	TransportHTTP Transport = "http"
+)
+
+const (
+	// DefaultAddr is the default listen address for the HTTP based transports
+	DefaultAddr = "localhost:8080"
+
+	// MCPEndpoint is the path of the Streamable HTTP endpoint
+	MCPEndpoint = "/mcp"
+
+	// SSEEndpoint is the path clients open the SSE stream on
+	SSEEndpoint = "/sse"
+
+	// MessageEndpoint is the path SSE clients post messages to
+	MessageEndpoint = "/message"
+
+	// maxMessageSize bounds the size of a single JSON-RPC request body
+	maxMessageSize = 4 << 20
+
+	// sessionIDHeader carries the session ID in the Streamable HTTP transport
+	sessionIDHeader = "Mcp-Session-Id"
+
+	// sessionIdleTimeout is how long a Streamable HTTP session without
+	// requests or an open event stream lives before it is ended
+	sessionIdleTimeout = 30 * time.Minute
+
+	// sessionReapInterval is how often idle sessions are looked for
+	sessionReapInterval = time.Minute
+)
+
+// ParseTransport converts a transport name into a Transport.
+func ParseTransport(name string) (Transport, error) {
+	switch t := Transport(strings.ToLower(strings.TrimSpace(name))); t {
+	case TransportStdio, TransportSSE, TransportHTTP:
+		return t, nil
+	case "":
+		return TransportStdio, nil
+	default:
+		return "", fmt.Errorf("unknown transport %q (must be stdio, sse or http)", name)
+	}
+}
+
+// IsHTTP reports whether the transport listens on a network address.
+func (t Transport) IsHTTP() bool {
+	return t == TransportSSE || t == TransportHTTP
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithTransport selects the transport used by Run.
+func WithTransport(t Transport) Option {
+	return func(s *Server) {
+		s.transport = t
+	}
+}
+
+// WithAddr sets the listen address for the HTTP based transports.
+func WithAddr(addr string) Option {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithOSMClient makes the server's tools send their upstream requests
+// with c instead of the default osm client, so servers configured
+// differently can run in one process.
+func WithOSMClient(c *osm.Client) Option {
+	return func(s *Server) {
+		s.osm = c
+		s.ownsOSM = false
+	}
+}
+
+// WithOwnedOSMClient is WithOSMClient for a client used by this server
+// only. Run closes it when it returns, which also ends the cleanup of a
+// cache store the client created.
+func WithOwnedOSMClient(c *osm.Client) Option {
+	return func(s *Server) {
+		s.osm = c
+		s.ownsOSM = true
+	}
+}
+
+// WithRegistryOptions configures the server's tool registry, for example
+// to select the enabled tools or the default region.
+func WithRegistryOptions(opts ...tools.RegistryOption) Option {
+	return func(s *Server) {
+		s.registryOpts = append(s.registryOpts, opts...)
+	}
+}
+
+// newMux builds the HTTP routes for the configured transport.
+// Every session is served by the same MCPServer, so the tool caches
+// and the osm client's rate limiters are shared between all connected
+// clients. Streamable HTTP sessions end when ctx is done.
+// All other paths are served by the REST gateway. Requests carrying a
+// W3C traceparent header continue the caller's trace.
+func (s *Server) newMux(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.rest)
+
+	switch s.transport {
+	case TransportSSE:
+		sse := server.NewSSEServer(s.srv,
+			server.WithSSEEndpoint(SSEEndpoint),
+			server.WithMessageEndpoint(MessageEndpoint),
+		)
+		mux.Handle(SSEEndpoint, sse)
+		mux.Handle(MessageEndpoint, sse)
+	case TransportHTTP:
+		streamable := newStreamableHandler(s.srv)
+		go streamable.reapSessions(ctx, sessionReapInterval)
+		mux.Handle(MCPEndpoint, streamable)
+	}
+
+	return tracing.Middleware(mux)
+}
+
+// streamableHandler implements the MCP Streamable HTTP transport. Every
+// POST carries one JSON-RPC message or a batch and is answered with a
+// plain JSON body. Notifications, such as a changed tool list, are sent
+// on the event stream a client opens with GET. Sessions end when the
+// client deletes them or after idleTimeout without use, so clients that
+// go away without a DELETE are not kept forever.
+type streamableHandler struct {
+	srv         *server.MCPServer
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*streamableSession
+}
+
+// newStreamableHandler creates a Streamable HTTP handler for srv
+func newStreamableHandler(srv *server.MCPServer) *streamableHandler {
+	return &streamableHandler{
+		srv:         srv,
+		idleTimeout: sessionIdleTimeout,
+		sessions:    make(map[string]*streamableSession),
+	}
+}
+
+// streamableSession is the server side of one Streamable HTTP session
+type streamableSession struct {
+	id            string
+	notifications chan mcp.JSONRPCNotification
+	initialized   atomic.Bool
+
+	// lastSeen is the time of the latest request, in Unix nanoseconds
+	lastSeen atomic.Int64
+
+	// streams counts the open event streams, which keep the session alive
+	streams atomic.Int32
+
+	// closed is closed when the session ends
+	closed chan struct{}
+}
+
+// touch records a request of the session
+func (s *streamableSession) touch() { s.lastSeen.Store(time.Now().UnixNano()) }
+
+// SessionID implements server.ClientSession
+func (s *streamableSession) SessionID() string { return s.id }
+
+// NotificationChannel implements server.ClientSession
+func (s *streamableSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+// Initialize implements server.ClientSession
+func (s *streamableSession) Initialize() { s.initialized.Store(true) }
+
+// Initialized implements server.ClientSession
+func (s *streamableSession) Initialized() bool { return s.initialized.Load() }
+
+// ServeHTTP implements the http.Handler interface
+func (h *streamableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.handlePost(w, r)
+	case http.MethodGet:
+		h.handleGet(w, r)
+	case http.MethodDelete:
+		h.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// session returns the session named by the request's session ID header.
+// Otherwise it writes 400 if the header is missing, or 404 if the
+// session is unknown or was deleted, and returns nil.
+func (h *streamableHandler) session(w http.ResponseWriter, r *http.Request) *streamableSession {
+	id := r.Header.Get(sessionIDHeader)
+	if id == "" {
+		http.Error(w, "missing "+sessionIDHeader+" header", http.StatusBadRequest)
+		return nil
+	}
+	h.mu.Lock()
+	session := h.sessions[id]
+	h.mu.Unlock()
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return nil
+	}
+	session.touch()
+	return session
+}
+
+// newSession starts a session and registers it for notifications
+func (h *streamableHandler) newSession(r *http.Request) (*streamableSession, error) {
+	session := &streamableSession{
+		id:            newSessionID(),
+		notifications: make(chan mcp.JSONRPCNotification, 100),
+		closed:        make(chan struct{}),
+	}
+	session.touch()
+	if err := h.srv.RegisterSession(r.Context(), session); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.sessions[session.id] = session
+	h.mu.Unlock()
+	return session, nil
+}
+
+// handlePost dispatches a single JSON-RPC message or a batch of messages.
+// An initialize request starts a new session; every other request must
+// name an existing one.
+func (h *streamableHandler) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxMessageSize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	// Batches are arrays of messages, everything else is a single message
+	var messages []json.RawMessage
+	batch := body[0] == '['
+	if batch {
+		if err := json.Unmarshal(body, &messages); err != nil {
+			http.Error(w, "invalid JSON-RPC batch", http.StatusBadRequest)
+			return
+		}
+	} else {
+		messages = []json.RawMessage{body}
+	}
+
+	var session *streamableSession
+	if slices.ContainsFunc(messages, isInitialize) {
+		if session, err = h.newSession(r); err != nil {
+			http.Error(w, "failed to start session", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(sessionIDHeader, session.id)
+	} else if session = h.session(w, r); session == nil {
+		return
+	}
+
+	ctx := h.srv.WithContext(r.Context(), session)
+	responses := make([]any, 0, len(messages))
+	for _, msg := range messages {
+		if resp := h.srv.HandleMessage(ctx, msg); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	// Notifications and responses produce no reply
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if batch {
+		enc.Encode(responses)
+		return
+	}
+	enc.Encode(responses[0])
+}
+
+// handleGet streams the notifications of a session as server-sent events
+// until the client disconnects, deletes the session or the server stops
+func (h *streamableHandler) handleGet(w http.ResponseWriter, r *http.Request) {
+	session := h.session(w, r)
+	if session == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	session.streams.Add(1)
+	defer func() {
+		session.touch()
+		session.streams.Add(-1)
+	}()
+	for {
+		select {
+		case notification := <-session.notifications:
+			data, err := json.Marshal(notification)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-session.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleDelete ends a session at the client's request
+func (h *streamableHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session := h.session(w, r)
+	if session == nil {
+		return
+	}
+
+	// Of concurrent deletes of one session, only the first ends it
+	h.mu.Lock()
+	current := h.sessions[session.id] == session
+	delete(h.sessions, session.id)
+	h.mu.Unlock()
+	if !current {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	h.end(session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// end unregisters a session already removed from h.sessions and stops
+// its event streams
+func (h *streamableHandler) end(session *streamableSession) {
+	h.srv.UnregisterSession(context.Background(), session.id)
+	close(session.closed)
+}
+
+// reapSessions ends the idle sessions every interval until ctx is done,
+// and then ends all remaining sessions
+func (h *streamableHandler) reapSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			h.endSessions(func(s *streamableSession) bool {
+				return s.streams.Load() == 0 && now.Sub(time.Unix(0, s.lastSeen.Load())) > h.idleTimeout
+			})
+		case <-ctx.Done():
+			h.endSessions(func(*streamableSession) bool { return true })
+			return
+		}
+	}
+}
+
+// endSessions ends the sessions for which match returns true
+func (h *streamableHandler) endSessions(match func(*streamableSession) bool) {
+	var ended []*streamableSession
+	h.mu.Lock()
+	for id, session := range h.sessions {
+		if match(session) {
+			delete(h.sessions, id)
+			ended = append(ended, session)
+		}
+	}
+	h.mu.Unlock()
+	for _, session := range ended {
+		h.end(session)
+	}
+}
+
+// isInitialize reports whether a JSON-RPC message is an initialize request
+func isInitialize(msg json.RawMessage) bool {
+	var probe struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(msg, &probe); err != nil {
+		return false
+	}
+	return probe.Method == "initialize"
+}
+
+// newSessionID returns a random session identifier
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return generateRequestID()
+	}
+	return hex.EncodeToString(b[:])
+}
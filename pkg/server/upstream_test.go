@@ -0,0 +1,520 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/metrics"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/testutil/fakeosm"
+	"github.com/NERVsystems/osmmcp/pkg/tools"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// mcpClient calls tools on a server through the Streamable HTTP transport
+type mcpClient struct {
+	t       *testing.T
+	url     string
+	id      atomic.Int64
+	session string // set by the initialize response
+}
+
+// toolResult is the part of a tools/call result the tests look at
+type toolResult struct {
+	IsError bool
+	Text    string
+}
+
+func (c *mcpClient) send(method string, params any) json.RawMessage {
+	c.t.Helper()
+	msg, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      c.id.Add(1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(string(msg)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.session != "" {
+		req.Header.Set(sessionIDHeader, c.session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("POST %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if id := resp.Header.Get(sessionIDHeader); id != "" {
+		c.session = id
+	}
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		c.t.Fatalf("failed to decode %s response: %v", method, err)
+	}
+	if out.Error != nil {
+		c.t.Fatalf("%s error: %s", method, out.Error.Message)
+	}
+	return out.Result
+}
+
+func (c *mcpClient) call(name string, args map[string]any) toolResult {
+	c.t.Helper()
+	var result struct {
+		IsError bool `json:"isError"`
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	raw := c.send("tools/call", map[string]any{"name": name, "arguments": args})
+	if err := json.Unmarshal(raw, &result); err != nil {
+		c.t.Fatalf("failed to decode %s result: %v", name, err)
+	}
+	var text []string
+	for _, content := range result.Content {
+		text = append(text, content.Text)
+	}
+	return toolResult{IsError: result.IsError, Text: strings.Join(text, "\n")}
+}
+
+// newFakeClient returns an osm client of its own whose services are
+// served by a fake. Retries back off for milliseconds instead of seconds
+// and rate limits are lifted, so faults can be tested quickly.
+func newFakeClient(t *testing.T, seed uint64) (*osm.Client, *fakeosm.Server) {
+	t.Helper()
+
+	fake := fakeosm.NewServer(fakeosm.NewDataset(seed, 60))
+	t.Cleanup(fake.Close)
+
+	client := osm.NewOSMClient()
+	t.Cleanup(client.Close)
+	if _, err := fake.InstallOn(client); err != nil {
+		t.Fatal(err)
+	}
+	client.SetRetryPolicy(osm.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		MaxRetryAfter:  time.Second,
+	})
+	for _, service := range osm.Services() {
+		if err := client.RateLimiter().SetLimit(service, 1000, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client, fake
+}
+
+// connect runs an MCP server with the given osm client and options and
+// opens a session with it
+func connect(t *testing.T, client *osm.Client, opts ...Option) *mcpClient {
+	t.Helper()
+	_, c, _ := serve(t, client, opts...)
+	return c
+}
+
+// serve is connect that also returns the server and the URL it is
+// served at
+func serve(t *testing.T, client *osm.Client, opts ...Option) (*Server, *mcpClient, string) {
+	t.Helper()
+
+	opts = append([]Option{WithTransport(TransportHTTP), WithOSMClient(client)}, opts...)
+	s, err := NewServer(opts...)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ts := httptest.NewServer(s.newMux(t.Context()))
+	t.Cleanup(ts.Close)
+
+	c := &mcpClient{t: t, url: ts.URL + MCPEndpoint}
+	c.initialize()
+	return s, c, ts.URL
+}
+
+// initialize starts the client's session
+func (c *mcpClient) initialize() {
+	c.t.Helper()
+	c.send("initialize", map[string]any{
+		"protocolVersion": "2025-03-26",
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "test", "version": "1.0"},
+	})
+	if c.session == "" {
+		c.t.Fatal("initialize response has no session ID")
+	}
+}
+
+// listTools returns the names of the tools a session is offered
+func (c *mcpClient) listTools() []string {
+	c.t.Helper()
+	var result struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(c.send("tools/list", map[string]any{}), &result); err != nil {
+		c.t.Fatalf("failed to decode tools/list result: %v", err)
+	}
+	names := make([]string, len(result.Tools))
+	for i, tool := range result.Tools {
+		names[i] = tool.Name
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestToolsAgainstFakeUpstream(t *testing.T) {
+	client, fake := newFakeClient(t, 1)
+	c := connect(t, client)
+	nearby := func(category string, radius float64) map[string]any {
+		return map[string]any{
+			"latitude":  fakeosm.Center.Lat,
+			"longitude": fakeosm.Center.Lon,
+			"radius":    radius,
+			"category":  category,
+		}
+	}
+
+	t.Run("answers from the dataset", func(t *testing.T) {
+		if r := c.call("geocode_address", map[string]any{"address": "Fernsehturm, Berlin"}); r.IsError || !strings.Contains(r.Text, "Panoramastraße") {
+			t.Errorf("geocode_address = %+v", r)
+		}
+		if r := c.call("find_nearby_places", nearby("cafe", 2000)); r.IsError || !strings.Contains(r.Text, "Café") {
+			t.Errorf("find_nearby_places = %+v", r)
+		}
+		if r := c.call("get_route_directions", map[string]any{
+			"start_lat": 52.5162699, "start_lon": 13.3777034, "end_lat": 52.5208182, "end_lon": 13.4094062,
+		}); r.IsError || !strings.Contains(r.Text, "Turn left") {
+			t.Errorf("get_route_directions = %+v", r)
+		}
+	})
+
+	t.Run("retries a rate limited request", func(t *testing.T) {
+		before := len(fake.Requests(osm.ServiceNominatim))
+		fake.SetFault(osm.ServiceNominatim, fakeosm.Fault{Status: http.StatusTooManyRequests, Times: 1})
+		if r := c.call("reverse_geocode", map[string]any{"latitude": 52.5163, "longitude": 13.3778}); r.IsError {
+			t.Errorf("reverse_geocode = %+v, want success after a retry", r)
+		}
+		if n := len(fake.Requests(osm.ServiceNominatim)) - before; n != 2 {
+			t.Errorf("Nominatim received %d requests, want 2", n)
+		}
+	})
+
+	t.Run("reports persistent rate limiting", func(t *testing.T) {
+		defer fake.ClearFaults()
+		before := len(fake.Requests(osm.ServiceOverpass))
+		fake.SetFault(osm.ServiceOverpass, fakeosm.Fault{Status: http.StatusTooManyRequests})
+		r := c.call("find_nearby_places", nearby("restaurant", 800))
+		if !r.IsError || !strings.Contains(r.Text, tools.GuidanceOverpassRateLimit) || !strings.Contains(r.Text, "after 3 attempts") {
+			t.Errorf("find_nearby_places = %+v, want rate limit guidance", r)
+		}
+		if n := len(fake.Requests(osm.ServiceOverpass)) - before; n != 3 {
+			t.Errorf("Overpass received %d requests, want 3", n)
+		}
+	})
+
+	t.Run("reports an unavailable geocoder", func(t *testing.T) {
+		defer fake.ClearFaults()
+		before := len(fake.Requests(osm.ServiceNominatim))
+		fake.SetFault(osm.ServiceNominatim, fakeosm.Fault{Status: http.StatusServiceUnavailable})
+		if r := c.call("geocode_address", map[string]any{"address": "Rotes Rathaus (Berlin)"}); !r.IsError || !strings.Contains(r.Text, "after 3 attempts") {
+			t.Errorf("geocode_address = %+v, want an upstream error after 3 attempts", r)
+		}
+		if n := len(fake.Requests(osm.ServiceNominatim)) - before; n != 3 {
+			t.Errorf("Nominatim received %d requests, want 3", n)
+		}
+		if r := c.call("reverse_geocode", map[string]any{"latitude": 52.5186, "longitude": 13.4081}); !r.IsError || !strings.Contains(r.Text, "after 3 attempts") {
+			t.Errorf("reverse_geocode = %+v, want an upstream error after 3 attempts", r)
+		}
+	})
+
+	t.Run("reports malformed responses", func(t *testing.T) {
+		defer fake.ClearFaults()
+		fake.SetFault(osm.ServiceOSRM, fakeosm.Fault{Malformed: true})
+		r := c.call("get_route_directions", map[string]any{
+			"start_lat": 52.5190608, "start_lon": 13.4010250, "end_lat": 52.5219184, "end_lon": 13.4132147,
+		})
+		if !r.IsError || !strings.Contains(r.Text, tools.GuidanceDataError) {
+			t.Errorf("get_route_directions = %+v, want data error guidance", r)
+		}
+	})
+
+	t.Run("waits for slow responses", func(t *testing.T) {
+		fake.SetFault(osm.ServiceNominatim, fakeosm.Fault{Latency: 100 * time.Millisecond, Times: 1})
+		start := time.Now()
+		if r := c.call("geocode_address", map[string]any{"address": "Berliner Dom, Berlin"}); r.IsError || !strings.Contains(r.Text, "Am Lustgarten") {
+			t.Errorf("geocode_address = %+v", r)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("call took %v, want the injected latency", elapsed)
+		}
+	})
+
+	t.Run("spaces requests by the rate limit", func(t *testing.T) {
+		limiter := client.RateLimiter()
+		if err := limiter.SetLimit(osm.ServiceNominatim, 20, 1); err != nil {
+			t.Fatal(err)
+		}
+		defer limiter.SetLimit(osm.ServiceNominatim, 1000, 10)
+
+		before := len(fake.Requests(osm.ServiceNominatim))
+		for i := range 3 {
+			c.call("reverse_geocode", map[string]any{"latitude": 52.51 + 0.001*float64(i), "longitude": 13.39})
+		}
+		requests := fake.Requests(osm.ServiceNominatim)[before:]
+		if len(requests) != 3 {
+			t.Fatalf("Nominatim received %d requests, want 3", len(requests))
+		}
+		for i := 1; i < len(requests); i++ {
+			// 20 requests per second leave 50 ms between requests
+			if gap := requests[i].Time.Sub(requests[i-1].Time); gap < 40*time.Millisecond {
+				t.Errorf("requests %d and %d were %v apart", i-1, i, gap)
+			}
+		}
+	})
+}
+
+func TestServerReconfigure(t *testing.T) {
+	client, _ := newFakeClient(t, 1)
+	s, c, url := serve(t, client, WithRegistryOptions(tools.WithDisabledTools("explore_area")))
+
+	if names := c.listTools(); slices.Contains(names, "explore_area") || !slices.Contains(names, "geocode_address") {
+		t.Errorf("tools = %v, want every tool but explore_area", names)
+	}
+
+	// The session's event stream announces the change
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(sessionIDHeader, c.session)
+	stream, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", MCPEndpoint, err)
+	}
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d, want %d", MCPEndpoint, stream.StatusCode, http.StatusOK)
+	}
+
+	// The open session sees the new tool list and keeps working
+	s.Reconfigure(tools.WithEnabledTools("geocode_address", "explore_area"))
+	var event string
+	for events := bufio.NewScanner(stream.Body); events.Scan(); {
+		if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+			event = data
+			break
+		}
+	}
+	if !strings.Contains(event, "notifications/tools/list_changed") {
+		t.Errorf("event = %q, want a tools/list_changed notification", event)
+	}
+	if names := c.listTools(); !slices.Equal(names, []string{"explore_area", "geocode_address"}) {
+		t.Errorf("tools after reconfigure = %v, want explore_area and geocode_address", names)
+	}
+	if r := c.call("geocode_address", map[string]any{"address": "Fernsehturm, Berlin"}); r.IsError {
+		t.Errorf("geocode_address after reconfigure = %+v", r)
+	}
+
+	// The REST gateway follows
+	for path, want := range map[string]int{
+		"/tools/explore_area":        http.StatusBadRequest,
+		"/tools/find_schools_nearby": http.StatusNotFound,
+	} {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestServersWithSeparateClients(t *testing.T) {
+	clientA, fakeA := newFakeClient(t, 1)
+	clientB, fakeB := newFakeClient(t, 2)
+	a, b := connect(t, clientA), connect(t, clientB)
+
+	// The same call on both servers reaches both upstreams; neither is
+	// answered from the other's caches
+	geocode := map[string]any{"address": "Fernsehturm, Berlin"}
+	if r := a.call("geocode_address", geocode); r.IsError {
+		t.Fatalf("geocode_address on A = %+v", r)
+	}
+	if n := len(fakeB.Requests(osm.ServiceNominatim)); n != 0 {
+		t.Errorf("B's Nominatim received %d requests from A", n)
+	}
+	if r := b.call("geocode_address", geocode); r.IsError {
+		t.Fatalf("geocode_address on B = %+v", r)
+	}
+	if len(fakeB.Requests(osm.ServiceNominatim)) == 0 {
+		t.Error("B's Nominatim received no requests")
+	}
+
+	// A failing upstream only fails the server configured with it
+	fakeA.SetFault(osm.ServiceOSRM, fakeosm.Fault{Status: http.StatusInternalServerError})
+	route := map[string]any{"start_lat": 52.5162699, "start_lon": 13.3777034, "end_lat": 52.5208182, "end_lon": 13.4094062}
+	if r := a.call("get_route_directions", route); !r.IsError {
+		t.Errorf("get_route_directions on A = %+v, want an error", r)
+	}
+	if r := b.call("get_route_directions", route); r.IsError {
+		t.Errorf("get_route_directions on B = %+v", r)
+	}
+
+	// Neither server touched the default client
+	for _, service := range osm.Services() {
+		if got, want := osm.BaseURL(service), clientA.BaseURL(service); got == want {
+			t.Errorf("default client %s URL = %s, want it unchanged", service, got)
+		}
+	}
+}
+
+func TestMetricsOfToolCalls(t *testing.T) {
+	client, fake := newFakeClient(t, 1)
+	m := metrics.New()
+	c := connect(t, client, WithMetrics(m))
+
+	fake.SetFault(osm.ServiceNominatim, fakeosm.Fault{Status: http.StatusTooManyRequests, Times: 1})
+	if r := c.call("geocode_address", map[string]any{"address": "Fernsehturm, Berlin"}); r.IsError {
+		t.Fatalf("geocode_address = %+v", r)
+	}
+	if r := c.call("geocode_address", map[string]any{}); !r.IsError {
+		t.Fatalf("geocode_address without an address = %+v, want an error", r)
+	}
+
+	admin := httptest.NewServer((&Server{metrics: m}).newAdminMux())
+	t.Cleanup(admin.Close)
+	resp, err := http.Get(admin.URL + MetricsEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`osmmcp_tool_calls_total{code="ok",tool="geocode_address"} 1`,
+		`osmmcp_tool_calls_total{code="400",tool="geocode_address"} 1`,
+		`osmmcp_upstream_requests_total{code="429",service="nominatim"} 1`,
+		`osmmcp_upstream_requests_total{code="200",service="nominatim"} 1`,
+		`osmmcp_upstream_retries_total{service="nominatim"} 1`,
+		`osmmcp_upstream_rate_limit_wait_seconds_count{service="nominatim"} 2`,
+		`osmmcp_cache_misses_total{namespace="tool_results"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
+
+func TestTracingOfToolCalls(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	client, fake := newFakeClient(t, 1)
+	s, err := NewServer(WithTransport(TransportHTTP), WithOSMClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.newMux(t.Context()))
+	t.Cleanup(ts.Close)
+	c := &mcpClient{t: t, url: ts.URL + MCPEndpoint}
+	c.initialize()
+
+	// The caller's trace is continued through the traceparent header
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	fake.SetFault(osm.ServiceNominatim, fakeosm.Fault{Status: http.StatusServiceUnavailable, Times: 1})
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"reverse_geocode","arguments":{"latitude":52.516275,"longitude":13.377704}}}`
+	req, err := http.NewRequest(http.MethodPost, ts.URL+MCPEndpoint, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(sessionIDHeader, c.session)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	byName := make(map[string][]tracetest.SpanStub)
+	for _, span := range spans {
+		if span.SpanContext.TraceID().String() != traceID {
+			t.Errorf("span %q is in trace %s, want the caller's", span.Name, span.SpanContext.TraceID())
+		}
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	tool := byName["tool reverse_geocode"]
+	if len(tool) != 1 {
+		t.Fatalf("got spans %v, want one tool span", slices.Collect(maps.Keys(byName)))
+	}
+	attrs := attributeMap(tool[0].Attributes)
+	if attrs["tool.argument.latitude"] != "52.52" || attrs["tool.result_code"] != "ok" {
+		t.Errorf("tool span attributes = %v, want rounded coordinates and the result code", attrs)
+	}
+
+	attempts := byName["GET nominatim"]
+	if len(attempts) != 2 {
+		t.Fatalf("got %d nominatim spans, want 2", len(attempts))
+	}
+	for i, span := range attempts {
+		attrs := attributeMap(span.Attributes)
+		if span.Parent.SpanID() != tool[0].SpanContext.SpanID() {
+			t.Errorf("attempt %d is not a child of the tool span", i+1)
+		}
+		wantStatus := []string{"503", "200"}[i]
+		if attrs["http.response.status_code"] != wantStatus || attrs["http.request.resend_count"] != strconv.Itoa(i) {
+			t.Errorf("attempt %d attributes = %v, want status %s", i+1, attrs, wantStatus)
+		}
+	}
+	if waits := byName["rate limit wait"]; len(waits) != 2 || waits[0].Parent.SpanID() != attempts[0].SpanContext.SpanID() {
+		t.Errorf("got %d rate limit wait spans, want one inside each attempt", len(waits))
+	}
+}
+
+// attributeMap formats span attributes for comparison
+func attributeMap(attrs []attribute.KeyValue) map[string]string {
+	out := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		out[string(a.Key)] = a.Value.Emit()
+	}
+	return out
+}
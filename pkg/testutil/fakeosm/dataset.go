@@ -0,0 +1,150 @@
+package fakeosm
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// Place is a tagged node or way of the dataset
+type Place struct {
+	ID         int64
+	Type       string // "node" or "way"
+	Name       string
+	Lat, Lon   float64
+	Tags       map[string]string
+	Address    Address
+	Importance float64
+}
+
+// Address is the address Nominatim reports for a place
+type Address struct {
+	HouseNumber string
+	Road        string
+	Suburb      string
+	City        string
+	Postcode    string
+	Country     string
+	CountryCode string
+}
+
+// Dataset is the data the fake serves
+type Dataset struct {
+	Places []Place
+}
+
+// Center is the middle of the seeded dataset, in central Berlin
+var Center = struct{ Lat, Lon float64 }{52.5200, 13.4050}
+
+// landmarks are part of every seeded dataset
+var landmarks = []Place{
+	{ID: 518071791, Type: "way", Name: "Brandenburger Tor", Lat: 52.5162699, Lon: 13.3777034,
+		Tags: map[string]string{"tourism": "attraction", "historic": "monument"}, Importance: 0.70,
+		Address: Address{Road: "Pariser Platz", Suburb: "Mitte", Postcode: "10117"}},
+	{ID: 240109189, Type: "node", Name: "Fernsehturm", Lat: 52.5208182, Lon: 13.4094062,
+		Tags: map[string]string{"tourism": "attraction", "man_made": "tower"}, Importance: 0.65,
+		Address: Address{HouseNumber: "1A", Road: "Panoramastraße", Suburb: "Mitte", Postcode: "10178"}},
+	{ID: 3856100103, Type: "node", Name: "Alexanderplatz", Lat: 52.5219184, Lon: 13.4132147,
+		Tags: map[string]string{"public_transport": "station", "railway": "station"}, Importance: 0.60,
+		Address: Address{Road: "Alexanderplatz", Suburb: "Mitte", Postcode: "10178"}},
+	{ID: 24467796, Type: "way", Name: "Berliner Dom", Lat: 52.5190608, Lon: 13.4010250,
+		Tags: map[string]string{"amenity": "place_of_worship", "tourism": "attraction"}, Importance: 0.55,
+		Address: Address{HouseNumber: "1", Road: "Am Lustgarten", Suburb: "Mitte", Postcode: "10178"}},
+}
+
+// kinds are the features generated around the landmarks
+var kinds = []struct {
+	typ  string
+	tags map[string]string
+	name string
+}{
+	{"node", map[string]string{"amenity": "restaurant", "cuisine": "german"}, "Restaurant"},
+	{"node", map[string]string{"amenity": "cafe"}, "Café"},
+	{"node", map[string]string{"amenity": "pharmacy"}, "Apotheke"},
+	{"node", map[string]string{"amenity": "charging_station", "capacity": "2", "socket:type2": "2"}, "Ladestation"},
+	{"way", map[string]string{"amenity": "school", "isced:level": "1"}, "Grundschule"},
+	{"way", map[string]string{"amenity": "parking", "parking": "surface", "capacity": "40"}, "Parkplatz"},
+	{"way", map[string]string{"leisure": "park"}, "Park"},
+	{"node", map[string]string{"shop": "supermarket"}, "Supermarkt"},
+	{"node", map[string]string{"public_transport": "stop_position", "bus": "yes"}, "Haltestelle"},
+	{"way", map[string]string{"amenity": "hospital"}, "Klinikum"},
+}
+
+// roads name the streets of generated addresses
+var roads = []string{
+	"Unter den Linden", "Friedrichstraße", "Karl-Liebknecht-Straße", "Rosenthaler Straße",
+	"Torstraße", "Spandauer Straße", "Oranienburger Straße", "Gormannstraße",
+}
+
+// NewDataset returns the landmarks of central Berlin and count features
+// scattered within 2 km of Center. The same seed gives the same dataset.
+func NewDataset(seed uint64, count int) *Dataset {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	d := &Dataset{}
+	for _, p := range landmarks {
+		p.Address.City, p.Address.Country, p.Address.CountryCode = "Berlin", "Deutschland", "de"
+		d.Places = append(d.Places, p)
+	}
+
+	const spread = 2000 // meters
+	metersPerDegree := osm.EarthRadius * math.Pi / 180
+	for i := range count {
+		kind := kinds[i%len(kinds)]
+		dist := spread * math.Sqrt(rng.Float64())
+		angle := 2 * math.Pi * rng.Float64()
+		lat := Center.Lat + dist*math.Cos(angle)/metersPerDegree
+		lon := Center.Lon + dist*math.Sin(angle)/(metersPerDegree*math.Cos(Center.Lat*math.Pi/180))
+
+		tags := make(map[string]string, len(kind.tags))
+		for k, v := range kind.tags {
+			tags[k] = v
+		}
+		d.Places = append(d.Places, Place{
+			ID:         int64(1000 + i),
+			Type:       kind.typ,
+			Name:       fmt.Sprintf("%s %d", kind.name, i/len(kinds)+1),
+			Lat:        math.Round(lat*1e7) / 1e7,
+			Lon:        math.Round(lon*1e7) / 1e7,
+			Tags:       tags,
+			Importance: 0.1 + 0.2*rng.Float64(),
+			Address: Address{
+				HouseNumber: strconv.Itoa(1 + rng.IntN(120)),
+				Road:        roads[rng.IntN(len(roads))],
+				Suburb:      "Mitte",
+				City:        "Berlin",
+				Postcode:    "10117",
+				Country:     "Deutschland",
+				CountryCode: "de",
+			},
+		})
+	}
+	return d
+}
+
+// nearest returns the place closest to a point and its distance in meters
+func (d *Dataset) nearest(lat, lon float64) (*Place, float64) {
+	var best *Place
+	bestDist := math.Inf(1)
+	for i := range d.Places {
+		p := &d.Places[i]
+		if dist := osm.HaversineDistance(lat, lon, p.Lat, p.Lon); dist < bestDist {
+			best, bestDist = p, dist
+		}
+	}
+	return best, bestDist
+}
+
+// allTags returns the tags of a place including its name
+func (p *Place) allTags() map[string]string {
+	tags := make(map[string]string, len(p.Tags)+1)
+	for k, v := range p.Tags {
+		tags[k] = v
+	}
+	if p.Name != "" {
+		tags["name"] = p.Name
+	}
+	return tags
+}
@@ -0,0 +1,125 @@
+package fakeosm
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reverseRadius is how far from a place reverse lookups still find it
+const reverseRadius = 5000
+
+// licence is sent with every Nominatim result, as the real service does
+const licence = "Data © OpenStreetMap contributors, ODbL 1.0. http://osm.org/copyright"
+
+// classKeys decide the class and type of a result, most significant first
+var classKeys = []string{"tourism", "amenity", "shop", "leisure", "public_transport", "railway", "historic", "man_made"}
+
+// handleSearch answers /search with the places whose name is part of
+// the query, or whose name contains the first part of the query
+func (s *Server) handleSearch(r *http.Request) (int, any) {
+	q := r.URL.Query()
+	query := strings.ToLower(strings.TrimSpace(q.Get("q")))
+	if query == "" {
+		return http.StatusBadRequest, "Nothing to search for."
+	}
+	first := strings.TrimSpace(strings.SplitN(query, ",", 2)[0])
+
+	var found []*Place
+	for i := range s.data.Places {
+		p := &s.data.Places[i]
+		name := strings.ToLower(p.Name)
+		if name != "" && (strings.Contains(query, name) || strings.Contains(name, first)) {
+			found = append(found, p)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Importance > found[j].Importance })
+
+	limit := 10
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	results := []map[string]any{}
+	for _, p := range found {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, nominatimResult(p))
+	}
+	return http.StatusOK, results
+}
+
+// handleReverse answers /reverse with the nearest place
+func (s *Server) handleReverse(r *http.Request) (int, any) {
+	q := r.URL.Query()
+	lat, errLat := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(q.Get("lon"), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": "Parameter 'lat' / 'lon' must be a number within range."}}
+	}
+
+	p, dist := s.data.nearest(lat, lon)
+	if p == nil || dist > reverseRadius {
+		return http.StatusOK, map[string]any{"error": "Unable to geocode"}
+	}
+	return http.StatusOK, nominatimResult(p)
+}
+
+// nominatimResult formats a place as a Nominatim result with address details
+func nominatimResult(p *Place) map[string]any {
+	class, typ := "place", "yes"
+	for _, key := range classKeys {
+		if v, ok := p.Tags[key]; ok {
+			class, typ = key, v
+			break
+		}
+	}
+
+	a := p.Address
+	address := map[string]any{}
+	for key, v := range map[string]string{
+		class:          p.Name,
+		"house_number": a.HouseNumber,
+		"road":         a.Road,
+		"suburb":       a.Suburb,
+		"city":         a.City,
+		"postcode":     a.Postcode,
+		"country":      a.Country,
+		"country_code": a.CountryCode,
+	} {
+		if v != "" {
+			address[key] = v
+		}
+	}
+
+	var parts []string
+	for _, v := range []string{p.Name, a.HouseNumber, a.Road, a.Suburb, a.City, a.Postcode, a.Country} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+
+	return map[string]any{
+		"place_id":     p.ID + 100000,
+		"licence":      licence,
+		"osm_type":     p.Type,
+		"osm_id":       p.ID,
+		"lat":          strconv.FormatFloat(p.Lat, 'f', 7, 64),
+		"lon":          strconv.FormatFloat(p.Lon, 'f', 7, 64),
+		"class":        class,
+		"type":         typ,
+		"place_rank":   30,
+		"importance":   p.Importance,
+		"addresstype":  class,
+		"name":         p.Name,
+		"display_name": strings.Join(parts, ", "),
+		"address":      address,
+		"boundingbox": []string{
+			strconv.FormatFloat(p.Lat-0.0001, 'f', 7, 64),
+			strconv.FormatFloat(p.Lat+0.0001, 'f', 7, 64),
+			strconv.FormatFloat(p.Lon-0.0001, 'f', 7, 64),
+			strconv.FormatFloat(p.Lon+0.0001, 'f', 7, 64),
+		},
+	}
+}
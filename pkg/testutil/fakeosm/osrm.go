@@ -0,0 +1,495 @@
+package fakeosm
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// speeds are the travel speeds of the OSRM profiles in meters per second
+var speeds = map[string]float64{
+	"car":     10,
+	"driving": 10,
+	"bike":    4.5,
+	"cycling": 4.5,
+	"foot":    1.4,
+	"walking": 1.4,
+}
+
+// osrmError is the body OSRM sends with a 400 response
+func osrmError(code, message string) map[string]any {
+	return map[string]any{"code": code, "message": message}
+}
+
+// parseCoordinates parses OSRM's lon,lat;lon,lat path segment
+func parseCoordinates(s string) ([]geo.Location, error) {
+	var points []geo.Location
+	for _, pair := range strings.Split(s, ";") {
+		lonStr, latStr, ok := strings.Cut(pair, ",")
+		lon, errLon := strconv.ParseFloat(lonStr, 64)
+		lat, errLat := strconv.ParseFloat(latStr, 64)
+		if !ok || errLon != nil || errLat != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("invalid coordinate %q", pair)
+		}
+		points = append(points, geo.Location{Latitude: lat, Longitude: lon})
+	}
+	return points, nil
+}
+
+// parseRequest reads the profile speed and coordinates of an OSRM request
+func parseRequest(r *http.Request, minPoints int) (float64, []geo.Location, map[string]any) {
+	speed, ok := speeds[r.PathValue("profile")]
+	if !ok {
+		return 0, nil, osrmError("InvalidOptions", "Unknown profile "+r.PathValue("profile"))
+	}
+	points, err := parseCoordinates(r.PathValue("coordinates"))
+	if err != nil {
+		return 0, nil, osrmError("InvalidQuery", "Query string malformed close to position 0: "+err.Error())
+	}
+	if len(points) < minPoints {
+		return 0, nil, osrmError("InvalidOptions", fmt.Sprintf("Number of coordinates needs to be at least %d", minPoints))
+	}
+	return speed, points, nil
+}
+
+// leg is the path between two waypoints: along the parallel of the start,
+// then along the meridian of the end, so every leg has one corner
+type leg struct {
+	from, corner, to geo.Location
+}
+
+func newLeg(from, to geo.Location) leg {
+	return leg{from: from, corner: geo.Location{Latitude: from.Latitude, Longitude: to.Longitude}, to: to}
+}
+
+// lengths returns the lengths of both parts of the leg in meters
+func (l leg) lengths() (float64, float64) {
+	return osm.HaversineDistance(l.from.Latitude, l.from.Longitude, l.corner.Latitude, l.corner.Longitude),
+		osm.HaversineDistance(l.corner.Latitude, l.corner.Longitude, l.to.Latitude, l.to.Longitude)
+}
+
+// turn returns the modifier of the turn at the corner
+func (l leg) turn() string {
+	east := l.to.Longitude > l.from.Longitude
+	north := l.to.Latitude > l.from.Latitude
+	if east == north {
+		return "left"
+	}
+	return "right"
+}
+
+// handleRoute answers /route with the route through all waypoints
+func (s *Server) handleRoute(r *http.Request) (int, any) {
+	speed, points, errBody := parseRequest(r, 2)
+	if errBody != nil {
+		return http.StatusBadRequest, errBody
+	}
+	q := r.URL.Query()
+	withSteps := q.Get("steps") == "true"
+
+	var legs []any
+	var distance float64
+	geometry := []geo.Location{points[0]}
+	for i := 1; i < len(points); i++ {
+		l := newLeg(points[i-1], points[i])
+		first, second := l.lengths()
+		geometry = append(geometry, l.corner, l.to)
+		distance += first + second
+
+		steps := []any{}
+		if withSteps {
+			steps = s.legSteps(l, speed, q.Get("geometries"))
+		}
+		legs = append(legs, map[string]any{
+			"distance": round(first + second),
+			"duration": round((first + second) / speed),
+			"weight":   round((first + second) / speed),
+			"summary":  s.roadAt(l.from) + ", " + s.roadAt(l.to),
+			"steps":    steps,
+		})
+	}
+
+	route := map[string]any{
+		"distance":    round(distance),
+		"duration":    round(distance / speed),
+		"weight":      round(distance / speed),
+		"weight_name": "routability",
+		"legs":        legs,
+	}
+	if q.Get("overview") != "false" {
+		route["geometry"] = encodeGeometry(q.Get("geometries"), geometry)
+	}
+
+	return http.StatusOK, map[string]any{
+		"code":      "Ok",
+		"routes":    []any{route},
+		"waypoints": s.waypoints(points),
+	}
+}
+
+// handleTable answers /table with the durations, and distances if asked
+// for, between the sources and destinations
+func (s *Server) handleTable(r *http.Request) (int, any) {
+	speed, points, errBody := parseRequest(r, 1)
+	if errBody != nil {
+		return http.StatusBadRequest, errBody
+	}
+	q := r.URL.Query()
+	sources, err := parseIndexes(q.Get("sources"), len(points))
+	if err != nil {
+		return http.StatusBadRequest, osrmError("InvalidOptions", err.Error())
+	}
+	destinations, err := parseIndexes(q.Get("destinations"), len(points))
+	if err != nil {
+		return http.StatusBadRequest, osrmError("InvalidOptions", err.Error())
+	}
+
+	durations := make([][]float64, len(sources))
+	distances := make([][]float64, len(sources))
+	for i, from := range sources {
+		durations[i] = make([]float64, len(destinations))
+		distances[i] = make([]float64, len(destinations))
+		for j, to := range destinations {
+			first, second := newLeg(points[from], points[to]).lengths()
+			distances[i][j] = round(first + second)
+			durations[i][j] = round((first + second) / speed)
+		}
+	}
+
+	resp := map[string]any{
+		"code":         "Ok",
+		"sources":      s.waypoints(pick(points, sources)),
+		"destinations": s.waypoints(pick(points, destinations)),
+	}
+	annotations := q.Get("annotations")
+	if annotations == "" || strings.Contains(annotations, "duration") {
+		resp["durations"] = durations
+	}
+	if strings.Contains(annotations, "distance") {
+		resp["distances"] = distances
+	}
+	return http.StatusOK, resp
+}
+
+// handleTrip answers /trip with a nearest neighbour tour of the
+// waypoints. Like OSRM it only leaves both ends open on round trips.
+func (s *Server) handleTrip(r *http.Request) (int, any) {
+	speed, points, errBody := parseRequest(r, 2)
+	if errBody != nil {
+		return http.StatusBadRequest, errBody
+	}
+	q := r.URL.Query()
+	roundTrip := q.Get("roundtrip") != "false"
+	fixedStart := q.Get("source") == "first"
+	fixedEnd := q.Get("destination") == "last"
+	if !roundTrip && !fixedStart && !fixedEnd {
+		return http.StatusBadRequest, osrmError("NotImplemented", "This request is not supported")
+	}
+
+	// Visit the nearest unvisited waypoint next, keeping a fixed end last
+	order := []int{0}
+	visited := map[int]bool{0: true}
+	if fixedEnd {
+		visited[len(points)-1] = true
+	}
+	for len(visited) < len(points) {
+		last, next, best := order[len(order)-1], -1, math.Inf(1)
+		for i := range points {
+			if visited[i] {
+				continue
+			}
+			first, second := newLeg(points[last], points[i]).lengths()
+			if first+second < best {
+				next, best = i, first+second
+			}
+		}
+		order = append(order, next)
+		visited[next] = true
+	}
+	if fixedEnd {
+		order = append(order, len(points)-1)
+	}
+
+	stops := pick(points, order)
+	if roundTrip {
+		stops = append(stops, stops[0])
+	}
+	var legs []any
+	var distance float64
+	geometry := []geo.Location{stops[0]}
+	for i := 1; i < len(stops); i++ {
+		l := newLeg(stops[i-1], stops[i])
+		first, second := l.lengths()
+		geometry = append(geometry, l.corner, l.to)
+		distance += first + second
+		legs = append(legs, map[string]any{
+			"distance": round(first + second),
+			"duration": round((first + second) / speed),
+			"weight":   round((first + second) / speed),
+			"summary":  "",
+			"steps":    []any{},
+		})
+	}
+
+	trip := map[string]any{
+		"distance":    round(distance),
+		"duration":    round(distance / speed),
+		"weight":      round(distance / speed),
+		"weight_name": "routability",
+		"legs":        legs,
+	}
+	if q.Get("overview") != "false" {
+		trip["geometry"] = encodeGeometry(q.Get("geometries"), geometry)
+	}
+
+	waypoints := s.waypoints(points)
+	for position, i := range order {
+		w := waypoints[i].(map[string]any)
+		w["waypoint_index"] = position
+		w["trips_index"] = 0
+	}
+	return http.StatusOK, map[string]any{
+		"code":      "Ok",
+		"trips":     []any{trip},
+		"waypoints": waypoints,
+	}
+}
+
+// maxMatchGap is the time between trace points, in seconds, at which
+// OSRM splits a trace when asked to with gaps=split
+const maxMatchGap = 60
+
+// handleMatch answers /match with every trace point matched where it
+// lies. The trace is split at gaps in the timestamps when asked to, and
+// sections of a single point stay unmatched.
+func (s *Server) handleMatch(r *http.Request) (int, any) {
+	speed, points, errBody := parseRequest(r, 2)
+	if errBody != nil {
+		return http.StatusBadRequest, errBody
+	}
+	q := r.URL.Query()
+
+	var timestamps []int64
+	if ts := q.Get("timestamps"); ts != "" {
+		for _, f := range strings.Split(ts, ";") {
+			t, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return http.StatusBadRequest, osrmError("InvalidQuery", "Query string malformed close to position 0: invalid timestamp "+f)
+			}
+			timestamps = append(timestamps, t)
+		}
+		if len(timestamps) != len(points) {
+			return http.StatusBadRequest, osrmError("InvalidOptions", "Number of timestamps does not match number of coordinates")
+		}
+	}
+
+	// Split the trace into sections of consecutive point indexes
+	var sections [][]int
+	for i := range points {
+		gap := i > 0 && timestamps != nil && q.Get("gaps") == "split" && timestamps[i]-timestamps[i-1] > maxMatchGap
+		if i == 0 || gap {
+			sections = append(sections, nil)
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], i)
+	}
+
+	matchings := []any{}
+	tracepoints := make([]any, len(points))
+	for _, section := range sections {
+		if len(section) < 2 {
+			continue
+		}
+		var legs []any
+		var distance float64
+		geometry := []geo.Location{points[section[0]]}
+		for k := 1; k < len(section); k++ {
+			l := newLeg(points[section[k-1]], points[section[k]])
+			first, second := l.lengths()
+			geometry = append(geometry, l.corner, l.to)
+			distance += first + second
+			legs = append(legs, map[string]any{
+				"distance": round(first + second),
+				"duration": round((first + second) / speed),
+				"weight":   round((first + second) / speed),
+				"summary":  "",
+				"steps":    s.legSteps(l, speed, q.Get("geometries")),
+			})
+		}
+
+		matching := map[string]any{
+			"confidence":  0.9,
+			"distance":    round(distance),
+			"duration":    round(distance / speed),
+			"weight":      round(distance / speed),
+			"weight_name": "routability",
+			"legs":        legs,
+		}
+		if q.Get("overview") != "false" {
+			matching["geometry"] = encodeGeometry(q.Get("geometries"), geometry)
+		}
+
+		for k, i := range section {
+			w := s.waypoints(points[i : i+1])[0].(map[string]any)
+			w["matchings_index"] = len(matchings)
+			w["waypoint_index"] = k
+			w["alternatives_count"] = 0
+			tracepoints[i] = w
+		}
+		matchings = append(matchings, matching)
+	}
+
+	if len(matchings) == 0 {
+		return http.StatusBadRequest, osrmError("NoMatch", "Could not match the trace.")
+	}
+	return http.StatusOK, map[string]any{
+		"code":        "Ok",
+		"matchings":   matchings,
+		"tracepoints": tracepoints,
+	}
+}
+
+// handleNearest answers /nearest with the places nearest to the point,
+// as if every place lay on its road
+func (s *Server) handleNearest(r *http.Request) (int, any) {
+	_, points, errBody := parseRequest(r, 1)
+	if errBody != nil {
+		return http.StatusBadRequest, errBody
+	}
+	if len(points) != 1 {
+		return http.StatusBadRequest, osrmError("InvalidOptions", "Only one input coordinate is supported")
+	}
+	number := 1
+	if n := r.URL.Query().Get("number"); n != "" {
+		var err error
+		if number, err = strconv.Atoi(n); err != nil || number < 1 {
+			return http.StatusBadRequest, osrmError("InvalidOptions", "Number of results must be a positive integer")
+		}
+	}
+
+	p := points[0]
+	places := make([]*Place, len(s.data.Places))
+	for i := range s.data.Places {
+		places[i] = &s.data.Places[i]
+	}
+	dist := func(place *Place) float64 {
+		return osm.HaversineDistance(p.Latitude, p.Longitude, place.Lat, place.Lon)
+	}
+	sort.SliceStable(places, func(i, j int) bool { return dist(places[i]) < dist(places[j]) })
+
+	waypoints := []any{}
+	for _, place := range places[:min(number, len(places))] {
+		waypoints = append(waypoints, map[string]any{
+			"name":     place.Address.Road,
+			"location": []float64{place.Lon, place.Lat},
+			"distance": round(dist(place)),
+			"nodes":    []int64{place.ID, 0},
+		})
+	}
+	if len(waypoints) == 0 {
+		return http.StatusBadRequest, osrmError("NoSegment", "Could not find a matching segment for coordinate")
+	}
+	return http.StatusOK, map[string]any{"code": "Ok", "waypoints": waypoints}
+}
+
+// parseIndexes parses a sources or destinations list; "" and "all"
+// select every coordinate
+func parseIndexes(s string, n int) ([]int, error) {
+	var indexes []int
+	if s == "" || s == "all" {
+		for i := range n {
+			indexes = append(indexes, i)
+		}
+		return indexes, nil
+	}
+	for _, f := range strings.Split(s, ";") {
+		i, err := strconv.Atoi(f)
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("invalid coordinate index %q", f)
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+// pick returns the points at the given indexes
+func pick(points []geo.Location, indexes []int) []geo.Location {
+	picked := make([]geo.Location, len(indexes))
+	for i, index := range indexes {
+		picked[i] = points[index]
+	}
+	return picked
+}
+
+// legSteps formats the maneuvers of a leg: the departure, the turn at
+// its corner and the arrival
+func (s *Server) legSteps(l leg, speed float64, geometries string) []any {
+	first, second := l.lengths()
+	return []any{
+		step(s.roadAt(l.from), "depart", "", first, speed, []geo.Location{l.from, l.corner}, geometries),
+		step(s.roadAt(l.to), "turn", l.turn(), second, speed, []geo.Location{l.corner, l.to}, geometries),
+		step(s.roadAt(l.to), "arrive", "", 0, speed, []geo.Location{l.to, l.to}, geometries),
+	}
+}
+
+// step formats one maneuver of a leg and the path followed after it,
+// which starts at the maneuver
+func step(name, typ, modifier string, distance, speed float64, path []geo.Location, geometries string) map[string]any {
+	at := path[0]
+	maneuver := map[string]any{"type": typ, "location": []float64{at.Longitude, at.Latitude}}
+	if modifier != "" {
+		maneuver["modifier"] = modifier
+	}
+	return map[string]any{
+		"distance": round(distance),
+		"duration": round(distance / speed),
+		"name":     name,
+		"mode":     "driving",
+		"geometry": encodeGeometry(geometries, path),
+		"maneuver": maneuver,
+	}
+}
+
+// waypoints formats the snapped waypoints of a response. Points are not
+// moved, so every waypoint lies where it was asked for.
+func (s *Server) waypoints(points []geo.Location) []any {
+	out := make([]any, len(points))
+	for i, p := range points {
+		out[i] = map[string]any{
+			"name":     s.roadAt(p),
+			"location": []float64{p.Longitude, p.Latitude},
+			"distance": 0,
+		}
+	}
+	return out
+}
+
+// roadAt names the road of the place nearest to a point
+func (s *Server) roadAt(p geo.Location) string {
+	if place, _ := s.data.nearest(p.Latitude, p.Longitude); place != nil {
+		return place.Address.Road
+	}
+	return ""
+}
+
+// encodeGeometry formats a route geometry as OSRM's geometries option asks
+func encodeGeometry(format string, points []geo.Location) any {
+	if format == "geojson" {
+		coords := make([][]float64, len(points))
+		for i, p := range points {
+			coords[i] = []float64{p.Longitude, p.Latitude}
+		}
+		return map[string]any{"type": "LineString", "coordinates": coords}
+	}
+	return osm.EncodePolyline(points)
+}
+
+// round rounds to a tenth, as OSRM reports distances and durations
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
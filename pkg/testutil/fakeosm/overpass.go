@@ -0,0 +1,210 @@
+package fakeosm
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+var (
+	// statementRe matches one query statement, such as
+	// node["amenity"="cafe"](around:500,52.52,13.40);
+	statementRe = regexp.MustCompile(`(node|way|relation|nwr)((?:\[[^\]]*\]|\([^)]*\))+);`)
+
+	// clauseRe splits a statement into its tag filters and area
+	clauseRe = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)`)
+
+	// outRe matches the output statement
+	outRe = regexp.MustCompile(`out\s*(\w*)\s*;`)
+)
+
+// query is the subset of Overpass QL the tools send: a union of
+// statements, each selecting one element type by tags within a circle
+// or bounding box
+type query struct {
+	statements []statement
+	center     bool // "out center" asks for the centers of ways
+}
+
+// statement selects elements of one type
+type statement struct {
+	typ     string
+	filters []tagFilter
+	contain func(lat, lon float64) bool
+}
+
+// tagFilter is a [key], [key=value] or [key~regex] clause
+type tagFilter struct {
+	key   string
+	value string
+	regex *regexp.Regexp
+}
+
+// matches reports whether tags pass the filter
+func (f tagFilter) matches(tags map[string]string) bool {
+	v, ok := tags[f.key]
+	switch {
+	case !ok:
+		return false
+	case f.regex != nil:
+		return f.regex.MatchString(v)
+	case f.value != "":
+		return v == f.value
+	}
+	return true
+}
+
+// parseQuery parses the statements of an Overpass query
+func parseQuery(ql string) (*query, error) {
+	q := &query{}
+	for _, m := range statementRe.FindAllStringSubmatch(ql, -1) {
+		st := statement{typ: m[1]}
+		for _, clause := range clauseRe.FindAllString(m[2], -1) {
+			inner := clause[1 : len(clause)-1]
+			if clause[0] == '(' {
+				contain, err := parseArea(inner)
+				if err != nil {
+					return nil, err
+				}
+				st.contain = contain
+				continue
+			}
+			f, err := parseTagFilter(inner)
+			if err != nil {
+				return nil, err
+			}
+			st.filters = append(st.filters, f)
+		}
+		if st.contain == nil {
+			return nil, fmt.Errorf("statement %q has no area", m[0])
+		}
+		q.statements = append(q.statements, st)
+	}
+	if len(q.statements) == 0 {
+		return nil, fmt.Errorf("no statements in query")
+	}
+	if m := outRe.FindStringSubmatch(ql); m != nil {
+		q.center = m[1] == "center"
+	}
+	return q, nil
+}
+
+// parseArea parses (around:radius,lat,lon) or (south,west,north,east)
+func parseArea(s string) (func(lat, lon float64) bool, error) {
+	around := strings.HasPrefix(s, "around:")
+	fields := strings.Split(strings.TrimPrefix(s, "around:"), ",")
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid area %q", s)
+		}
+		values[i] = v
+	}
+
+	switch {
+	case around && len(values) == 3:
+		radius, clat, clon := values[0], values[1], values[2]
+		return func(lat, lon float64) bool {
+			return osm.HaversineDistance(clat, clon, lat, lon) <= radius
+		}, nil
+	case !around && len(values) == 4:
+		south, west, north, east := values[0], values[1], values[2], values[3]
+		return func(lat, lon float64) bool {
+			return lat >= south && lat <= north && lon >= west && lon <= east
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid area %q", s)
+}
+
+// parseTagFilter parses the inside of a tag filter clause
+func parseTagFilter(s string) (tagFilter, error) {
+	unquote := func(v string) string { return strings.Trim(strings.TrimSpace(v), `"`) }
+
+	if key, pattern, ok := strings.Cut(s, "~"); ok {
+		re, err := regexp.Compile(unquote(pattern))
+		if err != nil {
+			return tagFilter{}, fmt.Errorf("invalid regular expression in [%s]: %w", s, err)
+		}
+		return tagFilter{key: unquote(key), regex: re}, nil
+	}
+	if key, value, ok := strings.Cut(s, "="); ok {
+		return tagFilter{key: unquote(key), value: unquote(value)}, nil
+	}
+	return tagFilter{key: unquote(s)}, nil
+}
+
+// handleInterpreter answers an Overpass query sent as the data form
+// field, or as the raw body
+func (s *Server) handleInterpreter(r *http.Request) (int, any) {
+	ql := r.URL.Query().Get("data")
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return http.StatusBadRequest, err.Error()
+		}
+		ql = string(body)
+		if form, err := url.ParseQuery(ql); err == nil && form.Has("data") {
+			ql = form.Get("data")
+		}
+	}
+
+	q, err := parseQuery(ql)
+	if err != nil {
+		return http.StatusBadRequest, "Error: line 1: parse error: " + err.Error()
+	}
+
+	elements := []map[string]any{}
+	seen := make(map[int64]bool)
+	for _, st := range q.statements {
+		for i := range s.data.Places {
+			p := &s.data.Places[i]
+			if seen[p.ID] || !st.selects(p) {
+				continue
+			}
+			seen[p.ID] = true
+			elements = append(elements, element(p, q.center))
+		}
+	}
+	return http.StatusOK, map[string]any{
+		"version":   0.6,
+		"generator": "Overpass API (fakeosm)",
+		"elements":  elements,
+	}
+}
+
+// selects reports whether a statement selects a place
+func (st statement) selects(p *Place) bool {
+	if st.typ != "nwr" && st.typ != p.Type {
+		return false
+	}
+	if !st.contain(p.Lat, p.Lon) {
+		return false
+	}
+	tags := p.allTags()
+	for _, f := range st.filters {
+		if !f.matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// element formats a place as an Overpass element. Ways only carry their
+// center when the query asked for it.
+func element(p *Place, center bool) map[string]any {
+	e := map[string]any{"type": p.Type, "id": p.ID, "tags": p.allTags()}
+	switch {
+	case p.Type == "node":
+		e["lat"], e["lon"] = p.Lat, p.Lon
+	case center:
+		e["center"] = map[string]float64{"lat": p.Lat, "lon": p.Lon}
+	}
+	return e
+}
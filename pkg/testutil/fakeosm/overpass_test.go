@@ -0,0 +1,68 @@
+package fakeosm
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+func TestInterpreter(t *testing.T) {
+	s := NewServer(NewDataset(1, 40))
+	defer s.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		want  func(tags map[string]string) bool
+	}{
+		{
+			name:  "tile filter",
+			query: `[out:json];(node["amenity"~"^(cafe|restaurant)$"](52.5,13.38,52.54,13.42););out center;`,
+			want:  func(tags map[string]string) bool { return tags["amenity"] == "cafe" || tags["amenity"] == "restaurant" },
+		},
+		{
+			name:  "key only",
+			query: `[out:json];(node(around:1500.000000,52.520008,13.404954)[shop];);out body;`,
+			want:  func(tags map[string]string) bool { return tags["shop"] != "" },
+		},
+		{
+			name:  "key and value",
+			query: `[out:json];(way(around:3000,52.52,13.405)[amenity=school];);out center;`,
+			want:  func(tags map[string]string) bool { return tags["amenity"] == "school" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out struct {
+				Elements []struct {
+					Type   string            `json:"type"`
+					Lat    float64           `json:"lat"`
+					Center *struct{}         `json:"center"`
+					Tags   map[string]string `json:"tags"`
+				} `json:"elements"`
+			}
+			status := postJSON(t, s.BaseURL(osm.ServiceOverpass), "data="+url.QueryEscape(tt.query), &out)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d", status)
+			}
+			if len(out.Elements) == 0 {
+				t.Fatal("query selected nothing")
+			}
+			for _, e := range out.Elements {
+				if !tt.want(e.Tags) {
+					t.Errorf("element with tags %v does not match", e.Tags)
+				}
+				if e.Type == "way" && (e.Center != nil) != strings.Contains(tt.query, "out center") {
+					t.Errorf("way center = %v, want it only for out center", e.Center)
+				}
+			}
+		})
+	}
+
+	if status := postJSON(t, s.BaseURL(osm.ServiceOverpass), "data=out;", nil); status != http.StatusBadRequest {
+		t.Errorf("invalid query status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,226 @@
+// Package fakeosm serves the parts of the Nominatim, Overpass and OSRM
+// APIs that osmmcp uses from a small seeded dataset, so tests can run
+// the tools end to end without network access. Faults such as latency,
+// rate limiting and malformed responses can be injected per service.
+package fakeosm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// Fault changes how the fake answers requests to one service
+type Fault struct {
+	// Latency delays every affected response
+	Latency time.Duration
+
+	// Status, if set, replaces the response with an empty error response
+	// of this status, for example http.StatusTooManyRequests
+	Status int
+
+	// RetryAfter is sent as the Retry-After header of Status responses
+	RetryAfter string
+
+	// Malformed cuts the JSON response body off halfway
+	Malformed bool
+
+	// Times is the number of requests affected; 0 affects all of them
+	Times int
+}
+
+// Request is a request received by the fake
+type Request struct {
+	Method string
+	URL    string
+	Time   time.Time
+}
+
+// Server is a running fake of the upstream services
+type Server struct {
+	// URL is the root URL of the fake; see BaseURL for each service
+	URL string
+
+	data *Dataset
+	ts   *httptest.Server
+
+	mu       sync.Mutex
+	faults   map[string]*Fault
+	requests map[string][]Request
+}
+
+// NewServer starts a fake serving data. It must be closed after use.
+func NewServer(data *Dataset) *Server {
+	s := &Server{
+		data:     data,
+		faults:   make(map[string]*Fault),
+		requests: make(map[string][]Request),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /nominatim/search", s.serve(osm.ServiceNominatim, s.handleSearch))
+	mux.Handle("GET /nominatim/reverse", s.serve(osm.ServiceNominatim, s.handleReverse))
+	mux.Handle("/overpass/api/interpreter", s.serve(osm.ServiceOverpass, s.handleInterpreter))
+	mux.HandleFunc("GET /overpass/api/status", handleStatus)
+	mux.Handle("GET /osrm/route/v1/{profile}/{coordinates}", s.serve(osm.ServiceOSRM, s.handleRoute))
+	mux.Handle("GET /osrm/table/v1/{profile}/{coordinates}", s.serve(osm.ServiceOSRM, s.handleTable))
+	mux.Handle("GET /osrm/trip/v1/{profile}/{coordinates}", s.serve(osm.ServiceOSRM, s.handleTrip))
+	mux.Handle("GET /osrm/match/v1/{profile}/{coordinates}", s.serve(osm.ServiceOSRM, s.handleMatch))
+	mux.Handle("GET /osrm/nearest/v1/{profile}/{coordinates}", s.serve(osm.ServiceOSRM, s.handleNearest))
+
+	s.ts = httptest.NewServer(mux)
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close shuts the fake down
+func (s *Server) Close() {
+	s.ts.Close()
+}
+
+// BaseURL returns the base URL of a service as osm.SetBaseURL expects it
+func (s *Server) BaseURL(service string) string {
+	switch service {
+	case osm.ServiceNominatim:
+		return s.URL + "/nominatim"
+	case osm.ServiceOverpass:
+		return s.URL + "/overpass/api/interpreter"
+	case osm.ServiceOSRM:
+		return s.URL + "/osrm"
+	}
+	return ""
+}
+
+// Install points every service of the default osm client at the fake.
+// The returned function restores the previous endpoints.
+func (s *Server) Install() (restore func(), err error) {
+	return s.InstallOn(osm.Default())
+}
+
+// InstallOn points every service of c at the fake. The returned function
+// restores the previous endpoints.
+func (s *Server) InstallOn(c *osm.Client) (restore func(), err error) {
+	saved := make(map[string][]string)
+	restore = func() {
+		for service, urls := range saved {
+			mirrors := make([]osm.Mirror, len(urls))
+			for i, u := range urls {
+				mirrors[i] = osm.Mirror{BaseURL: u}
+			}
+			c.SetMirrors(service, mirrors...)
+		}
+	}
+	for _, service := range osm.Services() {
+		saved[service] = c.BaseURLs(service)
+		if err := c.SetBaseURL(service, s.BaseURL(service)); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+// SetFault injects a fault into the responses of a service, replacing
+// any earlier fault of that service
+func (s *Server) SetFault(service string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[service] = &f
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.faults)
+}
+
+// Requests returns the requests a service received, in order
+func (s *Server) Requests(service string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests[service]...)
+}
+
+// handlerFunc answers a request with a status and a value to send as JSON
+type handlerFunc func(r *http.Request) (int, any)
+
+// serve records requests to a service and applies its fault
+func (s *Server) serve(service string, h handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := s.record(service, r)
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			http.Error(w, http.StatusText(fault.Status), fault.Status)
+			return
+		}
+
+		status, v := h(r)
+		if text, ok := v.(string); ok {
+			http.Error(w, text, status)
+			return
+		}
+		body, err := json.Marshal(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if fault.Malformed {
+			body = body[:len(body)/2]
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(body)
+	})
+}
+
+// record logs a request and returns the fault that applies to it
+func (s *Server) record(service string, r *http.Request) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[service] = append(s.requests[service], Request{
+		Method: r.Method,
+		URL:    r.URL.String(),
+		Time:   time.Now(),
+	})
+
+	f, ok := s.faults[service]
+	if !ok {
+		return Fault{}
+	}
+	if f.Times > 0 {
+		f.Times--
+		if f.Times == 0 {
+			delete(s.faults, service)
+		}
+	}
+	return *f
+}
+
+// handleStatus reports an Overpass instance without a slot limit
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(strings.Join([]string{
+		"Connected as: 0",
+		"Current time: " + time.Now().UTC().Format(time.RFC3339),
+		"Rate limit: 0",
+		"Currently running queries (pid, space limit, time limit, start time):",
+		"",
+	}, "\n")))
+}
@@ -0,0 +1,223 @@
+package fakeosm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// getJSONErr decodes the JSON response to a GET request
+func getJSONErr(u string, v any) error {
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func getJSON(t *testing.T, u string, v any) int {
+	t.Helper()
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func postJSON(t *testing.T, u, body string, v any) int {
+	t.Helper()
+	resp, err := http.Post(u, "application/x-www-form-urlencoded", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("POST %s: %v", u, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestFaults(t *testing.T) {
+	s := NewServer(NewDataset(1, 10))
+	defer s.Close()
+	search := s.BaseURL(osm.ServiceNominatim) + "/search?format=json&q=" + url.QueryEscape("Fernsehturm, Berlin")
+
+	s.SetFault(osm.ServiceNominatim, Fault{Status: http.StatusTooManyRequests, RetryAfter: "2", Times: 1})
+	resp, err := http.Get(search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("faulty response = %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	var results []map[string]any
+	if status := getJSON(t, search, &results); status != http.StatusOK || len(results) != 1 {
+		t.Errorf("search after fault = %d, %v", status, results)
+	}
+
+	s.SetFault(osm.ServiceNominatim, Fault{Malformed: true})
+	if err := getJSONErr(search, &results); err == nil {
+		t.Error("malformed response decoded without error")
+	}
+	if n := len(s.Requests(osm.ServiceNominatim)); n != 3 {
+		t.Errorf("recorded %d requests, want 3", n)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	s := NewServer(NewDataset(1, 10))
+	defer s.Close()
+	osrm := s.BaseURL(osm.ServiceOSRM)
+
+	var route struct {
+		Code   string `json:"code"`
+		Routes []struct {
+			Distance float64 `json:"distance"`
+			Geometry string  `json:"geometry"`
+			Legs     []struct {
+				Steps []struct {
+					Geometry string `json:"geometry"`
+					Maneuver struct {
+						Type     string `json:"type"`
+						Modifier string `json:"modifier"`
+					} `json:"maneuver"`
+				} `json:"steps"`
+			} `json:"legs"`
+		} `json:"routes"`
+	}
+	if status := getJSON(t, osrm+"/route/v1/car/13.3777,52.5163;13.4094,52.5208?steps=true", &route); status != http.StatusOK {
+		t.Fatalf("route status = %d", status)
+	}
+	if route.Code != "Ok" || len(route.Routes) != 1 || len(route.Routes[0].Legs) != 1 {
+		t.Fatalf("route = %+v", route)
+	}
+	r := route.Routes[0]
+	if got := len(osm.DecodePolyline(r.Geometry)); got != 3 {
+		t.Errorf("geometry has %d points, want 3", got)
+	}
+	steps := r.Legs[0].Steps
+	if len(steps) != 3 || steps[0].Maneuver.Type != "depart" || steps[1].Maneuver.Modifier != "left" || steps[2].Maneuver.Type != "arrive" {
+		t.Errorf("steps = %+v, want depart, turn left, arrive", steps)
+	}
+	if path := osm.DecodePolyline(steps[1].Geometry); len(path) != 2 || path[1] != osm.DecodePolyline(r.Geometry)[2] {
+		t.Errorf("turn geometry = %v, want the corner and the end", path)
+	}
+
+	var table struct {
+		Durations [][]float64 `json:"durations"`
+		Distances [][]float64 `json:"distances"`
+	}
+	if status := getJSON(t, osrm+"/table/v1/foot/13.3777,52.5163;13.4094,52.5208;13.40,52.52?sources=0&annotations=duration,distance", &table); status != http.StatusOK {
+		t.Fatalf("table status = %d", status)
+	}
+	if len(table.Durations) != 1 || len(table.Durations[0]) != 3 || table.Durations[0][0] != 0 {
+		t.Errorf("durations = %v, want one row of three from the first point", table.Durations)
+	}
+	if d := table.Distances[0][1]; d != r.Distance {
+		t.Errorf("table distance = %v, want the route distance %v", d, r.Distance)
+	}
+
+	if status := getJSON(t, osrm+"/route/v1/car/13.3777,52.5163", nil); status != http.StatusBadRequest {
+		t.Errorf("single coordinate status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestTrip(t *testing.T) {
+	s := NewServer(NewDataset(1, 10))
+	defer s.Close()
+	trip := s.BaseURL(osm.ServiceOSRM) + "/trip/v1/car/13.37,52.51;13.42,52.51;13.38,52.51;13.41,52.51"
+
+	var resp struct {
+		Code  string `json:"code"`
+		Trips []struct {
+			Legs []struct{} `json:"legs"`
+		} `json:"trips"`
+		Waypoints []struct {
+			WaypointIndex int `json:"waypoint_index"`
+		} `json:"waypoints"`
+	}
+	if status := getJSON(t, trip+"?roundtrip=false&source=first&destination=last", &resp); status != http.StatusOK {
+		t.Fatalf("trip status = %d", status)
+	}
+	var order []int
+	for _, w := range resp.Waypoints {
+		order = append(order, w.WaypointIndex)
+	}
+	if want := []int{0, 2, 1, 3}; len(resp.Trips) != 1 || len(resp.Trips[0].Legs) != 3 || !slices.Equal(order, want) {
+		t.Errorf("trip = %+v, want 3 legs and waypoint indexes %v", resp, want)
+	}
+
+	if status := getJSON(t, trip+"?roundtrip=true", &resp); status != http.StatusOK || len(resp.Trips[0].Legs) != 4 {
+		t.Errorf("round trip = %d, %+v, want 4 legs", status, resp)
+	}
+	if status := getJSON(t, trip+"?roundtrip=false", nil); status != http.StatusBadRequest {
+		t.Errorf("open trip status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	s := NewServer(NewDataset(1, 10))
+	defer s.Close()
+	match := s.BaseURL(osm.ServiceOSRM) + "/match/v1/car/13.37,52.51;13.38,52.51;13.39,52.52;13.40,52.52;13.41,52.52"
+
+	var resp struct {
+		Matchings []struct {
+			Legs []struct{} `json:"legs"`
+		} `json:"matchings"`
+		Tracepoints []*struct {
+			MatchingsIndex int `json:"matchings_index"`
+		} `json:"tracepoints"`
+	}
+	if status := getJSON(t, match+"?gaps=split&timestamps=0%3B10%3B200%3B210%3B220", &resp); status != http.StatusOK {
+		t.Fatalf("match status = %d", status)
+	}
+	if len(resp.Matchings) != 2 || len(resp.Matchings[0].Legs) != 1 || len(resp.Matchings[1].Legs) != 2 {
+		t.Errorf("matchings = %+v, want 1 and 2 legs split at the gap", resp.Matchings)
+	}
+	if tp := resp.Tracepoints; len(tp) != 5 || tp[1].MatchingsIndex != 0 || tp[2].MatchingsIndex != 1 {
+		t.Errorf("tracepoints = %+v, want the last three in the second matching", tp)
+	}
+
+	if status := getJSON(t, match+"?gaps=split&timestamps=0%3B100%3B200%3B300%3B400", nil); status != http.StatusBadRequest {
+		t.Errorf("unmatchable trace status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	s := NewServer(NewDataset(1, 10))
+	defer s.Close()
+
+	var resp struct {
+		Waypoints []struct {
+			Name     string    `json:"name"`
+			Location []float64 `json:"location"`
+			Distance float64   `json:"distance"`
+		} `json:"waypoints"`
+	}
+	if status := getJSON(t, s.BaseURL(osm.ServiceOSRM)+"/nearest/v1/foot/13.3779,52.5163?number=3", &resp); status != http.StatusOK {
+		t.Fatalf("nearest status = %d", status)
+	}
+	w := resp.Waypoints
+	if len(w) != 3 || w[0].Location[0] != 13.3777034 || w[0].Name == "" {
+		t.Fatalf("waypoints = %+v, want 3 starting at the Brandenburger Tor", w)
+	}
+	if w[0].Distance > w[1].Distance || w[1].Distance > w[2].Distance {
+		t.Errorf("waypoints = %+v, want them nearest first", w)
+	}
+}
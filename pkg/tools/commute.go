@@ -6,11 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"strings"
 
-	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
@@ -24,6 +21,8 @@ type CommuteOption struct {
 	CO2Emission    float64  `json:"co2_emission,omitempty"`    // in kg, if available
 	CaloriesBurned float64  `json:"calories_burned,omitempty"` // if applicable (walking, cycling)
 	Cost           float64  `json:"cost,omitempty"`            // estimated cost in local currency, if available
+
+	Snaps []SnapNotice `json:"snaps,omitempty"` // home or work moved to a road for this mode
 }
 
 // CommuteAnalysis represents the full analysis of commute options
@@ -140,93 +139,23 @@ func HandleAnalyzeCommute(ctx context.Context, req mcp.CallToolRequest) (*mcp.Ca
 	}
 
 	// Get routes for each mode
+	units := stateFrom(ctx).units
 	for _, mode := range modes {
 		// Map mode to OSRM profile
 		profile := mapModeToProfile(mode)
 
-		// Build OSRM request URL
-		baseURL := fmt.Sprintf("%s/route/v1/%s", osm.OSRMBaseURL, profile)
-		coordinates := fmt.Sprintf("%f,%f;%f,%f", homeLon, homeLat, workLon, workLat)
-
-		reqURL, err := url.Parse(baseURL + "/" + coordinates)
-		if err != nil {
-			logger.Error("failed to parse URL", "error", err)
-			continue
-		}
-
-		// Add query parameters
-		q := reqURL.Query()
-		q.Add("overview", "simplified") // Simplified geometry
-		q.Add("steps", "true")          // Include turn-by-turn instructions
-		reqURL.RawQuery = q.Encode()
-
-		// Make HTTP request
-		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
-		if err != nil {
-			logger.Error("failed to create request", "error", err)
-			continue
-		}
-
-		httpReq.Header.Set("User-Agent", osm.UserAgent)
-
-		// Execute request
-		client := osm.GetClient(ctx)
-		resp, err := client.Do(httpReq)
+		osrmRoute, err := fetchRoute(ctx, profile, homeLat, homeLon, workLat, workLon)
 		if err != nil {
-			logger.Error("failed to execute request", "error", err)
+			logger.Error("failed to get route", "mode", mode, "error", err)
 			continue
 		}
 
-		// Process response
-		if resp.StatusCode != http.StatusOK {
-			logger.Error("routing service returned error", "status", resp.StatusCode)
-			resp.Body.Close()
-			continue
-		}
-
-		// Parse OSRM response
-		var osrmResp struct {
-			Code   string `json:"code"`
-			Routes []struct {
-				Distance float64 `json:"distance"`
-				Duration float64 `json:"duration"`
-				Legs     []struct {
-					Steps []struct {
-						Distance float64 `json:"distance"`
-						Duration float64 `json:"duration"`
-						Name     string  `json:"name"`
-						Maneuver struct {
-							Type     string `json:"type"`
-							Modifier string `json:"modifier,omitempty"`
-						} `json:"maneuver"`
-					} `json:"steps"`
-				} `json:"legs"`
-			} `json:"routes"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&osrmResp); err != nil {
-			logger.Error("failed to decode response", "error", err)
-			resp.Body.Close()
-			continue
-		}
-		resp.Body.Close()
-
-		// Check if any routes were found
-		if len(osrmResp.Routes) == 0 {
-			continue
-		}
-
-		// Get the best route (first one)
-		osrmRoute := osrmResp.Routes[0]
-
 		// Extract instructions if available
 		instructions := make([]string, 0)
-		if len(osrmRoute.Legs) > 0 {
-			for _, step := range osrmRoute.Legs[0].Steps {
-				instruction := generateInstruction(step.Maneuver.Type, step.Maneuver.Modifier, step.Name)
-				if instruction != "" {
-					instructions = append(instructions, instruction)
-				}
+		for _, step := range osrmRoute.Steps {
+			instruction := generateInstruction(step.Type, step.Modifier, step.Name)
+			if instruction != "" {
+				instructions = append(instructions, instruction)
 			}
 		}
 
@@ -236,6 +165,7 @@ func HandleAnalyzeCommute(ctx context.Context, req mcp.CallToolRequest) (*mcp.Ca
 			Distance:     osrmRoute.Distance,
 			Duration:     osrmRoute.Duration,
 			Instructions: instructions,
+			Snaps:        routeSnapNotices(osrmRoute, analysis.HomeLocation, analysis.WorkLocation),
 		}
 
 		// Add estimated CO2 emissions (rough estimates)
@@ -262,11 +192,11 @@ func HandleAnalyzeCommute(ctx context.Context, req mcp.CallToolRequest) (*mcp.Ca
 		durationMinutesRemainder := durationMinutes % 60
 
 		if durationHours > 0 {
-			option.Summary = fmt.Sprintf("%s: %.1f km, %dh %dmin",
-				strings.Title(mode), osrmRoute.Distance/1000, durationHours, durationMinutesRemainder)
+			option.Summary = fmt.Sprintf("%s: %s, %dh %dmin",
+				strings.Title(mode), units.FormatDistance(osrmRoute.Distance), durationHours, durationMinutesRemainder)
 		} else {
-			option.Summary = fmt.Sprintf("%s: %.1f km, %d min",
-				strings.Title(mode), osrmRoute.Distance/1000, durationMinutes)
+			option.Summary = fmt.Sprintf("%s: %s, %d min",
+				strings.Title(mode), units.FormatDistance(osrmRoute.Distance), durationMinutes)
 		}
 
 		// Add to options
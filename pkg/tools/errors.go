@@ -2,9 +2,14 @@
 package tools
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
@@ -16,14 +21,19 @@ type APIError struct {
 	Message     string // Error message
 	Recoverable bool   // Whether the error can be recovered from
 	Guidance    string // Guidance for users on how to recover
+	Attempts    int    // Number of requests sent before giving up, 0 if unknown
 }
 
 // Error implements the error interface and provides a formatted error message.
 func (e *APIError) Error() string {
+	message := e.Message
+	if e.Attempts > 1 {
+		message = fmt.Sprintf("%s (after %d attempts)", message, e.Attempts)
+	}
 	if e.Guidance != "" {
-		return fmt.Sprintf("%s API error (%d): %s. %s", e.Service, e.StatusCode, e.Message, e.Guidance)
+		return fmt.Sprintf("%s API error (%d): %s. %s", e.Service, e.StatusCode, message, e.Guidance)
 	}
-	return fmt.Sprintf("%s API error (%d): %s", e.Service, e.StatusCode, e.Message)
+	return fmt.Sprintf("%s API error (%d): %s", e.Service, e.StatusCode, message)
 }
 
 // Common error guidance messages
@@ -42,7 +52,7 @@ const (
 	GuidanceOverpassGeneral   = "Try a smaller search radius or fewer search criteria."
 
 	// OSRM guidance
-	GuidanceOSRMRouteNotFound = "No route could be found between the specified points. Try locations with accessible roads."
+	GuidanceOSRMRouteNotFound = "No route could be found between the specified points. Try locations with accessible roads, such as those snap_to_road finds."
 	GuidanceOSRMRateLimit     = "The routing service is experiencing high load. Please try again in a few seconds."
 	GuidanceOSRMTimeout       = "The routing request timed out. Try a shorter route or check your internet connection."
 	GuidanceOSRMGeneral       = "Check that your coordinates are accessible by the specified transport mode."
@@ -84,8 +94,107 @@ func NewAPIError(service string, statusCode int, message, guidance string) *APIE
 
 // ErrorWithGuidance returns a properly formatted error response with user guidance.
 func ErrorWithGuidance(err *APIError) *mcp.CallToolResult {
-	errorText := fmt.Sprintf("Error: %s\n\nGuidance: %s", err.Message, err.Guidance)
-	return mcp.NewToolResultError(errorText)
+	message := err.Message
+	if err.Attempts > 1 {
+		message = fmt.Sprintf("%s (after %d attempts)", message, err.Attempts)
+	}
+	errorText := fmt.Sprintf("Error: %s\n\nGuidance: %s", message, err.Guidance)
+	return withErrorCode(mcp.NewToolResultError(errorText), strconv.Itoa(err.StatusCode))
+}
+
+// errorCodeMeta is the _meta entry that holds the code of an error result
+const errorCodeMeta = "error_code"
+
+// withErrorCode records the code of an error result in its _meta field,
+// where ResultCode finds it
+func withErrorCode(result *mcp.CallToolResult, code string) *mcp.CallToolResult {
+	if result.Meta == nil {
+		result.Meta = make(map[string]interface{})
+	}
+	result.Meta[errorCodeMeta] = code
+	return result
+}
+
+// upstreamError converts an error returned by osm.DoRequest into an
+// APIError, keeping the number of attempts and any Retry-After delay.
+func upstreamError(service string, err error) *APIError {
+	statusCode := http.StatusServiceUnavailable
+	message := fmt.Sprintf("Failed to communicate with %s service", service)
+	guidance := GuidanceNetworkError
+	attempts := 1
+
+	var reqErr *osm.RequestError
+	if errors.As(err, &reqErr) {
+		attempts = reqErr.Attempts
+		if reqErr.StatusCode != 0 {
+			statusCode = reqErr.StatusCode
+			message = fmt.Sprintf("%s service error: %d", service, statusCode)
+			guidance = serviceGuidance(service, statusCode)
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		statusCode = http.StatusRequestTimeout
+		message = "Request timed out"
+		guidance = serviceGuidance(service, statusCode)
+	case errors.Is(err, context.Canceled):
+		statusCode = 499 // Client closed request
+		message = "Request canceled"
+		guidance = "The request was canceled before completion"
+	}
+
+	apiErr := NewAPIError(service, statusCode, message, guidance)
+	apiErr.Recoverable = statusCode != 499
+	apiErr.Attempts = attempts
+	if reqErr != nil && reqErr.RetryAfter > 0 {
+		apiErr.Guidance = fmt.Sprintf("The %s service asked clients to wait %s before trying again.",
+			service, reqErr.RetryAfter.Round(time.Second))
+	}
+	return apiErr
+}
+
+// statusError creates an APIError for an upstream response with an
+// unexpected status that was not retried
+func statusError(service string, statusCode int) *APIError {
+	apiErr := NewAPIError(service, statusCode,
+		fmt.Sprintf("%s service error: %d", service, statusCode),
+		serviceGuidance(service, statusCode))
+	apiErr.Attempts = 1
+	return apiErr
+}
+
+// serviceGuidance picks the guidance for a service and status code, or
+// returns an empty string to let NewAPIError infer it
+func serviceGuidance(service string, statusCode int) string {
+	switch service {
+	case "Nominatim":
+		switch statusCode {
+		case http.StatusTooManyRequests:
+			return GuidanceNominatimRateLimit
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return GuidanceNominatimTimeout
+		}
+	case "Overpass":
+		switch statusCode {
+		case http.StatusTooManyRequests:
+			return GuidanceOverpassRateLimit
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return GuidanceOverpassTimeout
+		case http.StatusBadRequest:
+			return GuidanceOverpassSyntax
+		}
+	case "OSRM":
+		switch statusCode {
+		case http.StatusTooManyRequests:
+			return GuidanceOSRMRateLimit
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return GuidanceOSRMTimeout
+		default:
+			return GuidanceOSRMGeneral
+		}
+	}
+	return ""
 }
 
 // ValidationError creates an error for invalid coordinate or radius parameters.
@@ -101,7 +101,7 @@ func HandleExploreArea(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallT
 	queryBuilder.WriteString(");out body;")
 
 	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
+	reqURL, err := url.Parse(osm.FromContext(ctx).BaseURL(osm.ServiceOverpass))
 	if err != nil {
 		logger.Error("failed to parse URL", "error", err)
 		return ErrorResponse("Internal server error"), nil
@@ -118,18 +118,17 @@ func HandleExploreArea(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallT
 	httpReq.Header.Set("User-Agent", osm.UserAgent)
 
 	// Execute request with timeout
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
+	resp, err := osm.DoRequest(ctx, httpReq)
 	if err != nil {
 		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with OSM service"), nil
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 	defer resp.Body.Close()
 
 	// Process response
 	if resp.StatusCode != http.StatusOK {
 		logger.Error("OSM service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("OSM service error: %d", resp.StatusCode)), nil
+		return ErrorWithGuidance(statusError("Overpass", resp.StatusCode)), nil
 	}
 
 	// Parse response
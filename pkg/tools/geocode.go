@@ -3,86 +3,36 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/NERVsystems/osmmcp/pkg/osm"
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/mark3labs/mcp-go/mcp"
-	"golang.org/x/sync/singleflight"
 )
 
 const (
-	// Nominatim is OSM's geocoding service
-	nominatimBaseURL = "https://nominatim.openstreetmap.org"
-
-	// UserAgent identifies our application to Nominatim
-	userAgent = "NERV-MCP-Geocoder/1.0 (contact: ops@nerv.systems)"
-
 	// Search parameters
 	maxResults    = 3   // Maximum number of results to return
 	minImportance = 0.4 // Minimum importance threshold for result selection
 
 	// Cache configuration
-	cacheSize = 512            // Maximum number of entries in the LRU cache
+	cacheSize = 512            // Maximum number of entries in each geocoding namespace
 	cacheTTL  = 24 * time.Hour // Cache entries valid for 24 hours
-
-	// Retry configuration
-	maxRetries     = 3                      // Maximum number of retries for failed requests
-	initialBackoff = 500 * time.Millisecond // Initial backoff delay
-)
-
-// Default region to append for single-token or landmark queries
-var defaultRegion = "Singapore"
-
-// Global cache and request group to deduplicate in-flight requests
-var (
-	// geocodeCache is an LRU cache for geocoding results
-	geocodeCache *lru.Cache[string, []byte]
-
-	// reverseGeocodeCache is an LRU cache for reverse geocoding results
-	reverseGeocodeCache *lru.Cache[string, []byte]
-
-	// requestGroup deduplicates in-flight requests
-	requestGroup singleflight.Group
-
-	// Once ensures caches are initialized only once
-	initOnce sync.Once
 )
 
-// initCaches initializes the LRU caches
-func initCaches() {
-	initOnce.Do(func() {
-		var err error
-
-		// Initialize geocoding cache
-		geocodeCache, err = lru.New[string, []byte](cacheSize)
-		if err != nil {
-			slog.Error("failed to create geocode cache", "error", err)
-			// Create a minimal cache as fallback
-			geocodeCache, _ = lru.New[string, []byte](10)
-		}
-
-		// Initialize reverse geocoding cache
-		reverseGeocodeCache, err = lru.New[string, []byte](cacheSize)
-		if err != nil {
-			slog.Error("failed to create reverse geocode cache", "error", err)
-			// Create a minimal cache as fallback
-			reverseGeocodeCache, _ = lru.New[string, []byte](10)
-		}
-
-		// Set the user agent for all OSM requests
-		osm.SetUserAgent(userAgent)
-	})
-}
+// DefaultRegion is appended to single-token or landmark queries unless
+// the registry sets another region
+const DefaultRegion = "Singapore"
 
 // GeocodeAddressInput defines the input parameters for geocoding an address
 type GeocodeAddressInput struct {
@@ -118,10 +68,10 @@ func NewGeocodeDetailedError(code, message string, query string, suggestions ...
 	errorJSON, err := json.Marshal(errorObj)
 	if err != nil {
 		// Fallback if marshaling fails
-		return mcp.NewToolResultError(fmt.Sprintf("ERROR: %s - %s", code, message))
+		return withErrorCode(mcp.NewToolResultError(fmt.Sprintf("ERROR: %s - %s", code, message)), code)
 	}
 
-	return mcp.NewToolResultError(string(errorJSON))
+	return withErrorCode(mcp.NewToolResultError(string(errorJSON)), code)
 }
 
 // GeocodeAddressTool returns a tool definition for geocoding addresses
@@ -196,6 +146,17 @@ func cacheKey(query string) string {
 	return strings.ToLower(strings.TrimSpace(query))
 }
 
+// geocoderKey returns the local geocoder of a client, or nil for
+// Nominatim, and a cache key prefix naming it: the geocoder instance or
+// the Nominatim endpoint. Answers of a geocoder replaced by a config
+// reload are then not served any more.
+func geocoderKey(c *osm.Client) (osm.Geocoder, string) {
+	if g := c.Geocoder(); g != nil {
+		return g, fmt.Sprintf("local %p", g)
+	}
+	return nil, c.BaseURL(osm.ServiceNominatim)
+}
+
 // reverseGeoCacheKey generates a cache key for reverse geocoding
 func reverseGeoCacheKey(lat, lon float64) string {
 	// Round coordinates to 5 decimal places for caching
@@ -204,50 +165,6 @@ func reverseGeoCacheKey(lat, lon float64) string {
 	return fmt.Sprintf("%.5f,%.5f", roundedLat, roundedLon)
 }
 
-// withRetry performs a request with exponential backoff retry logic
-func withRetry(ctx context.Context, req *http.Request, maxAttempts int, initialDelay time.Duration) (*http.Response, error) {
-	logger := slog.Default().With("url", req.URL.String())
-	var lastErr error
-
-	delay := initialDelay
-
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		// If not the first attempt, log and wait
-		if attempt > 0 {
-			logger.Info("retrying request", "attempt", attempt+1, "max_attempts", maxAttempts, "delay", delay)
-
-			// Wait for backoff delay
-			select {
-			case <-time.After(delay):
-				// Continue with retry
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			}
-
-			// Double the delay for the next retry
-			delay *= 2
-		}
-
-		// Execute the request
-		resp, err := osm.DoRequest(ctx, req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			return resp, nil
-		}
-
-		// Record the error
-		if err != nil {
-			lastErr = err
-			logger.Error("request failed", "error", err, "attempt", attempt+1)
-		} else {
-			lastErr = fmt.Errorf("HTTP status %d", resp.StatusCode)
-			logger.Error("request returned error status", "status", resp.StatusCode, "attempt", attempt+1)
-			resp.Body.Close()
-		}
-	}
-
-	return nil, fmt.Errorf("max retries reached: %w", lastErr)
-}
-
 // NominatimResult represents a result from the Nominatim geocoding service
 type NominatimResult struct {
 	PlaceID     json.Number `json:"place_id"` // Using json.Number to handle both string and numeric IDs
@@ -270,65 +187,33 @@ type NominatimResult struct {
 // geocodeQuery performs a single geocoding request with caching
 func geocodeQuery(ctx context.Context, query string) ([]NominatimResult, error) {
 	logger := slog.Default().With("query", query)
-
-	// Initialize caches if needed
-	initCaches()
+	state := stateFrom(ctx)
 
 	// Create a normalized key for caching
-	key := cacheKey(query)
+	g, provider := geocoderKey(state.client)
+	key := provider + " " + cacheKey(query)
 
-	// Check cache first
-	if cachedData, found := geocodeCache.Get(key); found {
+	// Check cache first; callers may reorder the results, so hand out a copy
+	if cached, found := state.geocode.Get(key); found {
 		logger.Info("cache hit", "query", query)
-
-		var results []NominatimResult
-		if err := json.Unmarshal(cachedData, &results); err != nil {
-			logger.Error("failed to unmarshal cached results", "error", err)
-		} else {
-			return results, nil
-		}
+		return slices.Clone(cached), nil
 	}
 
 	// Use singleflight to deduplicate in-flight requests for the same query
-	result, err, _ := requestGroup.Do(key, func() (interface{}, error) {
-		// Build request URL
-		reqURL, err := url.Parse(fmt.Sprintf("%s/search", nominatimBaseURL))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse URL: %w", err)
-		}
-
-		// Add query parameters
-		q := reqURL.Query()
-		q.Add("q", query)
-		q.Add("format", "json")
-		q.Add("limit", fmt.Sprintf("%d", maxResults)) // Increased limit
-		q.Add("addressdetails", "1")                  // Get detailed address info
-		reqURL.RawQuery = q.Encode()
-
-		// Create request
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+	result, err, _ := state.requests.Do(key, func() (interface{}, error) {
+		var results []NominatimResult
+		var err error
+		if g != nil {
+			results, err = searchLocal(ctx, g, query)
+		} else {
+			results, err = searchNominatim(ctx, query)
 		}
-
-		// Execute request with retries
-		resp, err := withRetry(ctx, req, maxRetries, initialBackoff)
 		if err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var results []NominatimResult
-		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
 
 		// Cache the results
-		resultsJSON, err := json.Marshal(results)
-		if err == nil {
-			geocodeCache.Add(key, resultsJSON)
-		}
+		state.geocode.Set(key, slices.Clone(results))
 
 		return results, nil
 	})
@@ -337,7 +222,82 @@ func geocodeQuery(ctx context.Context, query string) ([]NominatimResult, error)
 		return nil, err
 	}
 
-	return result.([]NominatimResult), nil
+	return slices.Clone(result.([]NominatimResult)), nil
+}
+
+// searchNominatim sends a search query to Nominatim
+func searchNominatim(ctx context.Context, query string) ([]NominatimResult, error) {
+	// Build request URL
+	reqURL, err := url.Parse(fmt.Sprintf("%s/search", osm.FromContext(ctx).BaseURL(osm.ServiceNominatim)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	// Add query parameters
+	q := reqURL.Query()
+	q.Add("q", query)
+	q.Add("format", "json")
+	q.Add("limit", fmt.Sprintf("%d", maxResults)) // Increased limit
+	q.Add("addressdetails", "1")                  // Get detailed address info
+	reqURL.RawQuery = q.Encode()
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Execute request with retries and rate limiting
+	resp, err := osm.DoRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &osm.RequestError{Service: osm.ServiceNominatim, StatusCode: resp.StatusCode, Attempts: 1}
+	}
+
+	// Parse response
+	var results []NominatimResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return results, nil
+}
+
+// searchLocal answers a search query from a local geocoder
+func searchLocal(ctx context.Context, g osm.Geocoder, query string) ([]NominatimResult, error) {
+	found, err := g.Search(ctx, query, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]NominatimResult, 0, len(found))
+	for _, r := range found {
+		results = append(results, toNominatimResult(r))
+	}
+	return results, nil
+}
+
+// toNominatimResult converts a local geocoding result to the form
+// Nominatim returns, so both take the same path through the tools
+func toNominatimResult(r osm.GeocodeResult) NominatimResult {
+	result := NominatimResult{
+		PlaceID:     json.Number(strconv.FormatInt(r.ID, 10)),
+		DisplayName: r.DisplayName,
+		Lat:         strconv.FormatFloat(r.Lat, 'f', 7, 64),
+		Lon:         strconv.FormatFloat(r.Lon, 'f', 7, 64),
+		Type:        r.Type,
+		Importance:  r.Importance,
+	}
+	result.Address.Road = r.Address.Road
+	result.Address.HouseNumber = r.Address.HouseNumber
+	result.Address.City = r.Address.City
+	result.Address.Town = r.Address.Town
+	result.Address.State = r.Address.State
+	result.Address.Country = r.Address.Country
+	result.Address.PostCode = r.Address.PostCode
+	return result
 }
 
 // resultToPlace converts a Nominatim result to a Place object
@@ -398,15 +358,17 @@ func resultsToPlaces(results []NominatimResult) ([]Place, error) {
 
 // HandleGeocodeAddress implements the geocoding functionality
 //
-// Side-effects: performs up to four HTTP GET requests (first + three retries),
-// respects a 512-entry shared LRU cache, and annotates each outbound request
-// with a descriptive User-Agent header.
+// Side-effects: tries up to three variants of the query in turn against
+// Nominatim, or the offline geocoder if one is loaded. Requests are
+// retried under the client's RetryPolicy, rate limited and sent with its
+// User-Agent; results are cached in the "geocode" namespace of the
+// client's cache store.
 func HandleGeocodeAddress(ctx context.Context, rawInput mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	logger := slog.Default().With("tool", "geocode_address")
 
 	// Parse input
 	address := mcp.ParseString(rawInput, "address", "")
-	region := mcp.ParseString(rawInput, "region", defaultRegion)
+	region := mcp.ParseString(rawInput, "region", stateFrom(ctx).region)
 
 	// Log the original query for diagnostics
 	logger.Info("geocoding address", "original_query", address, "region", region)
@@ -464,8 +426,9 @@ func HandleGeocodeAddress(ctx context.Context, rawInput mcp.CallToolRequest) (*m
 
 		results, err := geocodeQuery(ctx, query)
 		if err != nil {
+			// An unreachable service would fail the other variants too
 			logger.Error("query failed", "query", query, "error", err)
-			continue
+			return ErrorWithGuidance(upstreamError("Nominatim", err)), nil
 		}
 
 		if len(results) > 0 {
@@ -600,14 +563,13 @@ func ReverseGeocodeTool() mcp.Tool {
 
 // HandleReverseGeocode implements the reverse geocoding functionality
 //
-// Side-effects: performs up to four HTTP GET requests (first + three retries),
-// respects a 512-entry shared LRU cache, and annotates each outbound request
-// with a descriptive User-Agent header.
+// Side-effects: sends one lookup to Nominatim, or the offline geocoder if
+// one is loaded. The request is retried under the client's RetryPolicy,
+// rate limited and sent with its User-Agent; results are cached in the
+// "reverse_geocode" namespace of the client's cache store.
 func HandleReverseGeocode(ctx context.Context, rawInput mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	logger := slog.Default().With("tool", "reverse_geocode")
-
-	// Initialize caches if needed
-	initCaches()
+	state := stateFrom(ctx)
 
 	// Parse input
 	latitude := mcp.ParseFloat64(rawInput, "latitude", 0)
@@ -635,75 +597,42 @@ func HandleReverseGeocode(ctx context.Context, rawInput mcp.CallToolRequest) (*m
 	}
 
 	// Create a cache key
-	key := reverseGeoCacheKey(latitude, longitude)
+	g, provider := geocoderKey(state.client)
+	key := provider + " " + reverseGeoCacheKey(latitude, longitude)
 
 	// Check cache first
-	if cachedData, found := reverseGeocodeCache.Get(key); found {
+	if cached, found := state.reverse.Get(key); found {
 		logger.Info("cache hit", "key", key)
 
-		var result struct {
-			Place Place `json:"place"`
-		}
-
-		if err := json.Unmarshal(cachedData, &result); err != nil {
-			logger.Error("failed to unmarshal cached results", "error", err)
+		resultBytes, err := json.Marshal(cached)
+		if err != nil {
+			logger.Error("failed to marshal cached result", "error", err)
 		} else {
-			resultBytes, err := json.Marshal(result)
-			if err != nil {
-				logger.Error("failed to marshal cached result", "error", err)
-			} else {
-				return mcp.NewToolResultText(string(resultBytes)), nil
-			}
+			return mcp.NewToolResultText(string(resultBytes)), nil
 		}
 	}
 
 	// Use singleflight to deduplicate in-flight requests
-	responseData, err, _ := requestGroup.Do(key, func() (interface{}, error) {
-		// Build request URL
-		reqURL, err := url.Parse(fmt.Sprintf("%s/reverse", nominatimBaseURL))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse URL: %w", err)
-		}
-
-		// Add query parameters
-		q := reqURL.Query()
-		q.Add("lat", fmt.Sprintf("%f", latitude))
-		q.Add("lon", fmt.Sprintf("%f", longitude))
-		q.Add("format", "json")
-		q.Add("addressdetails", "1")
-		reqURL.RawQuery = q.Encode()
-
-		// Make HTTP request
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		// Execute request with retries
-		resp, err := withRetry(ctx, req, maxRetries, initialBackoff)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		// Parse response
-		var result NominatimResult
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+	responseData, err, _ := state.requests.Do(key, func() (interface{}, error) {
+		if g != nil {
+			return reverseLocal(ctx, g, latitude, longitude)
 		}
-
-		return result, nil
+		return reverseNominatim(ctx, latitude, longitude)
 	})
 
-	if err != nil {
-		logger.Error("request failed", "error", err)
+	if errors.Is(err, errNoAddress) {
+		logger.Info("no address found", "latitude", latitude, "longitude", longitude)
 		return NewGeocodeDetailedError(
-			"SERVICE_ERROR",
-			"Failed to communicate with geocoding service",
+			"NO_RESULTS",
+			"No address found at the coordinates",
 			fmt.Sprintf("lat: %f, lon: %f", latitude, longitude),
-			"Try again in a few moments",
+			"The location may be outside the loaded map extract",
 		), nil
 	}
+	if err != nil {
+		logger.Error("request failed", "error", err)
+		return ErrorWithGuidance(upstreamError("Nominatim", err)), nil
+	}
 
 	result := responseData.(NominatimResult)
 
@@ -724,14 +653,73 @@ func HandleReverseGeocode(ctx context.Context, rawInput mcp.CallToolRequest) (*m
 	}
 
 	// Cache the result
+	state.reverse.Set(key, output)
+
 	outputJSON, err := json.Marshal(output)
-	if err == nil {
-		reverseGeocodeCache.Add(key, outputJSON)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
 	}
 
 	return mcp.NewToolResultText(string(outputJSON)), nil
 }
 
+// errNoAddress is returned by a local reverse lookup that finds nothing
+var errNoAddress = errors.New("no address found")
+
+// reverseNominatim sends a reverse geocoding query to Nominatim
+func reverseNominatim(ctx context.Context, latitude, longitude float64) (NominatimResult, error) {
+	// Build request URL
+	reqURL, err := url.Parse(fmt.Sprintf("%s/reverse", osm.FromContext(ctx).BaseURL(osm.ServiceNominatim)))
+	if err != nil {
+		return NominatimResult{}, fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	// Add query parameters
+	q := reqURL.Query()
+	q.Add("lat", fmt.Sprintf("%f", latitude))
+	q.Add("lon", fmt.Sprintf("%f", longitude))
+	q.Add("format", "json")
+	q.Add("addressdetails", "1")
+	reqURL.RawQuery = q.Encode()
+
+	// Make HTTP request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return NominatimResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Execute request with retries and rate limiting
+	resp, err := osm.DoRequest(ctx, req)
+	if err != nil {
+		return NominatimResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return NominatimResult{}, &osm.RequestError{Service: osm.ServiceNominatim, StatusCode: resp.StatusCode, Attempts: 1}
+	}
+
+	// Parse response
+	var result NominatimResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return NominatimResult{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result, nil
+}
+
+// reverseLocal answers a reverse geocoding query from a local geocoder
+func reverseLocal(ctx context.Context, g osm.Geocoder, latitude, longitude float64) (NominatimResult, error) {
+	found, err := g.Reverse(ctx, latitude, longitude)
+	if err != nil {
+		return NominatimResult{}, err
+	}
+	if found == nil {
+		return NominatimResult{}, errNoAddress
+	}
+	return toNominatimResult(*found), nil
+}
+
 // Example end-to-end flow for "Merlion Park (Singapore)"
 // 1. sanitizeAddress returns two siblings:
 //    • "Merlion Park"
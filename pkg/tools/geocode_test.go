@@ -6,6 +6,7 @@ import (
 	"math"
 	"testing"
 
+	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
@@ -460,3 +461,71 @@ func TestParentheticalHandling(t *testing.T) {
 			expectedLat, expectedLon)
 	}
 }
+
+// stubGeocoder answers every query with the same place
+type stubGeocoder struct {
+	result osm.GeocodeResult
+}
+
+func (s stubGeocoder) Search(ctx context.Context, query string, limit int) ([]osm.GeocodeResult, error) {
+	return []osm.GeocodeResult{s.result}, nil
+}
+
+func (s stubGeocoder) Reverse(ctx context.Context, lat, lon float64) (*osm.GeocodeResult, error) {
+	r := s.result
+	return &r, nil
+}
+
+func TestGeocodeQueryUsesLocalGeocoder(t *testing.T) {
+	stub := stubGeocoder{result: osm.GeocodeResult{
+		ID:          7,
+		DisplayName: "1, Teststraße, Berlin, Deutschland",
+		Lat:         52.52,
+		Lon:         13.405,
+		Type:        "house",
+		Importance:  0.2,
+		Address:     osm.GeocodeAddress{Road: "Teststraße", HouseNumber: "1", State: "Berlin", Country: "Deutschland"},
+	}}
+	prev := osm.SetGeocoder(stub)
+	defer osm.SetGeocoder(prev)
+
+	results, err := geocodeQuery(context.Background(), "local geocoder test query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("geocodeQuery() = %+v, want one result", results)
+	}
+	r := results[0]
+	if r.PlaceID != "7" || r.Lat != "52.5200000" || r.Lon != "13.4050000" || r.Address.HouseNumber != "1" || r.Address.Country != "Deutschland" {
+		t.Errorf("geocodeQuery() = %+v", r)
+	}
+
+	// The result converts to a Place like a Nominatim answer does
+	place, err := resultToPlace(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place.ID != "7" || math.Abs(place.Location.Latitude-52.52) > 1e-9 || place.Name != r.DisplayName {
+		t.Errorf("resultToPlace() = %+v", place)
+	}
+}
+
+func TestGeocodeCacheKeyedByGeocoder(t *testing.T) {
+	first := &stubGeocoder{result: osm.GeocodeResult{ID: 1, DisplayName: "First", Lat: 52.52, Lon: 13.405}}
+	second := &stubGeocoder{result: osm.GeocodeResult{ID: 2, DisplayName: "Second", Lat: 52.52, Lon: 13.405}}
+	prev := osm.SetGeocoder(first)
+	defer osm.SetGeocoder(prev)
+
+	const query = "geocoder switch test query"
+	if results, err := geocodeQuery(context.Background(), query); err != nil || len(results) != 1 || results[0].PlaceID != "1" {
+		t.Fatalf("geocodeQuery() = %+v, %v, want the first geocoder's result", results, err)
+	}
+
+	// A reload that replaces the geocoder must not be answered from the
+	// previous geocoder's cache entries
+	osm.SetGeocoder(second)
+	if results, err := geocodeQuery(context.Background(), query); err != nil || len(results) != 1 || results[0].PlaceID != "2" {
+		t.Errorf("geocodeQuery() after switching = %+v, %v, want the second geocoder's result", results, err)
+	}
+}
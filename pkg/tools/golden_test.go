@@ -0,0 +1,234 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files from the current output")
+
+// goldenCases lists the calls made to every tool, by tool and case name.
+// Each call is answered from testdata/fixtures and its result compared
+// with testdata/golden/<tool>/<case>.json.
+var goldenCases = map[string]map[string]map[string]any{
+	"geocode_address": {
+		"landmark": {"address": "Brandenburger Tor, Berlin"},
+		"empty":    {"address": ""},
+	},
+	"reverse_geocode": {
+		"berlin":  {"latitude": 52.516275, "longitude": 13.377704},
+		"invalid": {"latitude": 95.0, "longitude": 13.377704},
+	},
+	"find_nearby_places": {
+		"restaurants": {"latitude": 52.520008, "longitude": 13.404954, "radius": 500, "category": "restaurant", "limit": 3},
+	},
+	"search_category": {
+		"cafes": {"category": "cafe", "north_lat": 52.53, "south_lat": 52.51, "east_lon": 13.42, "west_lon": 13.39, "limit": 3},
+	},
+	"get_route_directions": {
+		"driving": {"start_lat": 52.516275, "start_lon": 13.377704, "end_lat": 52.520008, "end_lon": 13.404954, "mode": "car"},
+	},
+	"get_multi_stop_route": {
+		"in_order": {
+			"waypoints": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954},
+				map[string]any{"latitude": 52.507541, "longitude": 13.390394},
+			},
+			"mode": "foot",
+		},
+		"optimized_round_trip": {
+			"waypoints": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954},
+				map[string]any{"latitude": 52.507541, "longitude": 13.390394},
+				map[string]any{"latitude": 52.525084, "longitude": 13.369402},
+			},
+			"optimize":   true,
+			"round_trip": true,
+		},
+		"open_trip": {
+			"waypoints": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954},
+			},
+			"optimize":    true,
+			"fixed_start": false,
+		},
+	},
+	"suggest_meeting_point": {
+		"two_people": {
+			"locations": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954},
+			},
+			"category": "cafe",
+			"limit":    2,
+		},
+	},
+	"compute_distance_matrix": {
+		"offices": {
+			"origins": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954},
+			},
+			"destinations": []any{
+				map[string]any{"latitude": 52.507541, "longitude": 13.390394},
+				map[string]any{"latitude": 52.525084, "longitude": 13.369402},
+				map[string]any{"latitude": 52.510885, "longitude": 13.398937},
+			},
+			"mode": "bike",
+		},
+		"empty": {"origins": []any{}},
+	},
+	"match_gps_trace": {
+		"timestamped_with_gap": {
+			"points": []any{
+				map[string]any{"latitude": 52.516275, "longitude": 13.377704, "timestamp": 1700000000},
+				map[string]any{"latitude": 52.516498, "longitude": 13.381012, "timestamp": 1700000030},
+				map[string]any{"latitude": 52.516901, "longitude": 13.385044, "timestamp": 1700000060},
+				map[string]any{"latitude": 52.519987, "longitude": 13.398102, "timestamp": "2023-11-14T22:18:20Z"},
+				map[string]any{"latitude": 52.520008, "longitude": 13.404954, "timestamp": "2023-11-14T22:18:50Z"},
+			},
+		},
+		"polyline": {"polyline": "uap_IsyspAm@sSoA_X{@_X", "mode": "bike"},
+		"both":     {"polyline": "uap_IsyspAm@sSoA_X{@_X", "points": []any{}},
+	},
+	"snap_to_road": {
+		"pariser_platz": {"latitude": 52.5165, "longitude": 13.379, "mode": "foot", "number": 2},
+		"too_many":      {"latitude": 52.5165, "longitude": 13.379, "number": 50},
+	},
+	"compute_isochrone": {
+		"walk_with_restaurants": {"latitude": 52.520008, "longitude": 13.404954, "mode": "foot", "minutes": []any{10, 5}, "category": "restaurant"},
+		"bike":                  {"latitude": 52.520008, "longitude": 13.404954, "mode": "bike", "minutes": []any{15}},
+		"too_long":              {"latitude": 52.520008, "longitude": 13.404954, "minutes": []any{5, 45}},
+	},
+	"explore_area": {
+		"mitte": {"latitude": 52.520008, "longitude": 13.404954, "radius": 300},
+	},
+	"find_charging_stations": {
+		"nearby": {"latitude": 52.520008, "longitude": 13.404954, "radius": 2000, "limit": 3},
+	},
+	"find_route_charging_stations": {
+		"across_town": {"start_latitude": 52.516275, "start_longitude": 13.377704, "end_latitude": 52.520008, "end_longitude": 13.404954, "buffer_distance": 1000, "limit": 3},
+	},
+	"find_schools_nearby": {
+		"primary": {"latitude": 52.520008, "longitude": 13.404954, "radius": 1000, "school_type": "primary", "limit": 3},
+	},
+	"analyze_commute": {
+		"car_and_bike": {"home_latitude": 52.516275, "home_longitude": 13.377704, "work_latitude": 52.520008, "work_longitude": 13.404954, "transport_modes": []any{"car", "cycling"}},
+	},
+	"analyze_neighborhood": {
+		"mitte": {"latitude": 52.520008, "longitude": 13.404954, "radius": 500},
+	},
+	"find_parking_facilities": {
+		"nearby": {"latitude": 52.520008, "longitude": 13.404954, "radius": 1000, "limit": 3},
+	},
+}
+
+// TestToolsGolden calls every tool with upstream exchanges replayed from
+// fixtures. Set OSMMCP_FIXTURES=record to refresh the fixtures from the
+// live services, and pass -update to rewrite the golden files.
+func TestToolsGolden(t *testing.T) {
+	mode, err := osm.ParseFixtureMode(os.Getenv("OSMMCP_FIXTURES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode == osm.FixturesOff {
+		mode = osm.FixturesReplay
+	}
+	if err := osm.SetFixtures(mode, filepath.Join("testdata", "fixtures")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { osm.SetFixtures(osm.FixturesOff, "") })
+
+	defs := NewRegistry(slog.Default()).GetToolDefinitions()
+	registered := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		registered[def.Name] = true
+		cases, ok := goldenCases[def.Name]
+		if !ok {
+			t.Errorf("tool %s has no golden cases", def.Name)
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(cases)) {
+			args := cases[name]
+			t.Run(def.Name+"/"+name, func(t *testing.T) {
+				req := mcp.CallToolRequest{}
+				req.Params.Name = def.Name
+				req.Params.Arguments = args
+
+				result, err := def.Handler(context.Background(), req)
+				if err != nil {
+					t.Fatalf("handler error = %v", err)
+				}
+				compareGolden(t, filepath.Join("testdata", "golden", def.Name, name+".json"), renderResult(t, result))
+			})
+		}
+	}
+	for name := range goldenCases {
+		if !registered[name] {
+			t.Errorf("golden cases for unknown tool %s", name)
+		}
+	}
+}
+
+// renderResult formats a tool result for comparison. Text holding JSON
+// is indented, and the _meta field, which reports timings, is left out.
+func renderResult(t *testing.T, result *mcp.CallToolResult) []byte {
+	t.Helper()
+	out := struct {
+		IsError bool  `json:"is_error"`
+		Content []any `json:"content"`
+	}{IsError: result.IsError}
+	for _, c := range result.Content {
+		text, ok := c.(mcp.TextContent)
+		if !ok {
+			out.Content = append(out.Content, c)
+			continue
+		}
+		if json.Valid([]byte(text.Text)) {
+			out.Content = append(out.Content, json.RawMessage(text.Text))
+		} else {
+			out.Content = append(out.Content, text.Text)
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// compareGolden compares output with a golden file, or rewrites the file
+// when -update is set
+func compareGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run with -update: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("result differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
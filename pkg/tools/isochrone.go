@@ -0,0 +1,346 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// isochroneBearings and isochroneRings lay out the sampled destinations:
+	// rings of evenly spaced points around the origin, timed in one table
+	isochroneBearings = 16
+	isochroneRings    = 5
+
+	// maxIsochroneMinutes bounds the time thresholds of an isochrone
+	maxIsochroneMinutes = 30
+
+	// maxIsochroneThresholds bounds the polygons of one isochrone
+	maxIsochroneThresholds = 4
+)
+
+// isochroneSpeeds are generous straight-line travel speeds of the routing
+// profiles in meters per second. The outer ring of samples lies as far as
+// this speed covers in the largest threshold, so it is rarely reached.
+var isochroneSpeeds = map[string]float64{
+	"car":  14,
+	"bike": 5.5,
+	"foot": 1.6,
+}
+
+// ComputeIsochroneTool returns a tool definition for computing the area
+// reachable from a location within given travel times
+func ComputeIsochroneTool() mcp.Tool {
+	return mcp.NewTool("compute_isochrone",
+		mcp.WithDescription("Compute the areas reachable from a location within several travel times, as GeoJSON polygons, optionally counting places of a category inside each"),
+		mcp.WithNumber("latitude",
+			mcp.Required(),
+			mcp.Description("The latitude of the origin"),
+		),
+		mcp.WithNumber("longitude",
+			mcp.Required(),
+			mcp.Description("The longitude of the origin"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Transportation mode: car, bike, foot"),
+			mcp.DefaultString("car"),
+		),
+		mcp.WithArray("minutes",
+			mcp.Description(fmt.Sprintf("Travel time thresholds in whole minutes, 1 to %d each, at most %d of them. Defaults to [5, 10, 15].", maxIsochroneMinutes, maxIsochroneThresholds)),
+		),
+		mcp.WithString("category",
+			mcp.Description("Category of places to count inside each polygon (e.g., restaurant, cafe, school)"),
+		),
+	)
+}
+
+// Isochrone is a GeoJSON FeatureCollection with one polygon per time
+// threshold, smallest first
+type Isochrone struct {
+	Type     string             `json:"type"` // always "FeatureCollection"
+	Profile  string             `json:"profile"`
+	Origin   Location           `json:"origin"`
+	Features []IsochroneFeature `json:"features"`
+	Summary  string             `json:"summary"`
+
+	// Snaps reports the origin if it was moved far to reach a road
+	Snaps []SnapNotice `json:"snaps,omitempty"`
+}
+
+// IsochroneFeature is the GeoJSON Feature of the area reachable within
+// one time threshold
+type IsochroneFeature struct {
+	Type       string              `json:"type"` // always "Feature"
+	Geometry   IsochroneGeometry   `json:"geometry"`
+	Properties IsochroneProperties `json:"properties"`
+}
+
+// IsochroneGeometry is a GeoJSON Polygon with a single closed ring of
+// [lon, lat] positions
+type IsochroneGeometry struct {
+	Type        string        `json:"type"` // always "Polygon"
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// IsochroneProperties describes the area of an IsochroneFeature
+type IsochroneProperties struct {
+	Minutes  int     `json:"minutes"`
+	Area     float64 `json:"area_sq_km"`
+	Category string  `json:"category,omitempty"`
+	POICount *int    `json:"poi_count,omitempty"` // places of the category inside the polygon
+}
+
+// HandleComputeIsochrone computes the areas reachable from a location
+// within several travel times
+func HandleComputeIsochrone(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	logger := slog.Default().With("tool", "compute_isochrone")
+
+	lat := mcp.ParseFloat64(req, "latitude", 0)
+	lon := mcp.ParseFloat64(req, "longitude", 0)
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return ErrorWithGuidance(&APIError{
+			Service:     "Validation",
+			StatusCode:  http.StatusBadRequest,
+			Message:     fmt.Sprintf("Invalid coordinates: %f, %f", lat, lon),
+			Guidance:    "Latitude must be between -90 and 90 degrees and longitude between -180 and 180 degrees",
+			Recoverable: true,
+		}), nil
+	}
+	minutes, err := parseMinutes(req)
+	if err != nil {
+		return invalidArgument(err.Error()), nil
+	}
+	category := strings.ToLower(mcp.ParseString(req, "category", ""))
+	tags, ok := osm.CategoryMap[category]
+	if category != "" && !ok {
+		return invalidArgument(fmt.Sprintf("Unknown category %q; use one such as restaurant, cafe or school", category)), nil
+	}
+
+	profile := mapModeToProfile(mcp.ParseString(req, "mode", "car"))
+	origin := geo.Location{Latitude: lat, Longitude: lon}
+	radius := isochroneSpeeds[profile] * float64(minutes[len(minutes)-1]*60)
+	samples := isochroneSamples(origin, radius)
+
+	matrix := DistanceMatrix{
+		Profile:      profile,
+		Origins:      []Location{{Latitude: lat, Longitude: lon}},
+		Destinations: make([]Location, len(samples)),
+		Durations:    emptyMatrix(1, len(samples)),
+		Distances:    emptyMatrix(1, len(samples)),
+	}
+	for i, s := range samples {
+		matrix.Destinations[i] = Location{Latitude: s.Latitude, Longitude: s.Longitude}
+	}
+	if err := fillMatrix(ctx, &matrix); err != nil {
+		return osrmErrorResult(err, "No roads found near the origin", logger), nil
+	}
+
+	iso := Isochrone{
+		Type:     "FeatureCollection",
+		Profile:  profile,
+		Origin:   matrix.Origins[0],
+		Features: make([]IsochroneFeature, len(minutes)),
+	}
+	for _, n := range matrix.Snaps {
+		// Samples off the road network are expected and not reported
+		if n.Point == "origins[0]" {
+			n.Point = "origin"
+			iso.Snaps = append(iso.Snaps, n)
+		}
+	}
+	rings := make([][][]float64, len(minutes))
+	for i, m := range minutes {
+		rings[i] = isochroneRing(origin, radius, matrix.Durations[0], float64(m*60))
+		iso.Features[i] = IsochroneFeature{
+			Type: "Feature",
+			Geometry: IsochroneGeometry{
+				Type:        "Polygon",
+				Coordinates: [][][]float64{rings[i]},
+			},
+			Properties: IsochroneProperties{
+				Minutes:  m,
+				Area:     math.Round(ringArea(rings[i])/1e4) / 100,
+				Category: category,
+			},
+		}
+	}
+
+	if category != "" {
+		// The largest polygon contains the others, so one query covers all
+		box := geo.NewBoundingBox()
+		for _, p := range rings[len(rings)-1] {
+			box.ExtendWithPoint(p[1], p[0])
+		}
+		elements, err := osm.QueryElementsInBBox(ctx, osm.ElementFilter{Types: []string{"node"}, Tags: tags}, *box)
+		if err != nil {
+			logger.Error("failed to query places", "error", err)
+			return ErrorWithGuidance(upstreamError("Overpass", err)), nil
+		}
+		for i := range iso.Features {
+			count := 0
+			for _, e := range elements {
+				if ringContains(rings[i], e.Lon, e.Lat) {
+					count++
+				}
+			}
+			iso.Features[i].Properties.POICount = &count
+		}
+	}
+
+	parts := make([]string, len(iso.Features))
+	for i, f := range iso.Features {
+		parts[i] = fmt.Sprintf("%d min: %.2f km²", f.Properties.Minutes, f.Properties.Area)
+		if f.Properties.POICount != nil {
+			parts[i] += fmt.Sprintf(" with %d %s places", *f.Properties.POICount, category)
+		}
+	}
+	iso.Summary = fmt.Sprintf("Reachable by %s: %s", profile, strings.Join(parts, "; "))
+
+	resultBytes, err := json.Marshal(iso)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
+	}
+	return mcp.NewToolResultText(string(resultBytes)), nil
+}
+
+// parseMinutes reads the time thresholds of a compute_isochrone call,
+// sorted and without duplicates
+func parseMinutes(req mcp.CallToolRequest) ([]int, error) {
+	raw, ok := req.Params.Arguments["minutes"]
+	if !ok || raw == nil {
+		return []int{5, 10, 15}, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, errors.New("minutes must be an array of numbers")
+	}
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, errors.New("minutes must be an array of numbers")
+	}
+	if len(values) == 0 || len(values) > maxIsochroneThresholds {
+		return nil, fmt.Errorf("between 1 and %d time thresholds are required", maxIsochroneThresholds)
+	}
+
+	minutes := make([]int, len(values))
+	for i, m := range values {
+		if m != math.Trunc(m) || m < 1 || m > maxIsochroneMinutes {
+			return nil, fmt.Errorf("minutes[%d] must be a whole number from 1 to %d", i, maxIsochroneMinutes)
+		}
+		minutes[i] = int(m)
+	}
+	slices.Sort(minutes)
+	return slices.Compact(minutes), nil
+}
+
+// isochroneSamples returns the sampled destinations around origin, ring
+// by ring from the inside out, each ring starting north and turning
+// clockwise
+func isochroneSamples(origin geo.Location, radius float64) []geo.Location {
+	samples := make([]geo.Location, 0, isochroneRings*isochroneBearings)
+	for ring := range isochroneRings {
+		distance := ringDistance(radius, ring)
+		for b := range isochroneBearings {
+			samples = append(samples, destinationPoint(origin, 360*float64(b)/isochroneBearings, distance))
+		}
+	}
+	return samples
+}
+
+// isochroneRing returns the closed [lon, lat] ring bounding what is
+// reachable within limit seconds. Along each bearing the boundary lies
+// between the last sample reached in time and the first one that is not,
+// interpolated by travel time; samples without a route are skipped.
+func isochroneRing(origin geo.Location, radius float64, durations []*float64, limit float64) [][]float64 {
+	ring := make([][]float64, 0, isochroneBearings+1)
+	for b := range isochroneBearings {
+		var reached, reachedTime float64
+		boundary := -1.0
+		for r := range isochroneRings {
+			d := durations[r*isochroneBearings+b]
+			if d == nil {
+				continue
+			}
+			distance := ringDistance(radius, r)
+			if *d <= limit {
+				reached, reachedTime = distance, *d
+				continue
+			}
+			boundary = reached + (distance-reached)*(limit-reachedTime)/(*d-reachedTime)
+			break
+		}
+		if boundary < 0 {
+			boundary = reached
+		}
+		p := destinationPoint(origin, 360*float64(b)/isochroneBearings, boundary)
+		ring = append(ring, []float64{roundCoordinate(p.Longitude), roundCoordinate(p.Latitude)})
+	}
+	return append(ring, ring[0])
+}
+
+// ringDistance returns how far from the origin the samples of a ring lie
+func ringDistance(radius float64, ring int) float64 {
+	return radius * float64(ring+1) / isochroneRings
+}
+
+// destinationPoint returns the point distance meters from origin along
+// an initial bearing in degrees clockwise from north
+func destinationPoint(origin geo.Location, bearing, distance float64) geo.Location {
+	lat1 := origin.Latitude * math.Pi / 180
+	lon1 := origin.Longitude * math.Pi / 180
+	theta := bearing * math.Pi / 180
+	delta := distance / geo.EarthRadius
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	return geo.Location{
+		Latitude:  lat2 * 180 / math.Pi,
+		Longitude: math.Mod(lon2*180/math.Pi+540, 360) - 180,
+	}
+}
+
+// roundCoordinate rounds a degree value to 6 decimals, about 10 cm
+func roundCoordinate(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// ringArea returns the area in square meters enclosed by a closed
+// [lon, lat] ring, small enough to treat as flat
+func ringArea(ring [][]float64) float64 {
+	if len(ring) < 4 {
+		return 0
+	}
+	metersPerDegree := geo.EarthRadius * math.Pi / 180
+	scale := math.Cos(ring[0][1] * math.Pi / 180)
+	var sum float64
+	for i := 1; i < len(ring); i++ {
+		a, b := ring[i-1], ring[i]
+		sum += (a[0]*scale)*b[1] - (b[0]*scale)*a[1]
+	}
+	return math.Abs(sum) / 2 * metersPerDegree * metersPerDegree
+}
+
+// ringContains reports whether a point lies inside a closed [lon, lat]
+// ring, by counting the ring edges a ray from the point crosses
+func ringContains(ring [][]float64, lon, lat float64) bool {
+	inside := false
+	for i := 1; i < len(ring); i++ {
+		a, b := ring[i-1], ring[i]
+		if (a[1] > lat) != (b[1] > lat) &&
+			lon < a[0]+(lat-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
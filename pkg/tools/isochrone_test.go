@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"math"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+)
+
+func TestIsochroneRing(t *testing.T) {
+	origin := geo.Location{Latitude: 52.52, Longitude: 13.405}
+	const radius = 5000 // rings at 1, 2, 3, 4 and 5 km
+
+	durations := make([]*float64, isochroneRings*isochroneBearings)
+	for r := range isochroneRings {
+		for b := range isochroneBearings {
+			d := 100 * float64(r+1)
+			if b == 4 {
+				d = 10 * float64(r+1) // east is fast and reached to the last ring
+			}
+			durations[r*isochroneBearings+b] = &d
+		}
+	}
+	durations[1*isochroneBearings] = nil // north has no route to its second sample
+
+	ring := isochroneRing(origin, radius, durations, 250)
+	if len(ring) != isochroneBearings+1 || ring[0][0] != ring[isochroneBearings][0] || ring[0][1] != ring[isochroneBearings][1] {
+		t.Fatalf("ring = %v, want %d closed positions", ring, isochroneBearings+1)
+	}
+	for b, p := range ring[:isochroneBearings] {
+		want := 2500.0
+		if b == 4 {
+			want = radius
+		}
+		if got := geo.HaversineDistance(origin.Latitude, origin.Longitude, p[1], p[0]); math.Abs(got-want) > 1 {
+			t.Errorf("bearing %d boundary at %.1f m, want %.1f m", b, got, want)
+		}
+	}
+
+	if !ringContains(ring, origin.Longitude, origin.Latitude) {
+		t.Error("ring does not contain its origin")
+	}
+	east := destinationPoint(origin, 90, 4000)
+	if !ringContains(ring, east.Longitude, east.Latitude) {
+		t.Error("ring does not contain a point 4 km east")
+	}
+	west := destinationPoint(origin, 270, 4000)
+	if ringContains(ring, west.Longitude, west.Latitude) {
+		t.Error("ring contains a point 4 km west")
+	}
+}
+
+func TestRingArea(t *testing.T) {
+	// A square of 1 km sides around a point
+	origin := geo.Location{Latitude: 52.52, Longitude: 13.405}
+	var ring [][]float64
+	for _, bearing := range []float64{45, 135, 225, 315, 45} {
+		p := destinationPoint(origin, bearing, 500*math.Sqrt2)
+		ring = append(ring, []float64{p.Longitude, p.Latitude})
+	}
+	if got := ringArea(ring); math.Abs(got-1e6) > 1e3 {
+		t.Errorf("ringArea() = %.0f m², want 1000000 m²", got)
+	}
+}
@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxTracePoints is the most points the public OSRM instance matches in
+// one request
+const maxTracePoints = 100
+
+// MatchGPSTraceTool returns a tool definition for matching GPS traces to
+// roads
+func MatchGPSTraceTool() mcp.Tool {
+	return mcp.NewTool("match_gps_trace",
+		mcp.WithDescription("Match a noisy GPS trace to the roads it most likely followed"),
+		mcp.WithArray("points",
+			mcp.Description(fmt.Sprintf("Array of trace points in recorded order, each with latitude, longitude and optionally a timestamp as Unix seconds or RFC 3339 (2 to %d)", maxTracePoints)),
+		),
+		mcp.WithString("polyline",
+			mcp.Description("The trace as an encoded polyline with 5 digit precision, instead of points"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Transportation mode: car, bike, foot"),
+			mcp.DefaultString("car"),
+		),
+	)
+}
+
+// TraceMatch is a GPS trace matched to roads. The trace is split into
+// sections at gaps in time and where it cannot be matched.
+type TraceMatch struct {
+	Profile   string         `json:"profile"`
+	Sections  []TraceSection `json:"sections"`
+	Distance  float64        `json:"distance"`         // Total matched distance in meters
+	Duration  float64        `json:"duration"`         // Total travel time in seconds
+	Unmatched []int          `json:"unmatched_points"` // indexes of points matched to no road
+	Summary   string         `json:"summary"`
+}
+
+// TraceSection is one continuous matched part of a trace
+type TraceSection struct {
+	FirstPoint  int         `json:"first_point"` // index of the first trace point of the section
+	LastPoint   int         `json:"last_point"`  // index of the last trace point of the section
+	Confidence  float64     `json:"confidence"`  // 0 to 1, how likely the match is correct
+	Distance    float64     `json:"distance"`    // meters
+	Duration    float64     `json:"duration"`    // seconds
+	Roads       []string    `json:"roads"`       // names of the roads followed, in order
+	Coordinates [][]float64 `json:"coordinates"` // snapped geometry as [lon, lat] pairs
+}
+
+// HandleMatchGPSTrace matches a GPS trace to roads
+func HandleMatchGPSTrace(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	logger := slog.Default().With("tool", "match_gps_trace")
+
+	points, timestamps, err := parseTrace(req)
+	if err != nil {
+		return invalidArgument(err.Error()), nil
+	}
+	if len(points) < 2 || len(points) > maxTracePoints {
+		return invalidArgument(fmt.Sprintf("Between 2 and %d trace points are required", maxTracePoints)), nil
+	}
+
+	profile := mapModeToProfile(mcp.ParseString(req, "mode", "car"))
+	params := url.Values{}
+	params.Set("overview", "full")
+	params.Set("geometries", "polyline")
+	params.Set("steps", "true")
+	params.Set("gaps", "split")
+	if timestamps != nil {
+		stamps := make([]string, len(timestamps))
+		for i, t := range timestamps {
+			stamps[i] = strconv.FormatInt(t, 10)
+		}
+		params.Set("timestamps", strings.Join(stamps, ";"))
+	}
+
+	var resp struct {
+		Matchings []struct {
+			Confidence float64 `json:"confidence"`
+			Distance   float64 `json:"distance"`
+			Duration   float64 `json:"duration"`
+			Geometry   string  `json:"geometry"`
+			Legs       []struct {
+				Steps []struct {
+					Name string `json:"name"`
+				} `json:"steps"`
+			} `json:"legs"`
+		} `json:"matchings"`
+		Tracepoints []*struct {
+			MatchingsIndex int `json:"matchings_index"`
+		} `json:"tracepoints"`
+	}
+	if err := osrmGet(ctx, "match", profile, points, params, &resp); err != nil {
+		return osrmErrorResult(err, "The trace could not be matched to any road", logger), nil
+	}
+	if len(resp.Tracepoints) != len(points) {
+		return osrmErrorResult(fmt.Errorf("%w: match has %d tracepoints, want %d", errRouteResponse, len(resp.Tracepoints), len(points)), "", logger), nil
+	}
+
+	match := TraceMatch{
+		Profile:   profile,
+		Sections:  make([]TraceSection, len(resp.Matchings)),
+		Unmatched: []int{},
+	}
+	for i, m := range resp.Matchings {
+		section := TraceSection{
+			FirstPoint: -1,
+			Confidence: m.Confidence,
+			Distance:   m.Distance,
+			Duration:   m.Duration,
+			Roads:      []string{},
+		}
+		for _, leg := range m.Legs {
+			for _, step := range leg.Steps {
+				if step.Name != "" && (len(section.Roads) == 0 || section.Roads[len(section.Roads)-1] != step.Name) {
+					section.Roads = append(section.Roads, step.Name)
+				}
+			}
+		}
+		geometry := osm.DecodePolyline(m.Geometry)
+		section.Coordinates = make([][]float64, len(geometry))
+		for j, p := range geometry {
+			section.Coordinates[j] = []float64{p.Longitude, p.Latitude}
+		}
+		match.Sections[i] = section
+		match.Distance += m.Distance
+		match.Duration += m.Duration
+	}
+	for i, tp := range resp.Tracepoints {
+		if tp == nil || tp.MatchingsIndex < 0 || tp.MatchingsIndex >= len(match.Sections) {
+			match.Unmatched = append(match.Unmatched, i)
+			continue
+		}
+		section := &match.Sections[tp.MatchingsIndex]
+		if section.FirstPoint < 0 {
+			section.FirstPoint = i
+		}
+		section.LastPoint = i
+	}
+
+	match.Distance, match.Duration = roundTenth(match.Distance), roundTenth(match.Duration)
+	match.Summary = fmt.Sprintf("%d matched section(s) over %s; %d of %d points unmatched",
+		len(match.Sections), stateFrom(ctx).units.FormatDistance(match.Distance), len(match.Unmatched), len(points))
+
+	resultBytes, err := json.Marshal(match)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
+	}
+	return mcp.NewToolResultText(string(resultBytes)), nil
+}
+
+// parseTrace reads the trace of a match_gps_trace call from either its
+// points or its polyline. Timestamps are nil unless every point has one.
+func parseTrace(req mcp.CallToolRequest) ([]geo.Location, []int64, error) {
+	encoded := mcp.ParseString(req, "polyline", "")
+	raw, hasPoints := req.Params.Arguments["points"]
+	if hasPoints && raw != nil && encoded != "" {
+		return nil, nil, fmt.Errorf("give either points or polyline, not both")
+	}
+
+	if encoded != "" {
+		points := osm.DecodePolyline(encoded)
+		for i, p := range points {
+			if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+				return nil, nil, fmt.Errorf("polyline point %d is out of range; is it encoded with 5 digit precision?", i)
+			}
+		}
+		return points, nil, nil
+	}
+	if !hasPoints || raw == nil {
+		return nil, nil, fmt.Errorf("points or polyline is required")
+	}
+
+	locations, err := parseLocations(req, "points")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// parseLocations checked the shape of the array, so this only reads
+	// the timestamps
+	data, _ := json.Marshal(raw)
+	var items []struct {
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, nil, fmt.Errorf("points must be an array of objects with latitude and longitude")
+	}
+
+	var timestamps []int64
+	for i, item := range items {
+		if len(item.Timestamp) == 0 || string(item.Timestamp) == "null" {
+			if timestamps != nil {
+				return nil, nil, fmt.Errorf("points[%d] has no timestamp; give timestamps for all points or none", i)
+			}
+			continue
+		}
+		if i > 0 && timestamps == nil {
+			return nil, nil, fmt.Errorf("points[%d] has a timestamp; give timestamps for all points or none", i)
+		}
+		t, err := parseTimestamp(item.Timestamp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("points[%d] has an invalid timestamp: %v", i, err)
+		}
+		if len(timestamps) > 0 && t < timestamps[len(timestamps)-1] {
+			return nil, nil, fmt.Errorf("points[%d] is timestamped before the point before it", i)
+		}
+		timestamps = append(timestamps, t)
+	}
+	return geoLocations(locations), timestamps, nil
+}
+
+// parseTimestamp reads a timestamp given as Unix seconds or as an RFC 3339
+// string
+func parseTimestamp(raw json.RawMessage) (int64, error) {
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return int64(seconds), nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return 0, fmt.Errorf("want Unix seconds or an RFC 3339 string")
+	}
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return 0, fmt.Errorf("want Unix seconds or an RFC 3339 string")
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// maxMatrixLocations bounds the origins, and the destinations, of one
+	// distance matrix
+	maxMatrixLocations = 100
+
+	// maxTableCoordinates is the most coordinates sent in one OSRM table
+	// request; the public instance rejects larger tables
+	maxTableCoordinates = 100
+)
+
+// ComputeDistanceMatrixTool returns a tool definition for computing
+// travel times between many origins and destinations
+func ComputeDistanceMatrixTool() mcp.Tool {
+	return mcp.NewTool("compute_distance_matrix",
+		mcp.WithDescription("Compute travel durations and distances from every origin to every destination"),
+		mcp.WithArray("origins",
+			mcp.Required(),
+			mcp.Description(fmt.Sprintf("Array of origin locations, each with latitude and longitude (at most %d)", maxMatrixLocations)),
+		),
+		mcp.WithArray("destinations",
+			mcp.Description(fmt.Sprintf("Array of destination locations, each with latitude and longitude (at most %d). Defaults to the origins.", maxMatrixLocations)),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Transportation mode: car, bike, foot"),
+			mcp.DefaultString("car"),
+		),
+	)
+}
+
+// DistanceMatrix holds the travel durations and distances between every
+// origin and destination. Rows follow the origins and columns the
+// destinations; unreachable pairs are null.
+type DistanceMatrix struct {
+	Profile      string       `json:"profile"`
+	Origins      []Location   `json:"origins"`
+	Destinations []Location   `json:"destinations"`
+	Durations    [][]*float64 `json:"durations"` // seconds
+	Distances    [][]*float64 `json:"distances"` // meters
+
+	Snaps []SnapNotice `json:"snaps,omitempty"` // origins and destinations moved to a road
+}
+
+// HandleComputeDistanceMatrix computes the travel durations and distances
+// between lists of locations
+func HandleComputeDistanceMatrix(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	logger := slog.Default().With("tool", "compute_distance_matrix")
+
+	origins, err := parseLocations(req, "origins")
+	if err != nil {
+		return invalidArgument(err.Error()), nil
+	}
+	destinations, err := parseLocations(req, "destinations")
+	if err != nil {
+		return invalidArgument(err.Error()), nil
+	}
+	if destinations == nil {
+		destinations = origins
+	}
+	if len(origins) == 0 || len(destinations) == 0 {
+		return invalidArgument("At least one origin and one destination are required"), nil
+	}
+	if len(origins) > maxMatrixLocations || len(destinations) > maxMatrixLocations {
+		return invalidArgument(fmt.Sprintf("At most %d origins and %d destinations are supported", maxMatrixLocations, maxMatrixLocations)), nil
+	}
+
+	profile := mapModeToProfile(mcp.ParseString(req, "mode", "car"))
+	matrix := DistanceMatrix{
+		Profile:      profile,
+		Origins:      origins,
+		Destinations: destinations,
+		Durations:    emptyMatrix(len(origins), len(destinations)),
+		Distances:    emptyMatrix(len(origins), len(destinations)),
+	}
+
+	if err := fillMatrix(ctx, &matrix); err != nil {
+		return osrmErrorResult(err, "No routes found between the specified locations", logger), nil
+	}
+
+	resultBytes, err := json.Marshal(matrix)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
+	}
+	return mcp.NewToolResultText(string(resultBytes)), nil
+}
+
+// emptyMatrix returns a matrix of rows by cols null cells
+func emptyMatrix(rows, cols int) [][]*float64 {
+	m := make([][]*float64, rows)
+	for i := range m {
+		m[i] = make([]*float64, cols)
+	}
+	return m
+}
+
+// matrixSnaps holds the snapped point of each origin and destination of
+// a matrix, once known
+type matrixSnaps struct {
+	origins, destinations []*osm.Waypoint
+}
+
+// fillMatrix computes the cells of a matrix with the configured
+// osm.Router, or with OSRM table requests if there is none
+func fillMatrix(ctx context.Context, m *DistanceMatrix) error {
+	origins, destinations := geoLocations(m.Origins), geoLocations(m.Destinations)
+	snaps := matrixSnaps{
+		origins:      make([]*osm.Waypoint, len(origins)),
+		destinations: make([]*osm.Waypoint, len(destinations)),
+	}
+
+	if r := osm.FromContext(ctx).Router(); r != nil {
+		for i, from := range origins {
+			routes, err := routesFrom(ctx, r, m.Profile, from, destinations)
+			if errors.Is(err, osm.ErrNoRoute) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for j, route := range routes {
+				if route == nil {
+					continue
+				}
+				m.Durations[i][j] = &route.Duration
+				m.Distances[i][j] = &route.Distance
+				if len(route.Waypoints) == 2 {
+					snaps.origins[i], snaps.destinations[j] = &route.Waypoints[0], &route.Waypoints[1]
+				}
+			}
+		}
+	} else {
+		for _, c := range tableChunks(len(origins), len(destinations)) {
+			if err := fillTableChunk(ctx, m, origins, destinations, c, snaps); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.Snaps = nil
+	for i, w := range snaps.origins {
+		if w == nil {
+			continue
+		}
+		if n, ok := snapNotice(fmt.Sprintf("origins[%d]", i), m.Origins[i], *w); ok {
+			m.Snaps = append(m.Snaps, n)
+		}
+	}
+	for j, w := range snaps.destinations {
+		if w == nil {
+			continue
+		}
+		if n, ok := snapNotice(fmt.Sprintf("destinations[%d]", j), m.Destinations[j], *w); ok {
+			m.Snaps = append(m.Snaps, n)
+		}
+	}
+	return nil
+}
+
+// routesFrom finds the routes from one origin to each destination, in
+// a single search if the router is an osm.TableRouter. Destinations
+// without a route get a nil entry.
+func routesFrom(ctx context.Context, r osm.Router, profile string, from geo.Location, destinations []geo.Location) ([]*osm.RouteResult, error) {
+	if t, ok := r.(osm.TableRouter); ok {
+		return t.RoutesFrom(ctx, profile, from, destinations)
+	}
+	routes := make([]*osm.RouteResult, len(destinations))
+	for j, to := range destinations {
+		route, err := r.Route(ctx, profile, from, to)
+		if errors.Is(err, osm.ErrNoRoute) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		routes[j] = route
+	}
+	return routes, nil
+}
+
+// tableChunk is the block of a matrix one table request computes
+type tableChunk struct {
+	row, rows int // first origin and number of origins
+	col, cols int // first destination and number of destinations
+}
+
+// tableChunks splits a matrix into blocks small enough for one table
+// request each
+func tableChunks(rows, cols int) []tableChunk {
+	blockRows, blockCols := rows, cols
+	if rows+cols > maxTableCoordinates {
+		blockRows = min(rows, maxTableCoordinates/2)
+		blockCols = min(cols, maxTableCoordinates-blockRows)
+		blockRows = min(rows, maxTableCoordinates-blockCols)
+	}
+
+	var chunks []tableChunk
+	for row := 0; row < rows; row += blockRows {
+		for col := 0; col < cols; col += blockCols {
+			chunks = append(chunks, tableChunk{
+				row: row, rows: min(blockRows, rows-row),
+				col: col, cols: min(blockCols, cols-col),
+			})
+		}
+	}
+	return chunks
+}
+
+// fillTableChunk computes one block of a matrix with an OSRM table request
+func fillTableChunk(ctx context.Context, m *DistanceMatrix, origins, destinations []geo.Location, c tableChunk, snaps matrixSnaps) error {
+	points := make([]geo.Location, 0, c.rows+c.cols)
+	points = append(points, origins[c.row:c.row+c.rows]...)
+	points = append(points, destinations[c.col:c.col+c.cols]...)
+
+	params := url.Values{}
+	params.Set("sources", indexList(0, c.rows))
+	params.Set("destinations", indexList(c.rows, c.cols))
+	params.Set("annotations", "duration,distance")
+
+	var table struct {
+		Durations    [][]*float64   `json:"durations"`
+		Distances    [][]*float64   `json:"distances"`
+		Sources      []osrmWaypoint `json:"sources"`
+		Destinations []osrmWaypoint `json:"destinations"`
+	}
+	if err := osrmGet(ctx, "table", m.Profile, points, params, &table); err != nil {
+		return err
+	}
+	if len(table.Durations) != c.rows || len(table.Distances) != c.rows {
+		return fmt.Errorf("%w: table has %d rows, want %d", errRouteResponse, len(table.Durations), c.rows)
+	}
+
+	for i := range c.rows {
+		if len(table.Durations[i]) != c.cols || len(table.Distances[i]) != c.cols {
+			return fmt.Errorf("%w: table row %d has the wrong length", errRouteResponse, i)
+		}
+		copy(m.Durations[c.row+i][c.col:], table.Durations[i])
+		copy(m.Distances[c.row+i][c.col:], table.Distances[i])
+	}
+
+	if len(table.Sources) == c.rows && len(table.Destinations) == c.cols {
+		for i, w := range table.Sources {
+			w := w.waypoint()
+			snaps.origins[c.row+i] = &w
+		}
+		for j, w := range table.Destinations {
+			w := w.waypoint()
+			snaps.destinations[c.col+j] = &w
+		}
+	}
+	return nil
+}
+
+// indexList formats n consecutive coordinate indexes from first as OSRM's
+// semicolon separated list
+func indexList(first, n int) string {
+	indexes := make([]string, n)
+	for i := range indexes {
+		indexes[i] = strconv.Itoa(first + i)
+	}
+	return strings.Join(indexes, ";")
+}
@@ -0,0 +1,43 @@
+package tools
+
+import "testing"
+
+func TestTableChunks(t *testing.T) {
+	tests := []struct {
+		rows, cols int
+		want       int // number of chunks
+	}{
+		{rows: 2, cols: 3, want: 1},
+		{rows: 50, cols: 50, want: 1},
+		{rows: 3, cols: 100, want: 2},
+		{rows: 100, cols: 100, want: 4},
+	}
+	for _, tt := range tests {
+		chunks := tableChunks(tt.rows, tt.cols)
+		if len(chunks) != tt.want {
+			t.Errorf("tableChunks(%d, %d) made %d chunks, want %d", tt.rows, tt.cols, len(chunks), tt.want)
+		}
+
+		covered := make([][]int, tt.rows)
+		for i := range covered {
+			covered[i] = make([]int, tt.cols)
+		}
+		for _, c := range chunks {
+			if c.rows+c.cols > maxTableCoordinates {
+				t.Errorf("tableChunks(%d, %d) chunk %+v has more than %d coordinates", tt.rows, tt.cols, c, maxTableCoordinates)
+			}
+			for i := c.row; i < c.row+c.rows; i++ {
+				for j := c.col; j < c.col+c.cols; j++ {
+					covered[i][j]++
+				}
+			}
+		}
+		for i := range covered {
+			for j, n := range covered[i] {
+				if n != 1 {
+					t.Fatalf("tableChunks(%d, %d) covers cell %d,%d %d times", tt.rows, tt.cols, i, j, n)
+				}
+			}
+		}
+	}
+}
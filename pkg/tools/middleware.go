@@ -0,0 +1,546 @@
+// Package tools provides the OpenStreetMap MCP tools implementations.
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/NERVsystems/osmmcp/pkg/tracing"
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultToolTimeout bounds a tool call whose definition sets no timeout
+// of its own. Upstream requests are retried within it.
+const DefaultToolTimeout = 2 * time.Minute
+
+// ToolHandler handles one call of a tool.
+type ToolHandler func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)
+
+// Middleware wraps the handler of a tool with behavior shared by all
+// tools. It is given the tool's definition, so it can use the tool's
+// name, input schema and timeout.
+type Middleware func(def ToolDefinition, next ToolHandler) ToolHandler
+
+// WithMiddleware adds middleware to every tool of the registry. It runs
+// inside the built-in middleware, once the arguments have been validated,
+// in the order given.
+func WithMiddleware(mw ...Middleware) RegistryOption {
+	return func(r *Registry) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// Observer receives the outcome of every tool call, for example to
+// export it as metrics. Methods are called concurrently and must return
+// quickly.
+type Observer interface {
+	// ObserveToolCall reports a finished call with its ResultCode
+	ObserveToolCall(tool, code string, duration time.Duration)
+}
+
+// WithObserver reports every tool call of the registry to o.
+func WithObserver(o Observer) RegistryOption {
+	return func(r *Registry) {
+		r.observer = o
+	}
+}
+
+// WithToolTimeout sets the timeout of tools whose definition sets none.
+func WithToolTimeout(d time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.timeout = d
+	}
+}
+
+// chain returns the middleware applied to every tool, outermost first:
+// the registry's state, request IDs, trace spans, access logs, the
+// observer, panic and error capture, timeouts and argument validation,
+// then the registry's own middleware, upstream reporting and the result
+// cache.
+func (r *Registry) chain() []Middleware {
+	mw := []Middleware{
+		r.withState,
+		withRequestID,
+		withTracing,
+		r.withAccessLog,
+		r.withObserver,
+		r.withRecovery,
+		r.withTimeout,
+		withValidation,
+	}
+	mw = append(mw, r.middleware...)
+	return append(mw, withUpstreamInfo, withResultCache)
+}
+
+// wrap applies the middleware chain to the handler of a tool
+func (r *Registry) wrap(def ToolDefinition) ToolHandler {
+	handler := ToolHandler(def.Handler)
+	mw := r.chain()
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](def, handler)
+	}
+	return handler
+}
+
+// withState hands the registry's osm client and tool state to the handler
+// through its context.
+func (r *Registry) withState(_ ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx = osm.NewContext(ctx, r.client)
+		ctx = context.WithValue(ctx, stateKey{}, r.state)
+		return next(ctx, req)
+	}
+}
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose tool calls are logged and
+// reported under id. Calls without one get a random ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or an
+// empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// withRequestID gives every call a request ID and reports it in the
+// "request_id" entry of the result's _meta field.
+func withRequestID(_ ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id := RequestIDFromContext(ctx)
+		if id == "" {
+			id = newRequestID()
+			ctx = WithRequestID(ctx, id)
+		}
+
+		result, err := next(ctx, req)
+		if result == nil {
+			return result, err
+		}
+		return annotate(result, "request_id", id), err
+	}
+}
+
+// annotate returns a copy of result with an entry added to its _meta
+// field. Results may be cached and shared, so they are never changed.
+func annotate(result *mcp.CallToolResult, key string, value interface{}) *mcp.CallToolResult {
+	annotated := *result
+	annotated.Meta = make(map[string]interface{}, len(result.Meta)+1)
+	for k, v := range result.Meta {
+		annotated.Meta[k] = v
+	}
+	annotated.Meta[key] = value
+	return &annotated
+}
+
+// withTracing records every call as a span. The upstream requests made by
+// the tool are recorded as its children.
+func withTracing(def ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, span := tracing.Tracer().Start(ctx, "tool "+def.Name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		if span.IsRecording() {
+			span.SetAttributes(
+				attribute.String("tool.name", def.Name),
+				attribute.String("tool.request_id", RequestIDFromContext(ctx)),
+			)
+			span.SetAttributes(tracing.ArgumentAttributes(callArguments(req))...)
+		}
+
+		result, err := next(ctx, req)
+		code := ResultCode(result)
+		span.SetAttributes(attribute.String("tool.result_code", code))
+		if err != nil {
+			span.RecordError(err)
+		}
+		if err != nil || code != "ok" {
+			span.SetStatus(codes.Error, code)
+		}
+		return result, err
+	}
+}
+
+// withAccessLog logs every call with its duration and outcome.
+func (r *Registry) withAccessLog(def ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := next(ctx, req)
+
+		attrs := []any{
+			"tool", def.Name,
+			"request_id", RequestIDFromContext(ctx),
+			"duration", time.Since(start),
+			"is_error", err != nil || result == nil || result.IsError,
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		r.logger.Info("tool call", attrs...)
+		return result, err
+	}
+}
+
+// withObserver reports every call to the registry's observer, if any
+func (r *Registry) withObserver(def ToolDefinition, next ToolHandler) ToolHandler {
+	if r.observer == nil {
+		return next
+	}
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := next(ctx, req)
+		r.observer.ObserveToolCall(def.Name, ResultCode(result), time.Since(start))
+		return result, err
+	}
+}
+
+// ResultCode classifies a tool result: "ok" for a success, the HTTP
+// status of the APIError or the code of the detailed geocoding error an
+// error result was made from, or "error" for other error results.
+func ResultCode(result *mcp.CallToolResult) string {
+	if result == nil {
+		return "error"
+	}
+	if !result.IsError {
+		return "ok"
+	}
+	if code, ok := result.Meta[errorCodeMeta].(string); ok && code != "" {
+		return code
+	}
+	return "error"
+}
+
+// withRecovery turns panics and errors returned by a tool into error
+// results with guidance, so one failing tool cannot take down the
+// session and every failure reaches the client the same way.
+func (r *Registry) withRecovery(def ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (result *mcp.CallToolResult, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				r.logger.Error("tool panicked",
+					"tool", def.Name,
+					"request_id", RequestIDFromContext(ctx),
+					"panic", p,
+					"stack", string(debug.Stack()))
+				result, err = ErrorWithGuidance(&APIError{
+					Service:     "Internal",
+					StatusCode:  http.StatusInternalServerError,
+					Message:     fmt.Sprintf("The %s tool failed unexpectedly", def.Name),
+					Recoverable: true,
+					Guidance:    GuidanceGeneral,
+				}), nil
+			}
+		}()
+
+		result, err = next(ctx, req)
+		if err != nil {
+			return ErrorWithGuidance(toolError(def.Name, err)), nil
+		}
+		if result == nil {
+			return ErrorWithGuidance(&APIError{
+				Service:     "Internal",
+				StatusCode:  http.StatusInternalServerError,
+				Message:     fmt.Sprintf("The %s tool returned no result", def.Name),
+				Recoverable: true,
+				Guidance:    GuidanceGeneral,
+			}), nil
+		}
+		return result, nil
+	}
+}
+
+// toolError converts an error returned by a tool into an APIError
+func toolError(name string, err error) *APIError {
+	var apiErr *APIError
+	var reqErr *osm.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &reqErr):
+		service := reqErr.Service
+		if service == "" {
+			service = "upstream"
+		}
+		return upstreamError(service, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{
+			Service:     "Tool",
+			StatusCode:  http.StatusRequestTimeout,
+			Message:     fmt.Sprintf("The %s tool timed out", name),
+			Recoverable: true,
+			Guidance:    "Try a smaller search area or fewer locations, or try again later.",
+		}
+	case errors.Is(err, context.Canceled):
+		return &APIError{
+			Service:    "Tool",
+			StatusCode: 499, // Client closed request
+			Message:    "Request canceled",
+			Guidance:   "The request was canceled before completion",
+		}
+	}
+	return &APIError{
+		Service:     "Internal",
+		StatusCode:  http.StatusInternalServerError,
+		Message:     fmt.Sprintf("The %s tool failed: %v", name, err),
+		Recoverable: true,
+		Guidance:    GuidanceGeneral,
+	}
+}
+
+// withTimeout bounds each call by the tool's timeout. Handlers stop when
+// their context is done.
+func (r *Registry) withTimeout(def ToolDefinition, next ToolHandler) ToolHandler {
+	timeout := def.Timeout
+	if timeout <= 0 {
+		timeout = r.timeout
+	}
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if timeout <= 0 {
+			return next(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return next(ctx, req)
+	}
+}
+
+// withValidation checks the arguments against the tool's input schema
+// before the handler sees them.
+func withValidation(def ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if apiErr := ValidateArguments(def.Tool, callArguments(req)); apiErr != nil {
+			return ErrorWithGuidance(apiErr), nil
+		}
+		return next(ctx, req)
+	}
+}
+
+// callArguments returns the arguments of a call as a map
+func callArguments(req mcp.CallToolRequest) map[string]interface{} {
+	return req.Params.Arguments
+}
+
+// ValidateArguments checks tool arguments against the tool's input
+// schema: required arguments must be present, and arguments must have
+// the declared type and respect any enum, minimum and maximum. Numbers
+// and booleans may also be given as strings, as the handlers parse them
+// leniently.
+func ValidateArguments(tool mcp.Tool, args map[string]interface{}) *APIError {
+	invalid := func(format string, a ...interface{}) *APIError {
+		return &APIError{
+			Service:     "Validation",
+			StatusCode:  http.StatusBadRequest,
+			Message:     fmt.Sprintf(format, a...),
+			Recoverable: true,
+			Guidance:    "Please correct the parameters and try again.",
+		}
+	}
+
+	var missing []string
+	for _, name := range tool.InputSchema.Required {
+		if v, ok := args[name]; !ok || v == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return invalid("Missing required arguments: %s", strings.Join(missing, ", "))
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, ok := tool.InputSchema.Properties[name].(map[string]interface{})
+		value := args[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		typ, _ := prop["type"].(string)
+		number, isNumber := numberValue(value)
+		switch typ {
+		case "number", "integer":
+			if !isNumber {
+				return invalid("Argument %q must be a number", name)
+			}
+			if typ == "integer" && number != float64(int64(number)) {
+				return invalid("Argument %q must be a whole number", name)
+			}
+		case "string":
+			if _, ok := value.(string); !ok {
+				return invalid("Argument %q must be a string", name)
+			}
+		case "boolean":
+			if !isBool(value) {
+				return invalid("Argument %q must be true or false", name)
+			}
+		case "array":
+			if !isArray(value) {
+				return invalid("Argument %q must be an array", name)
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); !ok {
+				return invalid("Argument %q must be an object", name)
+			}
+		}
+
+		if enum := enumValues(prop["enum"]); len(enum) > 0 {
+			s := fmt.Sprint(value)
+			found := false
+			for _, e := range enum {
+				if e == s {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return invalid("Argument %q must be one of %s", name, strings.Join(enum, ", "))
+			}
+		}
+
+		if isNumber {
+			if min, ok := numberValue(prop["minimum"]); ok && number < min {
+				return invalid("Argument %q must be at least %g", name, min)
+			}
+			if max, ok := numberValue(prop["maximum"]); ok && number > max {
+				return invalid("Argument %q must be at most %g", name, max)
+			}
+		}
+	}
+	return nil
+}
+
+// numberValue converts a JSON number, a Go number or a numeric string
+// to a float64
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// isBool reports whether v is a boolean or a string naming one
+func isBool(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return true
+	case string:
+		_, err := strconv.ParseBool(b)
+		return err == nil
+	}
+	return false
+}
+
+// isArray reports whether v is a JSON array
+func isArray(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, []string, []float64, []map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// enumValues returns the allowed values of an enum as strings
+func enumValues(v interface{}) []string {
+	switch e := v.(type) {
+	case []string:
+		return e
+	case []interface{}:
+		values := make([]string, len(e))
+		for i, item := range e {
+			values[i] = fmt.Sprint(item)
+		}
+		return values
+	}
+	return nil
+}
+
+// withResultCache answers a repeated call with identical arguments from
+// the result cache. Error results are not cached, so failed calls can be
+// retried at once. Registries sharing a client share the cache, so the
+// key includes the default region and units the result depends on.
+func withResultCache(def ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// Map keys are marshaled in sorted order, so equal arguments give equal keys
+		args, err := json.Marshal(req.Params.Arguments)
+		if err != nil {
+			return next(ctx, req)
+		}
+		state := stateFrom(ctx)
+		key := fmt.Sprintf("%s %q %s %s", def.Name, state.region, state.units, args)
+
+		results := state.results
+		if result, found := results.Get(key); found {
+			return result, nil
+		}
+
+		result, err := next(ctx, req)
+		if err == nil && result != nil && !result.IsError {
+			results.Set(key, result)
+		}
+		return result, err
+	}
+}
+
+// withUpstreamInfo records the upstream requests made by a tool and
+// reports them, including any wait for an Overpass slot, in the "upstream"
+// entry of the result's _meta field.
+func withUpstreamInfo(_ ToolDefinition, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, info := osm.WithRequestInfo(ctx)
+		result, err := next(ctx, req)
+		if result == nil {
+			return result, err
+		}
+
+		if info.Requests() == 0 && info.CacheHits() == 0 {
+			return result, err
+		}
+		return annotate(result, "upstream", info.Snapshot()), err
+	}
+}
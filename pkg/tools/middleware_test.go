@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// testTool returns a definition of a tool with a required number argument
+func testTool(handler func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)) ToolDefinition {
+	return ToolDefinition{
+		Name: "test_tool",
+		Tool: mcp.NewTool("test_tool",
+			mcp.WithNumber("radius", mcp.Required(), mcp.Min(1), mcp.Max(100)),
+			mcp.WithString("mode", mcp.Enum("car", "foot")),
+		),
+		Handler: handler,
+	}
+}
+
+// call runs a tool definition through the middleware chain of a registry
+func call(t *testing.T, r *Registry, def ToolDefinition, ctx context.Context, args map[string]interface{}) *mcp.CallToolResult {
+	t.Helper()
+	req := mcp.CallToolRequest{}
+	req.Params.Name = def.Name
+	req.Params.Arguments = args
+
+	result, err := r.wrap(def)(ctx, req)
+	if err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if result == nil {
+		t.Fatal("handler returned no result")
+	}
+	return result
+}
+
+// resultText returns the text of the first content of a result
+func resultText(result *mcp.CallToolResult) string {
+	for _, c := range result.Content {
+		if text, ok := c.(mcp.TextContent); ok {
+			return text.Text
+		}
+	}
+	return ""
+}
+
+func TestMiddleware(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ok := func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(RequestIDFromContext(ctx)), nil
+	}
+
+	tests := []struct {
+		name      string
+		handler   func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args      map[string]interface{}
+		timeout   time.Duration
+		wantError string
+	}{
+		{name: "valid", handler: ok, args: map[string]interface{}{"radius": 10.0, "mode": "car"}},
+		{name: "numeric string", handler: ok, args: map[string]interface{}{"radius": "10"}},
+		{name: "missing argument", handler: ok, args: map[string]interface{}{}, wantError: "Missing required arguments: radius"},
+		{name: "wrong type", handler: ok, args: map[string]interface{}{"radius": "far"}, wantError: `"radius" must be a number`},
+		{name: "below minimum", handler: ok, args: map[string]interface{}{"radius": 0.5}, wantError: `"radius" must be at least 1`},
+		{name: "not in enum", handler: ok, args: map[string]interface{}{"radius": 10.0, "mode": "boat"}, wantError: `"mode" must be one of car, foot`},
+		{
+			name: "panic",
+			handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				panic("boom")
+			},
+			args:      map[string]interface{}{"radius": 10.0},
+			wantError: "test_tool tool failed unexpectedly",
+		},
+		{
+			name: "returned error",
+			handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return nil, &APIError{Service: "Test", StatusCode: 400, Message: "bad input", Guidance: "fix it"}
+			},
+			args:      map[string]interface{}{"radius": 10.0},
+			wantError: "bad input",
+		},
+		{
+			name: "timeout",
+			handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			args:      map[string]interface{}{"radius": 10.0},
+			timeout:   10 * time.Millisecond,
+			wantError: "test_tool tool timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(logger)
+			def := testTool(tt.handler)
+			def.Timeout = tt.timeout
+
+			result := call(t, r, def, context.Background(), tt.args)
+			text := resultText(result)
+			if tt.wantError == "" {
+				if result.IsError {
+					t.Fatalf("unexpected error result: %s", text)
+				}
+				return
+			}
+			if !result.IsError {
+				t.Fatalf("result is not an error: %s", text)
+			}
+			if !strings.Contains(text, tt.wantError) {
+				t.Errorf("error = %q, want it to contain %q", text, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var seen []string
+	record := func(def ToolDefinition, next ToolHandler) ToolHandler {
+		return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			seen = append(seen, def.Name+" "+RequestIDFromContext(ctx))
+			return next(ctx, req)
+		}
+	}
+	r := NewRegistry(slog.New(slog.NewTextHandler(io.Discard, nil)), WithMiddleware(record))
+	def := testTool(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(RequestIDFromContext(ctx)), nil
+	})
+
+	result := call(t, r, def, WithRequestID(context.Background(), "req-1"), map[string]interface{}{"radius": 42.0})
+	if got := resultText(result); got != "req-1" {
+		t.Errorf("handler saw request ID %q, want req-1", got)
+	}
+	if got := result.Meta["request_id"]; got != "req-1" {
+		t.Errorf("_meta request_id = %v, want req-1", got)
+	}
+	if len(seen) != 1 || seen[0] != "test_tool req-1" {
+		t.Errorf("registry middleware saw %v, want [test_tool req-1]", seen)
+	}
+
+	// Calls without a request ID get one of their own
+	result = call(t, r, def, context.Background(), map[string]interface{}{"radius": 43.0})
+	if id := resultText(result); id == "" || result.Meta["request_id"] != id {
+		t.Errorf("generated request ID %q, _meta request_id = %v", id, result.Meta["request_id"])
+	}
+}
+
+func TestResultCacheSharedClient(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := osm.NewOSMClient()
+	t.Cleanup(client.Close)
+
+	calls := 0
+	def := testTool(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return mcp.NewToolResultText(string(stateFrom(ctx).units)), nil
+	})
+	args := map[string]interface{}{"radius": 10.0}
+
+	metric := NewRegistry(logger, WithClient(client), WithUnits(UnitsMetric))
+	imperial := NewRegistry(logger, WithClient(client), WithUnits(UnitsImperial))
+	for _, r := range []*Registry{metric, imperial, metric, imperial} {
+		want := string(r.units)
+		if got := resultText(call(t, r, def, context.Background(), args)); got != want {
+			t.Errorf("registry with %s units got a result for %s", want, got)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want once per registry", calls)
+	}
+}
@@ -0,0 +1,347 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxRouteWaypoints bounds the waypoints of one multi-stop route
+const maxRouteWaypoints = 25
+
+// GetMultiStopRouteTool returns a tool definition for routes through
+// several waypoints
+func GetMultiStopRouteTool() mcp.Tool {
+	return mcp.NewTool("get_multi_stop_route",
+		mcp.WithDescription("Get directions for a route through several waypoints, optionally in the fastest visiting order"),
+		mcp.WithArray("waypoints",
+			mcp.Required(),
+			mcp.Description(fmt.Sprintf("Array of waypoints in visiting order, each with latitude and longitude (2 to %d)", maxRouteWaypoints)),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Transportation mode: car, bike, foot"),
+			mcp.DefaultString("car"),
+		),
+		mcp.WithBoolean("optimize",
+			mcp.Description("Reorder the waypoints to minimize the travel time"),
+			mcp.DefaultBool(false),
+		),
+		mcp.WithBoolean("fixed_start",
+			mcp.Description("When optimizing, keep the first waypoint first"),
+			mcp.DefaultBool(true),
+		),
+		mcp.WithBoolean("fixed_end",
+			mcp.Description("When optimizing, keep the last waypoint last"),
+			mcp.DefaultBool(false),
+		),
+		mcp.WithBoolean("round_trip",
+			mcp.Description("Return to the first stop after the last one"),
+			mcp.DefaultBool(false),
+		),
+	)
+}
+
+// MultiStopRoute is a route through several stops, one leg per pair of
+// consecutive stops
+type MultiStopRoute struct {
+	Profile   string            `json:"profile"`
+	Optimized bool              `json:"optimized"`
+	RoundTrip bool              `json:"round_trip"`
+	Order     []int             `json:"order"`    // index of each stop in the waypoints argument
+	Stops     []Location        `json:"stops"`    // waypoints in visiting order
+	Legs      []RouteDirections `json:"legs"`     // one per pair of stops, and back to the first on round trips
+	Distance  float64           `json:"distance"` // Total distance in meters
+	Duration  float64           `json:"duration"` // Total duration in seconds
+	Summary   string            `json:"summary"`
+}
+
+// HandleGetMultiStopRoute gets directions through several waypoints
+func HandleGetMultiStopRoute(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	logger := slog.Default().With("tool", "get_multi_stop_route")
+
+	waypoints, err := parseLocations(req, "waypoints")
+	if err != nil {
+		return invalidArgument(err.Error()), nil
+	}
+	if len(waypoints) < 2 || len(waypoints) > maxRouteWaypoints {
+		return invalidArgument(fmt.Sprintf("Between 2 and %d waypoints are required", maxRouteWaypoints)), nil
+	}
+
+	profile := mapModeToProfile(mcp.ParseString(req, "mode", "car"))
+	optimize := mcp.ParseBoolean(req, "optimize", false)
+	fixedStart := mcp.ParseBoolean(req, "fixed_start", true)
+	fixedEnd := mcp.ParseBoolean(req, "fixed_end", false)
+	roundTrip := mcp.ParseBoolean(req, "round_trip", false)
+
+	order := make([]int, len(waypoints))
+	for i := range order {
+		order[i] = i
+	}
+	if optimize {
+		if !roundTrip && !fixedStart && !fixedEnd {
+			return invalidArgument("Optimizing a route that is not a round trip needs a fixed start, a fixed end or both"), nil
+		}
+		order, err = tripOrder(ctx, profile, waypoints, fixedStart, fixedEnd, roundTrip)
+		if err != nil {
+			return osrmErrorResult(err, "No trip found through the specified waypoints", logger), nil
+		}
+	}
+
+	route := MultiStopRoute{
+		Profile:   profile,
+		Optimized: optimize,
+		RoundTrip: roundTrip,
+		Order:     order,
+		Stops:     make([]Location, len(order)),
+	}
+	for i, index := range order {
+		route.Stops[i] = waypoints[index]
+	}
+
+	legEnds := route.Stops
+	if roundTrip {
+		legEnds = append(legEnds[:len(legEnds):len(legEnds)], route.Stops[0])
+	}
+	legs, err := routeLegs(ctx, profile, legEnds)
+	if err != nil {
+		return osrmErrorResult(err, "No route found through the specified waypoints", logger), nil
+	}
+	for i, leg := range legs {
+		leg.Snaps = stopSnapNotices(leg.Snaps, i, (i+1)%len(route.Stops))
+		route.Legs = append(route.Legs, leg)
+		route.Distance += leg.Distance
+		route.Duration += leg.Duration
+	}
+
+	route.Distance, route.Duration = roundTenth(route.Distance), roundTenth(route.Duration)
+	route.Summary = fmt.Sprintf("%d stops, %s, %.0f min",
+		len(route.Stops), stateFrom(ctx).units.FormatDistance(route.Distance), route.Duration/60)
+
+	resultBytes, err := json.Marshal(route)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
+	}
+	return mcp.NewToolResultText(string(resultBytes)), nil
+}
+
+// routeLegs returns the directions between each pair of consecutive
+// stops. It routes every leg with the configured osm.Router, or else
+// asks OSRM for one route through all stops and splits it into its legs,
+// so that a long route costs a single request.
+func routeLegs(ctx context.Context, profile string, stops []Location) ([]RouteDirections, error) {
+	legs := make([]RouteDirections, 0, len(stops)-1)
+	if osm.FromContext(ctx).Router() != nil {
+		for i := 1; i < len(stops); i++ {
+			leg, err := routeDirections(ctx, profile, stops[i-1], stops[i])
+			if err != nil {
+				return nil, err
+			}
+			legs = append(legs, leg)
+		}
+		return legs, nil
+	}
+
+	params := url.Values{}
+	params.Set("overview", "false")
+	params.Set("steps", "true")
+	params.Set("geometries", "polyline")
+
+	var resp struct {
+		Routes []struct {
+			Legs []osrmLeg `json:"legs"`
+		} `json:"routes"`
+		Waypoints []osrmWaypoint `json:"waypoints"`
+	}
+	if err := osrmGet(ctx, "route", profile, geoLocations(stops), params, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Routes) == 0 {
+		return nil, osm.ErrNoRoute
+	}
+	if len(resp.Routes[0].Legs) != len(stops)-1 || len(resp.Waypoints) != len(stops) {
+		return nil, fmt.Errorf("%w: route has %d legs and %d waypoints for %d stops",
+			errRouteResponse, len(resp.Routes[0].Legs), len(resp.Waypoints), len(stops))
+	}
+
+	for i, l := range resp.Routes[0].Legs {
+		steps, err := l.routeSteps()
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, newRouteDirections(&osm.RouteResult{
+			Distance:  l.Distance,
+			Duration:  l.Duration,
+			Geometry:  l.geometry(),
+			Steps:     steps,
+			Waypoints: []osm.Waypoint{resp.Waypoints[i].waypoint(), resp.Waypoints[i+1].waypoint()},
+		}, stops[i], stops[i+1]))
+	}
+	return legs, nil
+}
+
+// stopSnapNotices names the start and end notices of a leg after the
+// indexes of the stops it connects. It copies the notices, which may be
+// shared with the route cache.
+func stopSnapNotices(notices []SnapNotice, from, to int) []SnapNotice {
+	if len(notices) == 0 {
+		return nil
+	}
+	named := make([]SnapNotice, len(notices))
+	for i, n := range notices {
+		switch n.Point {
+		case "start":
+			n.Point = fmt.Sprintf("stops[%d]", from)
+		case "end":
+			n.Point = fmt.Sprintf("stops[%d]", to)
+		}
+		named[i] = n
+	}
+	return named
+}
+
+// tripOrder returns the fastest visiting order of waypoints, as indexes
+// into waypoints. It solves the trip locally with the configured
+// osm.Router, or asks the OSRM trip service if there is none.
+func tripOrder(ctx context.Context, profile string, waypoints []Location, fixedStart, fixedEnd, roundTrip bool) ([]int, error) {
+	if osm.FromContext(ctx).Router() != nil {
+		matrix := DistanceMatrix{
+			Profile:      profile,
+			Origins:      waypoints,
+			Destinations: waypoints,
+			Durations:    emptyMatrix(len(waypoints), len(waypoints)),
+			Distances:    emptyMatrix(len(waypoints), len(waypoints)),
+		}
+		if err := fillMatrix(ctx, &matrix); err != nil {
+			return nil, err
+		}
+		return solveTrip(matrix.Durations, fixedStart, fixedEnd, roundTrip)
+	}
+
+	params := url.Values{}
+	params.Set("roundtrip", strconv.FormatBool(roundTrip))
+	params.Set("source", "any")
+	if fixedStart {
+		params.Set("source", "first")
+	}
+	params.Set("destination", "any")
+	if fixedEnd {
+		params.Set("destination", "last")
+	}
+	params.Set("overview", "false")
+
+	var trip struct {
+		Waypoints []struct {
+			WaypointIndex int `json:"waypoint_index"`
+			TripsIndex    int `json:"trips_index"`
+		} `json:"waypoints"`
+	}
+	if err := osrmGet(ctx, "trip", profile, geoLocations(waypoints), params, &trip); err != nil {
+		return nil, err
+	}
+	if len(trip.Waypoints) != len(waypoints) {
+		return nil, fmt.Errorf("%w: trip has %d waypoints, want %d", errRouteResponse, len(trip.Waypoints), len(waypoints))
+	}
+
+	order := make([]int, len(waypoints))
+	seen := make([]bool, len(waypoints))
+	for i, w := range trip.Waypoints {
+		// Waypoints in separate trips cannot be reached from each other
+		if w.TripsIndex != 0 {
+			return nil, osm.ErrNoRoute
+		}
+		if w.WaypointIndex < 0 || w.WaypointIndex >= len(order) || seen[w.WaypointIndex] {
+			return nil, fmt.Errorf("%w: invalid waypoint index %d", errRouteResponse, w.WaypointIndex)
+		}
+		order[w.WaypointIndex] = i
+		seen[w.WaypointIndex] = true
+	}
+	return order, nil
+}
+
+// solveTrip finds a short visiting order for a duration matrix by
+// nearest neighbour search improved with 2-opt moves. Fixed ends keep the
+// first and last points in place. It returns osm.ErrNoRoute if some
+// point cannot be reached.
+func solveTrip(durations [][]*float64, fixedStart, fixedEnd, roundTrip bool) ([]int, error) {
+	n := len(durations)
+	cost := func(order []int) float64 {
+		stops := order
+		if roundTrip {
+			stops = append(order[:n:n], order[0])
+		}
+		var total float64
+		for i := 1; i < len(stops); i++ {
+			d := durations[stops[i-1]][stops[i]]
+			if d == nil {
+				return math.Inf(1)
+			}
+			total += *d
+		}
+		return total
+	}
+
+	// Start with the nearest unvisited point next, keeping a fixed end last
+	order := []int{0}
+	visited := make([]bool, n)
+	visited[0] = true
+	if fixedEnd {
+		visited[n-1] = true
+	}
+	for len(order) < n {
+		last, next, best := order[len(order)-1], -1, math.Inf(1)
+		for i := range n {
+			if visited[i] {
+				continue
+			}
+			if d := durations[last][i]; next < 0 || (d != nil && *d < best) {
+				next = i
+				if d != nil {
+					best = *d
+				}
+			}
+		}
+		if next < 0 {
+			// Only the fixed end is left
+			next = n - 1
+		}
+		order = append(order, next)
+		visited[next] = true
+	}
+
+	// Reverse sections of the order while that shortens the trip
+	first, last := 0, n-1
+	if fixedStart {
+		first = 1
+	}
+	if fixedEnd {
+		last = n - 2
+	}
+	best := cost(order)
+	for improved := true; improved; {
+		improved = false
+		for i := first; i < last; i++ {
+			for j := i + 1; j <= last; j++ {
+				slices.Reverse(order[i : j+1])
+				if c := cost(order); c < best {
+					best, improved = c, true
+				} else {
+					slices.Reverse(order[i : j+1])
+				}
+			}
+		}
+	}
+
+	if math.IsInf(best, 1) {
+		return nil, osm.ErrNoRoute
+	}
+	return order, nil
+}
@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+// lineDurations returns the durations between points on a line at the
+// given positions
+func lineDurations(positions ...float64) [][]*float64 {
+	m := emptyMatrix(len(positions), len(positions))
+	for i, a := range positions {
+		for j, b := range positions {
+			d := math.Abs(a - b)
+			m[i][j] = &d
+		}
+	}
+	return m
+}
+
+func TestSolveTrip(t *testing.T) {
+	tests := []struct {
+		name                 string
+		durations            [][]*float64
+		fixedStart, fixedEnd bool
+		want                 []int
+	}{
+		{
+			name:       "fixed start",
+			durations:  lineDurations(0, 30, 10, 20),
+			fixedStart: true,
+			want:       []int{0, 2, 3, 1},
+		},
+		{
+			name:       "fixed start and end",
+			durations:  lineDurations(0, 20, 10, 30, 40),
+			fixedStart: true,
+			fixedEnd:   true,
+			want:       []int{0, 2, 1, 3, 4},
+		},
+		{
+			name:      "open start",
+			durations: lineDurations(10, 0, 20),
+			fixedEnd:  true,
+			want:      []int{1, 0, 2},
+		},
+	}
+	for _, tt := range tests {
+		got, err := solveTrip(tt.durations, tt.fixedStart, tt.fixedEnd, false)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: solveTrip() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	unreachable := lineDurations(0, 10, 20)
+	unreachable[0][2], unreachable[1][2] = nil, nil
+	if _, err := solveTrip(unreachable, true, false, false); !errors.Is(err, osm.ErrNoRoute) {
+		t.Errorf("solveTrip() with an unreachable point error = %v, want %v", err, osm.ErrNoRoute)
+	}
+}
@@ -134,7 +134,7 @@ func HandleAnalyzeNeighborhood(ctx context.Context, req mcp.CallToolRequest) (*m
 	queryBuilder.WriteString(");out center;")
 
 	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
+	reqURL, err := url.Parse(osm.FromContext(ctx).BaseURL(osm.ServiceOverpass))
 	if err != nil {
 		logger.Error("failed to parse URL", "error", err)
 		return ErrorResponse("Internal server error"), nil
@@ -151,18 +151,17 @@ func HandleAnalyzeNeighborhood(ctx context.Context, req mcp.CallToolRequest) (*m
 	httpReq.Header.Set("User-Agent", osm.UserAgent)
 
 	// Execute request
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
+	resp, err := osm.DoRequest(ctx, httpReq)
 	if err != nil {
 		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with OSM service"), nil
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 	defer resp.Body.Close()
 
 	// Process response
 	if resp.StatusCode != http.StatusOK {
 		logger.Error("OSM service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("OSM service error: %d", resp.StatusCode)), nil
+		return ErrorWithGuidance(statusError("Overpass", resp.StatusCode)), nil
 	}
 
 	// Parse response
@@ -543,7 +542,7 @@ func getNeighborhoodName(ctx context.Context, lat, lon float64) string {
 	neighborhoodName := "This area"
 
 	// Build Nominatim request URL
-	reqURL, err := url.Parse(osm.NominatimBaseURL + "/reverse")
+	reqURL, err := url.Parse(osm.FromContext(ctx).BaseURL(osm.ServiceNominatim) + "/reverse")
 	if err != nil {
 		return neighborhoodName
 	}
@@ -566,8 +565,7 @@ func getNeighborhoodName(ctx context.Context, lat, lon float64) string {
 	httpReq.Header.Set("User-Agent", osm.UserAgent)
 
 	// Execute request
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
+	resp, err := osm.DoRequest(ctx, httpReq)
 	if err != nil {
 		return neighborhoodName
 	}
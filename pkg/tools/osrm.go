@@ -0,0 +1,271 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// osrmStatus is the part every OSRM response shares
+type osrmStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// osrmWaypoint is an input coordinate as OSRM snapped it to the road
+// network
+type osrmWaypoint struct {
+	Name     string    `json:"name"`
+	Location []float64 `json:"location"` // [lon, lat]
+	Distance float64   `json:"distance"` // meters between input and snapped point
+}
+
+// waypoint converts a waypoint to the form of osm.RouteResult
+func (w osrmWaypoint) waypoint() osm.Waypoint {
+	wp := osm.Waypoint{Name: w.Name, Distance: w.Distance}
+	if len(w.Location) >= 2 {
+		wp.Location = geo.Location{Latitude: w.Location[1], Longitude: w.Location[0]}
+	}
+	return wp
+}
+
+// osrmLeg is the part of an OSRM route between two consecutive waypoints
+type osrmLeg struct {
+	Distance float64    `json:"distance"`
+	Duration float64    `json:"duration"`
+	Steps    []osrmStep `json:"steps"`
+}
+
+// osrmStep is one maneuver of a leg and the way followed after it
+type osrmStep struct {
+	Distance float64 `json:"distance"`
+	Duration float64 `json:"duration"`
+	Name     string  `json:"name"`
+	Geometry string  `json:"geometry"` // polyline from this maneuver to the next
+	Maneuver struct {
+		Location []float64 `json:"location"` // [lon, lat]
+		Type     string    `json:"type"`
+		Modifier string    `json:"modifier,omitempty"`
+	} `json:"maneuver"`
+}
+
+// routeSteps converts the steps of a leg to the form of osm.RouteResult
+func (l osrmLeg) routeSteps() ([]osm.RouteStep, error) {
+	steps := make([]osm.RouteStep, 0, len(l.Steps))
+	for _, step := range l.Steps {
+		if len(step.Maneuver.Location) < 2 {
+			return nil, fmt.Errorf("%w: maneuver without location", errRouteResponse)
+		}
+		steps = append(steps, osm.RouteStep{
+			Distance: step.Distance,
+			Duration: step.Duration,
+			Name:     step.Name,
+			Type:     step.Maneuver.Type,
+			Modifier: step.Maneuver.Modifier,
+			Location: geo.Location{Latitude: step.Maneuver.Location[1], Longitude: step.Maneuver.Location[0]},
+		})
+	}
+	return steps, nil
+}
+
+// geometry joins the step geometries of a leg, which meet at the
+// maneuvers
+func (l osrmLeg) geometry() []geo.Location {
+	var points []geo.Location
+	for _, step := range l.Steps {
+		for _, p := range osm.DecodePolyline(step.Geometry) {
+			if n := len(points); n > 0 && points[n-1] == p {
+				continue
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// osrmError is an error response of OSRM, such as InvalidQuery or NoRoute
+type osrmError struct {
+	Code    string
+	Message string
+}
+
+// Error implements the error interface
+func (e *osrmError) Error() string {
+	if e.Message == "" {
+		return "OSRM " + e.Code
+	}
+	return fmt.Sprintf("OSRM %s: %s", e.Code, e.Message)
+}
+
+// Is makes the codes that mean "nothing found" match osm.ErrNoRoute
+func (e *osrmError) Is(target error) bool {
+	if target != osm.ErrNoRoute {
+		return false
+	}
+	switch e.Code {
+	case "NoRoute", "NoTable", "NoMatch", "NoSegment", "NoTrips":
+		return true
+	}
+	return false
+}
+
+// roundTenth rounds a sum of OSRM distances or durations to a tenth, as
+// OSRM reports them
+func roundTenth(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// osrmCoordinates formats points as OSRM's lon,lat;lon,lat path segment
+func osrmCoordinates(points []geo.Location) string {
+	pairs := make([]string, len(points))
+	for i, p := range points {
+		pairs[i] = fmt.Sprintf("%f,%f", p.Longitude, p.Latitude)
+	}
+	return strings.Join(pairs, ";")
+}
+
+// osrmGet calls an OSRM service, such as table or nearest, for points
+// and decodes the response into out. Error responses are returned as
+// *osrmError; those meaning that nothing was found match osm.ErrNoRoute.
+func osrmGet(ctx context.Context, service, profile string, points []geo.Location, params url.Values, out interface{}) error {
+	client := osm.FromContext(ctx)
+	reqURL := fmt.Sprintf("%s/%s/v1/%s/%s",
+		client.BaseURL(osm.ServiceOSRM), service, profile, osrmCoordinates(points))
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	httpReq, err := osm.NewRequestWithUserAgent(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := osm.DoRequest(ctx, httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// OSRM explains rejected queries in a JSON body
+	var status osrmStatus
+	if err := json.Unmarshal(body, &status); err != nil || status.Code == "" {
+		if resp.StatusCode != http.StatusOK {
+			return &osm.RequestError{Service: osm.ServiceOSRM, StatusCode: resp.StatusCode, Attempts: 1}
+		}
+		return fmt.Errorf("%w: %s response without code", errRouteResponse, service)
+	}
+	if status.Code != "Ok" {
+		return &osrmError{Code: status.Code, Message: status.Message}
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%w: %v", errRouteResponse, err)
+	}
+	return nil
+}
+
+// osrmErrorResult turns an error of an OSRM service into a tool result
+// with guidance. notFound describes what no result means for the tool.
+func osrmErrorResult(err error, notFound string, logger *slog.Logger) *mcp.CallToolResult {
+	var osrmErr *osrmError
+	switch {
+	case errors.Is(err, osm.ErrNoRoute):
+		return ErrorWithGuidance(&APIError{
+			Service:     "OSRM",
+			StatusCode:  http.StatusOK,
+			Message:     notFound,
+			Guidance:    GuidanceOSRMRouteNotFound,
+			Recoverable: true,
+		})
+	case errors.As(err, &osrmErr):
+		return ErrorWithGuidance(&APIError{
+			Service:     "OSRM",
+			StatusCode:  http.StatusBadRequest,
+			Message:     osrmErr.Error(),
+			Guidance:    GuidanceOSRMGeneral,
+			Recoverable: true,
+		})
+	case errors.Is(err, errRouteResponse):
+		logger.Error("failed to decode response", "error", err)
+		return ErrorWithGuidance(&APIError{
+			Service:     "OSRM",
+			StatusCode:  http.StatusInternalServerError,
+			Message:     "Failed to parse routing response",
+			Guidance:    GuidanceDataError,
+			Recoverable: true,
+		})
+	}
+	logger.Error("OSRM request failed", "error", err)
+	return ErrorWithGuidance(upstreamError("OSRM", err))
+}
+
+// parseLocations reads an argument holding an array of objects with
+// latitude and longitude and checks that every coordinate is valid
+func parseLocations(req mcp.CallToolRequest, name string) ([]Location, error) {
+	raw, ok := req.Params.Arguments[name]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+
+	var items []struct {
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%s must be an array of objects with latitude and longitude", name)
+	}
+
+	locations := make([]Location, len(items))
+	for i, item := range items {
+		if item.Latitude == nil || item.Longitude == nil {
+			return nil, fmt.Errorf("%s[%d] needs latitude and longitude", name, i)
+		}
+		if *item.Latitude < -90 || *item.Latitude > 90 {
+			return nil, fmt.Errorf("%s[%d] has latitude %f; it must be between -90 and 90", name, i, *item.Latitude)
+		}
+		if *item.Longitude < -180 || *item.Longitude > 180 {
+			return nil, fmt.Errorf("%s[%d] has longitude %f; it must be between -180 and 180", name, i, *item.Longitude)
+		}
+		locations[i] = Location{Latitude: *item.Latitude, Longitude: *item.Longitude}
+	}
+	return locations, nil
+}
+
+// geoLocations converts locations to the points of the geo package
+func geoLocations(locations []Location) []geo.Location {
+	points := make([]geo.Location, len(locations))
+	for i, l := range locations {
+		points[i] = geo.Location{Latitude: l.Latitude, Longitude: l.Longitude}
+	}
+	return points
+}
+
+// invalidArgument returns the result of an argument that fails validation
+func invalidArgument(message string) *mcp.CallToolResult {
+	return ErrorWithGuidance(&APIError{
+		Service:     "Validation",
+		StatusCode:  http.StatusBadRequest,
+		Message:     message,
+		Guidance:    "Please correct the parameters and try again.",
+		Recoverable: true,
+	})
+}
@@ -6,8 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"sort"
 	"strings"
 
@@ -91,88 +89,22 @@ func HandleFindParkingFacilities(ctx context.Context, req mcp.CallToolRequest) (
 		limit = 50 // Max limit
 	}
 
-	// Build Overpass query for parking facilities
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("[out:json];")
-
-	// Search for nodes with amenity=parking
-	queryBuilder.WriteString(fmt.Sprintf("(node(around:%f,%f,%f)[amenity=parking];", radius, latitude, longitude))
-
-	// Search for ways (areas) with amenity=parking
-	queryBuilder.WriteString(fmt.Sprintf("way(around:%f,%f,%f)[amenity=parking];", radius, latitude, longitude))
-
-	// Search for relations with amenity=parking (for complex parking structures)
-	queryBuilder.WriteString(fmt.Sprintf("relation(around:%f,%f,%f)[amenity=parking];", radius, latitude, longitude))
-
-	// Complete the query
-	queryBuilder.WriteString(");out center;")
-
-	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
-	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
-	}
-
-	// Make HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(), strings.NewReader("data="+url.QueryEscape(queryBuilder.String())))
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create request"), nil
+	// Fetch parking facilities, reusing cached tiles of earlier searches
+	filter := osm.ElementFilter{
+		Types: []string{"node", "way", "relation"},
+		Tags:  map[string][]string{"amenity": {"parking"}},
 	}
-
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("User-Agent", osm.UserAgent)
-
-	// Execute request
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
+	elements, err := osm.QueryElementsAround(ctx, filter, latitude, longitude, radius)
 	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with OSM service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("OSM service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("OSM service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse response
-	var overpassResp struct {
-		Elements []struct {
-			ID     int     `json:"id"`
-			Type   string  `json:"type"`
-			Lat    float64 `json:"lat,omitempty"`
-			Lon    float64 `json:"lon,omitempty"`
-			Center *struct {
-				Lat float64 `json:"lat"`
-				Lon float64 `json:"lon"`
-			} `json:"center,omitempty"`
-			Tags map[string]string `json:"tags"`
-		} `json:"elements"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorResponse("Failed to parse parking facilities data"), nil
+		logger.Error("failed to query parking facilities", "error", err)
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 
 	// Convert to ParkingArea objects and calculate distances
 	facilities := make([]ParkingArea, 0)
-	for _, element := range overpassResp.Elements {
-		// Get coordinates (handling both nodes and ways/relations)
-		var lat, lon float64
-		if element.Type == "node" {
-			lat = element.Lat
-			lon = element.Lon
-		} else if (element.Type == "way" || element.Type == "relation") && element.Center != nil {
-			lat = element.Center.Lat
-			lon = element.Center.Lon
-		} else {
-			continue // Skip elements without coordinates
-		}
+	for _, element := range elements {
+		// Ways and relations are located at their center
+		lat, lon := element.Lat, element.Lon
 
 		// Skip private facilities if not requested
 		if !includePrivate {
@@ -4,14 +4,13 @@ package tools
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/NERVsystems/osmmcp/pkg/geo"
 	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/mark3labs/mcp-go/mcp"
 )
@@ -74,118 +73,27 @@ func HandleFindNearbyPlaces(ctx context.Context, rawInput mcp.CallToolRequest) (
 	// Map generic categories to OSM tags
 	osmTags := mapCategoryToOSMTags(category)
 
-	// Build Overpass query
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("[out:json];")
-	queryBuilder.WriteString(fmt.Sprintf("(node(around:%f,%f,%f)", radius, latitude, longitude))
-
-	// Add tag filters if category specified
-	if len(osmTags) > 0 {
-		for key, values := range osmTags {
-			for _, value := range values {
-				queryBuilder.WriteString(fmt.Sprintf("[%s=%s]", key, value))
-			}
-		}
-	}
-
-	// Complete the query
-	queryBuilder.WriteString(";);out body;")
-
-	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
+	// Fetch matching nodes, reusing cached tiles of earlier searches
+	elements, err := osm.QueryElementsAround(ctx, osm.ElementFilter{Types: []string{"node"}, Tags: osmTags}, latitude, longitude, radius)
 	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
-	}
-
-	// Make HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(), strings.NewReader("data="+url.QueryEscape(queryBuilder.String())))
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create request"), nil
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// Execute request with rate limiting
-	resp, err := osm.DoRequest(ctx, req)
-	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with places service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("places service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("Places service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse response
-	var overpassResp struct {
-		Elements []struct {
-			ID   int     `json:"id"`
-			Type string  `json:"type"`
-			Lat  float64 `json:"lat"`
-			Lon  float64 `json:"lon"`
-			Tags struct {
-				Name     string `json:"name"`
-				Amenity  string `json:"amenity"`
-				Shop     string `json:"shop"`
-				Tourism  string `json:"tourism"`
-				Leisure  string `json:"leisure"`
-				Highway  string `json:"highway"`
-				Building string `json:"building"`
-			} `json:"tags"`
-		} `json:"elements"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorResponse("Failed to parse places response"), nil
+		if errors.Is(err, osm.ErrInvalidFilter) {
+			return ErrorResponse("Invalid category: " + category), nil
+		}
+		logger.Error("failed to query places", "error", err)
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 
 	// Convert to Place objects and calculate distances
 	places := make([]Place, 0)
-	for _, element := range overpassResp.Elements {
-		// Skip elements without a name
-		if element.Tags.Name == "" {
+	for _, element := range elements {
+		place, ok := elementToPlace(element)
+		if !ok {
 			continue
 		}
-
-		// Calculate distance
-		distance := osm.HaversineDistance(
+		place.Distance = osm.HaversineDistance(
 			latitude, longitude,
 			element.Lat, element.Lon,
 		)
-
-		// Determine place category
-		categories := []string{}
-		if element.Tags.Amenity != "" {
-			categories = append(categories, element.Tags.Amenity)
-		}
-		if element.Tags.Shop != "" {
-			categories = append(categories, "shop:"+element.Tags.Shop)
-		}
-		if element.Tags.Tourism != "" {
-			categories = append(categories, "tourism:"+element.Tags.Tourism)
-		}
-		if element.Tags.Leisure != "" {
-			categories = append(categories, "leisure:"+element.Tags.Leisure)
-		}
-
-		// Create place object
-		place := Place{
-			ID:   strconv.Itoa(element.ID),
-			Name: element.Tags.Name,
-			Location: Location{
-				Latitude:  element.Lat,
-				Longitude: element.Lon,
-			},
-			Categories: categories,
-			Distance:   distance,
-		}
-
 		places = append(places, place)
 	}
 
@@ -257,6 +165,40 @@ func mapCategoryToOSMTags(category string) map[string][]string {
 	}
 }
 
+// elementToPlace converts a named Overpass element to a Place. Elements
+// without a name are skipped.
+func elementToPlace(element osm.OverpassElement) (Place, bool) {
+	name := element.Tags["name"]
+	if name == "" {
+		return Place{}, false
+	}
+
+	// Determine place category
+	categories := []string{}
+	if v := element.Tags["amenity"]; v != "" {
+		categories = append(categories, v)
+	}
+	if v := element.Tags["shop"]; v != "" {
+		categories = append(categories, "shop:"+v)
+	}
+	if v := element.Tags["tourism"]; v != "" {
+		categories = append(categories, "tourism:"+v)
+	}
+	if v := element.Tags["leisure"]; v != "" {
+		categories = append(categories, "leisure:"+v)
+	}
+
+	return Place{
+		ID:   strconv.FormatInt(element.ID, 10),
+		Name: name,
+		Location: Location{
+			Latitude:  element.Lat,
+			Longitude: element.Lon,
+		},
+		Categories: categories,
+	}, true
+}
+
 // sortPlacesByDistance sorts places by distance (closest first)
 func sortPlacesByDistance(places []Place) {
 	// Replace bubble sort with sort.Slice for better performance
@@ -331,110 +273,23 @@ func HandleSearchCategory(ctx context.Context, rawInput mcp.CallToolRequest) (*m
 	// Map generic categories to OSM tags
 	osmTags := mapCategoryToOSMTags(category)
 
-	// Build Overpass query
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("[out:json];")
-	queryBuilder.WriteString(fmt.Sprintf("(node(%f,%f,%f,%f)", southLat, westLon, northLat, eastLon))
-
-	// Add tag filters
-	for key, values := range osmTags {
-		for _, value := range values {
-			queryBuilder.WriteString(fmt.Sprintf("[%s=%s]", key, value))
-		}
-	}
-
-	// Complete the query
-	queryBuilder.WriteString(";);out body;")
-
-	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
+	// Fetch matching nodes, reusing cached tiles of earlier searches
+	box := geo.BoundingBox{MinLat: southLat, MinLon: westLon, MaxLat: northLat, MaxLon: eastLon}
+	elements, err := osm.QueryElementsInBBox(ctx, osm.ElementFilter{Types: []string{"node"}, Tags: osmTags}, box)
 	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
-	}
-
-	// Make HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(), strings.NewReader("data="+url.QueryEscape(queryBuilder.String())))
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create request"), nil
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	// Execute request with rate limiting
-	resp, err := osm.DoRequest(ctx, req)
-	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with places service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("places service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("Places service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse response
-	var overpassResp struct {
-		Elements []struct {
-			ID   int     `json:"id"`
-			Type string  `json:"type"`
-			Lat  float64 `json:"lat"`
-			Lon  float64 `json:"lon"`
-			Tags struct {
-				Name     string `json:"name"`
-				Amenity  string `json:"amenity"`
-				Shop     string `json:"shop"`
-				Tourism  string `json:"tourism"`
-				Leisure  string `json:"leisure"`
-				Highway  string `json:"highway"`
-				Building string `json:"building"`
-			} `json:"tags"`
-		} `json:"elements"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorResponse("Failed to parse places response"), nil
+		if errors.Is(err, osm.ErrInvalidFilter) {
+			return ErrorResponse("Invalid category: " + category), nil
+		}
+		logger.Error("failed to query places", "error", err)
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 
 	// Convert to Place objects
 	places := make([]Place, 0)
-	for _, element := range overpassResp.Elements {
-		// Skip elements without a name
-		if element.Tags.Name == "" {
-			continue
-		}
-
-		// Determine place category
-		categories := []string{}
-		if element.Tags.Amenity != "" {
-			categories = append(categories, element.Tags.Amenity)
-		}
-		if element.Tags.Shop != "" {
-			categories = append(categories, "shop:"+element.Tags.Shop)
-		}
-		if element.Tags.Tourism != "" {
-			categories = append(categories, "tourism:"+element.Tags.Tourism)
-		}
-		if element.Tags.Leisure != "" {
-			categories = append(categories, "leisure:"+element.Tags.Leisure)
+	for _, element := range elements {
+		if place, ok := elementToPlace(element); ok {
+			places = append(places, place)
 		}
-
-		// Create place object
-		place := Place{
-			ID:   strconv.Itoa(element.ID),
-			Name: element.Tags.Name,
-			Location: Location{
-				Latitude:  element.Lat,
-				Longitude: element.Lon,
-			},
-			Categories: categories,
-		}
-
-		places = append(places, place)
 	}
 
 	// Limit results
@@ -4,34 +4,222 @@ package tools
 import (
 	"context"
 	"log/slog"
+	"time"
 
+	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/NERVsystems/osmmcp/pkg/tools/prompts"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// resultCacheTTL is how long a tool result is reused for a repeated call
+	resultCacheTTL = 15 * time.Minute
+
+	// resultCacheSize is the number of tool results kept
+	resultCacheSize = 1000
 )
 
 // Registry holds all MCP tool registrations for the OpenStreetMap service.
 type Registry struct {
 	logger *slog.Logger
+	client *osm.Client
+	region string
+	units  Units
+	state  *toolState
+
+	// enabled limits the registered tools, if not empty; disabled tools
+	// are never registered
+	enabled  map[string]bool
+	disabled map[string]bool
+
+	// timeout applies to tools whose definition sets none
+	timeout time.Duration
+
+	// middleware is applied to every tool after the built-in middleware
+	middleware []Middleware
+
+	// observer receives the outcome of every call, if set
+	observer Observer
+}
+
+// RegistryOption configures a Registry.
+type RegistryOption func(*Registry)
+
+// WithClient makes the registry's tools send their upstream requests
+// with c instead of the default osm client.
+func WithClient(c *osm.Client) RegistryOption {
+	return func(r *Registry) {
+		r.client = c
+	}
+}
+
+// WithDefaultRegion sets the region geocode_address appends to queries
+// that name no region of their own.
+func WithDefaultRegion(region string) RegistryOption {
+	return func(r *Registry) {
+		r.region = region
+	}
+}
+
+// WithUnits sets the units distances are written in by tool summaries.
+func WithUnits(units Units) RegistryOption {
+	return func(r *Registry) {
+		r.units = units
+	}
+}
+
+// WithEnabledTools registers only the named tools. Without this option
+// every tool is registered.
+func WithEnabledTools(names ...string) RegistryOption {
+	return func(r *Registry) {
+		r.enabled = nameSet(names)
+	}
+}
+
+// WithDisabledTools leaves the named tools unregistered.
+func WithDisabledTools(names ...string) RegistryOption {
+	return func(r *Registry) {
+		r.disabled = nameSet(names)
+	}
+}
+
+// nameSet returns the set of names, or nil if there are none
+func nameSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
 }
 
 // NewRegistry creates a new MCP tool registry.
-func NewRegistry(logger *slog.Logger) *Registry {
-	return &Registry{
-		logger: logger,
+func NewRegistry(logger *slog.Logger, opts ...RegistryOption) *Registry {
+	r := &Registry{
+		logger:  logger,
+		client:  osm.Default(),
+		region:  DefaultRegion,
+		units:   UnitsMetric,
+		timeout: DefaultToolTimeout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.state = newToolState(r.client, r.region, r.units)
+	return r
+}
+
+// Client returns the osm client the registry's tools use.
+func (r *Registry) Client() *osm.Client {
+	return r.client
+}
+
+// ClearResultCache forgets the cached tool results, for example after
+// the default region or the units changed.
+func (r *Registry) ClearResultCache() {
+	r.state.results.Clear()
+}
+
+// toolState is what the tools of one registry share: the osm client,
+// the default region, the units and the caches. The caches live in the
+// client's store, so clients configured differently never see each
+// other's results, while registries of one client share them: result
+// keys include the region and units, and geocoding keys the full query
+// with its region.
+type toolState struct {
+	client *osm.Client
+	region string
+	units  Units
+
+	results *cache.Namespace[*mcp.CallToolResult]
+	geocode *cache.Namespace[[]NominatimResult]
+	reverse *cache.Namespace[ReverseGeocodeOutput]
+	routes  *cache.Namespace[RouteDirections]
+
+	// requests deduplicates in-flight geocoding requests
+	requests singleflight.Group
+}
+
+// newToolState creates the tool state for a client
+func newToolState(client *osm.Client, region string, units Units) *toolState {
+	store := client.Cache()
+	return &toolState{
+		client:  client,
+		region:  region,
+		units:   units,
+		results: cache.NewNamespace[*mcp.CallToolResult](store, "tool_results", resultCacheTTL, resultCacheSize),
+		geocode: cache.NewNamespace[[]NominatimResult](store, "geocode", cacheTTL, cacheSize),
+		reverse: cache.NewNamespace[ReverseGeocodeOutput](store, "reverse_geocode", cacheTTL, cacheSize),
+		routes:  cache.NewNamespace[RouteDirections](store, "route", 15*time.Minute, 1000),
 	}
 }
 
+// defaultState serves handlers called without a registry
+var defaultState = newToolState(osm.Default(), DefaultRegion, UnitsMetric)
+
+// stateKey is the context key of the tool state
+type stateKey struct{}
+
+// stateFrom returns the tool state carried by ctx, or the default state
+func stateFrom(ctx context.Context) *toolState {
+	if state, ok := ctx.Value(stateKey{}).(*toolState); ok {
+		return state
+	}
+	return defaultState
+}
+
 // ToolDefinition represents an OpenStreetMap MCP tool definition.
 type ToolDefinition struct {
 	Name        string
 	Description string
 	Tool        mcp.Tool
 	Handler     func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)
+
+	// Timeout bounds each call; zero uses the registry's timeout
+	Timeout time.Duration
 }
 
-// GetToolDefinitions returns all OpenStreetMap MCP tool definitions.
+// GetToolDefinitions returns the enabled OpenStreetMap MCP tool
+// definitions, with their handlers wrapped in the registry's middleware
+// chain.
 func (r *Registry) GetToolDefinitions() []ToolDefinition {
+	all := r.toolDefinitions()
+	defs := make([]ToolDefinition, 0, len(all))
+	for _, def := range all {
+		if !r.isEnabled(def.Name) {
+			continue
+		}
+		def.Handler = r.wrap(def)
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// isEnabled reports whether the registry registers the named tool
+func (r *Registry) isEnabled(name string) bool {
+	if r.disabled[name] {
+		return false
+	}
+	return r.enabled == nil || r.enabled[name]
+}
+
+// Names returns the names of all tools, whether enabled or not.
+func Names() []string {
+	defs := (&Registry{}).toolDefinitions()
+	names := make([]string, len(defs))
+	for i, def := range defs {
+		names[i] = def.Name
+	}
+	return names
+}
+
+// toolDefinitions lists the tools with their plain handlers.
+func (r *Registry) toolDefinitions() []ToolDefinition {
 	return []ToolDefinition{
 		// Geocoding Tools
 		{
@@ -68,12 +256,45 @@ func (r *Registry) GetToolDefinitions() []ToolDefinition {
 			Tool:        GetRouteDirectionsTool(),
 			Handler:     HandleGetRouteDirections,
 		},
+		{
+			Name:        "get_multi_stop_route",
+			Description: "Get directions through several waypoints, optionally in the fastest order",
+			Tool:        GetMultiStopRouteTool(),
+			Handler:     HandleGetMultiStopRoute,
+			Timeout:     time.Minute,
+		},
 		{
 			Name:        "suggest_meeting_point",
 			Description: "Suggest an optimal meeting point for multiple people",
 			Tool:        SuggestMeetingPointTool(),
 			Handler:     HandleSuggestMeetingPoint,
 		},
+		{
+			Name:        "compute_distance_matrix",
+			Description: "Compute travel durations and distances between lists of locations",
+			Tool:        ComputeDistanceMatrixTool(),
+			Handler:     HandleComputeDistanceMatrix,
+			Timeout:     time.Minute,
+		},
+		{
+			Name:        "match_gps_trace",
+			Description: "Match a GPS trace to the roads it most likely followed",
+			Tool:        MatchGPSTraceTool(),
+			Handler:     HandleMatchGPSTrace,
+		},
+		{
+			Name:        "snap_to_road",
+			Description: "Find the nearest routable points to a location, with street name and distance",
+			Tool:        SnapToRoadTool(),
+			Handler:     HandleSnapToRoad,
+		},
+		{
+			Name:        "compute_isochrone",
+			Description: "Compute the areas reachable from a location within several travel times",
+			Tool:        ComputeIsochroneTool(),
+			Handler:     HandleComputeIsochrone,
+			Timeout:     time.Minute,
+		},
 
 		// Exploration Tools
 		{
@@ -111,6 +332,7 @@ func (r *Registry) GetToolDefinitions() []ToolDefinition {
 			Description: "Analyze transportation options between home and work locations",
 			Tool:        AnalyzeCommuteTool(),
 			Handler:     HandleAnalyzeCommute,
+			Timeout:     3 * time.Minute,
 		},
 
 		// Neighborhood Analysis Tools
@@ -119,6 +341,7 @@ func (r *Registry) GetToolDefinitions() []ToolDefinition {
 			Description: "Evaluate neighborhood livability for real estate and relocation decisions",
 			Tool:        AnalyzeNeighborhoodTool(),
 			Handler:     HandleAnalyzeNeighborhood,
+			Timeout:     3 * time.Minute,
 		},
 
 		// Parking Tools
@@ -131,12 +354,21 @@ func (r *Registry) GetToolDefinitions() []ToolDefinition {
 	}
 }
 
-// RegisterTools registers all tools with the MCP server.
+// RegisterTools registers the enabled tools with the MCP server.
 func (r *Registry) RegisterTools(mcpServer *server.MCPServer) {
-	for _, def := range r.GetToolDefinitions() {
+	mcpServer.AddTools(r.ServerTools()...)
+}
+
+// ServerTools returns the enabled tools in the form the MCP server
+// takes them, for example to replace its tools with MCPServer.SetTools.
+func (r *Registry) ServerTools() []server.ServerTool {
+	defs := r.GetToolDefinitions()
+	list := make([]server.ServerTool, len(defs))
+	for i, def := range defs {
 		r.logger.Info("registering tool", "name", def.Name)
-		mcpServer.AddTool(def.Tool, def.Handler)
+		list[i] = server.ServerTool{Tool: def.Tool, Handler: def.Handler}
 	}
+	return list
 }
 
 // RegisterPrompts registers all prompts with the MCP server.
@@ -13,7 +13,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/NERVsystems/osmmcp/pkg/cache"
+	"github.com/NERVsystems/osmmcp/pkg/geo"
 	"github.com/NERVsystems/osmmcp/pkg/osm"
 	"github.com/mark3labs/mcp-go/mcp"
 )
@@ -53,6 +53,8 @@ type RouteDirections struct {
 	EndPoint    Location    `json:"end_point"`   // Ending point
 	Segments    []Segment   `json:"segments"`    // Route segments
 	Coordinates [][]float64 `json:"coordinates"` // Route geometry as [lon, lat] pairs
+
+	Snaps []SnapNotice `json:"snaps,omitempty"` // start or end moved to a road
 }
 
 // Segment represents a segment of a route with directions
@@ -74,10 +76,6 @@ func HandleGetRouteDirections(ctx context.Context, req mcp.CallToolRequest) (*mc
 	endLon := mcp.ParseFloat64(req, "end_lon", 0)
 	mode := mcp.ParseString(req, "mode", "car")
 
-	// Create a context with timeout for the request
-	reqCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-	defer cancel()
-
 	// Validate coordinates
 	if startLat < -90 || startLat > 90 {
 		return ErrorWithGuidance(&APIError{
@@ -122,30 +120,97 @@ func HandleGetRouteDirections(ctx context.Context, req mcp.CallToolRequest) (*mc
 	// Map transportation mode to OSRM profile
 	profile := mapModeToProfile(mode)
 
+	route, err := routeDirections(ctx, profile,
+		Location{Latitude: startLat, Longitude: startLon},
+		Location{Latitude: endLat, Longitude: endLon})
+	if err != nil {
+		return osrmErrorResult(err, "No route found between the specified points", logger), nil
+	}
+
+	return routeResult(route, logger), nil
+}
+
+// routeDirections returns the directions between two points for a
+// profile, from the route cache if possible
+func routeDirections(ctx context.Context, profile string, start, end Location) (RouteDirections, error) {
 	// Check cache first
-	cacheKey := fmt.Sprintf("route:%s:%f,%f:%f,%f", profile, startLat, startLon, endLat, endLon)
-	if cachedData, found := cache.GetGlobalCache().Get(cacheKey); found {
-		logger.Debug("route cache hit", "key", cacheKey)
-		result, ok := cachedData.(*mcp.CallToolResult)
-		if ok {
-			return result, nil
+	cacheKey := fmt.Sprintf("%s:%f,%f:%f,%f", profile, start.Latitude, start.Longitude, end.Latitude, end.Longitude)
+	routes := stateFrom(ctx).routes
+	if route, found := routes.Get(cacheKey); found {
+		slog.Default().Debug("route cache hit", "key", cacheKey)
+		return route, nil
+	}
+
+	// Create a context with timeout for the request
+	reqCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	osrmRoute, err := fetchRoute(reqCtx, profile, start.Latitude, start.Longitude, end.Latitude, end.Longitude)
+	if err != nil {
+		return RouteDirections{}, err
+	}
+	route := newRouteDirections(osrmRoute, start, end)
+
+	// Cache the route
+	routes.Set(cacheKey, route)
+
+	return route, nil
+}
+
+// newRouteDirections converts a route between two points to directions
+func newRouteDirections(osrmRoute *osm.RouteResult, start, end Location) RouteDirections {
+	// Convert to our coordinate format
+	coords := make([][]float64, len(osrmRoute.Geometry))
+	for i, point := range osrmRoute.Geometry {
+		coords[i] = []float64{point.Longitude, point.Latitude}
+	}
+
+	// Create RouteDirections object
+	route := RouteDirections{
+		Distance:    osrmRoute.Distance,
+		Duration:    osrmRoute.Duration,
+		StartPoint:  start,
+		EndPoint:    end,
+		Segments:    []Segment{},
+		Coordinates: coords,
+		Snaps:       routeSnapNotices(osrmRoute, start, end),
+	}
+
+	// Process route segments
+	for _, step := range osrmRoute.Steps {
+		segment := Segment{
+			Distance:    step.Distance,
+			Duration:    step.Duration,
+			Instruction: generateInstruction(step.Type, step.Modifier, step.Name),
+			Location: Location{
+				Longitude: step.Location.Longitude,
+				Latitude:  step.Location.Latitude,
+			},
 		}
+		route.Segments = append(route.Segments, segment)
 	}
+	return route
+}
 
-	// Build OSRM request URL
-	baseURL := fmt.Sprintf("%s/route/v1/%s", osm.OSRMBaseURL, profile)
-	coordinates := fmt.Sprintf("%f,%f;%f,%f", startLon, startLat, endLon, endLat)
+// errRouteResponse marks routing responses that cannot be parsed
+var errRouteResponse = errors.New("invalid routing response")
 
-	reqURL, err := url.Parse(baseURL + "/" + coordinates)
+// fetchRoute returns the route between two points for a profile: car,
+// bike or foot. It asks the configured osm.Router, or OSRM if there is
+// none, and returns osm.ErrNoRoute if no route exists.
+func fetchRoute(ctx context.Context, profile string, startLat, startLon, endLat, endLon float64) (*osm.RouteResult, error) {
+	client := osm.FromContext(ctx)
+	if r := client.Router(); r != nil {
+		return r.Route(ctx, profile,
+			geo.Location{Latitude: startLat, Longitude: startLon},
+			geo.Location{Latitude: endLat, Longitude: endLon})
+	}
+
+	// Build OSRM request URL
+	reqURL, err := url.Parse(fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f",
+		client.BaseURL(osm.ServiceOSRM), profile, startLon, startLat, endLon, endLat))
 	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorWithGuidance(&APIError{
-			Service:     "OSRM",
-			StatusCode:  http.StatusInternalServerError,
-			Message:     "Internal server error",
-			Guidance:    GuidanceOSRMGeneral,
-			Recoverable: true,
-		}), nil
+		return nil, fmt.Errorf("failed to parse URL: %w", err)
 	}
 
 	// Add query parameters
@@ -156,191 +221,77 @@ func HandleGetRouteDirections(ctx context.Context, req mcp.CallToolRequest) (*mc
 	q.Add("geometries", "polyline") // Use polyline format
 	reqURL.RawQuery = q.Encode()
 
-	// Wait for rate limiter
-	if err := osm.WaitForService(reqCtx, osm.ServiceOSRM); err != nil {
-		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-			logger.Error("rate limiter context canceled", "error", err)
-			return ErrorWithGuidance(&APIError{
-				Service:     "OSRM",
-				StatusCode:  http.StatusRequestTimeout,
-				Message:     "Request timed out waiting for rate limiter",
-				Guidance:    GuidanceOSRMTimeout,
-				Recoverable: true,
-			}), nil
-		}
-		logger.Error("rate limiter error", "error", err)
-	}
-
 	// Make HTTP request
-	httpReq, err := osm.NewRequestWithUserAgent(reqCtx, http.MethodGet, reqURL.String(), nil)
+	httpReq, err := osm.NewRequestWithUserAgent(ctx, http.MethodGet, reqURL.String(), nil)
 	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorWithGuidance(&APIError{
-			Service:     "OSRM",
-			StatusCode:  http.StatusInternalServerError,
-			Message:     "Failed to create request",
-			Guidance:    GuidanceOSRMGeneral,
-			Recoverable: true,
-		}), nil
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Execute request
-	client := osm.GetClient(reqCtx)
-	resp, err := client.Do(httpReq)
+	resp, err := osm.DoRequest(ctx, httpReq)
 	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-
-		var apiErr *APIError
-		if errors.Is(err, context.DeadlineExceeded) {
-			apiErr = &APIError{
-				Service:     "OSRM",
-				StatusCode:  http.StatusRequestTimeout,
-				Message:     "Request timed out",
-				Guidance:    GuidanceOSRMTimeout,
-				Recoverable: true,
-			}
-		} else if errors.Is(err, context.Canceled) {
-			apiErr = &APIError{
-				Service:     "OSRM",
-				StatusCode:  499, // Client closed request
-				Message:     "Request canceled",
-				Guidance:    "The request was canceled before completion",
-				Recoverable: false,
-			}
-		} else {
-			apiErr = &APIError{
-				Service:     "OSRM",
-				StatusCode:  http.StatusServiceUnavailable,
-				Message:     "Failed to communicate with routing service",
-				Guidance:    GuidanceNetworkError,
-				Recoverable: true,
-			}
-		}
-
-		return ErrorWithGuidance(apiErr), nil
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Process response
 	if resp.StatusCode != http.StatusOK {
-		logger.Error("routing service returned error", "status", resp.StatusCode)
-		return ErrorWithGuidance(&APIError{
-			Service:     "OSRM",
-			StatusCode:  resp.StatusCode,
-			Message:     fmt.Sprintf("Routing service error: %d", resp.StatusCode),
-			Guidance:    GuidanceOSRMGeneral,
-			Recoverable: true,
-		}), nil
+		return nil, &osm.RequestError{Service: osm.ServiceOSRM, StatusCode: resp.StatusCode, Attempts: 1}
 	}
 
 	// Parse OSRM response
 	var osrmResp struct {
 		Code   string `json:"code"`
 		Routes []struct {
-			Distance float64 `json:"distance"`
-			Duration float64 `json:"duration"`
-			Geometry string  `json:"geometry"`
-			Legs     []struct {
-				Steps []struct {
-					Distance float64 `json:"distance"`
-					Duration float64 `json:"duration"`
-					Name     string  `json:"name"`
-					Maneuver struct {
-						Location []float64 `json:"location"`
-						Type     string    `json:"type"`
-						Modifier string    `json:"modifier,omitempty"`
-					} `json:"maneuver"`
-				} `json:"steps"`
-			} `json:"legs"`
+			Distance float64   `json:"distance"`
+			Duration float64   `json:"duration"`
+			Geometry string    `json:"geometry"`
+			Legs     []osrmLeg `json:"legs"`
 		} `json:"routes"`
+		Waypoints []osrmWaypoint `json:"waypoints"`
 	}
-
 	if err := json.NewDecoder(resp.Body).Decode(&osrmResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorWithGuidance(&APIError{
-			Service:     "OSRM",
-			StatusCode:  http.StatusInternalServerError,
-			Message:     "Failed to parse routing response",
-			Guidance:    GuidanceDataError,
-			Recoverable: true,
-		}), nil
+		return nil, fmt.Errorf("%w: %v", errRouteResponse, err)
 	}
 
-	// Check if any routes were found
+	// Use the best route (first one)
 	if len(osrmResp.Routes) == 0 {
-		return ErrorWithGuidance(&APIError{
-			Service:     "OSRM",
-			StatusCode:  http.StatusOK, // OSRM returns 200 even when no route is found
-			Message:     "No route found between the specified points",
-			Guidance:    GuidanceOSRMRouteNotFound,
-			Recoverable: true,
-		}), nil
+		return nil, osm.ErrNoRoute
 	}
-
-	// Get the best route (first one)
 	osrmRoute := osrmResp.Routes[0]
 
-	// Decode the polyline geometry
-	polylinePoints := osm.DecodePolyline(osrmRoute.Geometry)
-
-	// Convert to our coordinate format
-	coords := make([][]float64, len(polylinePoints))
-	for i, point := range polylinePoints {
-		coords[i] = []float64{point.Longitude, point.Latitude}
-	}
-
-	// Create RouteDirections object
-	route := RouteDirections{
+	route := &osm.RouteResult{
 		Distance: osrmRoute.Distance,
 		Duration: osrmRoute.Duration,
-		StartPoint: Location{
-			Latitude:  startLat,
-			Longitude: startLon,
-		},
-		EndPoint: Location{
-			Latitude:  endLat,
-			Longitude: endLon,
-		},
-		Segments:    []Segment{},
-		Coordinates: coords,
+		Geometry: osm.DecodePolyline(osrmRoute.Geometry),
 	}
-
-	// Process route segments
-	if len(osrmRoute.Legs) > 0 {
-		for _, step := range osrmRoute.Legs[0].Steps {
-			segment := Segment{
-				Distance:    step.Distance,
-				Duration:    step.Duration,
-				Instruction: generateInstruction(step.Maneuver.Type, step.Maneuver.Modifier, step.Name),
-				Location: Location{
-					Longitude: step.Maneuver.Location[0],
-					Latitude:  step.Maneuver.Location[1],
-				},
-			}
-			route.Segments = append(route.Segments, segment)
+	for _, w := range osrmResp.Waypoints {
+		route.Waypoints = append(route.Waypoints, w.waypoint())
+	}
+	for _, leg := range osrmRoute.Legs {
+		steps, err := leg.routeSteps()
+		if err != nil {
+			return nil, err
 		}
+		route.Steps = append(route.Steps, steps...)
 	}
+	return route, nil
+}
 
-	// Create output
+// routeResult wraps route directions in a tool result
+func routeResult(route RouteDirections, logger *slog.Logger) *mcp.CallToolResult {
 	output := struct {
 		Route RouteDirections `json:"route"`
 	}{
 		Route: route,
 	}
 
-	// Return result
 	resultBytes, err := json.Marshal(output)
 	if err != nil {
 		logger.Error("failed to marshal result", "error", err)
-		return ErrorResponse("Failed to generate result"), nil
+		return ErrorResponse("Failed to generate result")
 	}
 
-	result := mcp.NewToolResultText(string(resultBytes))
-
-	// Cache the result
-	cache.GetGlobalCache().SetWithTTL(cacheKey, result, 15*time.Minute) // Cache for 15 minutes
-
-	return result, nil
+	return mcp.NewToolResultText(string(resultBytes))
 }
 
 // SuggestMeetingPointTool returns a tool definition for suggesting meeting points
@@ -571,7 +522,7 @@ func extractLocations(req mcp.CallToolRequest) ([]struct {
 func mapModeToProfile(mode string) string {
 	mode = strings.ToLower(mode)
 	switch mode {
-	case "bike", "bicycle":
+	case "bike", "bicycle", "cycling":
 		return "bike"
 	case "foot", "walk", "walking":
 		return "foot"
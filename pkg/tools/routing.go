@@ -117,14 +117,14 @@ func HandleGetRoute(ctx context.Context, rawInput mcp.CallToolRequest) (*mcp.Cal
 	}
 
 	// Build request URL
-	reqURL, err := url.Parse(osm.OSRMBaseURL)
+	reqURL, err := url.Parse(osm.FromContext(ctx).BaseURL(osm.ServiceOSRM))
 	if err != nil {
 		logger.Error("failed to parse URL", "error", err)
 		return ErrorResponse("Internal server error"), nil
 	}
 
 	// Add coordinates to path
-	reqURL.Path = fmt.Sprintf("/route/v1/%s/%f,%f;%f,%f",
+	reqURL.Path += fmt.Sprintf("/route/v1/%s/%f,%f;%f,%f",
 		profile,
 		startLon, startLat,
 		endLon, endLat,
@@ -150,7 +150,7 @@ func HandleGetRoute(ctx context.Context, rawInput mcp.CallToolRequest) (*mcp.Cal
 	resp, err := osm.DoRequest(ctx, req)
 	if err != nil {
 		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with routing service"), nil
+		return ErrorWithGuidance(upstreamError("OSRM", err)), nil
 	}
 	defer resp.Body.Close()
 
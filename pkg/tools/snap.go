@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// snapNoticeDistance is how far, in meters, routing may move a point
+	// to the road network before results report it
+	snapNoticeDistance = 50
+
+	// maxSnapPoints bounds the points snap_to_road returns
+	maxSnapPoints = 10
+)
+
+// SnapToRoadTool returns a tool definition for finding the nearest
+// routable points
+func SnapToRoadTool() mcp.Tool {
+	return mcp.NewTool("snap_to_road",
+		mcp.WithDescription("Find the nearest points on roads usable by a transportation mode, with street name and distance"),
+		mcp.WithNumber("latitude",
+			mcp.Required(),
+			mcp.Description("The latitude of the point to snap"),
+		),
+		mcp.WithNumber("longitude",
+			mcp.Required(),
+			mcp.Description("The longitude of the point to snap"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Transportation mode: car, bike, foot"),
+			mcp.DefaultString("car"),
+		),
+		mcp.WithNumber("number",
+			mcp.Description(fmt.Sprintf("Number of road points to return, nearest first (1 to %d)", maxSnapPoints)),
+			mcp.DefaultNumber(1),
+		),
+	)
+}
+
+// RoadPoint is a point on the road network near a requested location
+type RoadPoint struct {
+	Location Location `json:"location"`
+	Street   string   `json:"street,omitempty"`
+	Distance float64  `json:"distance"` // meters from the requested location
+}
+
+// SnapNotice reports that routing moved a point to the road network by
+// more than snapNoticeDistance. Routing results list these under "snaps",
+// one per point moved that far, so a caller can tell when a route starts
+// or ends somewhere other than where it asked.
+type SnapNotice struct {
+	Point    string   `json:"point"` // which point moved, such as "start" or "origins[2]"
+	Input    Location `json:"input"`
+	Snapped  Location `json:"snapped"`
+	Street   string   `json:"street,omitempty"`
+	Distance float64  `json:"distance"` // meters between input and snapped point
+}
+
+// HandleSnapToRoad finds the road points nearest to a location
+func HandleSnapToRoad(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	logger := slog.Default().With("tool", "snap_to_road")
+
+	lat := mcp.ParseFloat64(req, "latitude", 0)
+	lon := mcp.ParseFloat64(req, "longitude", 0)
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return ErrorWithGuidance(&APIError{
+			Service:     "Validation",
+			StatusCode:  http.StatusBadRequest,
+			Message:     fmt.Sprintf("Invalid coordinates: %f, %f", lat, lon),
+			Guidance:    "Latitude must be between -90 and 90 degrees and longitude between -180 and 180 degrees",
+			Recoverable: true,
+		}), nil
+	}
+	number := int(mcp.ParseFloat64(req, "number", 1))
+	if number < 1 || number > maxSnapPoints {
+		return invalidArgument(fmt.Sprintf("number must be between 1 and %d", maxSnapPoints)), nil
+	}
+	profile := mapModeToProfile(mcp.ParseString(req, "mode", "car"))
+
+	waypoints, err := nearestWaypoints(ctx, profile, geo.Location{Latitude: lat, Longitude: lon}, number)
+	if err != nil {
+		return osrmErrorResult(err, "No road found near the specified point", logger), nil
+	}
+
+	output := struct {
+		Profile string      `json:"profile"`
+		Input   Location    `json:"input"`
+		Points  []RoadPoint `json:"points"`
+	}{
+		Profile: profile,
+		Input:   Location{Latitude: lat, Longitude: lon},
+		Points:  make([]RoadPoint, len(waypoints)),
+	}
+	for i, w := range waypoints {
+		output.Points[i] = RoadPoint{
+			Location: Location{Latitude: w.Location.Latitude, Longitude: w.Location.Longitude},
+			Street:   w.Name,
+			Distance: roundTenth(w.Distance),
+		}
+	}
+
+	resultBytes, err := json.Marshal(output)
+	if err != nil {
+		logger.Error("failed to marshal result", "error", err)
+		return ErrorResponse("Failed to generate result"), nil
+	}
+	return mcp.NewToolResultText(string(resultBytes)), nil
+}
+
+// nearestWaypoints returns up to number road points near a location. It
+// asks the configured osm.Router if that is an osm.Snapper, or the OSRM
+// nearest service otherwise.
+func nearestWaypoints(ctx context.Context, profile string, point geo.Location, number int) ([]osm.Waypoint, error) {
+	if s, ok := osm.FromContext(ctx).Router().(osm.Snapper); ok {
+		return s.Nearest(ctx, profile, point, number)
+	}
+
+	params := url.Values{}
+	params.Set("number", strconv.Itoa(number))
+	var resp struct {
+		Waypoints []osrmWaypoint `json:"waypoints"`
+	}
+	if err := osrmGet(ctx, "nearest", profile, []geo.Location{point}, params, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Waypoints) == 0 {
+		return nil, osm.ErrNoRoute
+	}
+	waypoints := make([]osm.Waypoint, len(resp.Waypoints))
+	for i, w := range resp.Waypoints {
+		waypoints[i] = w.waypoint()
+	}
+	return waypoints, nil
+}
+
+// snapNotice returns the notice for a waypoint that moved its requested
+// point by more than snapNoticeDistance, or false
+func snapNotice(name string, input Location, w osm.Waypoint) (SnapNotice, bool) {
+	if w.Distance <= snapNoticeDistance {
+		return SnapNotice{}, false
+	}
+	return SnapNotice{
+		Point:    name,
+		Input:    input,
+		Snapped:  Location{Latitude: w.Location.Latitude, Longitude: w.Location.Longitude},
+		Street:   w.Name,
+		Distance: roundTenth(w.Distance),
+	}, true
+}
+
+// routeSnapNotices returns the notices for the start and end of a route
+func routeSnapNotices(route *osm.RouteResult, start, end Location) []SnapNotice {
+	if len(route.Waypoints) != 2 {
+		return nil
+	}
+	var notices []SnapNotice
+	if n, ok := snapNotice("start", start, route.Waypoints[0]); ok {
+		notices = append(notices, n)
+	}
+	if n, ok := snapNotice("end", end, route.Waypoints[1]); ok {
+		notices = append(notices, n)
+	}
+	return notices
+}
@@ -0,0 +1,41 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/NERVsystems/osmmcp/pkg/geo"
+	"github.com/NERVsystems/osmmcp/pkg/osm"
+)
+
+func TestRouteSnapNotices(t *testing.T) {
+	start := Location{Latitude: 52.5, Longitude: 13.4}
+	end := Location{Latitude: 52.51, Longitude: 13.41}
+	route := &osm.RouteResult{Waypoints: []osm.Waypoint{
+		{Location: geo.Location{Latitude: 52.5003, Longitude: 13.4}, Distance: 33.4},
+		{Location: geo.Location{Latitude: 52.5110, Longitude: 13.41}, Name: "Hauptstraße", Distance: 111.19},
+	}}
+
+	notices := routeSnapNotices(route, start, end)
+	if len(notices) != 1 {
+		t.Fatalf("notices = %+v, want only the end", notices)
+	}
+	want := SnapNotice{Point: "end", Input: end, Snapped: Location{Latitude: 52.511, Longitude: 13.41}, Street: "Hauptstraße", Distance: 111.2}
+	if notices[0] != want {
+		t.Errorf("notice = %+v, want %+v", notices[0], want)
+	}
+
+	if notices := routeSnapNotices(&osm.RouteResult{}, start, end); notices != nil {
+		t.Errorf("notices without waypoints = %+v, want none", notices)
+	}
+}
+
+func TestStopSnapNotices(t *testing.T) {
+	notices := []SnapNotice{{Point: "start"}, {Point: "end"}}
+	named := stopSnapNotices(notices, 2, 0)
+	if len(named) != 2 || named[0].Point != "stops[2]" || named[1].Point != "stops[0]" {
+		t.Errorf("named = %+v, want stops[2] and stops[0]", named)
+	}
+	if notices[0].Point != "start" {
+		t.Error("stopSnapNotices changed the notices it was given")
+	}
+}
@@ -4,11 +4,10 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
-	"net/http"
-	"net/url"
 	"sort"
 	"strings"
 
@@ -86,64 +85,20 @@ func HandleFindChargingStations(ctx context.Context, req mcp.CallToolRequest) (*
 		limit = 50 // Max limit
 	}
 
-	// Build Overpass query for charging stations
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("[out:json];")
-	queryBuilder.WriteString(fmt.Sprintf("(node(around:%f,%f,%f)[amenity=charging_station];", radius, latitude, longitude))
-	queryBuilder.WriteString(fmt.Sprintf("way(around:%f,%f,%f)[amenity=charging_station];", radius, latitude, longitude))
-	queryBuilder.WriteString(");out body;")
-
-	// Build request
-	reqURL, err := url.Parse(osm.OverpassBaseURL)
-	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
-	}
-
-	// Make HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(), strings.NewReader("data="+url.QueryEscape(queryBuilder.String())))
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create request"), nil
+	// Fetch charging stations, reusing cached tiles of earlier searches
+	filter := osm.ElementFilter{
+		Types: []string{"node", "way"},
+		Tags:  map[string][]string{"amenity": {"charging_station"}},
 	}
-
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("User-Agent", osm.UserAgent)
-
-	// Execute request
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
+	elements, err := osm.QueryElementsAround(ctx, filter, latitude, longitude, radius)
 	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with OSM service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("OSM service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("OSM service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse response
-	var overpassResp struct {
-		Elements []struct {
-			ID   int               `json:"id"`
-			Type string            `json:"type"`
-			Lat  float64           `json:"lat,omitempty"`
-			Lon  float64           `json:"lon,omitempty"`
-			Tags map[string]string `json:"tags"`
-		} `json:"elements"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorResponse("Failed to parse charging stations data"), nil
+		logger.Error("failed to query charging stations", "error", err)
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 
 	// Convert to ChargingStation objects and calculate distances
 	stations := make([]ChargingStation, 0)
-	for _, element := range overpassResp.Elements {
+	for _, element := range elements {
 		// Skip elements without proper coordinates
 		if element.Lat == 0 && element.Lon == 0 {
 			continue
@@ -288,78 +243,26 @@ func HandleFindRouteChargingStations(ctx context.Context, req mcp.CallToolReques
 		limit = 50 // Max limit
 	}
 
-	// First, get the route between the two points using OSRM
-	osrmURL := fmt.Sprintf("%s/route/v1/driving/%f,%f;%f,%f",
-		osm.OSRMBaseURL, startLon, startLat, endLon, endLat)
-	reqURL, err := url.Parse(osrmURL)
-	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
-	}
-
-	// Add query parameters for OSRM
-	q := reqURL.Query()
-	q.Add("overview", "full")
-	q.Add("geometries", "geojson")
-	reqURL.RawQuery = q.Encode()
-
-	// Make HTTP request to OSRM
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
-	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create route request"), nil
-	}
-
-	httpReq.Header.Set("User-Agent", osm.UserAgent)
-
-	// Execute request
-	client := osm.GetClient(ctx)
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with routing service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("routing service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("Routing service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse OSRM response
-	var osrmResp struct {
-		Routes []struct {
-			Distance float64 `json:"distance"`
-			Duration float64 `json:"duration"`
-			Geometry struct {
-				Coordinates [][]float64 `json:"coordinates"` // [lon, lat] format in GeoJSON
-			} `json:"geometry"`
-		} `json:"routes"`
+	// First, get the route between the two points
+	route, err := fetchRoute(ctx, "car", startLat, startLon, endLat, endLon)
+	if errors.Is(err, osm.ErrNoRoute) {
+		return ErrorResponse("No route found between the specified points"), nil
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&osrmResp); err != nil {
+	if errors.Is(err, errRouteResponse) {
 		logger.Error("failed to decode response", "error", err)
 		return ErrorResponse("Failed to parse routing data"), nil
 	}
-
-	// Check if we have any routes
-	if len(osrmResp.Routes) == 0 {
-		return ErrorResponse("No route found between the specified points"), nil
+	if err != nil {
+		logger.Error("failed to get route", "error", err)
+		return ErrorWithGuidance(upstreamError("OSRM", err)), nil
 	}
 
-	// Get the first route
-	route := osrmResp.Routes[0]
-
-	// Convert route coordinates to [lat, lon] format (OSRM returns [lon, lat])
-	routeCoords := make([]Location, 0, len(route.Geometry.Coordinates))
-	for _, coord := range route.Geometry.Coordinates {
-		if len(coord) >= 2 {
-			routeCoords = append(routeCoords, Location{
-				Latitude:  coord[1],
-				Longitude: coord[0],
-			})
-		}
+	routeCoords := make([]Location, 0, len(route.Geometry))
+	for _, coord := range route.Geometry {
+		routeCoords = append(routeCoords, Location{
+			Latitude:  coord.Latitude,
+			Longitude: coord.Longitude,
+		})
 	}
 
 	// Create a bounding box for the route
@@ -371,66 +274,22 @@ func HandleFindRouteChargingStations(ctx context.Context, req mcp.CallToolReques
 	// Add buffer to bounding box
 	bbox.Buffer(bufferDistance)
 
-	// Build Overpass query for charging stations in bounding box
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("[out:json];")
-	queryBuilder.WriteString(fmt.Sprintf("(node%s[amenity=charging_station];", bbox.String()))
-	queryBuilder.WriteString(fmt.Sprintf("way%s[amenity=charging_station];", bbox.String()))
-	queryBuilder.WriteString(");out body;")
-
-	// Build request for Overpass
-	reqURL, err = url.Parse(osm.OverpassBaseURL)
-	if err != nil {
-		logger.Error("failed to parse URL", "error", err)
-		return ErrorResponse("Internal server error"), nil
+	// Fetch charging stations, reusing cached tiles of earlier searches
+	filter := osm.ElementFilter{
+		Types: []string{"node", "way"},
+		Tags:  map[string][]string{"amenity": {"charging_station"}},
 	}
-
-	// Make HTTP request to Overpass
-	httpReq, err = http.NewRequestWithContext(ctx, http.MethodPost, reqURL.String(),
-		strings.NewReader("data="+url.QueryEscape(queryBuilder.String())))
+	elements, err := osm.QueryElementsInBBox(ctx, filter, *bbox)
 	if err != nil {
-		logger.Error("failed to create request", "error", err)
-		return ErrorResponse("Failed to create request"), nil
-	}
-
-	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	httpReq.Header.Set("User-Agent", osm.UserAgent)
-
-	// Execute request
-	resp, err = client.Do(httpReq)
-	if err != nil {
-		logger.Error("failed to execute request", "error", err)
-		return ErrorResponse("Failed to communicate with OSM service"), nil
-	}
-	defer resp.Body.Close()
-
-	// Process response
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("OSM service returned error", "status", resp.StatusCode)
-		return ErrorResponse(fmt.Sprintf("OSM service error: %d", resp.StatusCode)), nil
-	}
-
-	// Parse Overpass response
-	var overpassResp struct {
-		Elements []struct {
-			ID   int               `json:"id"`
-			Type string            `json:"type"`
-			Lat  float64           `json:"lat,omitempty"`
-			Lon  float64           `json:"lon,omitempty"`
-			Tags map[string]string `json:"tags"`
-		} `json:"elements"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&overpassResp); err != nil {
-		logger.Error("failed to decode response", "error", err)
-		return ErrorResponse("Failed to parse charging stations data"), nil
+		logger.Error("failed to query charging stations", "error", err)
+		return ErrorWithGuidance(upstreamError("Overpass", err)), nil
 	}
 
 	// Process charging stations
 	routeStations := make([]RouteChargingStation, 0)
 	totalRouteDistance := route.Distance // meters
 
-	for _, element := range overpassResp.Elements {
+	for _, element := range elements {
 		// Skip elements without proper coordinates
 		if element.Lat == 0 && element.Lon == 0 {
 			continue
@@ -516,10 +375,14 @@ func HandleFindRouteChargingStations(ctx context.Context, req mcp.CallToolReques
 		RouteDistance    float64                `json:"route_distance"`
 		RouteDuration    float64                `json:"route_duration"`
 		ChargingStations []RouteChargingStation `json:"charging_stations"`
+		Snaps            []SnapNotice           `json:"snaps,omitempty"` // points moved far to reach a road
 	}{
 		RouteDistance:    route.Distance,
 		RouteDuration:    route.Duration,
 		ChargingStations: routeStations,
+		Snaps: routeSnapNotices(route,
+			Location{Latitude: startLat, Longitude: startLon},
+			Location{Latitude: endLat, Longitude: endLon}),
 	}
 
 	// Return result
@@ -0,0 +1,37 @@
+package tools
+
+import "fmt"
+
+// Units selects how distances are written in the summaries of tool
+// results. Numeric fields always stay in meters and seconds.
+type Units string
+
+const (
+	// UnitsMetric writes distances in kilometers
+	UnitsMetric Units = "metric"
+
+	// UnitsImperial writes distances in miles
+	UnitsImperial Units = "imperial"
+
+	// metersPerMile converts meters to miles
+	metersPerMile = 1609.344
+)
+
+// ParseUnits returns the units named by name. An empty name means metric.
+func ParseUnits(name string) (Units, error) {
+	switch Units(name) {
+	case "", UnitsMetric:
+		return UnitsMetric, nil
+	case UnitsImperial:
+		return UnitsImperial, nil
+	}
+	return "", fmt.Errorf("unknown units %q (must be metric or imperial)", name)
+}
+
+// FormatDistance writes a distance given in meters, such as "3.2 km"
+func (u Units) FormatDistance(meters float64) string {
+	if u == UnitsImperial {
+		return fmt.Sprintf("%.1f mi", meters/metersPerMile)
+	}
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
@@ -0,0 +1,279 @@
+// Package tracing sets up OpenTelemetry tracing for osmmcp. Tool calls,
+// rate limiter waits, cache lookups and upstream HTTP requests are traced
+// through the global tracer provider, which does nothing until Setup
+// installs an exporter.
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// InstrumentationName names the tracer of osmmcp
+	InstrumentationName = "github.com/NERVsystems/osmmcp"
+
+	// coordinateDecimals is the precision coordinates are recorded with;
+	// two decimals locate a point to about a kilometer
+	coordinateDecimals = 2
+
+	// maxAttributeLength bounds the length of recorded argument values
+	maxAttributeLength = 256
+
+	// EnvTraceFile names the environment variable holding the trace file
+	EnvTraceFile = "OSMMCP_TRACE_FILE"
+
+	// EnvOTLPEndpoint and EnvOTLPTracesEndpoint are the standard
+	// OpenTelemetry variables naming the collector
+	EnvOTLPEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvOTLPTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+
+	// tracesPath is where OTLP/HTTP collectors receive spans
+	tracesPath = "/v1/traces"
+)
+
+// Options selects where spans are exported. Without an OTLP endpoint or
+// a file nothing is exported.
+type Options struct {
+	// ServiceName and ServiceVersion describe the process in every span
+	ServiceName    string
+	ServiceVersion string
+
+	// OTLPEndpoint is the URL of an OTLP/HTTP collector, such as
+	// http://localhost:4318
+	OTLPEndpoint string
+
+	// File receives every span as a line of JSON
+	File string
+}
+
+// ApplyEnv fills the targets left empty from the environment. The OTLP
+// endpoint is read from the standard OpenTelemetry variables.
+func (o *Options) ApplyEnv(getenv func(string) string) {
+	if o.OTLPEndpoint == "" {
+		o.OTLPEndpoint = getenv(EnvOTLPTracesEndpoint)
+	}
+	if o.OTLPEndpoint == "" {
+		o.OTLPEndpoint = getenv(EnvOTLPEndpoint)
+	}
+	if o.File == "" {
+		o.File = getenv(EnvTraceFile)
+	}
+}
+
+// Enabled reports whether the options export spans anywhere
+func (o Options) Enabled() bool {
+	return o.OTLPEndpoint != "" || o.File != ""
+}
+
+// Setup installs a tracer provider exporting to the configured targets
+// and the W3C trace context propagator. The returned function flushes
+// pending spans and closes the exporters.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if !opts.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var providerOpts []sdktrace.TracerProviderOption
+	var closers []func() error
+
+	if opts.OTLPEndpoint != "" {
+		endpoint, err := tracesURL(opts.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+	}
+
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+		closers = append(closers, f.Close)
+	}
+
+	name := opts.ServiceName
+	if name == "" {
+		name = "osmmcp"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(name),
+		semconv.ServiceVersion(opts.ServiceVersion),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+	providerOpts = append(providerOpts, sdktrace.WithResource(res))
+
+	provider := sdktrace.NewTracerProvider(providerOpts...)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		for _, closeFn := range closers {
+			err = errors.Join(err, closeFn())
+		}
+		return err
+	}, nil
+}
+
+// tracesURL returns the URL spans are posted to. A collector given
+// without a path receives them at /v1/traces.
+func tracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = tracesPath
+	}
+	return u.String(), nil
+}
+
+// Tracer returns the tracer of osmmcp from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Middleware continues the trace named in the headers of incoming
+// requests, so tool spans join the caller's trace
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ArgumentAttributes describes tool arguments as span attributes named
+// tool.argument.<name>. Coordinates are rounded so that traces do not
+// pinpoint where users are, and encoded polylines are recorded by their
+// number of points only; nested values are recorded as JSON.
+func ArgumentAttributes(args map[string]interface{}) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(args))
+	for name, value := range args {
+		key := "tool.argument." + name
+		if encoded, ok := value.(string); ok && isPolyline(name) {
+			attrs = append(attrs, attribute.String(key, fmt.Sprintf("%d points", polylinePoints(encoded))))
+			continue
+		}
+		switch v := roundCoordinates(name, value).(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, truncate(v)))
+		case float64:
+			attrs = append(attrs, attribute.Float64(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		case nil:
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			attrs = append(attrs, attribute.String(key, truncate(string(data))))
+		}
+	}
+	return attrs
+}
+
+// isCoordinate reports whether an argument name holds latitudes or
+// longitudes, such as latitude, start_lat, west_lon or coordinates
+func isCoordinate(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range strings.Split(name, "_") {
+		switch part {
+		case "lat", "lon", "lng", "latitude", "longitude", "coordinates", "points":
+			return true
+		}
+	}
+	return false
+}
+
+// isPolyline reports whether an argument name holds an encoded polyline
+func isPolyline(name string) bool {
+	return slices.Contains(strings.Split(strings.ToLower(name), "_"), "polyline")
+}
+
+// polylinePoints counts the points of an encoded polyline without
+// decoding them. Every value ends with a character lacking the
+// continuation bit, and every point has two values.
+func polylinePoints(encoded string) int {
+	values := 0
+	for i := 0; i < len(encoded); i++ {
+		if (encoded[i]-63)&0x20 == 0 {
+			values++
+		}
+	}
+	return values / 2
+}
+
+// roundCoordinates rounds the coordinates found in an argument value,
+// including those nested in arrays and objects
+func roundCoordinates(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if isCoordinate(name) {
+			return roundTo(v, coordinateDecimals)
+		}
+		return v
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = roundCoordinates(name, item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = roundCoordinates(k, item)
+		}
+		return out
+	}
+	return value
+}
+
+// roundTo rounds v to the given number of decimals
+func roundTo(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
+
+// truncate bounds the length of an attribute value
+func truncate(s string) string {
+	if len(s) <= maxAttributeLength {
+		return s
+	}
+	return s[:maxAttributeLength] + "..."
+}
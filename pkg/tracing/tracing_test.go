@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestArgumentAttributes(t *testing.T) {
+	attrs := ArgumentAttributes(map[string]interface{}{
+		"latitude":  52.520008,
+		"start_lon": 13.404954,
+		"radius":    500.25,
+		"address":   "Alexanderplatz, Berlin",
+		"locations": []interface{}{
+			map[string]interface{}{"latitude": 52.516275, "longitude": 13.377704},
+		},
+		"coordinates": []interface{}{[]interface{}{13.377704, 52.516275}},
+		"optional":    nil,
+		"polyline":    "_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+	})
+
+	got := make(map[attribute.Key]string, len(attrs))
+	for _, a := range attrs {
+		got[a.Key] = a.Value.Emit()
+	}
+	want := map[attribute.Key]string{
+		"tool.argument.latitude":    "52.52",
+		"tool.argument.start_lon":   "13.4",
+		"tool.argument.radius":      "500.25",
+		"tool.argument.address":     "Alexanderplatz, Berlin",
+		"tool.argument.locations":   `[{"latitude":52.52,"longitude":13.38}]`,
+		"tool.argument.coordinates": `[[13.38,52.52]]`,
+		"tool.argument.polyline":    "3 points",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d attributes, want %d: %v", len(got), len(want), got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://otel.example.com/", want: "https://otel.example.com/v1/traces"},
+		{endpoint: "https://otel.example.com/custom/traces", want: "https://otel.example.com/custom/traces"},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "grpc://localhost:4317", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tracesURL(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("tracesURL(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("tracesURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestOptionsApplyEnv(t *testing.T) {
+	env := map[string]string{
+		EnvOTLPEndpoint:       "http://collector:4318",
+		EnvOTLPTracesEndpoint: "http://traces:4318/v1/traces",
+		EnvTraceFile:          "/tmp/spans.jsonl",
+	}
+	opts := Options{File: "spans.jsonl"}
+	opts.ApplyEnv(func(name string) string { return env[name] })
+	if opts.OTLPEndpoint != env[EnvOTLPTracesEndpoint] {
+		t.Errorf("OTLPEndpoint = %q, want the traces endpoint", opts.OTLPEndpoint)
+	}
+	if opts.File != "spans.jsonl" {
+		t.Errorf("File = %q, want the configured file kept", opts.File)
+	}
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	shutdown, err := Setup(context.Background(), Options{ServiceVersion: "test", File: path})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "tool geocode_address")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"tool geocode_address"`) || !strings.Contains(string(data), `"osmmcp"`) {
+		t.Errorf("trace file = %s, want the span of the osmmcp service", data)
+	}
+}